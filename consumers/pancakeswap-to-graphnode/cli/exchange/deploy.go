@@ -0,0 +1,67 @@
+package exchange
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/postgres"
+)
+
+var deployCmd = &cobra.Command{
+	Use:          "deploy",
+	Short:        "register the subgraph in graph-node's metadata and create its deployment schema, so graph-node serves the loaded data",
+	RunE:         runDeploy,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	deployCmd.Flags().String("pg-dsn", "", "dsn of graph-node's postgres database")
+	deployCmd.Flags().String("subgraph-name", "pancakeswap/exchange", "name graph-node serves the subgraph under")
+	deployCmd.Flags().String("deployment-hash", "", "deployment hash, defaults to the hash of the subgraph manifest")
+	deployCmd.Flags().String("network", "", "network of the deployment, defaults to the network of the manifest")
+	deployCmd.Flags().String("shard", "primary", "graph-node store shard holding the deployment")
+	addPartitionFlags(deployCmd)
+	rootCmd.AddCommand(deployCmd)
+}
+
+func addPartitionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("partition-tables", nil, "entity tables created partitioned by the block their versions start at, ex: swap,transaction,pair_hour_data")
+	cmd.Flags().Uint64("partition-size", postgres.DefaultPartitionSize, "number of blocks held by each partition of the partitioned tables")
+}
+
+// partitioning returns the partitioning requested by the partition flags, nil when no
+// table is partitioned.
+func partitioning(cmd *cobra.Command) (*postgres.Partitioning, error) {
+	tables, err := cmd.Flags().GetStringSlice("partition-tables")
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	return &postgres.Partitioning{Tables: tables, Size: mustGetUint64(cmd, "partition-size")}, nil
+}
+
+func runDeploy(cmd *cobra.Command, args []string) error {
+	partitioning, err := partitioning(cmd)
+	if err != nil {
+		return err
+	}
+
+	deployment, err := postgres.Deploy(cmd.Context(), zlog, mustGetString(cmd, "pg-dsn"), graphnode.Definition, postgres.DeployOptions{
+		SubgraphName:   mustGetString(cmd, "subgraph-name"),
+		DeploymentHash: mustGetString(cmd, "deployment-hash"),
+		Network:        mustGetString(cmd, "network"),
+		Shard:          mustGetString(cmd, "shard"),
+		Partitioning:   partitioning,
+	})
+	if err != nil {
+		return fmt.Errorf("deploying: %w", err)
+	}
+
+	fmt.Printf("Deployment %s created in schema %s\n", deployment.Hash, deployment.Schema)
+	fmt.Printf("Load it with: --pg-schema %s --pg-deployment %s\n", deployment.Schema, deployment.Hash)
+	return nil
+}
@@ -0,0 +1,119 @@
+package exchange
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/diff"
+)
+
+var diffCmd = &cobra.Command{
+	Use:          "diff",
+	Short:        "compare the entities valid at --at-block against a reference deployment",
+	RunE:         runDiff,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	diffCmd.Flags().String("pg-dsn", "", "dsn of the database to verify, or sqlite://<path> for a local SQLite database")
+	diffCmd.Flags().String("pg-schema", "", "schema of the database to verify")
+	diffCmd.Flags().String("reference-dsn", "", "dsn of the reference database, or sqlite://<path> for a local SQLite database")
+	diffCmd.Flags().String("reference-schema", "", "schema of the reference deployment")
+	diffCmd.Flags().Uint64("at-block", 0, "Block at which entities are compared")
+	diffCmd.Flags().Float64("tolerance", 0, "Relative difference allowed between decimal values, ex: 1e-18")
+	diffCmd.Flags().StringSlice("tables", nil, "Only compare these tables")
+	diffCmd.Flags().Int("max-differences", 25, "Maximum number of differing entities printed per table, 0 prints them all")
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	atBlock := mustGetUint64(cmd, "at-block")
+	if atBlock == 0 {
+		return fmt.Errorf("--at-block is required")
+	}
+
+	actual, err := newReadStore(mustGetString(cmd, "pg-dsn"), mustGetString(cmd, "pg-schema"), graphnode.Definition)
+	if err != nil {
+		return err
+	}
+	defer actual.Close()
+
+	expected, err := newReadStore(mustGetString(cmd, "reference-dsn"), mustGetString(cmd, "reference-schema"), graphnode.Definition)
+	if err != nil {
+		return fmt.Errorf("reference: %w", err)
+	}
+	defer expected.Close()
+
+	tables, err := cmd.Flags().GetStringSlice("tables")
+	if err != nil {
+		return err
+	}
+	tolerance, err := cmd.Flags().GetFloat64("tolerance")
+	if err != nil {
+		return err
+	}
+	maxDifferences, err := cmd.Flags().GetInt("max-differences")
+	if err != nil {
+		return err
+	}
+
+	reports, err := diff.Stores(ctx, expected, actual, graphnode.Definition.Entities, atBlock, diff.Options{Tolerance: tolerance, Tables: tables})
+	if err != nil {
+		return fmt.Errorf("comparing stores: %w", err)
+	}
+
+	differingTables := 0
+	for _, report := range reports {
+		printTableReport(report, maxDifferences)
+		if !report.Identical() {
+			differingTables++
+		}
+	}
+
+	fmt.Printf("\n%-20s %10s %10s %10s %10s\n", "TABLE", "COMPARED", "MISSING", "EXTRA", "DIFFERING")
+	for _, report := range reports {
+		fmt.Printf("%-20s %10d %10d %10d %10d\n", report.Table, report.Compared, len(report.Missing), len(report.Extra), len(report.Differing))
+	}
+
+	if differingTables > 0 {
+		return fmt.Errorf("%d of %d tables differ at block %d", differingTables, len(reports), atBlock)
+	}
+	return nil
+}
+
+func printTableReport(report *diff.TableReport, maxDifferences int) {
+	if report.Identical() {
+		return
+	}
+
+	fmt.Printf("== %s\n", report.Table)
+	if len(report.Missing) > 0 {
+		fmt.Printf("missing: %s\n", strings.Join(limit(report.Missing, maxDifferences), ", "))
+	}
+	if len(report.Extra) > 0 {
+		fmt.Printf("extra: %s\n", strings.Join(limit(report.Extra, maxDifferences), ", "))
+	}
+
+	for i, entity := range report.Differing {
+		if maxDifferences > 0 && i >= maxDifferences {
+			fmt.Printf("... %d more differing entities\n", len(report.Differing)-i)
+			break
+		}
+		fmt.Printf("differing %s:\n", entity.ID)
+		for _, field := range entity.Fields {
+			fmt.Printf("  %s: expected %s, got %s\n", field.Field, field.Expected, field.Actual)
+		}
+	}
+}
+
+func limit(ids []string, max int) []string {
+	if max <= 0 || len(ids) <= max {
+		return ids
+	}
+	return append(ids[:max:max], fmt.Sprintf("... %d more", len(ids)-max))
+}
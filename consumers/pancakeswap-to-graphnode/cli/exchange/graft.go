@@ -0,0 +1,52 @@
+package exchange
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/postgres"
+)
+
+var graftCmd = &cobra.Command{
+	Use:          "graft",
+	Short:        "copy the entities of a deployment valid up to --block into a fresh schema, loading it then resumes at the next block",
+	RunE:         runGraft,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	graftCmd.Flags().String("pg-dsn", "", "dsn for postgres database")
+	graftCmd.Flags().String("source-schema", "", "schema of the deployment the entities are copied from")
+	graftCmd.Flags().String("target-schema", "", "schema the entities are copied to, created when it does not exist, its tables must be empty otherwise")
+	graftCmd.Flags().Uint64("block", 0, "last block copied, the target resumes at the next one")
+	graftCmd.Flags().String("block-id", "", "hash of --block, defaults to the block of the source cursor when it is at --block")
+	addPartitionFlags(graftCmd)
+	rootCmd.AddCommand(graftCmd)
+}
+
+func runGraft(cmd *cobra.Command, args []string) error {
+	block := mustGetUint64(cmd, "block")
+	target := mustGetString(cmd, "target-schema")
+
+	partitioning, err := partitioning(cmd)
+	if err != nil {
+		return err
+	}
+
+	_, err = postgres.Graft(cmd.Context(), zlog, mustGetString(cmd, "pg-dsn"), graphnode.Definition, postgres.GraftOptions{
+		SourceSchema: mustGetString(cmd, "source-schema"),
+		TargetSchema: target,
+		Block:        block,
+		BlockID:      mustGetString(cmd, "block-id"),
+		Partitioning: partitioning,
+	})
+	if err != nil {
+		return fmt.Errorf("grafting: %w", err)
+	}
+
+	fmt.Printf("Schema %s grafted at block %d\n", target, block)
+	fmt.Printf("Resume loading at block %d with: load-graphnode <manifest> --pg-schema %s\n", block+1, target)
+	return nil
+}
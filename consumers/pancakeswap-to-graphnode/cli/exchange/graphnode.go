@@ -1,18 +1,22 @@
 package exchange
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/streamingfast/bstream"
 	_ "github.com/streamingfast/sf-ethereum/types"
 	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/cdc"
 	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
-	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/postgres"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
 	"github.com/streamingfast/substreams/client"
 	"github.com/streamingfast/substreams/manifest"
 	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
-	"io"
+	"go.uber.org/zap"
 	"os"
+	"time"
 )
 
 // loadGraphNodeCmd represents the base command
@@ -22,12 +26,20 @@ var loadGraphNodeCmd = &cobra.Command{
 	RunE:         runLoadGraphnode,
 	Args:         cobra.ExactArgs(1),
 	SilenceUsage: true,
+	Long: `Run pcs sub graph and load database changes into postgress.
+
+When the store has a saved cursor, from a previous run or from 'exchange graft', loading
+resumes from it and --start-block is ignored. Use --resume=false to fail instead.`,
 }
 
 func init() {
-	loadGraphNodeCmd.Flags().Int64P("start-block", "s", -1, "Start block for blockchain firehose")
+	loadGraphNodeCmd.Flags().Int64P("start-block", "s", -1, "Start block for blockchain firehose, ignored when resuming from a saved cursor")
+	loadGraphNodeCmd.Flags().Bool("resume", true, "resume from the cursor saved in the store by a previous run or a graft, when false loading fails if a cursor is saved")
 	loadGraphNodeCmd.Flags().Uint64P("stop-block", "t", 0, "Stop block for blockchain firehose")
 	loadGraphNodeCmd.Flags().Bool("no-return-handler", false, "Avoid printing output for module")
+	loadGraphNodeCmd.Flags().Bool("dry-run", false, "Load entities in an in-memory store instead of postgres, nothing is persisted")
+	loadGraphNodeCmd.Flags().Int64("pipeline-buffer", 10, "number of blocks each processing stage can get ahead of the next one")
+	loadGraphNodeCmd.Flags().String("reference-check", "off", "what to do with references to missing entities in a block: off, warn (log them) or fail (stop before writing the block), checking loads every referenced entity")
 
 	loadGraphNodeCmd.Flags().String("firehose-endpoint", "api.streamingfast.io:443", "firehose GRPC endpoint")
 	loadGraphNodeCmd.Flags().String("substreams-api-key-envvar", "FIREHOSE_API_KEY", "name of variable containing firehose authentication token (JWT)")
@@ -35,10 +47,25 @@ func init() {
 	loadGraphNodeCmd.Flags().BoolP("plaintext", "p", false, "Establish GRPC connection in plaintext")
 
 	///postgres loader flags
-	loadGraphNodeCmd.Flags().String("pg-dsn", "", "dsn for postgres database")
+	loadGraphNodeCmd.Flags().String("pg-dsn", "", "dsn for postgres database, or sqlite://<path> to load into a local SQLite database")
 	loadGraphNodeCmd.Flags().String("pg-schema", "", "postgres schema name")
 	loadGraphNodeCmd.Flags().Bool("pg-disable-transactions", false, "disable postgres transactions for faster inserts")
 	loadGraphNodeCmd.Flags().String("pg-deployment", "", "subgraph deployment name")
+	loadGraphNodeCmd.Flags().StringSlice("pg-latest-tables", nil, "maintain a <table>_latest table holding the current version of each entity of these tables, keyed by id, once enabled a mirror is maintained by every later run")
+	loadGraphNodeCmd.Flags().Bool("force-module-change", false, "continue loading even if the db_out module hash differs from the one the existing data was loaded with")
+
+	///pruning flags
+	loadGraphNodeCmd.Flags().Uint64("prune-window", 0, "keep the full history of the last N blocks only, older versions are pruned in the background (0 keeps all history)")
+	loadGraphNodeCmd.Flags().Duration("prune-interval", time.Minute, "interval between two pruning runs")
+	loadGraphNodeCmd.Flags().Int64("prune-batch-size", 10000, "maximum number of versions deleted by a single pruning statement")
+
+	///change feed flags
+	loadGraphNodeCmd.Flags().String("cdc-dir", "cdc", "directory of the change feed journal and of the cursors of its sinks")
+	loadGraphNodeCmd.Flags().String("cdc-ndjson", "", "publish the entity changes as JSON lines appended to this file, - for stdout")
+	loadGraphNodeCmd.Flags().String("cdc-webhook", "", "publish the entity changes by POSTing them to this URL")
+	loadGraphNodeCmd.Flags().Int64("cdc-webhook-retries", 5, "number of times a failed webhook request is retried before backing off")
+	loadGraphNodeCmd.Flags().Duration("cdc-webhook-backoff", time.Second, "delay before the first retry of a failed webhook request, doubled on each retry")
+	loadGraphNodeCmd.Flags().String("cdc-grpc-listen", "", "publish the entity changes to the subscribers of a pcs.cdc.v1.Feed gRPC server listening on this address")
 	rootCmd.AddCommand(loadGraphNodeCmd)
 }
 
@@ -56,24 +83,88 @@ func runLoadGraphnode(cmd *cobra.Command, args []string) error {
 	transactionsDisabled := mustGetBool(cmd, "pg-disable-transactions")
 
 	subgraphDef := graphnode.Definition
+	blockMetrics := metrics.NewBlockMetrics()
+
+	var store storage.Store
+	if mustGetBool(cmd, "dry-run") {
+		zlog.Info("dry run, entities are loaded in memory only")
+		store = memory.New(zlog, subgraphDef.Entities)
+	} else {
+		store, err = newStore(dsn, schema, deployment, subgraphDef, blockMetrics, !transactionsDisabled)
+		if err != nil {
+			return err
+		}
+	}
+	defer store.Close()
 
-	storage, err := postgres.New(zlog, metrics.NewBlockMetrics(), dsn, schema, deployment, subgraphDef, map[string]bool{}, !transactionsDisabled)
+	manifestPath := args[0]
+	manifestReader := manifest.NewReader(manifestPath)
+	pkg, err := manifestReader.Read()
 	if err != nil {
-		return fmt.Errorf("creating postgres store: %w", err)
+		return fmt.Errorf("read manifest %q: %w", manifestPath, err)
 	}
 
-	err = storage.RegisterEntities()
+	// A saved cursor, from a previous run or a graft, takes precedence over --start-block
+	cursor, cursorBlock, err := cursorBlockNum(ctx, store)
 	if err != nil {
-		return fmt.Errorf("store: registaring entities:%w", err)
+		return err
+	}
+	if cursor != "" {
+		if !mustGetBool(cmd, "resume") {
+			return fmt.Errorf("a cursor at block %d is saved in the store, refusing to resume with --resume=false", cursorBlock)
+		}
+		if cmd.Flags().Changed("start-block") {
+			zlog.Warn("ignoring --start-block, resuming from saved cursor", zap.Int64("start_block", mustGetInt64(cmd, "start-block")), zap.Uint64("block_num", cursorBlock))
+		}
+		zlog.Info("resuming from saved cursor", zap.Uint64("block_num", cursorBlock))
 	}
 
-	loader := graphnode.NewLoader(storage, graphnode.Definition.Entities)
+	// Nothing is written to the store before the module it was loaded with is checked
+	if provenanceStore, ok := store.(storage.ProvenanceStore); ok {
+		sinceBlock := mustGetInt64(cmd, "start-block")
+		if cursor != "" {
+			sinceBlock = int64(cursorBlock + 1)
+		}
+		provenance, err := packageProvenance(pkg, "db_out", sinceBlock)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckProvenance(ctx, provenanceStore, provenance, mustGetBool(cmd, "force-module-change"), zlog); err != nil {
+			return err
+		}
+		zlog.Info("loading module", zap.Stringer("provenance", provenance))
+	}
 
-	manifestPath := args[0]
-	manifestReader := manifest.NewReader(manifestPath)
-	pkg, err := manifestReader.Read()
+	latestTables, err := cmd.Flags().GetStringSlice("pg-latest-tables")
 	if err != nil {
-		return fmt.Errorf("read manifest %q: %w", manifestPath, err)
+		return err
+	}
+	if len(latestTables) > 0 {
+		mirror, ok := store.(storage.LatestMirror)
+		if !ok {
+			return fmt.Errorf("store %T does not support latest tables", store)
+		}
+		if err := mirror.EnableLatestTables(ctx, latestTables); err != nil {
+			return fmt.Errorf("enabling latest tables: %w", err)
+		}
+	}
+
+	var pruneRunner *storage.PruneRunner
+	if window := mustGetUint64(cmd, "prune-window"); window > 0 {
+		pruner, ok := store.(storage.Pruner)
+		if !ok {
+			return fmt.Errorf("store %T does not support pruning", store)
+		}
+
+		pruneCtx, cancelPruning := context.WithCancel(ctx)
+		defer cancelPruning()
+
+		pruneRunner = storage.NewPruneRunner(pruner, window, mustGetDuration(cmd, "prune-interval"), int(mustGetInt64(cmd, "prune-batch-size")), zlog)
+		go func() {
+			if err := pruneRunner.Run(pruneCtx); err != nil {
+				zlog.Error("pruning stopped", zap.Error(err))
+			}
+		}()
 	}
 
 	ssClient, callOpts, err := client.NewSubstreamsClient(
@@ -86,26 +177,79 @@ func runLoadGraphnode(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("substreams client setup: %w", err)
 	}
 
+	referenceCheck, err := graphnode.ParseReferenceCheck(mustGetString(cmd, "reference-check"))
+	if err != nil {
+		return err
+	}
+
+	loader := graphnode.NewLoader(store, graphnode.Definition.Entities)
+
+	feed, err := newChangeFeed(cmd, cursorBlock)
+	if err != nil {
+		return err
+	}
+	if feed != nil {
+		feedCtx, stopFeed := context.WithCancel(ctx)
+		defer func() {
+			stopFeed()
+			if err := feed.Close(); err != nil {
+				zlog.Warn("closing change feed", zap.Error(err))
+			}
+		}()
+		feed.Start(feedCtx)
+
+		loader = graphnode.NewLoader(cdc.NewStore(store, feed), graphnode.Definition.Entities)
+		loader.SetChangeFeed(feed)
+	}
+	loader.SetReferenceCheck(referenceCheck)
+
 	req := &pbsubstreams.Request{
 		StartBlockNum: mustGetInt64(cmd, "start-block"),
+		StartCursor:   cursor,
 		StopBlockNum:  mustGetUint64(cmd, "stop-block"),
 		ForkSteps:     []pbsubstreams.ForkStep{pbsubstreams.ForkStep_STEP_IRREVERSIBLE},
 		Modules:       pkg.Modules,
 		OutputModules: []string{"db_out", "pairs", "totals"},
 	}
 
-	stream, err := ssClient.Blocks(ctx, req, callOpts...)
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+
+	stream, err := ssClient.Blocks(streamCtx, req, callOpts...)
 	if err != nil {
 		return fmt.Errorf("call sf.substreams.v1.Stream/Blocks: %w", err)
 	}
 
+	// Only read once the pipeline is done
+	var lastSaved *pbsubstreams.Clock
+	pipeline := graphnode.NewPipeline(loader, blockMetrics, int(mustGetInt64(cmd, "pipeline-buffer")), func(clock *pbsubstreams.Clock) {
+		lastSaved = clock
+		if pruneRunner != nil {
+			pruneRunner.SetHead(clock.Number)
+		}
+	})
+
+	if err := pipeline.Run(ctx, &streamSource{stream: stream, stop: stopStream}); err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		logShutdown(lastSaved)
+	}
+	return nil
+}
+
+// streamSource feeds the pipeline with the `db_out` output of the blocks received.
+type streamSource struct {
+	stream pbsubstreams.Stream_BlocksClient
+	stop   func()
+}
+
+func (s *streamSource) Next() (*graphnode.StreamBlock, error) {
 	for {
-		resp, err := stream.Recv()
+		resp, err := s.stream.Recv()
 		if err != nil {
-			if err == io.EOF {
-				return nil
-			}
-			return err
+			return nil, err
 		}
 
 		switch r := resp.Message.(type) {
@@ -116,17 +260,108 @@ func runLoadGraphnode(cmd *cobra.Command, args []string) error {
 		case *pbsubstreams.Response_SnapshotComplete:
 			_ = r.SnapshotComplete
 		case *pbsubstreams.Response_Data:
-
+			var block *graphnode.StreamBlock
 			for _, output := range r.Data.Outputs {
 				for _, log := range output.Logs {
-					fmt.Println("LOG: ", log)
+					zlog.Debug("module log", zap.String("module_name", output.Name), zap.String("log", log))
 				}
 				if output.Name == "db_out" {
-					if err := loader.ReturnHandler(output.GetMapOutput().GetValue(), r.Data.Step, r.Data.Cursor, r.Data.Clock); err != nil {
-						fmt.Printf("RETURN HANDLER ERROR: %s\n", err)
+					block = &graphnode.StreamBlock{
+						Data:   output.GetMapOutput().GetValue(),
+						Step:   r.Data.Step,
+						Cursor: r.Data.Cursor,
+						Clock:  r.Data.Clock,
 					}
 				}
 			}
+			if block != nil {
+				return block, nil
+			}
+		}
+	}
+}
+
+func (s *streamSource) Stop() {
+	s.stop()
+}
+
+// packageProvenance identifies `moduleName` of `pkg` by its hash, which covers its code,
+// initial block and every module it depends on. A negative `sinceBlock` is the initial
+// block of the module.
+func packageProvenance(pkg *pbsubstreams.Package, moduleName string, sinceBlock int64) (*storage.Provenance, error) {
+	graph, err := manifest.NewModuleGraph(pkg.Modules.Modules)
+	if err != nil {
+		return nil, fmt.Errorf("creating module graph: %w", err)
+	}
+
+	var module *pbsubstreams.Module
+	for _, m := range pkg.Modules.Modules {
+		if m.Name == moduleName {
+			module = m
 		}
 	}
+	if module == nil {
+		return nil, fmt.Errorf("module %q not found in package", moduleName)
+	}
+
+	provenance := &storage.Provenance{
+		ModuleName: moduleName,
+		ModuleHash: manifest.HashModuleAsString(pkg.Modules, graph, module),
+		StartBlock: module.InitialBlock,
+		SinceBlock: module.InitialBlock,
+	}
+	if sinceBlock >= 0 {
+		provenance.SinceBlock = uint64(sinceBlock)
+	}
+	if len(pkg.PackageMeta) > 0 {
+		provenance.PackageName = pkg.PackageMeta[0].Name
+		provenance.PackageVersion = pkg.PackageMeta[0].Version
+	}
+	return provenance, nil
+}
+
+// newChangeFeed opens the change feed with the sinks requested, nil when there is none.
+func newChangeFeed(cmd *cobra.Command, cursorBlock uint64) (*cdc.Feed, error) {
+	sinks := map[string]func() (cdc.Sink, error){}
+	if path := mustGetString(cmd, "cdc-ndjson"); path != "" {
+		sinks["ndjson"] = func() (cdc.Sink, error) { return cdc.NewNDJSONSink(path) }
+	}
+	if url := mustGetString(cmd, "cdc-webhook"); url != "" {
+		sinks["webhook"] = func() (cdc.Sink, error) {
+			return cdc.NewWebhookSink(url, int(mustGetInt64(cmd, "cdc-webhook-retries")), mustGetDuration(cmd, "cdc-webhook-backoff")), nil
+		}
+	}
+	if addr := mustGetString(cmd, "cdc-grpc-listen"); addr != "" {
+		sinks["grpc"] = func() (cdc.Sink, error) { return cdc.NewGRPCSink(addr, zlog) }
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	feed, err := cdc.Open(mustGetString(cmd, "cdc-dir"), cursorBlock, zlog)
+	if err != nil {
+		return nil, fmt.Errorf("opening change feed: %w", err)
+	}
+	for name, newSink := range sinks {
+		sink, err := newSink()
+		if err != nil {
+			feed.Close()
+			return nil, fmt.Errorf("creating %s sink: %w", name, err)
+		}
+		if err := feed.AddSink(name, sink); err != nil {
+			sink.Close()
+			feed.Close()
+			return nil, err
+		}
+		zlog.Info("publishing entity changes", zap.String("sink", name))
+	}
+	return feed, nil
+}
+
+func logShutdown(lastSaved *pbsubstreams.Clock) {
+	if lastSaved == nil {
+		zlog.Info("shutdown complete, no block was saved during this run")
+		return
+	}
+	zlog.Info("shutdown complete, cursor saved with the last persisted block", zap.Uint64("block_num", lastSaved.Number), zap.String("block_id", lastSaved.Id))
 }
@@ -214,7 +214,7 @@ type Pair @entity {
   swaps: [Swap!]! @derivedFrom(field: "pair")
 }
 
-type Transaction @entity @cache(skip_db_lookup: true) {
+type Transaction @entity(immutable: true) @cache(skip_db_lookup: true) {
   id: ID!
 
   block: BigInt! @parallel(step: 4)
@@ -226,7 +226,7 @@ type Transaction @entity @cache(skip_db_lookup: true) {
   swaps: [Swap]!
 }
 
-type Mint @entity {
+type Mint @entity(immutable: true) {
   # transaction hash + "-" + index in mints Transaction array
   id: ID!
   transaction: Transaction! @parallel(step: 4)
@@ -252,7 +252,7 @@ type Mint @entity {
   feeLiquidity: BigDecimal @parallel(step: 4)
 }
 
-type Burn @entity {
+type Burn @entity(immutable: true) {
   # transaction hash + "-" + index in mints Transaction array
   id: ID!
   transaction: Transaction! @parallel(step: 4)
@@ -281,7 +281,7 @@ type Burn @entity {
   feeLiquidity: BigDecimal @parallel(step: 4)
 }
 
-type Swap @entity {
+type Swap @entity(immutable: true) {
   # transaction hash + "-" + index in swaps Transaction array
   id: ID!
   transaction: Transaction!  @parallel(step: 4)
@@ -1163,6 +1163,10 @@ func (f *PancakeFactory) Default() {
 func (_ *PancakeFactory) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *PancakeFactory) Immutable() bool {
+	return false
+}
 func (next *PancakeFactory) Merge(step int, cached *PancakeFactory) {
 	if step == 2 {
 		next.TotalPairs = graphnode.IntAdd(next.TotalPairs, cached.TotalPairs)
@@ -1200,6 +1204,10 @@ func (b *Bundle) Default() {
 func (_ *Bundle) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Bundle) Immutable() bool {
+	return false
+}
 func (next *Bundle) Merge(step int, cached *Bundle) {
 	if step == 5 {
 		if next.MutatedOnStep != 4 {
@@ -1248,6 +1256,10 @@ func (t *Token) Default() {
 func (_ *Token) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Token) Immutable() bool {
+	return false
+}
 func (next *Token) Merge(step int, cached *Token) {
 	if step == 2 {
 		if next.MutatedOnStep != 1 {
@@ -1338,6 +1350,10 @@ func (p *Pair) Default() {
 func (_ *Pair) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Pair) Immutable() bool {
+	return false
+}
 func (next *Pair) Merge(step int, cached *Pair) {
 	if step == 2 {
 		if next.MutatedOnStep != 1 {
@@ -1398,6 +1414,10 @@ func (t *Transaction) Default() {
 func (_ *Transaction) SkipDBLookup() bool {
 	return true
 }
+
+func (_ *Transaction) Immutable() bool {
+	return true
+}
 func (next *Transaction) Merge(step int, cached *Transaction) {
 	if step == 5 {
 		if next.MutatedOnStep != 4 {
@@ -1442,6 +1462,10 @@ func (m *Mint) Default() {
 func (_ *Mint) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Mint) Immutable() bool {
+	return true
+}
 func (next *Mint) Merge(step int, cached *Mint) {
 	if step == 5 {
 		if next.MutatedOnStep != 4 {
@@ -1499,6 +1523,10 @@ func (b *Burn) Default() {
 func (_ *Burn) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Burn) Immutable() bool {
+	return true
+}
 func (next *Burn) Merge(step int, cached *Burn) {
 	if step == 5 {
 		if next.MutatedOnStep != 4 {
@@ -1563,6 +1591,10 @@ func (s *Swap) Default() {
 func (_ *Swap) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *Swap) Immutable() bool {
+	return true
+}
 func (next *Swap) Merge(step int, cached *Swap) {
 	if step == 5 {
 		if next.MutatedOnStep != 4 {
@@ -1627,6 +1659,10 @@ func (d *PancakeDayData) Default() {
 func (_ *PancakeDayData) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *PancakeDayData) Immutable() bool {
+	return false
+}
 func (next *PancakeDayData) Merge(step int, cached *PancakeDayData) {
 	if step == 5 {
 		next.DailyVolumeBNB = graphnode.FloatAdd(next.DailyVolumeBNB, cached.DailyVolumeBNB)
@@ -1687,6 +1723,10 @@ func (d *PairHourData) Default() {
 func (_ *PairHourData) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *PairHourData) Immutable() bool {
+	return false
+}
 func (next *PairHourData) Merge(step int, cached *PairHourData) {
 	if step == 5 {
 		next.TotalSupply = graphnode.FloatAdd(next.TotalSupply, cached.TotalSupply)
@@ -1749,6 +1789,10 @@ func (d *PairDayData) Default() {
 func (_ *PairDayData) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *PairDayData) Immutable() bool {
+	return false
+}
 func (next *PairDayData) Merge(step int, cached *PairDayData) {
 	if step == 5 {
 		next.TotalSupply = graphnode.FloatAdd(next.TotalSupply, cached.TotalSupply)
@@ -1812,6 +1856,10 @@ func (d *TokenDayData) Default() {
 func (_ *TokenDayData) SkipDBLookup() bool {
 	return false
 }
+
+func (_ *TokenDayData) Immutable() bool {
+	return false
+}
 func (next *TokenDayData) Merge(step int, cached *TokenDayData) {
 	if step == 5 {
 		next.DailyVolumeToken = graphnode.FloatAdd(next.DailyVolumeToken, cached.DailyVolumeToken)
@@ -2011,8 +2059,8 @@ create table if not exists %%SCHEMA%%.transaction
 	"swaps" text[],
 
 	vid bigserial not null constraint transaction_pkey primary key,
-	block_range int4range not null,
-	_updated_block_number numeric not null
+	block$ int not null,
+	constraint transaction_id_key unique (id)
 );
 
 alter table %%SCHEMA%%.transaction owner to graph;
@@ -2054,8 +2102,8 @@ create table if not exists %%SCHEMA%%.mint
 	"fee_liquidity" numeric,
 
 	vid bigserial not null constraint mint_pkey primary key,
-	block_range int4range not null,
-	_updated_block_number numeric not null
+	block$ int not null,
+	constraint mint_id_key unique (id)
 );
 
 alter table %%SCHEMA%%.mint owner to graph;
@@ -2099,8 +2147,8 @@ create table if not exists %%SCHEMA%%.burn
 	"fee_liquidity" numeric,
 
 	vid bigserial not null constraint burn_pkey primary key,
-	block_range int4range not null,
-	_updated_block_number numeric not null
+	block$ int not null,
+	constraint burn_id_key unique (id)
 );
 
 alter table %%SCHEMA%%.burn owner to graph;
@@ -2142,8 +2190,8 @@ create table if not exists %%SCHEMA%%.swap
 	"amount_usd" numeric not null,
 
 	vid bigserial not null constraint swap_pkey primary key,
-	block_range int4range not null,
-	_updated_block_number numeric not null
+	block$ int not null,
+	constraint swap_id_key unique (id)
 );
 
 alter table %%SCHEMA%%.swap owner to graph;
@@ -2548,21 +2596,8 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 	ddl.indexes["transaction"] = func() []*index {
 		var indexes []*index
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists transaction_block_range_closed on %%SCHEMA%%.transaction (COALESCE(upper(block_range), 2147483647)) where (COALESCE(upper(block_range), 2147483647) < 2147483647);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.transaction_block_range_closed;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists transaction_id on %%SCHEMA%%.transaction (id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.transaction_id;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists transaction_updated_block_number on %%SCHEMA%%.transaction (_updated_block_number);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.transaction_updated_block_number;`,
-		})
-
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists transaction_id_block_range_fake_excl on %%SCHEMA%%.transaction using gist (block_range, id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.transaction_id_block_range_fake_excl;`,
+			createStatement: `create index if not exists transaction_block$ on %%SCHEMA%%.transaction (block$);`,
+			dropStatement:   `drop index if exists %%SCHEMA%%.transaction_block$;`,
 		})
 
 		indexes = append(indexes, &index{
@@ -2596,25 +2631,12 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 	ddl.indexes["mint"] = func() []*index {
 		var indexes []*index
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_block_range_closed on %%SCHEMA%%.mint (COALESCE(upper(block_range), 2147483647)) where (COALESCE(upper(block_range), 2147483647) < 2147483647);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.mint_block_range_closed;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_id on %%SCHEMA%%.mint (id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.mint_id;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_updated_block_number on %%SCHEMA%%.mint (_updated_block_number);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.mint_updated_block_number;`,
+			createStatement: `create index if not exists mint_block$ on %%SCHEMA%%.mint (block$);`,
+			dropStatement:   `drop index if exists %%SCHEMA%%.mint_block$;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_id_block_range_fake_excl on %%SCHEMA%%.mint using gist (block_range, id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.mint_id_block_range_fake_excl;`,
-		})
-
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_transaction on %%SCHEMA%%.mint using gist ("transaction", block_range);`,
+			createStatement: `create index if not exists mint_transaction on %%SCHEMA%%.mint using btree ("transaction", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.mint_transaction;`,
 		})
 
@@ -2624,17 +2646,17 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_pair on %%SCHEMA%%.mint using gist ("pair", block_range);`,
+			createStatement: `create index if not exists mint_pair on %%SCHEMA%%.mint using btree ("pair", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.mint_pair;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_token_0 on %%SCHEMA%%.mint using gist ("token_0", block_range);`,
+			createStatement: `create index if not exists mint_token_0 on %%SCHEMA%%.mint using btree ("token_0", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.mint_token_0;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists mint_token_1 on %%SCHEMA%%.mint using gist ("token_1", block_range);`,
+			createStatement: `create index if not exists mint_token_1 on %%SCHEMA%%.mint using btree ("token_1", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.mint_token_1;`,
 		})
 
@@ -2689,25 +2711,12 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 	ddl.indexes["burn"] = func() []*index {
 		var indexes []*index
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_block_range_closed on %%SCHEMA%%.burn (COALESCE(upper(block_range), 2147483647)) where (COALESCE(upper(block_range), 2147483647) < 2147483647);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.burn_block_range_closed;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_id on %%SCHEMA%%.burn (id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.burn_id;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_updated_block_number on %%SCHEMA%%.burn (_updated_block_number);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.burn_updated_block_number;`,
-		})
-
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_id_block_range_fake_excl on %%SCHEMA%%.burn using gist (block_range, id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.burn_id_block_range_fake_excl;`,
+			createStatement: `create index if not exists burn_block$ on %%SCHEMA%%.burn (block$);`,
+			dropStatement:   `drop index if exists %%SCHEMA%%.burn_block$;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_transaction on %%SCHEMA%%.burn using gist ("transaction", block_range);`,
+			createStatement: `create index if not exists burn_transaction on %%SCHEMA%%.burn using btree ("transaction", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.burn_transaction;`,
 		})
 
@@ -2717,17 +2726,17 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_pair on %%SCHEMA%%.burn using gist ("pair", block_range);`,
+			createStatement: `create index if not exists burn_pair on %%SCHEMA%%.burn using btree ("pair", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.burn_pair;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_token_0 on %%SCHEMA%%.burn using gist ("token_0", block_range);`,
+			createStatement: `create index if not exists burn_token_0 on %%SCHEMA%%.burn using btree ("token_0", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.burn_token_0;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists burn_token_1 on %%SCHEMA%%.burn using gist ("token_1", block_range);`,
+			createStatement: `create index if not exists burn_token_1 on %%SCHEMA%%.burn using btree ("token_1", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.burn_token_1;`,
 		})
 
@@ -2787,25 +2796,12 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 	ddl.indexes["swap"] = func() []*index {
 		var indexes []*index
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_block_range_closed on %%SCHEMA%%.swap (COALESCE(upper(block_range), 2147483647)) where (COALESCE(upper(block_range), 2147483647) < 2147483647);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.swap_block_range_closed;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_id on %%SCHEMA%%.swap (id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.swap_id;`,
-		})
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_updated_block_number on %%SCHEMA%%.swap (_updated_block_number);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.swap_updated_block_number;`,
-		})
-
-		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_id_block_range_fake_excl on %%SCHEMA%%.swap using gist (block_range, id);`,
-			dropStatement:   `drop index if exists %%SCHEMA%%.swap_id_block_range_fake_excl;`,
+			createStatement: `create index if not exists swap_block$ on %%SCHEMA%%.swap (block$);`,
+			dropStatement:   `drop index if exists %%SCHEMA%%.swap_block$;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_transaction on %%SCHEMA%%.swap using gist ("transaction", block_range);`,
+			createStatement: `create index if not exists swap_transaction on %%SCHEMA%%.swap using btree ("transaction", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.swap_transaction;`,
 		})
 
@@ -2815,17 +2811,17 @@ alter table only %%SCHEMA%%.token_day_data alter column vid SET DEFAULT nextval(
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_pair on %%SCHEMA%%.swap using gist ("pair", block_range);`,
+			createStatement: `create index if not exists swap_pair on %%SCHEMA%%.swap using btree ("pair", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.swap_pair;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_token_0 on %%SCHEMA%%.swap using gist ("token_0", block_range);`,
+			createStatement: `create index if not exists swap_token_0 on %%SCHEMA%%.swap using btree ("token_0", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.swap_token_0;`,
 		})
 
 		indexes = append(indexes, &index{
-			createStatement: `create index if not exists swap_token_1 on %%SCHEMA%%.swap using gist ("token_1", block_range);`,
+			createStatement: `create index if not exists swap_token_1 on %%SCHEMA%%.swap using btree ("token_1", block$);`,
 			dropStatement:   `drop index if exists %%SCHEMA%%.swap_token_1;`,
 		})
 
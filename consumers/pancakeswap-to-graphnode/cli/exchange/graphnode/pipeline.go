@@ -0,0 +1,209 @@
+package graphnode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const metricsLogInterval = 30 * time.Second
+
+// StreamBlock is the `db_out` output of a block along with its position in the stream.
+type StreamBlock struct {
+	Data   []byte
+	Step   pbsubstreams.ForkStep
+	Cursor string
+	Clock  *pbsubstreams.Clock
+}
+
+// BlockSource is the stream of blocks feeding the pipeline.
+type BlockSource interface {
+	// Next blocks until the next block is received, it returns `io.EOF` once the stream ends.
+	Next() (*StreamBlock, error)
+	// Stop interrupts a blocked `Next`, it is called when a stage fails.
+	Stop()
+}
+
+type decodedBlock struct {
+	changes *database.DatabaseChanges
+	block   *StreamBlock
+}
+
+// Pipeline overlaps the processing of consecutive blocks: a receiver, a decoder (unmarshal
+// and squash), a resolver (entity loads and changes) and a single writer run concurrently,
+// linked by bounded channels so that a slow stage holds back the ones before it. Each stage
+// handles the blocks in stream order and the writer saves them, with their cursor, one
+// after the other.
+type Pipeline struct {
+	loader     *Loader
+	metrics    *metrics.BlockMetrics
+	bufferSize int
+	onWritten  func(clock *pbsubstreams.Clock)
+}
+
+func NewPipeline(loader *Loader, blockMetrics *metrics.BlockMetrics, bufferSize int, onWritten func(clock *pbsubstreams.Clock)) *Pipeline {
+	return &Pipeline{
+		loader:     loader,
+		metrics:    blockMetrics,
+		bufferSize: bufferSize,
+		onWritten:  onWritten,
+	}
+}
+
+// Run processes the blocks of `source` until it ends, a stage fails or `ctx` is cancelled.
+// On cancellation the blocks not written yet are dropped, the in-flight write completes
+// within the store's grace period, and nil is returned: the dropped blocks are streamed
+// again from the saved cursor.
+func (p *Pipeline) Run(ctx context.Context, source BlockSource) error {
+	group, groupCtx := errgroup.WithContext(ctx)
+	go func() {
+		<-groupCtx.Done()
+		source.Stop()
+	}()
+
+	received := make(chan *StreamBlock, p.bufferSize)
+	decoded := make(chan *decodedBlock, p.bufferSize)
+	resolved := make(chan *ResolvedBlock, p.bufferSize)
+
+	group.Go(func() error {
+		defer close(received)
+		return p.receive(groupCtx, source, received)
+	})
+	group.Go(func() error {
+		defer close(decoded)
+		return p.decode(groupCtx, received, decoded)
+	})
+	group.Go(func() error {
+		defer close(resolved)
+		return p.resolve(ctx, groupCtx, decoded, resolved)
+	})
+	group.Go(func() error {
+		return p.write(ctx, groupCtx, resolved)
+	})
+
+	return group.Wait()
+}
+
+func (p *Pipeline) receive(groupCtx context.Context, source BlockSource, out chan<- *StreamBlock) error {
+	for {
+		start := time.Now()
+		block, err := source.Next()
+		if err != nil {
+			if err == io.EOF || groupCtx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receiving block: %w", err)
+		}
+		p.record(func(e *metrics.ExecutionTime) { e.WaitForBlock += time.Since(start) })
+
+		select {
+		case out <- block:
+		case <-groupCtx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *Pipeline) decode(groupCtx context.Context, in <-chan *StreamBlock, out chan<- *decodedBlock) error {
+	for block := range in {
+		if groupCtx.Err() != nil {
+			continue // draining
+		}
+
+		start := time.Now()
+		changes, err := p.loader.Decode(block.Data)
+		if err != nil {
+			return fmt.Errorf("decoding block %d: %w", block.Clock.Number, err)
+		}
+		p.record(func(e *metrics.ExecutionTime) { e.UnmarshalBlock += time.Since(start) })
+
+		select {
+		case out <- &decodedBlock{changes: changes, block: block}:
+		case <-groupCtx.Done():
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) resolve(ctx, groupCtx context.Context, in <-chan *decodedBlock, out chan<- *ResolvedBlock) error {
+	for decoded := range in {
+		if groupCtx.Err() != nil {
+			continue // draining
+		}
+
+		start := time.Now()
+		resolved, err := p.loader.Resolve(ctx, decoded.changes, decoded.block.Cursor, decoded.block.Clock)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue // shutting down, the block is dropped
+			}
+			return fmt.Errorf("resolving block %d: %w", decoded.block.Clock.Number, err)
+		}
+		p.record(func(e *metrics.ExecutionTime) {
+			e.BlockProc += time.Since(start)
+			e.StoreSkipped += int64(resolved.Skipped)
+			e.DanglingReferences += int64(len(resolved.Dangling))
+		})
+
+		select {
+		case out <- resolved:
+		case <-groupCtx.Done():
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) write(ctx, groupCtx context.Context, in <-chan *ResolvedBlock) error {
+	lastWrite := time.Now()
+	lastLog := time.Now()
+	var blockCount int
+	p.metrics.BlockRate.Clean()
+
+	for block := range in {
+		if groupCtx.Err() != nil {
+			continue // draining
+		}
+
+		start := time.Now()
+		if err := p.loader.Write(ctx, block); err != nil {
+			if ctx.Err() != nil {
+				zlog.Warn("block not saved while shutting down", zap.Uint64("block_num", block.Clock.Number), zap.Error(err))
+				continue
+			}
+			return fmt.Errorf("writing block %d: %w", block.Clock.Number, err)
+		}
+		p.record(func(e *metrics.ExecutionTime) { e.StoreFlush += time.Since(start) })
+
+		// The stages overlap, the total is the wall time between two writes
+		p.metrics.Exec.Finalize(time.Since(lastWrite))
+		lastWrite = time.Now()
+		p.metrics.LastBlockRef = bstream.NewBlockRef(block.Clock.Id, block.Clock.Number)
+		p.metrics.BlockRate.Inc()
+		blockCount++
+
+		if p.onWritten != nil {
+			p.onWritten(block.Clock)
+		}
+
+		if time.Since(lastLog) > metricsLogInterval {
+			zlog.Info("pipeline metrics", zap.Object("metrics", p.metrics), zap.Int("block_count", blockCount))
+			p.metrics.Exec.Clean()
+			p.metrics.BlockRate.Clean()
+			lastLog = time.Now()
+			blockCount = 0
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) record(update func(e *metrics.ExecutionTime)) {
+	p.metrics.Exec.Record(update)
+}
@@ -0,0 +1,232 @@
+package graphnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type sliceSource struct {
+	blocks []*StreamBlock
+}
+
+func (s *sliceSource) Next() (*StreamBlock, error) {
+	if len(s.blocks) == 0 {
+		return nil, io.EOF
+	}
+	block := s.blocks[0]
+	s.blocks = s.blocks[1:]
+	return block, nil
+}
+
+func (s *sliceSource) Stop() {}
+
+// slowStore makes every save slow so that the resolver gets ahead of the writer.
+type slowStore struct {
+	storage.Store
+	failAt uint64
+}
+
+func (s *slowStore) BatchSave(ctx context.Context, blockNum uint64, blockHash string, blockTime time.Time, updates map[string]map[string]graphnode.Entity, cursor string) error {
+	time.Sleep(2 * time.Millisecond)
+	if blockNum == s.failAt {
+		return errors.New("boom")
+	}
+	return s.Store.BatchSave(ctx, blockNum, blockHash, blockTime, updates, cursor)
+}
+
+func streamBlock(t *testing.T, blockNum uint64, changes ...*database.TableChange) *StreamBlock {
+	data, err := proto.Marshal(&database.DatabaseChanges{TableChanges: changes})
+	require.NoError(t, err)
+
+	return &StreamBlock{
+		Data:   data,
+		Step:   pbsubstreams.ForkStep_STEP_IRREVERSIBLE,
+		Cursor: fmt.Sprintf("cursor:%d", blockNum),
+		Clock:  &pbsubstreams.Clock{Id: fmt.Sprintf("block-%d", blockNum), Number: blockNum, Timestamp: timestamppb.New(time.Unix(int64(blockNum), 0))},
+	}
+}
+
+func amountChange(blockNum uint64, id string, ordinal uint64, amount uint64) *database.TableChange {
+	return &database.TableChange{
+		Table:     "test_entity",
+		Pk:        id,
+		BlockNum:  blockNum,
+		Ordinal:   ordinal,
+		Operation: database.TableChange_UPDATE,
+		Fields:    []*database.Field{{Name: "amount", NewValue: fmt.Sprintf("%d", amount)}},
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+
+	// Every block updates `a`, so each block depends on the previous one not written yet
+	var blocks []*StreamBlock
+	for blockNum := uint64(10); blockNum < 40; blockNum++ {
+		blocks = append(blocks, streamBlock(t, blockNum,
+			amountChange(blockNum, "a", 1, blockNum),
+			amountChange(blockNum, fmt.Sprintf("b%d", blockNum), 2, 1),
+		))
+	}
+
+	var written []uint64
+	pipeline := NewPipeline(NewLoader(&slowStore{Store: store}, storagetest.Registry()), metrics.NewBlockMetrics(), 4, func(clock *pbsubstreams.Clock) {
+		written = append(written, clock.Number)
+	})
+	require.NoError(t, pipeline.Run(ctx, &sliceSource{blocks: blocks}))
+
+	require.Len(t, written, 30)
+	for i, blockNum := range written {
+		assert.Equal(t, uint64(10+i), blockNum)
+	}
+
+	cursor, err := store.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cursor:39", cursor)
+
+	for blockNum := uint64(10); blockNum < 40; blockNum++ {
+		entities, err := store.LoadAllDistinct(ctx, &storagetest.TestEntity{}, blockNum)
+		require.NoError(t, err)
+
+		var a *storagetest.TestEntity
+		for _, ent := range entities {
+			if ent.GetID() == "a" {
+				a = ent.(*storagetest.TestEntity)
+			}
+		}
+		require.NotNil(t, a, "block %d", blockNum)
+		assert.Equal(t, fmt.Sprintf("%d", blockNum), a.Amount.String(), "block %d", blockNum)
+		assert.Equal(t, blockNum, a.BlockRange.StartBlock, "block %d", blockNum)
+		assert.Len(t, entities, int(blockNum-10)+2, "block %d", blockNum)
+	}
+}
+
+func TestPipeline_WriteFailure(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+
+	var blocks []*StreamBlock
+	for blockNum := uint64(10); blockNum < 20; blockNum++ {
+		blocks = append(blocks, streamBlock(t, blockNum, amountChange(blockNum, "a", 1, blockNum)))
+	}
+
+	pipeline := NewPipeline(NewLoader(&slowStore{Store: store, failAt: 13}, storagetest.Registry()), metrics.NewBlockMetrics(), 2, nil)
+	err := pipeline.Run(ctx, &sliceSource{blocks: blocks})
+	assert.EqualError(t, err, "writing block 13: flushing block changes: boom")
+
+	cursor, err := store.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cursor:12", cursor)
+}
+
+func TestPipeline_SkipUnchanged(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+
+	blocks := []*StreamBlock{
+		streamBlock(t, 10, amountChange(10, "a", 1, 1)),
+		streamBlock(t, 11, amountChange(11, "a", 1, 1)),
+		streamBlock(t, 12, amountChange(12, "a", 1, 2)),
+		streamBlock(t, 13, amountChange(13, "a", 1, 2), amountChange(13, "b", 2, 2)),
+	}
+
+	blockMetrics := metrics.NewBlockMetrics()
+	pipeline := NewPipeline(NewLoader(store, storagetest.Registry()), blockMetrics, 2, nil)
+	require.NoError(t, pipeline.Run(ctx, &sliceSource{blocks: blocks}))
+
+	history, err := store.LoadHistory(ctx, "a", &storagetest.TestEntity{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, uint64(10), history[0].GetBlockRange().StartBlock)
+	assert.Equal(t, uint64(12), history[0].GetBlockRange().EndBlock)
+	assert.Equal(t, uint64(12), history[1].GetBlockRange().StartBlock)
+
+	cursor, err := store.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cursor:13", cursor)
+	assert.Equal(t, int64(2), blockMetrics.Exec.StoreSkipped)
+}
+
+func TestPipeline_Recreate(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+
+	created := &database.TableChange{Table: "test_entity", Pk: "a", BlockNum: 10, Ordinal: 1, Operation: database.TableChange_CREATE, Fields: []*database.Field{
+		{Name: "name", NewValue: "first"},
+		{Name: "amount", NewValue: "1"},
+	}}
+	deleted := &database.TableChange{Table: "test_entity", Pk: "a", BlockNum: 11, Ordinal: 1, Operation: database.TableChange_DELETE}
+	recreated := &database.TableChange{Table: "test_entity", Pk: "a", BlockNum: 11, Ordinal: 2, Operation: database.TableChange_CREATE, Fields: []*database.Field{
+		{Name: "amount", NewValue: "2"},
+	}}
+
+	blocks := []*StreamBlock{streamBlock(t, 10, created), streamBlock(t, 11, deleted, recreated)}
+	require.NoError(t, NewPipeline(NewLoader(store, storagetest.Registry()), metrics.NewBlockMetrics(), 2, nil).Run(ctx, &sliceSource{blocks: blocks}))
+
+	history, err := store.LoadHistory(ctx, "a", &storagetest.TestEntity{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "first", history[0].(*storagetest.TestEntity).Name)
+	assert.Equal(t, uint64(11), history[0].GetBlockRange().EndBlock)
+	assert.Equal(t, "", history[1].(*storagetest.TestEntity).Name, "fields of the deleted row must not survive")
+	assert.Equal(t, "2", history[1].(*storagetest.TestEntity).Amount.String())
+}
+
+type recordingFeed struct {
+	appended  map[uint64][]*database.TableChange
+	committed []uint64
+}
+
+func (f *recordingFeed) Append(changes []*database.TableChange, clock *pbsubstreams.Clock) error {
+	f.appended[clock.Number] = changes
+	return nil
+}
+
+func (f *recordingFeed) Commit(blockNum uint64) error {
+	f.committed = append(f.committed, blockNum)
+	return nil
+}
+
+func TestPipeline_ChangeFeed(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+
+	blocks := []*StreamBlock{
+		streamBlock(t, 10, amountChange(10, "a", 1, 1)),
+		streamBlock(t, 11, amountChange(11, "a", 1, 1), amountChange(11, "b", 2, 2)),
+		streamBlock(t, 12, amountChange(12, "a", 1, 2)),
+	}
+
+	feed := &recordingFeed{appended: map[uint64][]*database.TableChange{}}
+	loader := NewLoader(&slowStore{Store: store, failAt: 12}, storagetest.Registry())
+	loader.SetChangeFeed(feed)
+
+	err := NewPipeline(loader, metrics.NewBlockMetrics(), 2, nil).Run(ctx, &sliceSource{blocks: blocks})
+	require.Error(t, err)
+
+	require.Len(t, feed.appended, 3)
+	assert.Len(t, feed.appended[10], 1)
+	// The unchanged `a` is not published
+	require.Len(t, feed.appended[11], 1)
+	assert.Equal(t, "b", feed.appended[11][0].Pk)
+	// Block 12 failed to save, it is journaled but never committed
+	assert.Equal(t, []uint64{10, 11}, feed.committed)
+}
@@ -0,0 +1,219 @@
+package graphnode
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+)
+
+func init() {
+	// The relations between the entities are declared by the schema
+	if err := Definition.Entities.LoadSchema(Definition.GraphQLSchema); err != nil {
+		panic(fmt.Errorf("loading relations from schema: %w", err))
+	}
+}
+
+// ReferenceCheck tells what happens to the references of the entities written which
+// target an entity that does not exist.
+type ReferenceCheck int
+
+const (
+	ReferenceCheckOff ReferenceCheck = iota
+	// ReferenceCheckWarn logs the dangling references of each block
+	ReferenceCheckWarn
+	// ReferenceCheckFail stops before writing a block holding dangling references
+	ReferenceCheckFail
+)
+
+func ParseReferenceCheck(in string) (ReferenceCheck, error) {
+	switch in {
+	case "off":
+		return ReferenceCheckOff, nil
+	case "warn":
+		return ReferenceCheckWarn, nil
+	case "fail":
+		return ReferenceCheckFail, nil
+	}
+	return ReferenceCheckOff, fmt.Errorf("invalid reference check %q, expected off, warn or fail", in)
+}
+
+// SetReferenceCheck validates the references of the entities updated by each block once
+// resolved, the dangling ones are reported when the block is written.
+func (l *Loader) SetReferenceCheck(check ReferenceCheck) {
+	l.referenceCheck = check
+}
+
+// danglingReferences lists the references of the entities updated by the block being
+// resolved to entities which do not exist at `blockNum`. Only the entities written are
+// checked: an entity deleted while still referenced by an unchanged one goes unnoticed.
+func (l *Loader) danglingReferences(ctx context.Context, blockNum uint64) ([]*graphnode.DanglingReference, error) {
+	// Loading the targets adds their table to `l.updates`, the tables are listed beforehand
+	var tableNames []string
+	for tableName := range l.updates {
+		if len(l.registry.Relations(tableName)) != 0 {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+
+	var out []*graphnode.DanglingReference
+	for _, tableName := range tableNames {
+		relations := l.registry.Relations(tableName)
+		for id, ent := range l.updates[tableName] {
+			if ent == nil {
+				continue
+			}
+			for _, field := range relations {
+				if field.Kind == graphnode.DerivedField {
+					continue
+				}
+				for _, targetID := range field.IDs(ent) {
+					target, err := l.loadByID(ctx, field.TargetTable, targetID, blockNum)
+					if err != nil {
+						return nil, fmt.Errorf("loading %s %s referenced by %s %s: %w", field.TargetTable, targetID, tableName, id, err)
+					}
+					if target == nil {
+						out = append(out, &graphnode.DanglingReference{Table: tableName, ID: id, Field: field.Name, Target: field.TargetTable, TargetID: targetID})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out, nil
+}
+
+// LoadReference loads the entity referenced by the field `field` of `ent` as it is at
+// `blockNum`, through the cache of the block being resolved. It returns nil when the field
+// is empty or the entity does not exist.
+func (l *Loader) LoadReference(ctx context.Context, ent graphnode.Entity, field string, blockNum uint64) (graphnode.Entity, error) {
+	relation, err := l.relation(ent, field, graphnode.ReferenceField)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := relation.IDs(ent)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return l.loadByID(ctx, relation.TargetTable, ids[0], blockNum)
+}
+
+// LoadReferences loads the entities referenced by the list field `field` of `ent`, in the
+// order of the list. The entities which do not exist are left out.
+func (l *Loader) LoadReferences(ctx context.Context, ent graphnode.Entity, field string, blockNum uint64) ([]graphnode.Entity, error) {
+	relation, err := l.relation(ent, field, graphnode.ReferenceListField)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []graphnode.Entity
+	for _, id := range relation.IDs(ent) {
+		target, err := l.loadByID(ctx, relation.TargetTable, id, blockNum)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil {
+			out = append(out, target)
+		}
+	}
+	return out, nil
+}
+
+// LoadDerived loads the entities listed by the `@derivedFrom` field `field` of `ent`,
+// ordered by id. There is no index of the references: every entity of the target table
+// is read from the store, then overridden by the blocks not written yet.
+func (l *Loader) LoadDerived(ctx context.Context, ent graphnode.Entity, field string, blockNum uint64) ([]graphnode.Entity, error) {
+	relation, err := l.relation(ent, field, graphnode.DerivedField)
+	if err != nil {
+		return nil, err
+	}
+	target, _ := l.registry.SchemaEntity(relation.TargetTable)
+	from := target.Field(relation.DerivedFrom)
+
+	// The pending entities are read first: a block written in between is then in the store
+	l.pendingLock.Lock()
+	pending := map[string]graphnode.Entity{}
+	for id, p := range l.pending[relation.TargetTable] {
+		pending[id] = p.entity
+	}
+	l.pendingLock.Unlock()
+
+	model, _ := l.registry.GetInterface(relation.TargetTable)
+	stored, err := l.store.LoadAllDistinct(ctx, model, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s entities: %w", relation.TargetTable, err)
+	}
+
+	candidates := map[string]graphnode.Entity{}
+	for _, candidate := range stored {
+		candidates[candidate.GetID()] = candidate
+	}
+	for _, overrides := range []map[string]graphnode.Entity{pending, l.updates[relation.TargetTable]} {
+		for id, candidate := range overrides {
+			if candidate == nil {
+				delete(candidates, id)
+				continue
+			}
+			candidates[id] = candidate
+		}
+	}
+
+	var ids []string
+	for id, candidate := range candidates {
+		for _, referenced := range from.IDs(candidate) {
+			if referenced == ent.GetID() {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	out := make([]graphnode.Entity, 0, len(ids))
+	for _, id := range ids {
+		derived, err := l.loadByID(ctx, relation.TargetTable, id, blockNum)
+		if err != nil {
+			return nil, err
+		}
+		if derived != nil {
+			out = append(out, derived)
+		}
+	}
+	return out, nil
+}
+
+func (l *Loader) relation(ent graphnode.Entity, field string, kind graphnode.FieldKind) (*graphnode.SchemaField, error) {
+	tableName := graphnode.GetTableName(ent)
+	entity, found := l.registry.SchemaEntity(tableName)
+	if !found {
+		return nil, fmt.Errorf("table %s: no relations loaded from the schema", tableName)
+	}
+
+	relation := entity.Field(field)
+	if relation == nil || relation.Kind != kind {
+		return nil, fmt.Errorf("table %s: field %s is not a relation of the expected kind", tableName, field)
+	}
+	return relation, nil
+}
+
+// loadByID loads the entity `id` of `tableName` through the cache, nil when it does not exist.
+func (l *Loader) loadByID(ctx context.Context, tableName, id string, blockNum uint64) (graphnode.Entity, error) {
+	ent, found := l.registry.GetInterface(tableName)
+	if !found {
+		return nil, fmt.Errorf("unknown entity for table %s", tableName)
+	}
+	ent.SetID(id)
+
+	if err := l.load(ctx, ent, blockNum); err != nil {
+		return nil, err
+	}
+	if !ent.Exists() {
+		return nil, nil
+	}
+	return ent, nil
+}
@@ -0,0 +1,118 @@
+package graphnode
+
+import (
+	"context"
+	"testing"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func createChange(table, id string, fields map[string]string) *database.TableChange {
+	change := &database.TableChange{Table: table, Pk: id, Operation: database.TableChange_CREATE}
+	for name, value := range fields {
+		change.Fields = append(change.Fields, &database.Field{Name: name, NewValue: value})
+	}
+	return change
+}
+
+func TestPipeline_ReferenceCheck(t *testing.T) {
+	blocks := func() []*StreamBlock {
+		return []*StreamBlock{
+			streamBlock(t, 10,
+				createChange("token", "t0", map[string]string{"name": "T0"}),
+				createChange("pair", "p", map[string]string{"token_0": "t0", "token_1": "t1"}),
+			),
+			streamBlock(t, 11, createChange("token", "t1", map[string]string{"name": "T1"})),
+		}
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		store := memory.New(zap.NewNop(), Definition.Entities)
+		loader := NewLoader(store, Definition.Entities)
+		loader.SetReferenceCheck(ReferenceCheckWarn)
+
+		blockMetrics := metrics.NewBlockMetrics()
+		require.NoError(t, NewPipeline(loader, blockMetrics, 2, nil).Run(context.Background(), &sliceSource{blocks: blocks()}))
+		assert.Equal(t, int64(1), blockMetrics.Exec.DanglingReferences)
+
+		cursor, err := store.LoadCursor(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "cursor:11", cursor)
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		store := memory.New(zap.NewNop(), Definition.Entities)
+		loader := NewLoader(store, Definition.Entities)
+		loader.SetReferenceCheck(ReferenceCheckFail)
+
+		err := NewPipeline(loader, metrics.NewBlockMetrics(), 2, nil).Run(context.Background(), &sliceSource{blocks: blocks()})
+		assert.EqualError(t, err, "writing block 10: dangling reference: block 10: 1 references to missing entities, first one: pair p.token1 -> token t1")
+		assert.ErrorIs(t, err, graphnode.ErrDanglingReference)
+
+		cursor, err := store.LoadCursor(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "", cursor)
+	})
+}
+
+func TestLoader_Relations(t *testing.T) {
+	ctx := context.Background()
+	loader := NewLoader(memory.New(zap.NewNop(), Definition.Entities), Definition.Entities)
+
+	resolve := func(blockNum uint64, changes ...*database.TableChange) *ResolvedBlock {
+		block, err := loader.Resolve(ctx, &database.DatabaseChanges{TableChanges: changes}, "", &pbsubstreams.Clock{Number: blockNum})
+		require.NoError(t, err)
+		return block
+	}
+
+	written := resolve(10,
+		createChange("token", "t0", map[string]string{"name": "T0"}),
+		createChange("token", "t1", map[string]string{"name": "T1"}),
+		createChange("pair", "p1", map[string]string{"token_0": "t0", "token_1": "t1"}),
+		createChange("pair", "p2", map[string]string{"token_0": "t1", "token_1": "t0"}),
+	)
+	require.NoError(t, loader.Write(ctx, written))
+
+	// Block 11 is being resolved: `p3` is only known to the loader
+	resolve(11,
+		createChange("pair", "p3", map[string]string{"token_0": "t0", "token_1": "t1"}),
+		createChange("swap", "s1", map[string]string{"pair": "p1"}),
+	)
+
+	p3 := NewPair("p3")
+	p3.Token0 = "t0"
+	token, err := loader.LoadReference(ctx, p3, "token0", 11)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "T0", token.(*Token).Name)
+
+	p3.Token0 = ""
+	token, err = loader.LoadReference(ctx, p3, "token0", 11)
+	require.NoError(t, err)
+	assert.Nil(t, token)
+
+	transaction := NewTransaction("tx")
+	transaction.Swaps = graphnode.LocalStringArray{"s1", "missing"}
+	swaps, err := loader.LoadReferences(ctx, transaction, "swaps", 11)
+	require.NoError(t, err)
+	require.Len(t, swaps, 1)
+	assert.Equal(t, "p1", swaps[0].(*Swap).Pair)
+
+	pairs, err := loader.LoadDerived(ctx, NewToken("t0"), "pairBase", 11)
+	require.NoError(t, err)
+	var ids []string
+	for _, pair := range pairs {
+		ids = append(ids, pair.GetID())
+	}
+	assert.Equal(t, []string{"p1", "p3"}, ids)
+
+	_, err = loader.LoadReference(ctx, p3, "name", 11)
+	assert.EqualError(t, err, "table pair: field name is not a relation of the expected kind")
+}
@@ -10,25 +10,78 @@ import (
 	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
 	"go.uber.org/zap"
 	"reflect"
-	"time"
+	"sync"
 )
 
 type Loader struct {
 	store    storage.Store
 	registry *graphnode.Registry
 
-	// cached entities
+	// cached entities of the block being resolved, `current` keeps the versions as loaded
+	// to tell the unchanged entities apart
 	current map[string]map[string]graphnode.Entity
 	updates map[string]map[string]graphnode.Entity
+	sources map[string]map[string]graphnode.Entity
+
+	// pending holds the entities of the blocks resolved but not written yet, the next
+	// blocks must see them instead of what the store holds.
+	pendingLock sync.Mutex
+	pending     map[string]map[string]*pendingEntity
+
+	feed           ChangeFeed
+	referenceCheck ReferenceCheck
+}
+
+// ChangeFeed publishes the changes written, `Append` is called before a block is saved and
+// `Commit` once it is.
+type ChangeFeed interface {
+	Append(changes []*database.TableChange, clock *pbsubstreams.Clock) error
+	Commit(blockNum uint64) error
+}
+
+type pendingEntity struct {
+	blockNum uint64
+	// entity is the resolver's copy, nil when deleted
+	entity graphnode.Entity
+	// written is the instance handed to the store, its `vid` and `block_range` are only
+	// known once its block is written
+	written graphnode.Entity
+}
+
+// ResolvedBlock holds the changes of a block, ready to be written.
+type ResolvedBlock struct {
+	Clock   *pbsubstreams.Clock
+	Cursor  string
+	Updates map[string]map[string]graphnode.Entity
+
+	// Skipped counts the entities left out of `Updates` as their values did not change
+	Skipped int
+
+	// sources maps the updated entities loaded from a pending block to the entity
+	// written by that block
+	sources map[string]map[string]graphnode.Entity
+
+	// Dangling lists the references of the entities in `Updates` to entities which do not
+	// exist, when references are checked
+	Dangling []*graphnode.DanglingReference
+
+	// changes are the table changes of the entities in `Updates`
+	changes []*database.TableChange
 }
 
 func NewLoader(store storage.Store, registry *graphnode.Registry) *Loader {
 	return &Loader{
 		store:    store,
 		registry: registry,
+		pending:  map[string]map[string]*pendingEntity{},
 	}
 }
 
+// SetChangeFeed publishes the changes of the blocks written to `feed`.
+func (l *Loader) SetChangeFeed(feed ChangeFeed) {
+	l.feed = feed
+}
+
 func (l *Loader) save(ent graphnode.Entity) error {
 	tableName := graphnode.GetTableName(ent)
 
@@ -44,7 +97,7 @@ func (l *Loader) save(ent graphnode.Entity) error {
 	return nil
 }
 
-func (l *Loader) load(entity graphnode.Entity, blockNum uint64) error {
+func (l *Loader) load(ctx context.Context, entity graphnode.Entity, blockNum uint64) error {
 	tableName := graphnode.GetTableName(entity)
 	id := entity.GetID()
 
@@ -86,19 +139,25 @@ func (l *Loader) load(entity graphnode.Entity, blockNum uint64) error {
 		return nil
 	}
 
-	if err := l.store.Load(context.TODO(), id, entity, blockNum); err != nil {
+	if pending, found := l.pendingEntity(tableName, id); found {
+		if pending.entity == nil {
+			currentTable[id] = nil
+			return nil
+		}
+		l.setSource(tableName, id, pending.written)
+
+		ve := reflect.ValueOf(entity).Elem()
+		ve.Set(reflect.ValueOf(pending.entity).Elem())
+		currentTable[id] = l.detachedClone(tableName, entity)
+		return nil
+	}
+
+	if err := l.store.Load(ctx, id, entity, blockNum); err != nil {
 		return fmt.Errorf("failed loading entity: %w", err)
 	}
 
 	if entity.Exists() {
-		reflectType, ok := l.registry.GetType(tableName) //subgraph.MainSubgraphDef.Entities.GetType(tableName)
-		if !ok {
-			return fmt.Errorf("unable to retrieve entity type")
-		}
-		clone := reflect.New(reflectType).Interface()
-		ve := reflect.ValueOf(clone).Elem()
-		ve.Set(reflect.ValueOf(entity).Elem())
-		currentTable[id] = clone.(graphnode.Entity)
+		currentTable[id] = l.detachedClone(tableName, entity)
 	} else {
 		currentTable[id] = nil
 	}
@@ -106,62 +165,253 @@ func (l *Loader) load(entity graphnode.Entity, blockNum uint64) error {
 	return nil
 }
 
-func (l *Loader) Flush(cursor string, blockNum uint64, blockID string, blockTime time.Time) error {
-	return l.store.BatchSave(context.TODO(), blockNum, blockID, blockTime, l.updates, cursor)
+func (l *Loader) clone(tableName string, entity graphnode.Entity) graphnode.Entity {
+	reflectType, _ := l.registry.GetType(tableName)
+	clone := reflect.New(reflectType).Interface()
+	ve := reflect.ValueOf(clone).Elem()
+	ve.Set(reflect.ValueOf(entity).Elem())
+	return clone.(graphnode.Entity)
 }
 
-func (l *Loader) ReturnHandler(data []byte, step pbsubstreams.ForkStep, cursor string, clock *pbsubstreams.Clock) error {
-	databaseChanges := &database.DatabaseChanges{}
+// detachedClone copies `entity` along with the values its pointer fields point to, as
+// applying a change to a nullable field writes through its pointer.
+func (l *Loader) detachedClone(tableName string, entity graphnode.Entity) graphnode.Entity {
+	clone := l.clone(tableName, entity)
+	v := reflect.ValueOf(clone).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr && !field.IsNil() && field.CanSet() {
+			copied := reflect.New(field.Type().Elem())
+			copied.Elem().Set(field.Elem())
+			field.Set(copied)
+		}
+	}
+	return clone
+}
 
-	l.current = make(map[string]map[string]graphnode.Entity)
-	l.updates = make(map[string]map[string]graphnode.Entity)
+func (l *Loader) pendingEntity(tableName, id string) (*pendingEntity, bool) {
+	l.pendingLock.Lock()
+	defer l.pendingLock.Unlock()
+
+	pending, found := l.pending[tableName][id]
+	return pending, found
+}
+
+func (l *Loader) setSource(tableName, id string, written graphnode.Entity) {
+	table, found := l.sources[tableName]
+	if !found {
+		table = map[string]graphnode.Entity{}
+		l.sources[tableName] = table
+	}
+	table[id] = written
+}
+
+// Decode unmarshals and squashes the database changes of a block.
+func (l *Loader) Decode(data []byte) (*database.DatabaseChanges, error) {
+	databaseChanges := &database.DatabaseChanges{}
 
 	err := proto.Unmarshal(data, databaseChanges)
 	zlog.Debug("unmarshalled database changes", zap.Int("number_of_db_changes", len(databaseChanges.TableChanges)))
 
 	if err != nil {
-		return fmt.Errorf("unmarshaling database changes proto: %w", err)
+		return nil, fmt.Errorf("unmarshaling database changes proto: %w", err)
 	}
 
 	//todo: should be applied in a transform inside the firehose, not here.
 	err = databaseChanges.Squash()
 	if err != nil {
-		return fmt.Errorf("squashing database changes: %w", err)
+		return nil, fmt.Errorf("squashing database changes: %w", err)
 	}
 	zlog.Debug("squashed database changes")
 
+	return databaseChanges, nil
+}
+
+// Resolve applies the changes of a block to the entities they target. Blocks must be
+// resolved in order, a block can be resolved before the previous ones are written.
+func (l *Loader) Resolve(ctx context.Context, databaseChanges *database.DatabaseChanges, cursor string, clock *pbsubstreams.Clock) (*ResolvedBlock, error) {
+	l.current = make(map[string]map[string]graphnode.Entity)
+	l.updates = make(map[string]map[string]graphnode.Entity)
+	l.sources = make(map[string]map[string]graphnode.Entity)
+
 	for _, change := range databaseChanges.TableChanges {
-		fmt.Println("change: ", change.Operation.String(), change.Table, change.Pk, change.Fields)
+		zlog.Debug("resolving change", zap.Stringer("operation", change.Operation), zap.String("table", change.Table), zap.String("pk", change.Pk), zap.Int("field_count", len(change.Fields)))
 
 		ent, ok := l.registry.GetInterface(change.Table)
 		if !ok {
-			return fmt.Errorf("unknown entity for table %s", change.Table)
+			return nil, fmt.Errorf("unknown entity for table %s", change.Table)
 		}
 		ent.SetID(change.Pk)
-		err = l.load(ent, clock.Number)
-		if err != nil {
-			return fmt.Errorf("loading entity: %w", err)
-		}
-		if !ent.Exists() {
+
+		if graphnode.IsImmutable(ent) {
+			// Created once, there is no previous version to look up
+			if change.Operation != database.TableChange_CREATE {
+				return nil, graphnode.NewImmutableEntityError(change.Table, change.Pk, change.Operation.String())
+			}
 			ent.Default()
+		} else {
+			err := l.load(ctx, ent, clock.Number)
+			if err != nil {
+				return nil, fmt.Errorf("loading entity: %w", err)
+			}
+			if !ent.Exists() {
+				ent.Default()
+			} else if change.Operation == database.TableChange_CREATE {
+				// A row deleted then created again, squashed to a CREATE: the fields of the
+				// deleted row must not survive, only the version being closed is kept
+				ent = l.recreated(change.Table, ent)
+			}
 		}
 
 		err := database.ApplyTableChange(change, ent)
 		if err != nil {
-			return fmt.Errorf("applying table change: %w", err)
+			return nil, fmt.Errorf("applying table change: %w", err)
 		}
 
 		err = l.save(ent)
 		if err != nil {
-			return fmt.Errorf("saving entity: %w", err)
+			return nil, fmt.Errorf("saving entity: %w", err)
 		}
 		zlog.Debug("successfully saved change in database")
 	}
 
-	err = l.Flush(cursor, clock.Number, clock.Id, clock.Timestamp.AsTime())
+	skipped := l.skipUnchanged()
+
+	var dangling []*graphnode.DanglingReference
+	if l.referenceCheck != ReferenceCheckOff {
+		var err error
+		if dangling, err = l.danglingReferences(ctx, clock.Number); err != nil {
+			return nil, fmt.Errorf("checking references: %w", err)
+		}
+	}
+
+	block := &ResolvedBlock{Clock: clock, Cursor: cursor, Updates: l.updates, Skipped: skipped, Dangling: dangling, sources: l.sources}
+	for _, change := range databaseChanges.TableChanges {
+		if _, found := block.Updates[change.Table][change.Pk]; found {
+			block.changes = append(block.changes, change)
+		}
+	}
+
+	l.pendingLock.Lock()
+	defer l.pendingLock.Unlock()
+	for tableName, entities := range block.Updates {
+		table, found := l.pending[tableName]
+		if !found {
+			table = map[string]*pendingEntity{}
+			l.pending[tableName] = table
+		}
+		for id, ent := range entities {
+			pending := &pendingEntity{blockNum: clock.Number, written: ent}
+			if ent != nil {
+				pending.entity = l.detachedClone(tableName, ent)
+			}
+			table[id] = pending
+		}
+	}
+
+	return block, nil
+}
+
+// recreated returns a new entity with the default field values, taking over the version of
+// `previous` so that it gets closed when written.
+func (l *Loader) recreated(tableName string, previous graphnode.Entity) graphnode.Entity {
+	ent, _ := l.registry.GetInterface(tableName)
+	ent.SetID(previous.GetID())
+	ent.Default()
+	ent.SetVID(previous.GetVID())
+	ent.SetBlockRange(previous.GetBlockRange())
+	ent.SetExists(true)
+	return ent
+}
+
+// skipUnchanged drops the updated entities whose fields are the same as the version
+// loaded, writing them would only close a version to open an identical one.
+func (l *Loader) skipUnchanged() (skipped int) {
+	for tableName, entities := range l.updates {
+		for id, ent := range entities {
+			if ent == nil {
+				continue
+			}
+			previous := l.current[tableName][id]
+			if previous == nil || !graphnode.EqualFields(previous, ent) {
+				continue
+			}
+
+			delete(entities, id)
+			delete(l.sources[tableName], id)
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// Write saves the changes of the block along with its cursor. Once started, the save is
+// given a grace period to complete when `ctx` is cancelled.
+func (l *Loader) Write(ctx context.Context, block *ResolvedBlock) error {
+	// The versions the entities replace were written by the previous blocks, which are
+	// now saved
+	for tableName, sources := range block.sources {
+		for id, written := range sources {
+			if ent := block.Updates[tableName][id]; ent != nil {
+				ent.SetVID(written.GetVID())
+				ent.SetBlockRange(written.GetBlockRange())
+			}
+		}
+	}
+
+	if len(block.Dangling) > 0 {
+		if l.referenceCheck == ReferenceCheckFail {
+			return graphnode.NewDanglingReferencesError(block.Clock.Number, block.Dangling)
+		}
+
+		references := make([]string, len(block.Dangling))
+		for i, reference := range block.Dangling {
+			references[i] = reference.String()
+		}
+		zlog.Warn("dangling references", zap.Uint64("block_num", block.Clock.Number), zap.Strings("references", references))
+	}
+
+	if l.feed != nil {
+		if err := l.feed.Append(block.changes, block.Clock); err != nil {
+			return fmt.Errorf("journaling block changes: %w", err)
+		}
+	}
+
+	err := l.store.BatchSave(ctx, block.Clock.Number, block.Clock.Id, block.Clock.Timestamp.AsTime(), block.Updates, block.Cursor)
 	if err != nil {
 		return fmt.Errorf("flushing block changes: %w", err)
 	}
 
+	if l.feed != nil {
+		if err := l.feed.Commit(block.Clock.Number); err != nil {
+			return fmt.Errorf("committing block changes to feed: %w", err)
+		}
+	}
+
+	l.pendingLock.Lock()
+	defer l.pendingLock.Unlock()
+	for tableName, entities := range block.Updates {
+		for id := range entities {
+			if pending := l.pending[tableName][id]; pending != nil && pending.blockNum == block.Clock.Number {
+				delete(l.pending[tableName], id)
+			}
+		}
+	}
+
 	return nil
 }
+
+// ReturnHandler decodes, resolves then writes a block.
+func (l *Loader) ReturnHandler(ctx context.Context, data []byte, step pbsubstreams.ForkStep, cursor string, clock *pbsubstreams.Clock) error {
+	databaseChanges, err := l.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	block, err := l.Resolve(ctx, databaseChanges, cursor, clock)
+	if err != nil {
+		return err
+	}
+
+	return l.Write(ctx, block)
+}
@@ -0,0 +1,88 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/graphql"
+	"go.uber.org/zap"
+)
+
+var graphqlCmd = &cobra.Command{
+	Use:          "graphql",
+	Short:        "serve the entities of the store through the GraphQL API of the subgraph schema, as graph-node would",
+	RunE:         runGraphQL,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	graphqlCmd.Flags().String("pg-dsn", "", "dsn for postgres database, or sqlite://<path> for a local SQLite database")
+	graphqlCmd.Flags().String("pg-schema", "", "postgres schema name")
+	graphqlCmd.Flags().String("http-listen", ":8000", "address the GraphQL API listens on, served at /graphql and /subgraphs/name/<name>")
+	graphqlCmd.Flags().String("deployment", "", "deployment reported by _meta")
+	rootCmd.AddCommand(graphqlCmd)
+}
+
+func runGraphQL(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	store, err := newQueryStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	server, err := graphql.NewServer(graphnode.Definition.GraphQLSchema, graphnode.Definition.Entities, store, func(ctx context.Context) (*graphql.Head, error) {
+		cursor, err := store.LoadCursor(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading cursor: %w", err)
+		}
+		if cursor == "" {
+			return nil, nil
+		}
+
+		c, err := bstream.CursorFromOpaque(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("decoding cursor %q: %w", cursor, err)
+		}
+		return &graphql.Head{Number: c.Block.Num(), Hash: c.Block.ID()}, nil
+	}, mustGetString(cmd, "deployment"), zlog)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", server)
+	mux.Handle("/subgraphs/name/", server)
+
+	listenAddr := mustGetString(cmd, "http-listen")
+	httpServer := &http.Server{
+		Addr:        listenAddr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("serving graphql: %w", err)
+		}
+	}()
+	defer shutdownHTTP(httpServer)
+	zlog.Info("serving graphql api", zap.String("listen_addr", listenAddr))
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		zlog.Info("shutting down graphql api")
+		return nil
+	}
+}
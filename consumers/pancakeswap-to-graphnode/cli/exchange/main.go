@@ -5,7 +5,10 @@ import "fmt"
 func Main() {
 	setup()
 
-	err := rootCmd.Execute()
+	ctx, stop := signalContext()
+	defer stop()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		fmt.Println("Error:", err)
 	}
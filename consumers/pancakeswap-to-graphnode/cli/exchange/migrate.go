@@ -0,0 +1,51 @@
+package exchange
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/postgres"
+	"go.uber.org/zap"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:          "migrate",
+	Short:        "apply additive schema changes (new tables, nullable or defaulted columns, indexes) from the subgraph DDL",
+	RunE:         runMigrate,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	migrateCmd.Flags().String("pg-dsn", "", "dsn for postgres database")
+	migrateCmd.Flags().String("pg-schema", "", "postgres schema name")
+	migrateCmd.Flags().Bool("dry-run", false, "Only print the statements that would be applied")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	store, err := postgres.New(zlog, metrics.NewBlockMetrics(), mustGetString(cmd, "pg-dsn"), mustGetString(cmd, "pg-schema"), "", graphnode.Definition, map[string]bool{}, true)
+	if err != nil {
+		return fmt.Errorf("creating postgres store: %w", err)
+	}
+	defer store.Close()
+
+	dryRun := mustGetBool(cmd, "dry-run")
+	statements, err := store.Migrate(cmd.Context(), dryRun)
+	if err != nil {
+		return fmt.Errorf("migrating: %w", err)
+	}
+
+	if len(statements) == 0 {
+		zlog.Info("schema is up to date")
+		return nil
+	}
+
+	for _, statement := range statements {
+		fmt.Println(statement)
+	}
+	zlog.Info("migration statements", zap.Bool("dry_run", dryRun), zap.Int("count", len(statements)))
+	return nil
+}
@@ -0,0 +1,260 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "inspect the entities of the store as of a given block",
+}
+
+var queryGetCmd = &cobra.Command{
+	Use:          "get <table> <id>",
+	Short:        "print the entity valid at --at-block, defaults to the block of the saved cursor",
+	RunE:         runQueryGet,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+}
+
+var queryListCmd = &cobra.Command{
+	Use:          "list <table>",
+	Short:        "print the entities of a table valid at --at-block matching every --filter",
+	RunE:         runQueryList,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+var queryHistoryCmd = &cobra.Command{
+	Use:          "history <table> <id>",
+	Short:        "print every version of an entity with its block range and the columns it changed",
+	RunE:         runQueryHistory,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{queryGetCmd, queryListCmd, queryHistoryCmd} {
+		cmd.Flags().String("pg-dsn", "", "dsn for postgres database, or sqlite://<path> for a local SQLite database")
+		cmd.Flags().String("pg-schema", "", "postgres schema name")
+		cmd.Flags().String("output", "table", "Output format, one of: table, json")
+	}
+	for _, cmd := range []*cobra.Command{queryGetCmd, queryListCmd} {
+		cmd.Flags().Uint64("at-block", 0, "Block at which entities are read, defaults to the block of the saved cursor")
+	}
+	queryListCmd.Flags().StringArray("filter", nil, "Only print the entities whose column has this value, as column=value, can be repeated")
+
+	queryCmd.AddCommand(queryGetCmd)
+	queryCmd.AddCommand(queryListCmd)
+	queryCmd.AddCommand(queryHistoryCmd)
+	rootCmd.AddCommand(queryCmd)
+}
+
+func runQueryGet(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	table, id := args[0], args[1]
+
+	output, err := queryOutput(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := newQueryStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	atBlock, err := queryBlock(ctx, cmd, store)
+	if err != nil {
+		return err
+	}
+
+	ent, err := query.Get(ctx, store, graphnode.Definition.Entities, table, id, atBlock)
+	if err != nil {
+		return err
+	}
+	if ent == nil {
+		return fmt.Errorf("entity %q not found in %q at block %d", id, table, atBlock)
+	}
+
+	if output == "json" {
+		return printJSON(ent)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLUMN\tVALUE")
+	for _, column := range query.Columns(ent) {
+		fmt.Fprintf(w, "%s\t%s\n", column.Name, column.Value)
+	}
+	return w.Flush()
+}
+
+func runQueryList(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	table := args[0]
+
+	output, err := queryOutput(cmd)
+	if err != nil {
+		return err
+	}
+
+	rawFilters, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+	var filters []*query.Filter
+	for _, raw := range rawFilters {
+		filter, err := query.ParseFilter(raw)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, filter)
+	}
+
+	store, err := newQueryStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	atBlock, err := queryBlock(ctx, cmd, store)
+	if err != nil {
+		return err
+	}
+
+	entities, err := query.List(ctx, store, graphnode.Definition.Entities, table, atBlock, filters)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		if entities == nil {
+			return printJSON([]interface{}{})
+		}
+		return printJSON(entities)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, ent := range entities {
+		columns := query.Columns(ent)
+		if i == 0 {
+			names := make([]string, len(columns))
+			for j, column := range columns {
+				names[j] = strings.ToUpper(column.Name)
+			}
+			fmt.Fprintln(w, strings.Join(names, "\t"))
+		}
+
+		values := make([]string, len(columns))
+		for j, column := range columns {
+			values[j] = column.Value
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d entities in %q at block %d\n", len(entities), table, atBlock)
+	return nil
+}
+
+func runQueryHistory(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	table, id := args[0], args[1]
+
+	output, err := queryOutput(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := newQueryStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	versions, err := query.History(ctx, store, graphnode.Definition.Entities, table, id)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("entity %q not found in %q", id, table)
+	}
+
+	if output == "json" {
+		return printJSON(versions)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VID\tBLOCK RANGE\tCHANGES")
+	for i, version := range versions {
+		var changes []string
+		switch {
+		case i == 0:
+			changes = append(changes, "created")
+		case version.DeletedBefore:
+			changes = append(changes, fmt.Sprintf("deleted at block %d, recreated", versions[i-1].Entity.GetBlockRange().EndBlock))
+		}
+		for _, change := range version.Changes {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", change.Column, change.Old, change.New))
+		}
+		if len(changes) == 0 {
+			changes = append(changes, "no change")
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\n", version.Entity.GetVID(), version.Entity.GetBlockRange().String(), changes[0])
+		for _, change := range changes[1:] {
+			fmt.Fprintf(w, "\t\t%s\n", change)
+		}
+	}
+	if last := versions[len(versions)-1].Entity.GetBlockRange(); !last.IsOpen() {
+		fmt.Fprintf(w, "\t\tdeleted at block %d\n", last.EndBlock)
+	}
+	return w.Flush()
+}
+
+func newQueryStore(cmd *cobra.Command) (storage.Store, error) {
+	return newReadStore(mustGetString(cmd, "pg-dsn"), mustGetString(cmd, "pg-schema"), graphnode.Definition)
+}
+
+func queryOutput(cmd *cobra.Command) (string, error) {
+	output := mustGetString(cmd, "output")
+	if output != "table" && output != "json" {
+		return "", fmt.Errorf("invalid --output %q, expected table or json", output)
+	}
+	return output, nil
+}
+
+// queryBlock returns --at-block, or the block of the saved cursor when not set.
+func queryBlock(ctx context.Context, cmd *cobra.Command, store storage.Store) (uint64, error) {
+	if atBlock := mustGetUint64(cmd, "at-block"); atBlock != 0 {
+		return atBlock, nil
+	}
+
+	_, blockNum, err := cursorBlockNum(ctx, store)
+	if err != nil {
+		return 0, err
+	}
+	if blockNum == 0 {
+		return 0, fmt.Errorf("no cursor saved in store, --at-block is required")
+	}
+	return blockNum, nil
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+var serveCmd = &cobra.Command{
+	Use:          "serve",
+	Short:        "serve the entities of the store, at the head block or a past block, over HTTP and gRPC",
+	RunE:         runServe,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Long: `Serve the entities of the store, at the head block or a past block, over HTTP and gRPC.
+
+Subscriptions stream the entity changes journaled by the loader in --cdc-dir, which must be
+the --cdc-dir of the loader. The loader only journals changes when it publishes them to a
+sink, one of its --cdc-* flags must be set. The journal is compacted once the sinks of the
+loader delivered its events: a subscription resuming after compacted events is refused,
+subscribing without after_seq starts at the last committed event.`,
+}
+
+func init() {
+	serveCmd.Flags().String("pg-dsn", "", "dsn for postgres database, or sqlite://<path> for a local SQLite database")
+	serveCmd.Flags().String("pg-schema", "", "postgres schema name")
+	serveCmd.Flags().String("http-listen", ":8080", "address the HTTP API listens on, empty to disable it")
+	serveCmd.Flags().String("grpc-listen", ":9000", "address the pcs.api.v1.Entities gRPC service listens on, empty to disable it")
+	serveCmd.Flags().String("cdc-dir", "cdc", "--cdc-dir of the loader, subscriptions stream the changes it journals when a --cdc-* sink is set, empty to disable subscriptions")
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	httpListen := mustGetString(cmd, "http-listen")
+	grpcListen := mustGetString(cmd, "grpc-listen")
+	if httpListen == "" && grpcListen == "" {
+		return fmt.Errorf("nothing to serve, --http-listen and --grpc-listen are both empty")
+	}
+
+	store, err := newQueryStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	server := api.NewServer(store, graphnode.Definition.Entities, func(ctx context.Context) (uint64, error) {
+		_, blockNum, err := cursorBlockNum(ctx, store)
+		return blockNum, err
+	}, mustGetString(cmd, "cdc-dir"), zlog)
+
+	errs := make(chan error, 2)
+
+	if httpListen != "" {
+		httpServer := &http.Server{
+			Addr:    httpListen,
+			Handler: server.Handler(),
+			// Subscriptions end on shutdown
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("serving http: %w", err)
+			}
+		}()
+		defer shutdownHTTP(httpServer)
+		zlog.Info("serving http api", zap.String("listen_addr", httpListen))
+	}
+
+	if grpcListen != "" {
+		listener, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", grpcListen, err)
+		}
+
+		grpcServer := grpc.NewServer()
+		server.RegisterGRPC(grpcServer)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				errs <- fmt.Errorf("serving grpc: %w", err)
+			}
+		}()
+		defer grpcServer.Stop()
+		zlog.Info("serving grpc api", zap.String("listen_addr", grpcListen), zap.String("service", api.GRPCServiceName))
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		zlog.Info("shutting down api")
+		return nil
+	}
+}
+
+func shutdownHTTP(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		zlog.Warn("shutting down http server", zap.Error(err))
+	}
+}
@@ -0,0 +1,41 @@
+package exchange
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// signalContext returns a context cancelled on the first SIGINT or SIGTERM, commands stop
+// receiving blocks and let the in-flight save complete. A second signal exits right away.
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		zlog.Info("received signal, shutting down once the in-flight block is saved, send it again to force quit", zap.Stringer("signal", sig))
+		cancel()
+
+		sig, ok = <-signals
+		if !ok {
+			return
+		}
+		zlog.Warn("received second signal, forcing quit, the in-flight block may not be saved", zap.Stringer("signal", sig))
+		os.Exit(1)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/substream-pancakeswap/cli/exchange/graphnode"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/snapshot"
+	"go.uber.org/zap"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "dump and restore the entities valid at a given block",
+}
+
+var snapshotDumpCmd = &cobra.Command{
+	Use:          "dump <output.json.gz>",
+	Short:        "dump every entity valid at --at-block, use '-' to write to stdout",
+	RunE:         runSnapshotDump,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:          "restore <input.json.gz>",
+	Short:        "seed an empty schema from a snapshot, use '-' to read from stdin",
+	RunE:         runSnapshotRestore,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{snapshotDumpCmd, snapshotRestoreCmd} {
+		cmd.Flags().String("pg-dsn", "", "dsn for postgres database, or sqlite://<path> for a local SQLite database")
+		cmd.Flags().String("pg-schema", "", "postgres schema name")
+		cmd.Flags().String("pg-deployment", "", "subgraph deployment name")
+	}
+	snapshotDumpCmd.Flags().Uint64("at-block", 0, "Block at which entities are dumped, defaults to the block of the saved cursor")
+	snapshotDumpCmd.Flags().String("temp-dir", "", "Directory where the tables are dumped before being merged in the snapshot, defaults to the system temporary directory")
+
+	snapshotCmd.AddCommand(snapshotDumpCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
+
+func runSnapshotDump(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	store, err := newStore(mustGetString(cmd, "pg-dsn"), mustGetString(cmd, "pg-schema"), mustGetString(cmd, "pg-deployment"), graphnode.Definition, metrics.NewBlockMetrics(), true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	cursor, cursorBlock, err := cursorBlockNum(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	atBlock := mustGetUint64(cmd, "at-block")
+	if atBlock == 0 {
+		if cursorBlock == 0 {
+			return fmt.Errorf("no cursor saved in store, --at-block is required")
+		}
+		atBlock = cursorBlock
+	}
+	if atBlock != cursorBlock {
+		zlog.Warn("snapshot is not taken at the cursor's block, it will not contain a cursor", zap.Uint64("at_block", atBlock), zap.Uint64("cursor_block_num", cursorBlock))
+		cursor = ""
+	}
+
+	var out io.Writer = os.Stdout
+	if args[0] != "-" {
+		file, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("creating %q: %w", args[0], err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	header, err := snapshot.Dump(ctx, store, graphnode.Definition.Entities, atBlock, cursor, mustGetString(cmd, "temp-dir"), out, zlog)
+	if err != nil {
+		return fmt.Errorf("dumping snapshot: %w", err)
+	}
+
+	zlog.Info("snapshot dumped", zap.Uint64("block_num", header.BlockNum), zap.Any("tables", header.Tables))
+	return nil
+}
+
+func runSnapshotRestore(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	store, err := newStore(mustGetString(cmd, "pg-dsn"), mustGetString(cmd, "pg-schema"), mustGetString(cmd, "pg-deployment"), graphnode.Definition, metrics.NewBlockMetrics(), true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("opening %q: %w", args[0], err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	header, err := snapshot.Restore(ctx, store, graphnode.Definition.Entities, in, zlog)
+	if err != nil {
+		return fmt.Errorf("restoring snapshot: %w", err)
+	}
+
+	if header.Cursor == "" {
+		zlog.Info("snapshot has no cursor, resume loading with the next block", zap.Uint64("start_block", header.BlockNum+1))
+	}
+	return nil
+}
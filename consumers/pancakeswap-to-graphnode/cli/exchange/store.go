@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/postgres"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/sqlite"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+)
+
+// newStore opens the store targeted by `dsn`: a `sqlite://path` DSN opens (and creates)
+// a local SQLite database, anything else is a Postgres DSN.
+func newStore(dsn, schema, deployment string, subgraphDef *subgraph.Definition, blockMetrics *metrics.BlockMetrics, withTransactions bool) (storage.Store, error) {
+	if sqlite.IsDSN(dsn) {
+		store, err := sqlite.New(zlog, dsn, subgraphDef)
+		if err != nil {
+			return nil, fmt.Errorf("creating sqlite store: %w", err)
+		}
+		return store, nil
+	}
+
+	store, err := postgres.New(zlog, blockMetrics, dsn, schema, deployment, subgraphDef, map[string]bool{}, withTransactions)
+	if err != nil {
+		return nil, fmt.Errorf("creating postgres store: %w", err)
+	}
+
+	err = store.RegisterEntities()
+	if err != nil {
+		return nil, fmt.Errorf("store: registaring entities:%w", err)
+	}
+	return store, nil
+}
+
+// newReadStore opens the store targeted by `dsn` for the commands only reading it, a
+// database is neither created, checked nor prepared for writes, see `postgres.NewReader`.
+func newReadStore(dsn, schema string, subgraphDef *subgraph.Definition) (storage.Store, error) {
+	if sqlite.IsDSN(dsn) {
+		store, err := sqlite.NewReader(zlog, dsn, subgraphDef)
+		if err != nil {
+			return nil, fmt.Errorf("opening sqlite store: %w", err)
+		}
+		return store, nil
+	}
+
+	store, err := postgres.NewReader(zlog, dsn, schema, subgraphDef)
+	if err != nil {
+		return nil, fmt.Errorf("opening postgres store: %w", err)
+	}
+	return store, nil
+}
+
+// cursorBlockNum returns the block of the cursor saved in `store`, 0 when there is none.
+func cursorBlockNum(ctx context.Context, store storage.Store) (string, uint64, error) {
+	cursor, err := store.LoadCursor(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("loading cursor: %w", err)
+	}
+	if cursor == "" {
+		return "", 0, nil
+	}
+
+	c, err := bstream.CursorFromOpaque(cursor)
+	if err != nil {
+		return "", 0, fmt.Errorf("decoding cursor %q: %w", cursor, err)
+	}
+	return cursor, c.Block.Num(), nil
+}
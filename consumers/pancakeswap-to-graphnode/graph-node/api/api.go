@@ -0,0 +1,255 @@
+// Package api serves the entities of a store to clients that do not run graph-node: an
+// entity by id, a page of the entities of a table matching some filters, both at the head
+// block or at a past block, and the entity changes as the loader commits them, read from
+// the journal of its change feed.
+//
+// Subscriptions read the change feed directory of the loader, which only journals changes
+// when it publishes them to at least one sink. The journal is compacted once every sink of
+// the loader delivered its events, regardless of the subscriptions: a subscription
+// resuming after compacted events fails with `ErrGone`.
+//
+// Responses are JSON encoded, `Int` and `Float` values as decimal strings so that clients
+// decode them without losing precision. The same requests are served over HTTP and gRPC.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/cdc"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+
+	tailInterval = 500 * time.Millisecond
+)
+
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrUnavailable    = errors.New("unavailable")
+	// ErrGone is returned when subscribing after events removed from the journal
+	ErrGone = errors.New("gone")
+)
+
+// HeadFunc returns the last block saved in the store, 0 when there is none.
+type HeadFunc func(ctx context.Context) (uint64, error)
+
+type Server struct {
+	store    storage.Store
+	registry *graphnode.Registry
+	head     HeadFunc
+	// cdcDir is the directory of the change feed subscriptions read, subscriptions are
+	// disabled when empty
+	cdcDir string
+	logger *zap.Logger
+}
+
+func NewServer(store storage.Store, registry *graphnode.Registry, head HeadFunc, cdcDir string, logger *zap.Logger) *Server {
+	return &Server{
+		store:    store,
+		registry: registry,
+		head:     head,
+		cdcDir:   cdcDir,
+		logger:   logger,
+	}
+}
+
+// GetRequest reads the entity `ID` of `Table` at `Block`, the head block when 0.
+type GetRequest struct {
+	Table string `json:"table"`
+	ID    string `json:"id"`
+	Block uint64 `json:"block"`
+}
+
+// ListRequest reads a page of the entities of `Table` at `Block`, the head block when 0,
+// whose columns have the values of `Filters`. Entities are sorted on `OrderBy`, then on
+// their id.
+type ListRequest struct {
+	Table          string            `json:"table"`
+	Block          uint64            `json:"block"`
+	Filters        map[string]string `json:"filters"`
+	OrderBy        string            `json:"order_by"`
+	OrderDirection string            `json:"order_direction"`
+	// First defaults to `DefaultPageSize`
+	First int `json:"first"`
+	Skip  int `json:"skip"`
+}
+
+// SubscribeRequest streams the entity changes committed after the event `AfterSeq`, the
+// last committed one when not set, those of `Tables` only when set.
+type SubscribeRequest struct {
+	AfterSeq *uint64  `json:"after_seq"`
+	Tables   []string `json:"tables"`
+}
+
+type EntityResponse struct {
+	Block  uint64          `json:"block"`
+	Entity json.RawMessage `json:"entity"`
+}
+
+type ListResponse struct {
+	Block    uint64            `json:"block"`
+	Entities []json.RawMessage `json:"entities"`
+}
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*EntityResponse, error) {
+	if req.Table == "" || req.ID == "" {
+		return nil, fmt.Errorf("%w: table and id are required", ErrInvalidRequest)
+	}
+
+	blockNum, err := s.block(ctx, req.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	ent, err := query.Get(ctx, s.store, s.registry, req.Table, req.ID, blockNum)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	if ent == nil {
+		return nil, fmt.Errorf("%w: entity %q of %q at block %d", ErrNotFound, req.ID, req.Table, blockNum)
+	}
+
+	encoded, err := query.Encode(ent)
+	if err != nil {
+		return nil, err
+	}
+	return &EntityResponse{Block: blockNum, Entity: encoded}, nil
+}
+
+func (s *Server) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	if req.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidRequest)
+	}
+
+	opts := &query.ListOptions{First: req.First, Skip: req.Skip}
+	switch {
+	case opts.First == 0:
+		opts.First = DefaultPageSize
+	case opts.First < 0 || opts.First > MaxPageSize:
+		return nil, fmt.Errorf("%w: first must be between 1 and %d", ErrInvalidRequest, MaxPageSize)
+	}
+	if opts.Skip < 0 {
+		return nil, fmt.Errorf("%w: skip must not be negative", ErrInvalidRequest)
+	}
+
+	switch req.OrderDirection {
+	case "", "asc", "desc":
+	default:
+		return nil, fmt.Errorf("%w: invalid order direction %q, expected asc or desc", ErrInvalidRequest, req.OrderDirection)
+	}
+	if req.OrderBy != "" {
+		opts.OrderBy = &query.Order{Column: req.OrderBy, Descending: req.OrderDirection == "desc"}
+	}
+
+	// Sorted so that errors do not depend on the order of the map
+	columns := make([]string, 0, len(req.Filters))
+	for column := range req.Filters {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		opts.Filters = append(opts.Filters, &query.Filter{Column: column, Value: req.Filters[column]})
+	}
+
+	blockNum, err := s.block(ctx, req.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := query.Find(ctx, s.store, s.registry, req.Table, blockNum, opts)
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	resp := &ListResponse{Block: blockNum, Entities: make([]json.RawMessage, len(entities))}
+	for i, ent := range entities {
+		if resp.Entities[i], err = query.Encode(ent); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// Subscribe calls `handle` with the entity changes matching `req` as the loader commits
+// them, until `ctx` is cancelled or `handle` fails.
+func (s *Server) Subscribe(ctx context.Context, req *SubscribeRequest, handle func(event *cdc.Event) error) error {
+	if s.cdcDir == "" {
+		return fmt.Errorf("%w: subscriptions are disabled, the change feed directory is not set", ErrUnavailable)
+	}
+	if _, err := os.Stat(s.cdcDir); os.IsNotExist(err) {
+		return fmt.Errorf("%w: change feed directory %q not found, the loader only journals changes when a --cdc-* sink is set", ErrUnavailable, s.cdcDir)
+	}
+
+	tables := map[string]bool{}
+	for _, table := range req.Tables {
+		if _, found := s.registry.GetType(table); !found {
+			return fmt.Errorf("%w: %s %q", ErrInvalidRequest, query.ErrUnknownTable, table)
+		}
+		tables[table] = true
+	}
+
+	var afterSeq uint64
+	if req.AfterSeq != nil {
+		afterSeq = *req.AfterSeq
+	} else {
+		committed, err := cdc.Committed(s.cdcDir)
+		if err != nil {
+			return fmt.Errorf("reading committed event: %w", err)
+		}
+		afterSeq = committed
+	}
+
+	err := cdc.Tail(ctx, s.cdcDir, afterSeq, tailInterval, func(event *cdc.Event) error {
+		if len(tables) > 0 && !tables[event.Table] {
+			return nil
+		}
+		return handle(event)
+	})
+	if errors.Is(err, cdc.ErrCompacted) {
+		return fmt.Errorf("%w: %s, subscribe again after a later event or without after_seq", ErrGone, err)
+	}
+	return err
+}
+
+// block returns the block requested, the head block when 0.
+func (s *Server) block(ctx context.Context, requested uint64) (uint64, error) {
+	head, err := s.head(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if head == 0 {
+		return 0, fmt.Errorf("%w: no block saved in store yet", ErrUnavailable)
+	}
+
+	if requested == 0 {
+		return head, nil
+	}
+	if requested > head {
+		return 0, fmt.Errorf("%w: block %d is above the head block %d", ErrInvalidRequest, requested, head)
+	}
+	return requested, nil
+}
+
+// queryError tells the errors of the request apart from the errors of the store.
+func queryError(err error) error {
+	switch {
+	case errors.Is(err, query.ErrUnknownTable):
+		return fmt.Errorf("%w: %s", ErrNotFound, err)
+	case errors.Is(err, query.ErrUnknownColumn), errors.Is(err, query.ErrInvalidFilter), errors.Is(err, storage.ErrBlockPruned):
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+	return err
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/cdc"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func save(t *testing.T, store storage.Store, blockNum uint64, entities map[string]graphnode.Entity) {
+	ctx := context.Background()
+	for id, ent := range entities {
+		if ent == nil {
+			continue
+		}
+		current := &storagetest.TestEntity{Base: graphnode.NewBase(id)}
+		require.NoError(t, store.Load(ctx, id, current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+	}
+	require.NoError(t, store.BatchSave(ctx, blockNum, "", time.Time{}, map[string]map[string]graphnode.Entity{"test_entity": entities}, ""))
+}
+
+func testServer(t *testing.T, cdcDir string) *Server {
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+	save(t, store, 10, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a1", 9), "b": storagetest.NewTestEntity("b", "b1", 10)})
+	save(t, store, 20, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a2", 11), "c": storagetest.NewTestEntity("c", "c1", 2)})
+
+	return NewServer(store, storagetest.Registry(), func(ctx context.Context) (uint64, error) { return 20, nil }, cdcDir, zap.NewNop())
+}
+
+func httpGet(t *testing.T, server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(testServer(t, "").Handler())
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "get at head",
+			path:           "/v1/entities/test_entity/a",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"block":20,"entity":{"id":"a","vid":3,"block_range":"[20,)","_updated_block_number":20,"name":"a2","amount":"11"}}`,
+		},
+		{
+			name:           "get at block",
+			path:           "/v1/entities/test_entity/a?block=15",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"block":15,"entity":{"id":"a","vid":1,"block_range":"[10,20)","_updated_block_number":20,"name":"a1","amount":"9"}}`,
+		},
+		{
+			name:           "get not existing yet",
+			path:           "/v1/entities/test_entity/c?block=15",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"not found: entity \"c\" of \"test_entity\" at block 15"}`,
+		},
+		{
+			name:           "list ordered",
+			path:           "/v1/entities/test_entity?order_by=amount&order_direction=desc&first=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"block":20,"entities":[{"id":"a","vid":3,"block_range":"[20,)","_updated_block_number":20,"name":"a2","amount":"11"},{"id":"b","vid":2,"block_range":"[10,)","_updated_block_number":10,"name":"b1","amount":"10"}]}`,
+		},
+		{
+			name:           "list filtered at block",
+			path:           "/v1/entities/test_entity?block=10&filter=" + url.QueryEscape("name=a1"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"block":10,"entities":[{"id":"a","vid":1,"block_range":"[10,20)","_updated_block_number":20,"name":"a1","amount":"9"}]}`,
+		},
+		{
+			name:           "list empty page",
+			path:           "/v1/entities/test_entity?skip=10",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"block":20,"entities":[]}`,
+		},
+		{
+			name:           "unknown table",
+			path:           "/v1/entities/pair",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"not found: unknown table \"pair\""}`,
+		},
+		{
+			name:           "unknown column",
+			path:           "/v1/entities/test_entity?order_by=size",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid request: unknown column \"size\" in table \"test_entity\""}`,
+		},
+		{
+			name:           "above head",
+			path:           "/v1/entities/test_entity/a?block=30",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid request: block 30 is above the head block 20"}`,
+		},
+		{
+			name:           "invalid page size",
+			path:           "/v1/entities/test_entity?first=5000",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid request: first must be between 1 and 1000"}`,
+		},
+		{
+			name:           "subscriptions disabled",
+			path:           "/v1/subscribe",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"error":"unavailable: subscriptions are disabled, the change feed directory is not set"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := httpGet(t, server, test.path)
+			assert.Equal(t, test.expectedStatus, status)
+			assert.JSONEq(t, test.expectedBody, body)
+		})
+	}
+}
+
+func TestHTTP_Subscribe(t *testing.T) {
+	dir := t.TempDir()
+	feed, err := cdc.Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	defer feed.Close()
+
+	appendBlock := func(blockNum uint64, table string) {
+		require.NoError(t, feed.Append([]*database.TableChange{{
+			Table:     table,
+			Pk:        "a",
+			Operation: database.TableChange_UPDATE,
+			Fields:    []*database.Field{{Name: "name", OldValue: "a1", NewValue: "a2"}},
+		}}, &pbsubstreams.Clock{Number: blockNum}))
+		require.NoError(t, feed.Commit(blockNum))
+	}
+	appendBlock(21, "test_entity")
+	appendBlock(22, "pair")
+
+	server := httptest.NewServer(testServer(t, dir).Handler())
+	defer server.Close()
+
+	// Cancelled before the server is closed, which waits for the subscriptions to end
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscribe := func(query string) func() *cdc.Event {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/subscribe?"+query, nil)
+		require.NoError(t, err)
+
+		// Headers are only written with the first event
+		lines := make(chan []byte)
+		go func() {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			reader := bufio.NewReader(resp.Body)
+			for {
+				line, err := reader.ReadBytes('\n')
+				if err != nil {
+					return
+				}
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return func() *cdc.Event {
+			select {
+			case line := <-lines:
+				event := &cdc.Event{}
+				require.NoError(t, json.Unmarshal(line, event))
+				return event
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "no event received")
+				return nil
+			}
+		}
+	}
+
+	replay := subscribe("after_seq=0&table=test_entity")
+	event := replay()
+	assert.Equal(t, uint64(1), event.Seq)
+	assert.Equal(t, map[string]string{"name": "a2"}, event.New)
+
+	// Without after_seq, the subscription starts after the last committed event as saved,
+	// within a second of the commit
+	require.Eventually(t, func() bool {
+		committed, err := cdc.Committed(dir)
+		require.NoError(t, err)
+		return committed == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	head := subscribe("")
+
+	// Committed after the subscriptions started, the `pair` change is filtered out
+	appendBlock(23, "test_entity")
+	event = replay()
+	assert.Equal(t, uint64(3), event.Seq)
+	assert.Equal(t, uint64(23), event.Block)
+	event = head()
+	assert.Equal(t, uint64(3), event.Seq)
+}
+
+func TestHTTP_SubscribeCompacted(t *testing.T) {
+	dir := t.TempDir()
+	feed, err := cdc.Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	for _, blockNum := range []uint64{10, 10_000} {
+		require.NoError(t, feed.Append([]*database.TableChange{{Table: "test_entity", Pk: "a", Operation: database.TableChange_CREATE}}, &pbsubstreams.Clock{Number: blockNum}))
+		require.NoError(t, feed.Commit(blockNum))
+	}
+	require.NoError(t, feed.Close())
+
+	// Compacted, the first segment is removed
+	segments, err := filepath.Glob(filepath.Join(dir, "journal-*.ndjson"))
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	sort.Strings(segments)
+	require.NoError(t, os.Remove(segments[0]))
+
+	server := httptest.NewServer(testServer(t, dir).Handler())
+	defer server.Close()
+
+	status, body := httpGet(t, server, "/v1/subscribe?after_seq=0")
+	assert.Equal(t, http.StatusGone, status)
+	assert.Contains(t, body, "subscribe again")
+
+	status, _ = httpGet(t, server, "/v1/subscribe?after_seq=x")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Subscriptions need the change feed of the loader
+	server = httptest.NewServer(testServer(t, filepath.Join(dir, "missing")).Handler())
+	defer server.Close()
+	status, body = httpGet(t, server, "/v1/subscribe")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, "--cdc-*")
+}
+
+func TestGRPC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	testServer(t, "").RegisterGRPC(grpcServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.DialContext(ctx, listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	request := func(fields map[string]interface{}) *structpb.Struct {
+		in, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+		return in
+	}
+
+	out := &structpb.Struct{}
+	require.NoError(t, conn.Invoke(ctx, GRPCGetMethod, request(map[string]interface{}{"table": "test_entity", "id": "a", "block": 15}), out))
+	assert.Equal(t, map[string]interface{}{
+		"block":  float64(15),
+		"entity": map[string]interface{}{"id": "a", "vid": float64(1), "block_range": "[10,20)", "_updated_block_number": float64(20), "name": "a1", "amount": "9"},
+	}, out.AsMap())
+
+	out = &structpb.Struct{}
+	require.NoError(t, conn.Invoke(ctx, GRPCListMethod, request(map[string]interface{}{"table": "test_entity", "filters": map[string]interface{}{"amount": "2"}}), out))
+	entities := out.AsMap()["entities"].([]interface{})
+	require.Len(t, entities, 1)
+	assert.Equal(t, "c", entities[0].(map[string]interface{})["id"])
+
+	err = conn.Invoke(ctx, GRPCGetMethod, request(map[string]interface{}{"table": "test_entity", "id": "z"}), &structpb.Struct{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err := conn.NewStream(ctx, GRPCSubscribeStreamDesc, GRPCSubscribeMethod)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(request(nil)))
+	require.NoError(t, stream.CloseSend())
+	assert.Equal(t, codes.Unavailable, status.Code(stream.RecvMsg(&structpb.Struct{})))
+}
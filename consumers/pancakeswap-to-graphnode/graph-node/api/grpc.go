@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/cdc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The gRPC service takes and returns `google.protobuf.Struct` messages holding the JSON
+// encoding of the requests and responses, `Subscribe` streams one message per event.
+const (
+	GRPCServiceName     = "pcs.api.v1.Entities"
+	GRPCGetMethod       = "/" + GRPCServiceName + "/Get"
+	GRPCListMethod      = "/" + GRPCServiceName + "/List"
+	GRPCSubscribeMethod = "/" + GRPCServiceName + "/Subscribe"
+)
+
+// GRPCSubscribeStreamDesc describes the `Subscribe` stream to clients.
+var GRPCSubscribeStreamDesc = &grpc.StreamDesc{StreamName: "Subscribe", ServerStreams: true}
+
+// RegisterGRPC registers the `pcs.api.v1.Entities` service on `server`.
+func (s *Server) RegisterGRPC(server *grpc.Server) {
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: GRPCServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Get", Handler: unaryHandler(GRPCGetMethod, func() interface{} { return &GetRequest{} }, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Get(ctx, req.(*GetRequest))
+			})},
+			{MethodName: "List", Handler: unaryHandler(GRPCListMethod, func() interface{} { return &ListRequest{} }, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return s.List(ctx, req.(*ListRequest))
+			})},
+		},
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Subscribe",
+			Handler:       s.subscribeStream,
+			ServerStreams: true,
+		}},
+	}, s)
+}
+
+// unaryHandler decodes the request of a unary method into the value `newRequest` returns,
+// then encodes the response of `call`.
+func unaryHandler(method string, newRequest func() interface{}, call func(ctx context.Context, req interface{}) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &structpb.Struct{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+
+		handler := func(ctx context.Context, in interface{}) (interface{}, error) {
+			req := newRequest()
+			if err := fromStruct(in.(*structpb.Struct), req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			resp, err := call(ctx, req)
+			if err != nil {
+				return nil, grpcError(err)
+			}
+			return toStruct(resp)
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		return interceptor(ctx, in, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+}
+
+func (s *Server) subscribeStream(_ interface{}, stream grpc.ServerStream) error {
+	in := &structpb.Struct{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	req := &SubscribeRequest{}
+	if err := fromStruct(in, req); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.Subscribe(stream.Context(), req, func(event *cdc.Event) error {
+		message, err := toStruct(event)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg(message)
+	})
+	return grpcError(err)
+}
+
+func fromStruct(in *structpb.Struct, out interface{}) error {
+	data, err := json.Marshal(in.AsMap())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decoding request: %w", err)
+	}
+	return nil
+}
+
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrGone):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/cdc"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"go.uber.org/zap"
+)
+
+// Handler serves the API over HTTP:
+//
+//	GET /v1/entities/<table>/<id>?block=<n>
+//	GET /v1/entities/<table>?block=<n>&filter=<column>=<value>&order_by=<column>&order_direction=asc|desc&first=<n>&skip=<n>
+//	GET /v1/subscribe?after_seq=<n>&table=<table>
+//
+// `filter` and `table` can be repeated. Subscriptions stream the events as JSON lines, from
+// the last committed one when `after_seq` is not set.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/entities/", s.serveEntities)
+	mux.HandleFunc("/v1/subscribe", s.serveSubscribe)
+	return mux
+}
+
+func (s *Server) serveEntities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/entities/"), "/")
+	params := r.URL.Query()
+
+	block, err := uintParam(params, "block")
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	switch {
+	case len(path) == 1 && path[0] != "":
+		req := &ListRequest{
+			Table:          path[0],
+			Block:          block,
+			OrderBy:        params.Get("order_by"),
+			OrderDirection: params.Get("order_direction"),
+		}
+		if req.First, err = intParam(params, "first"); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if req.Skip, err = intParam(params, "skip"); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		for _, raw := range params["filter"] {
+			filter, err := query.ParseFilter(raw)
+			if err != nil {
+				s.writeError(w, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+				return
+			}
+			if req.Filters == nil {
+				req.Filters = map[string]string{}
+			}
+			req.Filters[filter.Column] = filter.Value
+		}
+
+		resp, err := s.List(r.Context(), req)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		writeJSON(w, resp)
+
+	case len(path) == 2 && path[0] != "" && path[1] != "":
+		resp, err := s.Get(r.Context(), &GetRequest{Table: path[0], ID: path[1], Block: block})
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		writeJSON(w, resp)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for %s", r.URL.Path))
+	}
+}
+
+func (s *Server) serveSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	req := &SubscribeRequest{Tables: r.URL.Query()["table"]}
+	if r.URL.Query().Get("after_seq") != "" {
+		afterSeq, err := uintParam(r.URL.Query(), "after_seq")
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		req.AfterSeq = &afterSeq
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+
+	err := s.Subscribe(r.Context(), req, func(event *cdc.Event) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started && r.Context().Err() == nil {
+		s.writeError(w, err)
+	}
+}
+
+func uintParam(params url.Values, name string) (uint64, error) {
+	raw := params.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidRequest, name, raw)
+	}
+	return value, nil
+}
+
+func intParam(params url.Values, name string) (int, error) {
+	raw := params.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidRequest, name, raw)
+	}
+	return value, nil
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnavailable):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrGone):
+		status = http.StatusGone
+	default:
+		s.logger.Warn("serving request", zap.Error(err))
+	}
+	writeError(w, status, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
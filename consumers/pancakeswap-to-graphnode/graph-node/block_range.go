@@ -13,6 +13,14 @@ type BlockRange struct {
 	EndBlock   uint64
 }
 
+// MarshalJSON encodes the range as a `[start,end)` string, `[start,)` when open-ended.
+func (b *BlockRange) MarshalJSON() ([]byte, error) {
+	if b.StartBlock == 0 && b.EndBlock == 0 {
+		return nil, fmt.Errorf("empty block range not allowed")
+	}
+	return json.Marshal(b.String())
+}
+
 func (b *BlockRange) UnmarshalJSON(data []byte) error {
 	// if data is a raw string representation of a block range eg: [23,2314) or "[23,2314)"
 	if string(data[0]) == "[" || string(data[0:2]) == `"[` {
@@ -33,6 +41,19 @@ func (b *BlockRange) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Contains reports whether `blockNum` is within the range, an `EndBlock` of 0 means the
+// range is open-ended, like `block_range @> blockNum` in SQL.
+func (b *BlockRange) Contains(blockNum uint64) bool {
+	if blockNum < b.StartBlock {
+		return false
+	}
+	return b.IsOpen() || blockNum < b.EndBlock
+}
+
+func (b *BlockRange) IsOpen() bool {
+	return b.EndBlock == 0
+}
+
 func (b *BlockRange) Value() (driver.Value, error) {
 	return b.String(), nil
 }
@@ -59,16 +80,11 @@ func (b *BlockRange) Scan(value interface{}) error {
 		return nil
 	}
 
-	sv, err := driver.String.ConvertValue(value)
+	bs, err := scanBytes(value)
 	if err != nil {
 		return err
 	}
 
-	bs, ok := sv.([]byte)
-	if !ok {
-		return fmt.Errorf("could not convert data to byte array")
-	}
-
 	return b.ParseBytes(bs)
 }
 
@@ -89,7 +105,7 @@ func (b *BlockRange) ParseBytes(bs []byte) error {
 	}
 
 	if vals[1] != "" {
-		endBlock, err := strconv.ParseUint(vals[0], 10, 64)
+		endBlock, err := strconv.ParseUint(vals[1], 10, 64)
 		if err != nil {
 			return err
 		}
@@ -3,6 +3,7 @@ package graphnode
 import (
 	"database/sql/driver"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -37,3 +38,34 @@ func (b *Bytes) UnmarshalCSV(hexStr []byte) error {
 	*b = d
 	return nil
 }
+
+// MarshalJSON encodes the value as a `0x` prefixed hex string, `null` when nil.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal("0x" + hex.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes a `0x` prefixed hex string.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to json unmarshall BYTES: %w", err)
+	}
+
+	if !strings.HasPrefix(s, "0x") {
+		return fmt.Errorf("failed to decode BYTES %q, expected 0x prefixed hex", s)
+	}
+	d, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return fmt.Errorf("failed to decode BYTES %q: %w", s, err)
+	}
+	*b = d
+	return nil
+}
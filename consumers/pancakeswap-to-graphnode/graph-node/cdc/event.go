@@ -0,0 +1,86 @@
+// Package cdc publishes the entity changes committed by the loader to downstream services.
+//
+// The changes of a block are appended to a journal, files of JSON lines in the feed
+// directory, before the block is saved, and marked committed once the save succeeded.
+// Each sink delivers the committed events in journal order from its own cursor, the
+// sequence number of the last event it delivered, persisted next to the journal. Events
+// are delivered at least once: after a restart a sink resumes from its cursor, and events
+// journaled for a block that the store committed but the feed did not mark are kept.
+//
+// The journal is split in segments of `segmentBlocks` blocks, the oldest are removed once
+// every sink delivered their events.
+package cdc
+
+import (
+	"strings"
+
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+)
+
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	// OperationUndo reverts an event of a block removed by a fork, `Undone` is the
+	// operation reverted and `Old`/`New` are swapped.
+	OperationUndo = "undo"
+)
+
+type Event struct {
+	// Seq orders the events of the feed, it increases by one with every event
+	Seq       uint64            `json:"seq"`
+	Table     string            `json:"table"`
+	ID        string            `json:"id"`
+	Block     uint64            `json:"block"`
+	BlockID   string            `json:"block_id,omitempty"`
+	Operation string            `json:"operation"`
+	Undone    string            `json:"undone,omitempty"`
+	Old       map[string]string `json:"old,omitempty"`
+	New       map[string]string `json:"new,omitempty"`
+}
+
+// NewEvents builds the events of the changes of a block, their `Seq` is assigned when they
+// are appended to the feed.
+func NewEvents(changes []*database.TableChange, clock *pbsubstreams.Clock) (out []*Event) {
+	for _, change := range changes {
+		event := &Event{
+			Table:     change.Table,
+			ID:        change.Pk,
+			Block:     clock.Number,
+			BlockID:   clock.Id,
+			Operation: strings.ToLower(change.Operation.String()),
+		}
+
+		for _, field := range change.Fields {
+			if change.Operation != database.TableChange_CREATE {
+				if event.Old == nil {
+					event.Old = map[string]string{}
+				}
+				event.Old[field.Name] = field.OldValue
+			}
+			if change.Operation != database.TableChange_DELETE {
+				if event.New == nil {
+					event.New = map[string]string{}
+				}
+				event.New[field.Name] = field.NewValue
+			}
+		}
+		out = append(out, event)
+	}
+	return out
+}
+
+// undo returns the event reverting `e`.
+func (e *Event) undo() *Event {
+	return &Event{
+		Table:     e.Table,
+		ID:        e.ID,
+		Block:     e.Block,
+		BlockID:   e.BlockID,
+		Operation: OperationUndo,
+		Undone:    e.Operation,
+		Old:       e.New,
+		New:       e.Old,
+	}
+}
@@ -0,0 +1,545 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	committedFile = "committed"
+	cursorSuffix  = ".cursor"
+
+	// segmentBlocks is the number of blocks a journal segment spans before a new one is
+	// started
+	segmentBlocks = 100
+	// keptSegments is the number of newest segments never compacted, `Undo` reverts the
+	// blocks they hold
+	keptSegments = 10
+	// commitSaveInterval is the delay the committed sequence number is written within
+	commitSaveInterval = time.Second
+
+	maxBatchSize    = 500
+	retryMinBackoff = 500 * time.Millisecond
+	retryMaxBackoff = 30 * time.Second
+)
+
+// Sink delivers events downstream. `Send` returns once the events are delivered, an error
+// has the same events sent again later.
+type Sink interface {
+	Send(ctx context.Context, events []*Event) error
+	Close() error
+}
+
+type Feed struct {
+	dir    string
+	logger *zap.Logger
+
+	lock sync.Mutex
+	// journal is the last segment, the one appended to
+	journal  *os.File
+	segments []uint64
+	// segmentBlock is the block of the first event of the last segment, unless it is empty
+	segmentBlock uint64
+	segmentEmpty bool
+
+	nextSeq   uint64
+	committed uint64
+	// savedCommitted is the committed sequence number last written, a write is scheduled
+	// by `saveTimer` while it is behind
+	savedCommitted uint64
+	saveTimer      *time.Timer
+	// uncommitted holds, in order, the last sequence number of the blocks appended but not
+	// committed yet
+	uncommitted []appendedBlock
+	// changed is closed, then replaced, whenever events are committed
+	changed chan struct{}
+
+	sinks []*sinkWorker
+	wg    sync.WaitGroup
+}
+
+type appendedBlock struct {
+	blockNum uint64
+	lastSeq  uint64
+}
+
+// Open opens the feed of `dir`, created when missing. The journal is recovered first: the
+// events appended but not committed are dropped, unless their block is at or below
+// `committedBlock`, the block of the cursor of the store, as the store did save them. Only
+// the last segments, the ones holding events not committed, are read.
+func Open(dir string, committedBlock uint64, logger *zap.Logger) (*Feed, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating feed directory: %w", err)
+	}
+
+	f := &Feed{dir: dir, logger: logger, changed: make(chan struct{})}
+
+	committed, err := readUint(filepath.Join(dir, committedFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading committed sequence: %w", err)
+	}
+
+	names, err := openSegments(dir, committed)
+	if err != nil {
+		return nil, err
+	}
+
+	// The segments after the one holding the first event not committed only hold events
+	// not committed
+	first := 0
+	for i, name := range names {
+		if name <= committed+1 {
+			first = i
+		}
+	}
+
+	var events []*Event
+	for _, name := range names[first:] {
+		segment, _, err := readJournal(segmentPath(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, segment...)
+	}
+
+	var kept []*Event
+	for _, event := range events {
+		if event.Seq <= committed || (event.Operation != OperationUndo && event.Block <= committedBlock) {
+			kept = append(kept, event)
+			continue
+		}
+		break
+	}
+	if len(kept) > 0 {
+		committed = kept[len(kept)-1].Seq
+	}
+	if dropped := len(events) - len(kept); dropped > 0 {
+		logger.Info("dropping journaled events of blocks not saved", zap.Int("event_count", dropped))
+	}
+
+	// The events kept, the partial line of a crash dropped, are rewritten in a single
+	// segment
+	if err := writeJournal(segmentPath(dir, names[first]), kept); err != nil {
+		return nil, err
+	}
+	for _, name := range names[first+1:] {
+		if err := os.Remove(segmentPath(dir, name)); err != nil {
+			return nil, fmt.Errorf("removing journal segment: %w", err)
+		}
+	}
+	if err := writeUint(filepath.Join(dir, committedFile), committed); err != nil {
+		return nil, err
+	}
+
+	f.journal, err = os.OpenFile(segmentPath(dir, names[first]), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	f.segments = names[:first+1]
+	f.segmentEmpty = len(kept) == 0
+	if len(kept) > 0 {
+		f.segmentBlock = kept[0].Block
+	}
+	f.committed = committed
+	f.savedCommitted = committed
+	f.nextSeq = committed + 1
+	return f, nil
+}
+
+// openSegments lists the segments of the journal of `dir`, an empty segment is created when
+// there is none.
+func openSegments(dir string, committed uint64) ([]uint64, error) {
+	names, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		return names, nil
+	}
+
+	if err := writeFile(segmentPath(dir, committed+1), nil); err != nil {
+		return nil, err
+	}
+	return []uint64{committed + 1}, nil
+}
+
+// AddSink registers a sink, `name` identifies its cursor. Sinks must be added before
+// `Start`. A sink whose cursor is behind the compacted events, a new one, starts after
+// them.
+func (f *Feed) AddSink(name string, sink Sink) error {
+	cursorPath := filepath.Join(f.dir, name+cursorSuffix)
+	cursor, err := readUint(cursorPath)
+	if err != nil {
+		return fmt.Errorf("reading cursor of sink %q: %w", name, err)
+	}
+	if first := f.segments[0]; cursor+1 < first {
+		f.logger.Warn("sink starts after the compacted events", zap.String("sink", name), zap.Uint64("cursor", cursor), zap.Uint64("first_seq", first))
+		cursor = first - 1
+	}
+
+	f.sinks = append(f.sinks, &sinkWorker{feed: f, name: name, sink: sink, cursor: cursor, cursorPath: cursorPath})
+	return nil
+}
+
+// Start delivers the committed events to every sink until `ctx` is cancelled.
+func (f *Feed) Start(ctx context.Context) {
+	for _, worker := range f.sinks {
+		worker := worker
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			worker.run(ctx)
+		}()
+	}
+}
+
+// Close waits for the sinks to stop, `ctx` of `Start` must be cancelled first, then
+// closes them.
+func (f *Feed) Close() error {
+	f.wg.Wait()
+	for _, worker := range f.sinks {
+		if err := worker.sink.Close(); err != nil {
+			f.logger.Warn("closing sink", zap.String("sink", worker.name), zap.Error(err))
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.saveTimer != nil {
+		f.saveTimer.Stop()
+		f.saveTimer = nil
+	}
+	if err := f.saveCommitted(); err != nil {
+		f.journal.Close()
+		return err
+	}
+	return f.journal.Close()
+}
+
+// Append journals the changes of a block about to be saved, they are delivered once the
+// block is committed. A new segment is started every `segmentBlocks` blocks.
+func (f *Feed) Append(changes []*database.TableChange, clock *pbsubstreams.Clock) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.segmentEmpty && clock.Number >= f.segmentBlock+segmentBlocks {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	events := NewEvents(changes, clock)
+	if err := f.appendEvents(events); err != nil {
+		return err
+	}
+	if f.segmentEmpty && len(events) > 0 {
+		f.segmentBlock, f.segmentEmpty = clock.Number, false
+	}
+	f.uncommitted = append(f.uncommitted, appendedBlock{blockNum: clock.Number, lastSeq: f.nextSeq - 1})
+	return nil
+}
+
+// rotate starts a new segment, the events of the previous one are all synced.
+func (f *Feed) rotate() error {
+	file, err := os.OpenFile(segmentPath(f.dir, f.nextSeq), os.O_CREATE|os.O_EXCL|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("creating journal segment: %w", err)
+	}
+	if err := f.journal.Close(); err != nil {
+		file.Close()
+		return fmt.Errorf("closing journal segment: %w", err)
+	}
+
+	f.journal = file
+	f.segments = append(f.segments, f.nextSeq)
+	f.segmentEmpty = true
+	return nil
+}
+
+// Commit marks the events of the blocks up to `blockNum` as committed.
+func (f *Feed) Commit(blockNum uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	committed := f.committed
+	for len(f.uncommitted) > 0 && f.uncommitted[0].blockNum <= blockNum {
+		committed = f.uncommitted[0].lastSeq
+		f.uncommitted = f.uncommitted[1:]
+	}
+	return f.commit(committed, false)
+}
+
+// Undo appends and commits an undo event for every committed event of the blocks at or
+// above `newHeadBlock`, latest first, once the store reverted them. The journal is read
+// backward, from its last segment, down to the last event not undone below
+// `newHeadBlock`: the events not undone are in block order.
+func (f *Feed) Undo(newHeadBlock uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.uncommitted) > 0 {
+		return fmt.Errorf("undoing blocks while block %d is not committed", f.uncommitted[0].blockNum)
+	}
+
+	// Events already undone are not undone twice, an undo event reverts every event of its
+	// block journaled before it
+	undone := map[uint64]bool{}
+	var undos []*Event
+	reachedHead := false
+	for i := len(f.segments) - 1; i >= 0 && !reachedHead; i-- {
+		events, _, err := readJournal(segmentPath(f.dir, f.segments[i]))
+		if err != nil {
+			return err
+		}
+
+		for j := len(events) - 1; j >= 0; j-- {
+			event := events[j]
+			if event.Seq > f.committed {
+				continue
+			}
+			if event.Operation == OperationUndo {
+				undone[event.Block] = true
+				continue
+			}
+			if undone[event.Block] {
+				continue
+			}
+			if event.Block < newHeadBlock {
+				reachedHead = true
+				break
+			}
+			undos = append(undos, event.undo())
+		}
+	}
+	if !reachedHead && f.segments[0] > 1 {
+		return fmt.Errorf("undoing blocks from %d: %w, the journal starts at event %d", newHeadBlock, ErrCompacted, f.segments[0])
+	}
+	if len(undos) == 0 {
+		return nil
+	}
+
+	if err := f.appendEvents(undos); err != nil {
+		return err
+	}
+	f.logger.Info("journaled undo events", zap.Uint64("new_head_block", newHeadBlock), zap.Int("event_count", len(undos)))
+	// Undo events are only recovered once written as committed, see `Open`
+	return f.commit(f.nextSeq-1, true)
+}
+
+func (f *Feed) appendEvents(events []*Event) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		event.Seq = f.nextSeq
+		f.nextSeq++
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	if _, err := f.journal.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := f.journal.Sync(); err != nil {
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	return nil
+}
+
+// commit marks the events up to `seq` as committed. The committed sequence number is
+// written within `commitSaveInterval`, right away with `save`: after a crash, `Open` gets
+// the events of the blocks saved by the store back from their block.
+func (f *Feed) commit(seq uint64, save bool) error {
+	if seq != f.committed {
+		f.committed = seq
+		close(f.changed)
+		f.changed = make(chan struct{})
+	}
+
+	if save {
+		return f.saveCommitted()
+	}
+	if f.saveTimer == nil && f.savedCommitted != f.committed {
+		f.saveTimer = time.AfterFunc(commitSaveInterval, f.saveCommittedLater)
+	}
+	return nil
+}
+
+func (f *Feed) saveCommittedLater() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.saveTimer = nil
+	if err := f.saveCommitted(); err != nil {
+		f.logger.Warn("saving committed sequence", zap.Error(err))
+	}
+}
+
+func (f *Feed) saveCommitted() error {
+	if f.savedCommitted == f.committed {
+		return nil
+	}
+	if err := writeUint(filepath.Join(f.dir, committedFile), f.committed); err != nil {
+		return err
+	}
+	f.savedCommitted = f.committed
+	return nil
+}
+
+// compact removes the oldest segments once every sink delivered their events, the newest
+// `keptSegments` are kept for `Undo`.
+func (f *Feed) compact() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delivered := f.committed
+	for _, worker := range f.sinks {
+		if cursor := atomic.LoadUint64(&worker.cursor); cursor < delivered {
+			delivered = cursor
+		}
+	}
+
+	removed := 0
+	defer func() { f.segments = f.segments[removed:] }()
+	for removed < len(f.segments)-keptSegments && f.segments[removed+1] <= delivered+1 {
+		if err := os.Remove(segmentPath(f.dir, f.segments[removed])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing journal segment: %w", err)
+		}
+		removed++
+	}
+	if removed > 0 {
+		f.logger.Debug("compacted journal", zap.Int("segment_count", removed), zap.Uint64("first_seq", f.segments[removed]))
+	}
+	return nil
+}
+
+// state returns the last committed sequence number and a channel closed when it changes.
+func (f *Feed) state() (uint64, <-chan struct{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.committed, f.changed
+}
+
+type sinkWorker struct {
+	feed *Feed
+	name string
+	sink Sink
+	// cursor is read by `compact` while the worker runs
+	cursor     uint64
+	cursorPath string
+}
+
+func (w *sinkWorker) run(ctx context.Context) {
+	logger := w.feed.logger.With(zap.String("sink", w.name))
+
+	reader := newJournalReader(w.feed.dir, atomic.LoadUint64(&w.cursor))
+	defer reader.Close()
+
+	var pending []*Event
+	backoff := retryMinBackoff
+	for {
+		committed, changed := w.feed.state()
+
+		for len(pending) < maxBatchSize {
+			event, err := reader.next(committed)
+			if err != nil {
+				logger.Error("sink stopped", zap.Error(err))
+				return
+			}
+			if event == nil {
+				break
+			}
+			pending = append(pending, event)
+		}
+
+		if len(pending) == 0 {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := w.sink.Send(ctx, pending); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warn("delivering events failed, retrying", zap.Int("event_count", len(pending)), zap.Duration("backoff", backoff), zap.Error(err))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+			continue
+		}
+		backoff = retryMinBackoff
+
+		cursor := pending[len(pending)-1].Seq
+		pending = pending[:0]
+		if err := writeUint(w.cursorPath, cursor); err != nil {
+			logger.Error("sink stopped", zap.Error(err))
+			return
+		}
+		atomic.StoreUint64(&w.cursor, cursor)
+
+		if err := w.feed.compact(); err != nil {
+			logger.Warn("compacting journal", zap.Error(err))
+		}
+	}
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func writeUint(path string, value uint64) error {
+	return writeFile(path, []byte(strconv.FormatUint(value, 10)+"\n"))
+}
+
+// writeFile replaces the content of `path` atomically.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmp, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("syncing %s: %w", tmp, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
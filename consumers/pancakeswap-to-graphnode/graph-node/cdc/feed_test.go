@@ -0,0 +1,376 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/substream-pancakeswap/pb/pcs/database/v1"
+	pbsubstreams "github.com/streamingfast/substreams/pb/sf/substreams/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memorySink struct {
+	lock     sync.Mutex
+	events   []*Event
+	failures int
+}
+
+func (s *memorySink) Send(_ context.Context, events []*Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("boom")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) waitFor(t *testing.T, count int) []*Event {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return len(s.events) >= count
+	}, 5*time.Second, 5*time.Millisecond)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Event(nil), s.events...)
+}
+
+func clock(blockNum uint64) *pbsubstreams.Clock {
+	return &pbsubstreams.Clock{Id: fmt.Sprintf("block-%d", blockNum), Number: blockNum}
+}
+
+func pairChange(id string, operation database.TableChange_Operation, oldValue, newValue string) *database.TableChange {
+	return &database.TableChange{
+		Table:     "pair",
+		Pk:        id,
+		Operation: operation,
+		Fields:    []*database.Field{{Name: "reserve0", OldValue: oldValue, NewValue: newValue}},
+	}
+}
+
+func appendBlock(t *testing.T, feed *Feed, blockNum uint64, commit bool) {
+	t.Helper()
+	require.NoError(t, feed.Append([]*database.TableChange{
+		pairChange(fmt.Sprintf("p%d", blockNum), database.TableChange_CREATE, "", "1"),
+		pairChange("p", database.TableChange_UPDATE, fmt.Sprintf("%d", blockNum-1), fmt.Sprintf("%d", blockNum)),
+	}, clock(blockNum)))
+	if commit {
+		require.NoError(t, feed.Commit(blockNum))
+	}
+}
+
+// journalEvents reads the events of every segment of the journal of `dir`.
+func journalEvents(t *testing.T, dir string) (out []*Event) {
+	t.Helper()
+	names, err := listSegments(dir)
+	require.NoError(t, err)
+	for _, name := range names {
+		events, _, err := readJournal(segmentPath(dir, name))
+		require.NoError(t, err)
+		out = append(out, events...)
+	}
+	return out
+}
+
+func TestNewEvents(t *testing.T) {
+	events := NewEvents([]*database.TableChange{
+		pairChange("a", database.TableChange_CREATE, "", "1"),
+		pairChange("a", database.TableChange_UPDATE, "1", "2"),
+		pairChange("a", database.TableChange_DELETE, "2", ""),
+	}, clock(10))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, &Event{Table: "pair", ID: "a", Block: 10, BlockID: "block-10", Operation: OperationCreate, New: map[string]string{"reserve0": "1"}}, events[0])
+	assert.Equal(t, &Event{Table: "pair", ID: "a", Block: 10, BlockID: "block-10", Operation: OperationUpdate, Old: map[string]string{"reserve0": "1"}, New: map[string]string{"reserve0": "2"}}, events[1])
+	assert.Equal(t, &Event{Table: "pair", ID: "a", Block: 10, BlockID: "block-10", Operation: OperationDelete, Old: map[string]string{"reserve0": "2"}}, events[2])
+}
+
+func TestFeed_Deliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+
+	sink := &memorySink{failures: 2}
+	require.NoError(t, feed.AddSink("memory", sink))
+	feed.Start(ctx)
+
+	appendBlock(t, feed, 10, true)
+	appendBlock(t, feed, 11, true)
+	appendBlock(t, feed, 12, false)
+
+	events := sink.waitFor(t, 4)
+	require.Len(t, events, 4)
+	for i, event := range events {
+		assert.Equal(t, uint64(i+1), event.Seq)
+	}
+	assert.Equal(t, uint64(11), events[3].Block)
+
+	// Block 12 is not committed, it is never delivered
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, sink.waitFor(t, 4), 4)
+
+	cancel()
+	require.NoError(t, feed.Close())
+
+	cursor, err := readUint(filepath.Join(dir, "memory"+cursorSuffix))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), cursor)
+}
+
+func TestFeed_Resume(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	first := &memorySink{}
+	require.NoError(t, feed.AddSink("memory", first))
+	feed.Start(ctx)
+	appendBlock(t, feed, 10, true)
+	first.waitFor(t, 2)
+	cancel()
+	require.NoError(t, feed.Close())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	feed, err = Open(dir, 10, zap.NewNop())
+	require.NoError(t, err)
+	second := &memorySink{}
+	require.NoError(t, feed.AddSink("memory", second))
+	feed.Start(ctx)
+	appendBlock(t, feed, 11, true)
+
+	// Only the events not delivered before the restart
+	events := second.waitFor(t, 2)
+	require.Len(t, events, 2)
+	assert.Equal(t, uint64(3), events[0].Seq)
+	assert.Equal(t, uint64(11), events[0].Block)
+
+	cancel()
+	require.NoError(t, feed.Close())
+}
+
+func TestOpen_Recover(t *testing.T) {
+	dir := t.TempDir()
+
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	appendBlock(t, feed, 10, true)
+	// Crashes after saving block 11 but before committing it to the feed
+	appendBlock(t, feed, 11, false)
+	// Crashes while saving block 12
+	appendBlock(t, feed, 12, false)
+	require.NoError(t, feed.Close())
+
+	// A partial line is left by a crash while appending
+	journal, err := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"seq":7,"tab`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	feed, err = Open(dir, 11, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), feed.committed)
+
+	events := journalEvents(t, dir)
+	require.Len(t, events, 4)
+	assert.Equal(t, uint64(11), events[3].Block)
+
+	// The sequence continues after the events kept
+	appendBlock(t, feed, 12, true)
+	events = journalEvents(t, dir)
+	require.Len(t, events, 6)
+	assert.Equal(t, uint64(5), events[4].Seq)
+	require.NoError(t, feed.Close())
+}
+
+func TestFeed_Undo(t *testing.T) {
+	dir := t.TempDir()
+
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	defer feed.Close()
+
+	appendBlock(t, feed, 10, true)
+	appendBlock(t, feed, 11, true)
+	appendBlock(t, feed, 12, true)
+
+	require.NoError(t, feed.Undo(11))
+	// Blocks already undone are not undone twice
+	require.NoError(t, feed.Undo(11))
+
+	events := journalEvents(t, dir)
+	require.Len(t, events, 10)
+	assert.Equal(t, uint64(10), feed.committed)
+
+	undos := events[6:]
+	assert.Equal(t, []uint64{12, 12, 11, 11}, []uint64{undos[0].Block, undos[1].Block, undos[2].Block, undos[3].Block})
+	assert.Equal(t, &Event{
+		Seq:       7,
+		Table:     "pair",
+		ID:        "p",
+		Block:     12,
+		BlockID:   "block-12",
+		Operation: OperationUndo,
+		Undone:    OperationUpdate,
+		Old:       map[string]string{"reserve0": "12"},
+		New:       map[string]string{"reserve0": "11"},
+	}, undos[0])
+	assert.Equal(t, OperationCreate, undos[1].Undone)
+
+	// Block 11 is processed again on the new chain, then reverted again
+	appendBlock(t, feed, 11, true)
+	require.NoError(t, feed.Undo(11))
+	events = journalEvents(t, dir)
+	require.Len(t, events, 14)
+	assert.Equal(t, uint64(11), events[13].Block)
+	assert.Equal(t, uint64(11), events[12].Block)
+}
+
+func TestFeed_Compact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	sink := &memorySink{}
+	require.NoError(t, feed.AddSink("memory", sink))
+	feed.Start(ctx)
+
+	// Every block starts a new segment
+	blockCount := keptSegments + 5
+	for i := 0; i < blockCount; i++ {
+		appendBlock(t, feed, uint64(10+i*segmentBlocks), true)
+	}
+	sink.waitFor(t, 2*blockCount)
+
+	require.Eventually(t, func() bool {
+		names, err := listSegments(dir)
+		require.NoError(t, err)
+		return len(names) == keptSegments
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// The compacted blocks can no longer be undone
+	err = feed.Undo(10)
+	assert.True(t, errors.Is(err, ErrCompacted), "got %v", err)
+
+	cancel()
+	require.NoError(t, feed.Close())
+
+	// The committed sequence number is written when closed
+	committed, err := readUint(filepath.Join(dir, committedFile))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2*blockCount), committed)
+
+	// A new sink starts after the compacted events
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	feed, err = Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	late := &memorySink{}
+	require.NoError(t, feed.AddSink("late", late))
+	feed.Start(ctx)
+
+	events := late.waitFor(t, 2*keptSegments)
+	require.Len(t, events, 2*keptSegments)
+	assert.Equal(t, uint64(2*(blockCount-keptSegments)+1), events[0].Seq)
+
+	// The blocks of the kept segments are still undone
+	require.NoError(t, feed.Undo(uint64(10+(blockCount-1)*segmentBlocks)))
+
+	cancel()
+	require.NoError(t, feed.Close())
+}
+
+func TestFeed_UndoUncommitted(t *testing.T) {
+	feed, err := Open(t.TempDir(), 0, zap.NewNop())
+	require.NoError(t, err)
+	defer feed.Close()
+
+	appendBlock(t, feed, 10, false)
+	assert.EqualError(t, feed.Undo(10), "undoing blocks while block 10 is not committed")
+}
+
+func TestNDJSONSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.ndjson")
+
+	sink, err := NewNDJSONSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), []*Event{
+		{Seq: 1, Table: "pair", ID: "a", Block: 10, Operation: OperationCreate, New: map[string]string{"reserve0": "1"}},
+		{Seq: 2, Table: "pair", ID: "a", Block: 11, Operation: OperationDelete, Old: map[string]string{"reserve0": "1"}},
+	}))
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"seq":1,"table":"pair","id":"a","block":10,"operation":"create","new":{"reserve0":"1"}}
+{"seq":2,"table":"pair","id":"a","block":11,"operation":"delete","old":{"reserve0":"1"}}
+`, string(data))
+}
+
+func TestTail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	feed, err := Open(dir, 0, zap.NewNop())
+	require.NoError(t, err)
+	appendBlock(t, feed, 10, true)
+	appendBlock(t, feed, 11, true)
+
+	events := make(chan *Event, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Tail(ctx, dir, 1, time.Millisecond, func(event *Event) error {
+			events <- event
+			return nil
+		})
+	}()
+
+	receive := func() *Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no event received")
+			return nil
+		}
+	}
+	assert.Equal(t, uint64(2), receive().Seq)
+	assert.Equal(t, uint64(3), receive().Seq)
+	assert.Equal(t, uint64(4), receive().Seq)
+
+	// Followed across a restart of the feed, which replaces the journal
+	appendBlock(t, feed, 12, false)
+	require.NoError(t, feed.Close())
+	feed, err = Open(dir, 11, zap.NewNop())
+	require.NoError(t, err)
+	appendBlock(t, feed, 12, true)
+
+	event := receive()
+	assert.Equal(t, uint64(5), event.Seq)
+	assert.Equal(t, uint64(12), event.Block)
+	assert.Equal(t, uint64(6), receive().Seq)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	require.NoError(t, feed.Close())
+}
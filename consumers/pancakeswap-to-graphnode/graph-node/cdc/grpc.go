@@ -0,0 +1,195 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	GRPCServiceName = "pcs.cdc.v1.Feed"
+	// GRPCSubscribeMethod streams the events, one `google.protobuf.Struct` per event, for
+	// a `google.protobuf.Empty` request.
+	GRPCSubscribeMethod = "/" + GRPCServiceName + "/Subscribe"
+)
+
+var errNoSubscriber = errors.New("no subscriber connected")
+
+// GRPCSubscribeStreamDesc describes the `Subscribe` stream to clients.
+var GRPCSubscribeStreamDesc = &grpc.StreamDesc{StreamName: "Subscribe", ServerStreams: true}
+
+// GRPCSink serves the events to the clients subscribed to its gRPC server. Events are
+// delivered once sent to at least one subscriber, they are retained while no client is
+// connected.
+type GRPCSink struct {
+	server   *grpc.Server
+	listener net.Listener
+	logger   *zap.Logger
+
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	batches chan *delivery
+}
+
+type delivery struct {
+	events []*structpb.Struct
+	done   chan error
+}
+
+// NewGRPCSink starts serving the `pcs.cdc.v1.Feed` service on `listenAddr`.
+func NewGRPCSink(listenAddr string, logger *zap.Logger) (*GRPCSink, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", listenAddr, err)
+	}
+
+	s := &GRPCSink{
+		server:      grpc.NewServer(),
+		listener:    listener,
+		logger:      logger,
+		subscribers: map[*subscriber]struct{}{},
+	}
+	s.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: GRPCServiceName,
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Subscribe",
+			Handler:       s.subscribe,
+			ServerStreams: true,
+		}},
+	}, s)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			logger.Error("cdc grpc server stopped", zap.Error(err))
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *GRPCSink) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *GRPCSink) Send(ctx context.Context, events []*Event) error {
+	batch := make([]*structpb.Struct, len(events))
+	for i, event := range events {
+		message, err := eventStruct(event)
+		if err != nil {
+			return err
+		}
+		batch[i] = message
+	}
+
+	s.lock.Lock()
+	var deliveries []*delivery
+	for sub := range s.subscribers {
+		d := &delivery{events: batch, done: make(chan error, 1)}
+		select {
+		case sub.batches <- d:
+			deliveries = append(deliveries, d)
+		default:
+			// The subscriber still sends the previous batch
+		}
+	}
+	s.lock.Unlock()
+
+	if len(deliveries) == 0 {
+		return errNoSubscriber
+	}
+
+	var delivered bool
+	var lastErr error
+	for _, d := range deliveries {
+		select {
+		case err := <-d.done:
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			delivered = true
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !delivered {
+		return fmt.Errorf("sending to subscribers: %w", lastErr)
+	}
+	return nil
+}
+
+func (s *GRPCSink) subscribe(_ interface{}, stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+
+	sub := &subscriber{batches: make(chan *delivery, 1)}
+	s.lock.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.subscribers, sub)
+		s.lock.Unlock()
+
+		// Fails a batch handed over while the stream ended
+		select {
+		case d := <-sub.batches:
+			d.done <- errNoSubscriber
+		default:
+		}
+	}()
+
+	for {
+		select {
+		case d := <-sub.batches:
+			err := sendBatch(stream, d.events)
+			d.done <- err
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func sendBatch(stream grpc.ServerStream, events []*structpb.Struct) error {
+	for _, event := range events {
+		if err := stream.SendMsg(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GRPCSink) Close() error {
+	s.server.Stop()
+	return nil
+}
+
+func eventStruct(event *Event) (*structpb.Struct, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+	return structpb.NewStruct(fields)
+}
@@ -0,0 +1,52 @@
+package cdc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGRPCSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sink, err := NewGRPCSink("127.0.0.1:0", zap.NewNop())
+	require.NoError(t, err)
+	defer sink.Close()
+
+	events := []*Event{{Seq: 1, Table: "pair", ID: "a", Block: 10, Operation: OperationCreate, New: map[string]string{"reserve0": "1"}}}
+
+	// Retained until a client subscribes
+	assert.Equal(t, errNoSubscriber, sink.Send(ctx, events))
+
+	conn, err := grpc.DialContext(ctx, sink.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, GRPCSubscribeStreamDesc, GRPCSubscribeMethod)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&emptypb.Empty{}))
+	require.NoError(t, stream.CloseSend())
+
+	require.Eventually(t, func() bool {
+		return sink.Send(ctx, events) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	message := &structpb.Struct{}
+	require.NoError(t, stream.RecvMsg(message))
+	assert.Equal(t, map[string]interface{}{
+		"seq":       float64(1),
+		"table":     "pair",
+		"id":        "a",
+		"block":     float64(10),
+		"operation": "create",
+		"new":       map[string]interface{}{"reserve0": "1"},
+	}, message.AsMap())
+}
@@ -0,0 +1,244 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentPrefix = "journal-"
+	segmentSuffix = ".ndjson"
+)
+
+// ErrCompacted is returned when reading events removed from the journal once every sink
+// delivered them.
+var ErrCompacted = errors.New("events compacted")
+
+// The journal is split in segments, the files `journal-<seq>.ndjson`, each holding the
+// events from sequence number `<seq>` up to the first one of the next segment.
+func segmentPath(dir string, name uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", segmentPrefix, name, segmentSuffix))
+}
+
+// listSegments returns the names of the segments of `dir`, in order.
+func listSegments(dir string) (out []uint64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing journal segments: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, seq)
+	}
+	return out, nil
+}
+
+// segmentFor returns the segment holding the event `seq` among `names`.
+func segmentFor(names []uint64, seq uint64) (uint64, error) {
+	if len(names) == 0 || names[0] > seq {
+		first := uint64(0)
+		if len(names) > 0 {
+			first = names[0]
+		}
+		return 0, fmt.Errorf("%w: event %d is before the first one of the journal, %d", ErrCompacted, seq, first)
+	}
+
+	name := names[0]
+	for _, candidate := range names[1:] {
+		if candidate > seq {
+			break
+		}
+		name = candidate
+	}
+	return name, nil
+}
+
+// journalReader reads the events of the journal from a sequence number on, following the
+// segments as they are appended to, rotated and replaced. An event is only returned once
+// complete and committed.
+type journalReader struct {
+	dir string
+	// lastSeq is the sequence number of the last event returned
+	lastSeq uint64
+
+	name    uint64
+	file    *os.File
+	info    os.FileInfo
+	reader  *bufio.Reader
+	partial []byte
+	peeked  *Event
+}
+
+// newJournalReader returns a reader of the events after `afterSeq`, the journal is opened
+// once it has segments.
+func newJournalReader(dir string, afterSeq uint64) *journalReader {
+	return &journalReader{dir: dir, lastSeq: afterSeq}
+}
+
+// open opens the segment holding the event following the last one returned.
+func (r *journalReader) open() error {
+	names, err := listSegments(r.dir)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	name, err := segmentFor(names, r.lastSeq+1)
+	if err != nil {
+		return err
+	}
+
+	r.Close()
+	file, err := os.Open(segmentPath(r.dir, name))
+	if err != nil {
+		return fmt.Errorf("opening journal segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("reading journal segment: %w", err)
+	}
+
+	r.name, r.file, r.info = name, file, info
+	r.reader = bufio.NewReader(file)
+	r.partial, r.peeked = r.partial[:0], nil
+	return nil
+}
+
+// reopenIfReplaced reopens the segment read when the feed replaced it, as it does for the
+// last segments when opened.
+func (r *journalReader) reopenIfReplaced() error {
+	if r.file == nil {
+		return nil
+	}
+	info, err := os.Stat(segmentPath(r.dir, r.name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info == nil || !os.SameFile(info, r.info) {
+		return r.open()
+	}
+	return nil
+}
+
+// next returns the next event when its sequence number is at most `committed`, nil
+// otherwise.
+func (r *journalReader) next(committed uint64) (*Event, error) {
+	for r.peeked == nil {
+		if r.file == nil {
+			if err := r.open(); err != nil || r.file == nil {
+				return nil, err
+			}
+		}
+
+		line, err := r.reader.ReadBytes('\n')
+		r.partial = append(r.partial, line...)
+		if err == io.EOF {
+			// A complete segment followed by a newer one was rotated, the journal goes on there
+			rotated, err := r.rotated()
+			if err != nil || !rotated {
+				return nil, err
+			}
+			if err := r.open(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading journal: %w", err)
+		}
+
+		event := &Event{}
+		if err := json.Unmarshal(r.partial, event); err != nil {
+			return nil, fmt.Errorf("decoding journal line: %w", err)
+		}
+		r.partial = r.partial[:0]
+		// Reopened segments are read from their start
+		if event.Seq > r.lastSeq {
+			r.peeked = event
+		}
+	}
+
+	if r.peeked.Seq > committed {
+		return nil, nil
+	}
+	event := r.peeked
+	r.peeked = nil
+	r.lastSeq = event.Seq
+	return event, nil
+}
+
+func (r *journalReader) rotated() (bool, error) {
+	if len(r.partial) > 0 {
+		return false, nil
+	}
+	names, err := listSegments(r.dir)
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0 && names[len(names)-1] > r.name, nil
+}
+
+func (r *journalReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// readJournal reads the events of the segment `path`, a partial last line, an append
+// interrupted by a crash, is ignored and reported by `partial`.
+func readJournal(path string) (out []*Event, partial bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("opening journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return out, len(line) > 0, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("reading journal: %w", err)
+		}
+
+		event := &Event{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return nil, false, fmt.Errorf("decoding journal line: %w", err)
+		}
+		out = append(out, event)
+	}
+}
+
+func writeJournal(path string, events []*Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+	}
+	return writeFile(path, buf.Bytes())
+}
@@ -0,0 +1,55 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NDJSONSink writes the events as JSON lines to a file, or to the standard output.
+type NDJSONSink struct {
+	writer io.Writer
+	file   *os.File
+}
+
+// NewNDJSONSink appends the events to the file at `path`, created when missing, or writes
+// them to the standard output when `path` is "-".
+func NewNDJSONSink(path string) (*NDJSONSink, error) {
+	if path == "-" {
+		return &NDJSONSink{writer: os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &NDJSONSink{writer: file, file: file}, nil
+}
+
+func (s *NDJSONSink) Send(_ context.Context, events []*Event) error {
+	buf := bufio.NewWriter(s.writer)
+	encoder := json.NewEncoder(buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("writing events: %w", err)
+	}
+
+	if s.file != nil {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+func (s *NDJSONSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+// Store journals undo events for the blocks its store reverts, the other calls go to the
+// store as is.
+type Store struct {
+	storage.Store
+	feed *Feed
+}
+
+func NewStore(store storage.Store, feed *Feed) *Store {
+	return &Store{Store: store, feed: feed}
+}
+
+func (s *Store) CleanDataAtBlock(ctx context.Context, blockNum uint64) error {
+	if err := s.Store.CleanDataAtBlock(ctx, blockNum); err != nil {
+		return err
+	}
+	if err := s.feed.Undo(blockNum + 1); err != nil {
+		return fmt.Errorf("journaling undo events: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) CleanUpFork(ctx context.Context, newHeadBlock uint64) error {
+	if err := s.Store.CleanUpFork(ctx, newHeadBlock); err != nil {
+		return err
+	}
+	if err := s.feed.Undo(newHeadBlock); err != nil {
+		return fmt.Errorf("journaling undo events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cdc
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+)
+
+// Tail calls `handle` with the committed events of the feed of `dir` whose sequence
+// number is above `afterSeq`, following the journal as the loader, possibly another
+// process, appends to it until `ctx` is cancelled or `handle` fails. The journal is
+// polled every `interval` once every committed event is handled, the events are seen
+// committed within `commitSaveInterval`. It fails with `ErrCompacted` when the events
+// following `afterSeq` were compacted.
+func Tail(ctx context.Context, dir string, afterSeq uint64, interval time.Duration, handle func(event *Event) error) error {
+	reader := newJournalReader(dir, afterSeq)
+	defer reader.Close()
+
+	for {
+		// Read first: the feed replaces its last segment before its committed sequence
+		// number when opened, a committed sequence number never applies to a replaced segment
+		committed, err := readUint(filepath.Join(dir, committedFile))
+		if err != nil {
+			return err
+		}
+		if err := reader.reopenIfReplaced(); err != nil {
+			return err
+		}
+
+		handled := false
+		for {
+			event, err := reader.next(committed)
+			if err != nil {
+				return err
+			}
+			if event == nil {
+				break
+			}
+			if err := handle(event); err != nil {
+				return err
+			}
+			handled = true
+		}
+		if handled {
+			continue
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Committed returns the sequence number of the last event committed to the feed of `dir`,
+// 0 when there is none. It lags behind the feed by up to `commitSaveInterval`.
+func Committed(dir string) (uint64, error) {
+	return readUint(filepath.Join(dir, committedFile))
+}
@@ -0,0 +1,82 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookSink POSTs the events, as a JSON array, to an HTTP endpoint. A request failing,
+// or answered with a 5xx status, is retried with an exponential backoff up to `Retries`
+// times before the batch is reported failed to the feed, which retries it later.
+type WebhookSink struct {
+	URL     string
+	Retries int
+	Backoff time.Duration
+	Client  *http.Client
+}
+
+func NewWebhookSink(url string, retries int, backoff time.Duration) *WebhookSink {
+	return &WebhookSink{
+		URL:     url,
+		Retries: retries,
+		Backoff: backoff,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Send(ctx context.Context, events []*Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("encoding events: %w", err)
+	}
+
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := s.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= s.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (s *WebhookSink) post(ctx context.Context, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("posting events: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("posting events: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("posting events: %s", resp.Status)
+	}
+	return false, nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
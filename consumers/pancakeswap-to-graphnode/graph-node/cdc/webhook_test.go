@@ -0,0 +1,57 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var requests int
+	var received []*Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, 3, time.Millisecond)
+	require.NoError(t, sink.Send(context.Background(), []*Event{{Seq: 1, Table: "pair", ID: "a", Block: 10, Operation: OperationCreate}}))
+
+	assert.Equal(t, 3, requests)
+	require.Len(t, received, 1)
+	assert.Equal(t, uint64(1), received[0].Seq)
+}
+
+func TestWebhookSink_Failures(t *testing.T) {
+	var requests int
+	status := http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, 2, time.Millisecond)
+	err := sink.Send(context.Background(), []*Event{{Seq: 1}})
+	assert.EqualError(t, err, "posting events: 502 Bad Gateway")
+	assert.Equal(t, 3, requests)
+
+	// Client errors are not retried
+	requests = 0
+	status = http.StatusBadRequest
+	err = sink.Send(context.Background(), []*Event{{Seq: 1}})
+	assert.EqualError(t, err, "posting events: 400 Bad Request")
+	assert.Equal(t, 1, requests)
+}
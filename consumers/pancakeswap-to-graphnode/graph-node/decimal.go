@@ -0,0 +1,246 @@
+package graphnode
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxSignificantDigits is the precision graph-node normalizes every BigDecimal to.
+	MaxSignificantDigits = 34
+
+	// divisionPrecision is the number of significant digits a quotient is computed to
+	// before being normalized, as done by the `bigdecimal` crate graph-node relies on.
+	divisionPrecision = 100
+)
+
+var (
+	bigOne = big.NewInt(1)
+	bigTen = big.NewInt(10)
+)
+
+// BigDecimal is an arbitrary precision decimal number, `digits * 10^exp`, following the
+// rules of graph-node's BigDecimal: every value is rounded to 34 significant digits and its
+// trailing zeros are stripped, so two equal numbers always have the same representation.
+//
+// Rounding mirrors the `bigdecimal` crate graph-node uses: positive values are rounded half
+// up on the first dropped digit while negative values are truncated towards zero.
+type BigDecimal struct {
+	digits *big.Int
+	exp    int64
+}
+
+// NewBigDecimal returns the normalized value of `digits * 10^exp`.
+func NewBigDecimal(digits *big.Int, exp int64) BigDecimal {
+	return normalize(new(big.Int).Set(digits), exp)
+}
+
+// ParseBigDecimal parses a decimal number, either plain (`-12.5`) or in scientific notation
+// (`1.25e-3`), and normalizes it.
+func ParseBigDecimal(s string) (BigDecimal, error) {
+	mantissa, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		parsed, err := strconv.ParseInt(s[i+1:], 10, 64)
+		if err != nil {
+			return BigDecimal{}, fmt.Errorf("invalid exponent in decimal %q: %w", s, err)
+		}
+		mantissa, exp = s[:i], parsed
+	}
+
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		fraction := mantissa[i+1:]
+		if strings.ContainsAny(fraction, "+-") {
+			return BigDecimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		mantissa = mantissa[:i] + fraction
+		exp -= int64(len(fraction))
+	}
+
+	digits, ok := new(big.Int).SetString(mantissa, 10)
+	if !ok {
+		return BigDecimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return normalize(digits, exp), nil
+}
+
+// NewBigDecimalFromBigFloat returns the shortest decimal that reads back as `f` at its
+// precision, normalized, so that the binary approximation of `1823.231` is 1823.231. `f`
+// must be finite.
+//
+// Printing the first 34 digits instead (`f.Text('e', 33)`) keeps the binary error: the
+// 100-bit float parsed from `1823.231` reads 1823.231000000000000000000000000207. Floats
+// decoded from the gob values of legacy snapshots are such approximations, the shortest
+// representation gets the original decimal back before it is rounded to 34 digits.
+func NewBigDecimalFromBigFloat(f *big.Float) BigDecimal {
+	d, err := ParseBigDecimal(f.Text('g', -1))
+	if err != nil {
+		panic(fmt.Sprintf("converting %s to decimal: %s", f.String(), err))
+	}
+	return d
+}
+
+// normalize rounds `digits * 10^exp` to 34 significant digits and strips its trailing
+// zeros, it takes ownership of `digits`.
+func normalize(digits *big.Int, exp int64) BigDecimal {
+	if digits.Sign() == 0 {
+		return BigDecimal{digits: new(big.Int)}
+	}
+
+	if count := digitCount(digits); count > MaxSignificantDigits {
+		drop := count - MaxSignificantDigits
+		digits, exp = roundDigits(digits, drop), exp+drop
+	}
+
+	quotient, remainder := new(big.Int), new(big.Int)
+	for {
+		quotient.QuoRem(digits, bigTen, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		digits, quotient = quotient, digits
+		exp++
+	}
+
+	return BigDecimal{digits: digits, exp: exp}
+}
+
+// roundDigits drops the `drop` last digits of `digits`, rounding positive values half up
+// and truncating negative ones.
+func roundDigits(digits *big.Int, drop int64) *big.Int {
+	divisor := pow10(drop)
+	quotient, remainder := new(big.Int).QuoRem(digits, divisor, new(big.Int))
+	if remainder.Sign() > 0 && remainder.Lsh(remainder, 1).Cmp(divisor) >= 0 {
+		quotient.Add(quotient, bigOne)
+	}
+	return quotient
+}
+
+func digitCount(i *big.Int) int64 {
+	s := i.String()
+	if i.Sign() < 0 {
+		return int64(len(s) - 1)
+	}
+	return int64(len(s))
+}
+
+func pow10(n int64) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(n), nil)
+}
+
+// aligned returns the digits of `d` and `o` scaled to their smallest exponent.
+func (d BigDecimal) aligned(o BigDecimal) (*big.Int, *big.Int, int64) {
+	a, b := new(big.Int).Set(d.digits), new(big.Int).Set(o.digits)
+	switch {
+	case d.exp > o.exp:
+		a.Mul(a, pow10(d.exp-o.exp))
+		return a, b, o.exp
+	case o.exp > d.exp:
+		b.Mul(b, pow10(o.exp-d.exp))
+	}
+	return a, b, d.exp
+}
+
+func (d BigDecimal) Add(o BigDecimal) BigDecimal {
+	a, b, exp := d.aligned(o)
+	return normalize(a.Add(a, b), exp)
+}
+
+func (d BigDecimal) Sub(o BigDecimal) BigDecimal {
+	a, b, exp := d.aligned(o)
+	return normalize(a.Sub(a, b), exp)
+}
+
+func (d BigDecimal) Mul(o BigDecimal) BigDecimal {
+	return normalize(new(big.Int).Mul(d.digits, o.digits), d.exp+o.exp)
+}
+
+// Quo divides `d` by `o`. Like graph-node, the quotient is computed to 100 significant
+// digits, rounded half up on the next one, then normalized. It panics when `o` is zero.
+func (d BigDecimal) Quo(o BigDecimal) BigDecimal {
+	if o.digits.Sign() == 0 {
+		panic("decimal division by zero")
+	}
+	if d.digits.Sign() == 0 {
+		return d
+	}
+
+	num := new(big.Int).Abs(d.digits)
+	den := new(big.Int).Abs(o.digits)
+	exp := d.exp - o.exp
+
+	for num.Cmp(den) < 0 {
+		num.Mul(num, bigTen)
+		exp--
+	}
+
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if remainder.Sign() != 0 {
+		precision := digitCount(quotient)
+		digit := new(big.Int)
+		for remainder.Sign() != 0 && precision < divisionPrecision {
+			digit.QuoRem(remainder.Mul(remainder, bigTen), den, remainder)
+			quotient.Mul(quotient, bigTen).Add(quotient, digit)
+			precision++
+			exp--
+		}
+		if remainder.Sign() != 0 {
+			digit.Quo(remainder.Mul(remainder, bigTen), den)
+			if digit.Int64() >= 5 {
+				quotient.Add(quotient, bigOne)
+			}
+		}
+	}
+
+	if d.digits.Sign() != o.digits.Sign() {
+		quotient.Neg(quotient)
+	}
+	return normalize(quotient, exp)
+}
+
+func (d BigDecimal) Cmp(o BigDecimal) int {
+	a, b, _ := d.aligned(o)
+	return a.Cmp(b)
+}
+
+func (d BigDecimal) Sign() int { return d.digits.Sign() }
+
+// Round returns `d` rounded to `digits` significant digits, with the rounding rules of the
+// normalization.
+func (d BigDecimal) Round(digits int64) BigDecimal {
+	if count := digitCount(d.digits); count > digits {
+		return normalize(roundDigits(d.digits, count-digits), d.exp+count-digits)
+	}
+	return d
+}
+
+// BigFloat returns `d` as a binary float of precision `prec`, rounded to the nearest.
+func (d BigDecimal) BigFloat(prec uint) *big.Float {
+	f, _, err := big.ParseFloat(d.String(), 10, prec, big.ToNearestEven)
+	if err != nil {
+		panic(fmt.Sprintf("converting decimal %s to float: %s", d.String(), err))
+	}
+	return f
+}
+
+// String formats `d` in plain notation, without exponent, as graph-node does.
+func (d BigDecimal) String() string {
+	abs := new(big.Int).Abs(d.digits).String()
+
+	var out string
+	switch {
+	case d.exp >= 0:
+		out = abs + strings.Repeat("0", int(d.exp))
+	case -d.exp >= int64(len(abs)):
+		out = "0." + strings.Repeat("0", int(-d.exp)-len(abs)) + abs
+	default:
+		point := len(abs) + int(d.exp)
+		out = abs[:point] + "." + abs[point:]
+	}
+
+	if d.digits.Sign() < 0 {
+		return "-" + out
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package graphnode
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decimal(t *testing.T, s string) BigDecimal {
+	d, err := ParseBigDecimal(s)
+	require.NoError(t, err)
+	return d
+}
+
+func TestParseBigDecimal(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedError string
+	}{
+		{input: "0", expected: "0"},
+		{input: "-0.000", expected: "0"},
+		{input: "1.500", expected: "1.5"},
+		{input: "+100", expected: "100"},
+		{input: "1e3", expected: "1000"},
+		{input: "1.25E-3", expected: "0.00125"},
+		{input: "-42.", expected: "-42"},
+		// float64(0.1) written exactly, rounded to 34 significant digits
+		{input: "0.1000000000000000055511151231257827021181583404541015625", expected: "0.1000000000000000055511151231257827"},
+		{input: "12345678901234567890123456789012345", expected: "12345678901234567890123456789012350"},
+		{input: "-12345678901234567890123456789012345", expected: "-12345678901234567890123456789012340"},
+		{input: "1234567890123456789012345678901234.4", expected: "1234567890123456789012345678901234"},
+		{input: "9999999999999999999999999999999999.5", expected: "10000000000000000000000000000000000"},
+		{input: "-9999999999999999999999999999999999.9", expected: "-9999999999999999999999999999999999"},
+		{input: "abc", expectedError: `invalid decimal "abc"`},
+		{input: "1.-5", expectedError: `invalid decimal "1.-5"`},
+		{input: "1e", expectedError: `invalid exponent in decimal "1e": strconv.ParseInt: parsing "": invalid syntax`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			d, err := ParseBigDecimal(test.input)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, d.String())
+		})
+	}
+}
+
+// Values produced by graph-node for the same operations
+func TestBigDecimalOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       func(a, b BigDecimal) BigDecimal
+		a, b     string
+		expected string
+	}{
+		{"add", BigDecimal.Add, "0.1", "0.2", "0.3"},
+		{"add beyond precision", BigDecimal.Add, "1e40", "1", "10000000000000000000000000000000000000000"},
+		{"add strips zeros", BigDecimal.Add, "0.25", "0.75", "1"},
+		{"sub", BigDecimal.Sub, "1", "0.9999999999999999999999999999999999", "0.0000000000000000000000000000000001"},
+		{"sub to zero", BigDecimal.Sub, "12.5", "12.50", "0"},
+		{"mul", BigDecimal.Mul, "1.1", "1.1", "1.21"},
+		{"mul rounded", BigDecimal.Mul, "1.000000000000000000000000000000001", "1.000000000000000000000000000000001", "1.000000000000000000000000000000002"},
+		{"mul negative truncated", BigDecimal.Mul, "-1.000000000000000000000000000000009", "1.000000000000000000000000000000009", "-1.000000000000000000000000000000018"},
+		{"quo exact", BigDecimal.Quo, "10", "4", "2.5"},
+		{"quo small divisor", BigDecimal.Quo, "1", "0.0000001", "10000000"},
+		{"quo third", BigDecimal.Quo, "1", "3", "0.3333333333333333333333333333333333"},
+		{"quo rounded up", BigDecimal.Quo, "2", "3", "0.6666666666666666666666666666666667"},
+		{"quo negative truncated", BigDecimal.Quo, "-2", "3", "-0.6666666666666666666666666666666666"},
+		{"quo both negative", BigDecimal.Quo, "-2", "-3", "0.6666666666666666666666666666666667"},
+		{"quo zero", BigDecimal.Quo, "0", "3", "0"},
+		{"token price", BigDecimal.Quo, "45.765335860512456044", "0.074892810941332951", "611.0778228949449984270327371611629"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.op(decimal(t, test.a), decimal(t, test.b)).String())
+		})
+	}
+}
+
+func TestBigDecimal_QuoByZero(t *testing.T) {
+	assert.PanicsWithValue(t, "decimal division by zero", func() {
+		decimal(t, "1").Quo(decimal(t, "0"))
+	})
+}
+
+func TestBigDecimal_Normalized(t *testing.T) {
+	// Equal values have the same representation whatever the way they were obtained
+	assert.Equal(t, decimal(t, "1.5"), decimal(t, "1.500"))
+	assert.Equal(t, decimal(t, "1.5"), decimal(t, "0.5").Add(decimal(t, "1")))
+	assert.Equal(t, decimal(t, "0"), decimal(t, "1").Sub(decimal(t, "1")))
+	assert.Equal(t, 0, decimal(t, "100").Cmp(decimal(t, "1e2")))
+	assert.Equal(t, -1, decimal(t, "-100").Cmp(decimal(t, "1e-2")))
+}
+
+func TestNewBigDecimalFromBigFloat(t *testing.T) {
+	assert.Equal(t, "0.1", NewBigDecimalFromBigFloat(big.NewFloat(0.1)).String())
+	assert.Equal(t, "0.6666666666666666666666666666666667", NewBigDecimalFromBigFloat(decimal(t, "2").Quo(decimal(t, "3")).BigFloat(floatPrec)).String())
+	assert.Equal(t, "1823.231", NewBigDecimalFromBigFloat(decimal(t, "1823.231").BigFloat(floatPrec)).String())
+	assert.Equal(t, "-0.3333333333333333333333333333333333", NewBigDecimalFromBigFloat(decimal(t, "-1").Quo(decimal(t, "3")).BigFloat(floatPrec)).String())
+}
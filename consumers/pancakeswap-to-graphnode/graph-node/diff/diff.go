@@ -0,0 +1,172 @@
+// Package diff compares, field by field, the entities valid at a given block in two stores.
+package diff
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+var (
+	floatType    = reflect.TypeOf(graphnode.Float{})
+	intType      = reflect.TypeOf(graphnode.Int{})
+	floatPtrType = reflect.TypeOf(&graphnode.Float{})
+	intPtrType   = reflect.TypeOf(&graphnode.Int{})
+)
+
+type Options struct {
+	// Tolerance is the relative difference allowed between two `graphnode.Float` values,
+	// 0 requires an exact match.
+	Tolerance float64
+
+	// Tables restricts the comparison to these tables, all tables of the registry are
+	// compared when empty.
+	Tables []string
+}
+
+type FieldDifference struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+type EntityDifference struct {
+	ID     string
+	Fields []*FieldDifference
+}
+
+type TableReport struct {
+	Table     string
+	Compared  int
+	Missing   []string // in the expected store only
+	Extra     []string // in the actual store only
+	Differing []*EntityDifference
+}
+
+func (r *TableReport) Identical() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Differing) == 0
+}
+
+// Stores compares every table of the registry in `actual` against `expected`, at `blockNum`.
+func Stores(ctx context.Context, expected, actual storage.Store, registry *graphnode.Registry, blockNum uint64, opts Options) (out []*TableReport, err error) {
+	onlyTables := map[string]bool{}
+	for _, table := range opts.Tables {
+		if _, found := registry.GetType(table); !found {
+			return nil, fmt.Errorf("unknown table %q", table)
+		}
+		onlyTables[table] = true
+	}
+
+	for _, model := range registry.Entities() {
+		tableName := graphnode.GetTableName(model)
+		if len(onlyTables) > 0 && !onlyTables[tableName] {
+			continue
+		}
+
+		expectedEntities, err := loadByID(ctx, expected, model, blockNum)
+		if err != nil {
+			return nil, fmt.Errorf("loading expected %q entities: %w", tableName, err)
+		}
+		actualEntities, err := loadByID(ctx, actual, model, blockNum)
+		if err != nil {
+			return nil, fmt.Errorf("loading actual %q entities: %w", tableName, err)
+		}
+
+		out = append(out, Table(tableName, expectedEntities, actualEntities, opts.Tolerance))
+	}
+	return out, nil
+}
+
+func loadByID(ctx context.Context, store storage.Store, model graphnode.Entity, blockNum uint64) (map[string]graphnode.Entity, error) {
+	entities, err := store.LoadAllDistinct(ctx, model, blockNum)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]graphnode.Entity, len(entities))
+	for _, ent := range entities {
+		out[ent.GetID()] = ent
+	}
+	return out, nil
+}
+
+// Table compares two sets of entities of the same table, keyed by id.
+func Table(tableName string, expected, actual map[string]graphnode.Entity, tolerance float64) *TableReport {
+	report := &TableReport{Table: tableName}
+	for id, expectedEnt := range expected {
+		actualEnt, found := actual[id]
+		if !found {
+			report.Missing = append(report.Missing, id)
+			continue
+		}
+
+		report.Compared++
+		if fields := Entities(expectedEnt, actualEnt, tolerance); len(fields) > 0 {
+			report.Differing = append(report.Differing, &EntityDifference{ID: id, Fields: fields})
+		}
+	}
+
+	for id := range actual {
+		if _, found := expected[id]; !found {
+			report.Extra = append(report.Extra, id)
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Slice(report.Differing, func(i, j int) bool {
+		return report.Differing[i].ID < report.Differing[j].ID
+	})
+	return report
+}
+
+// Entities compares the entity fields stored in the database, the versioning columns
+// (`vid`, `block_range` and `_updated_block_number`) are ignored as they depend on how
+// each store was filled.
+func Entities(expected, actual graphnode.Entity, tolerance float64) (out []*FieldDifference) {
+	expectedValue := reflect.ValueOf(expected).Elem()
+	actualValue := reflect.ValueOf(actual).Elem()
+
+	for _, field := range graphnode.DBFields(expectedValue.Type()) {
+		if field.Base {
+			continue
+		}
+
+		e := expectedValue.FieldByName(field.Name)
+		a := actualValue.FieldByName(field.Name)
+		if !fieldEqual(e, a, tolerance) {
+			out = append(out, &FieldDifference{Field: field.ColumnName, Expected: graphnode.FormatField(e), Actual: graphnode.FormatField(a)})
+		}
+	}
+	return out
+}
+
+func fieldEqual(expected, actual reflect.Value, tolerance float64) bool {
+	switch expected.Type() {
+	case floatType:
+		return expected.Interface().(graphnode.Float).EqualWithin(actual.Interface().(graphnode.Float), tolerance)
+	case intType:
+		return expected.Interface().(graphnode.Int).Equal(actual.Interface().(graphnode.Int))
+	case floatPtrType, intPtrType:
+		if expected.IsNil() || actual.IsNil() {
+			return expected.IsNil() && actual.IsNil()
+		}
+		return fieldEqual(expected.Elem(), actual.Elem(), tolerance)
+	}
+
+	if expected.Kind() == reflect.Ptr {
+		if expected.IsNil() || actual.IsNil() {
+			return expected.IsNil() && actual.IsNil()
+		}
+		return reflect.DeepEqual(expected.Elem().Interface(), actual.Elem().Interface())
+	}
+	if expected.Kind() == reflect.Slice && expected.Len() == 0 && actual.Len() == 0 {
+		// An empty array and a NULL one are the same value
+		return true
+	}
+	return reflect.DeepEqual(expected.Interface(), actual.Interface())
+}
@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testPair struct {
+	graphnode.Base
+	Name     string                     `db:"name"`
+	Reserve  graphnode.Float            `db:"reserve"`
+	Volume   *graphnode.Float           `db:"volume,nullable"`
+	TxCount  graphnode.Int              `db:"tx_count"`
+	Swaps    graphnode.LocalStringArray `db:"swaps,nullable"`
+	Approved graphnode.Bool             `db:"approved"`
+}
+
+func float(s string) graphnode.Float {
+	f, err := graphnode.ParseFloat(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func TestEntities(t *testing.T) {
+	base := func() *testPair {
+		return &testPair{
+			Base:    graphnode.Base{ID: "a", VID: 1, BlockRange: &graphnode.BlockRange{StartBlock: 10}},
+			Name:    "WBNB/BUSD",
+			Reserve: float("1234.5678"),
+			TxCount: graphnode.NewIntFromLiteral(10),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		tolerance float64
+		mutate    func(p *testPair)
+		expected  []*FieldDifference
+	}{
+		{"identical", 0, func(p *testPair) {}, nil},
+		{"versioning ignored", 0, func(p *testPair) { p.VID = 3; p.BlockRange = &graphnode.BlockRange{StartBlock: 12} }, nil},
+		{"same float different precision", 0, func(p *testPair) { p.Reserve = float("1234.56780") }, nil},
+		{"float difference", 0, func(p *testPair) { p.Reserve = float("1234.5679") }, []*FieldDifference{{"reserve", "1234.5678", "1234.5679"}}},
+		{"float within tolerance", 1e-6, func(p *testPair) { p.Reserve = float("1234.5679") }, nil},
+		{"float outside tolerance", 1e-9, func(p *testPair) { p.Reserve = float("1234.5679") }, []*FieldDifference{{"reserve", "1234.5678", "1234.5679"}}},
+		{"nullable set", 0, func(p *testPair) { v := float("1"); p.Volume = &v }, []*FieldDifference{{"volume", "null", "1"}}},
+		{"int difference", 0, func(p *testPair) { p.TxCount = graphnode.NewIntFromLiteral(11) }, []*FieldDifference{{"tx_count", "10", "11"}}},
+		{"string difference", 0, func(p *testPair) { p.Name = "WBNB/USDT" }, []*FieldDifference{{"name", "WBNB/BUSD", "WBNB/USDT"}}},
+		{"empty array is null", 0, func(p *testPair) { p.Swaps = graphnode.LocalStringArray{} }, nil},
+		{"bool difference", 0, func(p *testPair) { p.Approved = true }, []*FieldDifference{{"approved", "false", "true"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := base()
+			test.mutate(actual)
+			assert.Equal(t, test.expected, Entities(base(), actual, test.tolerance))
+		})
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	registry := storagetest.Registry()
+
+	save := func(store storage.Store, entities ...*storagetest.TestEntity) {
+		table := map[string]graphnode.Entity{}
+		for _, ent := range entities {
+			table[ent.ID] = ent
+		}
+		require.NoError(t, store.BatchSave(ctx, 10, "", time.Time{}, map[string]map[string]graphnode.Entity{"test_entity": table}, ""))
+	}
+
+	expected := memory.New(zap.NewNop(), registry)
+	save(expected, storagetest.NewTestEntity("a", "a", 1), storagetest.NewTestEntity("b", "b", 1), storagetest.NewTestEntity("c", "c", 1))
+
+	actual := memory.New(zap.NewNop(), registry)
+	save(actual, storagetest.NewTestEntity("a", "a", 1), storagetest.NewTestEntity("b", "b", 2), storagetest.NewTestEntity("d", "d", 1))
+
+	reports, err := Stores(ctx, expected, actual, registry, 10, Options{Tables: []string{"test_entity"}})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+
+	assert.Equal(t, &TableReport{
+		Table:     "test_entity",
+		Compared:  2,
+		Missing:   []string{"c"},
+		Extra:     []string{"d"},
+		Differing: []*EntityDifference{{ID: "b", Fields: []*FieldDifference{{"amount", "1", "2"}}}},
+	}, reports[0])
+	assert.False(t, reports[0].Identical())
+
+	_, err = Stores(ctx, expected, actual, registry, 10, Options{Tables: []string{"unknown"}})
+	assert.EqualError(t, err, `unknown table "unknown"`)
+}
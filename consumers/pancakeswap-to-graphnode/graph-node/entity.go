@@ -55,3 +55,7 @@ func (b *Base) SetMutated(step int) {
 func (b *Base) SetUpdatedBlockNum(blockNum uint64) {
 	b.UpdatedBlockNum = blockNum
 }
+
+func (b *Base) GetUpdatedBlockNum() uint64 {
+	return b.UpdatedBlockNum
+}
@@ -2,12 +2,23 @@ package graphnode
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"time"
 )
 
+// ExportedEntities is the JSON representation of the entities of a table, as found in
+// snapshots. Entities are objects keyed by their Go field names and their values encode as:
+//   - `Float` and `Int`: decimal strings, like "1234.5678", `null` when not set
+//   - `Bytes`: `0x` prefixed hex strings
+//   - `BlockRange`: `[start,end)` strings, `[start,)` when open-ended
+//   - `Bool`, strings and numbers: native JSON values
+//
+// Decoding also accepts the hex encoded gob blobs previously written for `Float` and `Int`.
+// Previous versions encoded `Bytes` as base64 strings, `Legacy` must be set to decode them.
 type ExportedEntities struct {
 	BlockNum       uint64
 	BlockTimestamp time.Time
@@ -17,6 +28,7 @@ type ExportedEntities struct {
 	TypeGetter interface {
 		GetType(string) (reflect.Type, bool)
 	} `json:"-"`
+	Legacy bool `json:"-"`
 }
 
 type Map map[string]Entity
@@ -50,6 +62,13 @@ func (ee *ExportedEntities) UnmarshalJSON(in []byte) error {
 			continue
 		}
 
+		if ee.Legacy {
+			var err error
+			if rawEntity, err = legacyEntityJSON(rawEntity, reflectType); err != nil {
+				return fmt.Errorf("converting legacy entity %q: %w", id, err)
+			}
+		}
+
 		el := reflect.New(reflectType).Interface()
 		if err := json.Unmarshal(rawEntity, el); err != nil {
 			return fmt.Errorf("unmarshal raw entity: %w", err)
@@ -65,3 +84,48 @@ func (ee *ExportedEntities) UnmarshalJSON(in []byte) error {
 	}
 	return nil
 }
+
+var bytesType = reflect.TypeOf(Bytes{})
+
+// legacyEntityJSON rewrites the base64 `Bytes` of an entity encoded by previous versions to
+// the current encoding.
+func legacyEntityJSON(raw json.RawMessage, entityType reflect.Type) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	for _, field := range reflect.VisibleFields(entityType) {
+		value, found := fields[field.Name]
+		if field.Anonymous || !found || string(value) == "null" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType != bytesType {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if s == "" {
+			// a nil value
+			continue
+		}
+
+		d, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: decoding base64: %w", field.Name, err)
+		}
+		encoded, err := json.Marshal("0x" + hex.EncodeToString(d))
+		if err != nil {
+			return nil, err
+		}
+		fields[field.Name] = encoded
+	}
+	return json.Marshal(fields)
+}
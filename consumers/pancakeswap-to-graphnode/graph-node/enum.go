@@ -2,7 +2,6 @@ package graphnode
 
 import (
 	"database/sql/driver"
-	"fmt"
 )
 
 type Enum string
@@ -30,15 +29,10 @@ func (e *Enum) Scan(value interface{}) error {
 		return nil
 	}
 
-	sv, err := driver.String.ConvertValue(value)
+	bs, err := scanBytes(value)
 	if err != nil {
 		return err
 	}
-
-	bs, ok := sv.([]byte)
-	if !ok {
-		return fmt.Errorf("could not convert data to byte array")
-	}
 	str := string(bs)
 
 	*e = Enum(str)
@@ -1,6 +1,7 @@
 package graphnode
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/jmoiron/sqlx/reflectx"
@@ -47,3 +48,53 @@ type FieldTag struct {
 	ColumnName string
 	Optional   bool
 }
+
+// FormatField formats the value of an entity field the way it reads in the database,
+// `null` for nil values.
+func FormatField(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "null"
+		}
+		v = v.Elem()
+	}
+
+	switch val := v.Interface().(type) {
+	case Float:
+		if val.IsNil() {
+			return "null"
+		}
+		return val.String()
+	case Int:
+		if val.IsNil() {
+			return "null"
+		}
+		return val.String()
+	case Bytes:
+		return fmt.Sprintf("0x%x", []byte(val))
+	case BlockRange:
+		return val.String()
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// EqualFields tells whether `a` and `b` hold the same value in every field stored in the
+// database, the version fields of `Base` (id, vid, block range) are not compared. Values
+// are compared as they read in the database, numbers being normalized.
+func EqualFields(a, b Entity) bool {
+	va := reflect.ValueOf(a).Elem()
+	vb := reflect.ValueOf(b).Elem()
+	if va.Type() != vb.Type() {
+		return false
+	}
+
+	for _, field := range DBFields(va.Type()) {
+		if field.Base {
+			continue
+		}
+		if FormatField(va.FieldByName(field.Name)) != FormatField(vb.FieldByName(field.Name)) {
+			return false
+		}
+	}
+	return true
+}
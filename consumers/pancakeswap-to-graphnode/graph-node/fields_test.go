@@ -42,3 +42,29 @@ func TestFieldTags(t *testing.T) {
 		})
 	}
 }
+
+func TestEqualFields(t *testing.T) {
+	liquidity := NewFloatFromLiteral(1.5)
+	base := &PancakeFactory{
+		Base:              Base{ID: "factory", VID: 1, BlockRange: &BlockRange{StartBlock: 10}},
+		TotalTransactions: NewIntFromLiteral(3),
+		TotalVolumeUSD:    NewFloatFromLiteral(2.5),
+		TotalLiquidityUSD: &liquidity,
+	}
+
+	sameValues := &PancakeFactory{
+		Base:              Base{ID: "factory", VID: 2, BlockRange: &BlockRange{StartBlock: 12}},
+		TotalTransactions: NewIntFromLiteral(3),
+		TotalVolumeUSD:    FloatAdd(NewFloatFromLiteral(1.25), NewFloatFromLiteral(1.25)),
+		TotalLiquidityUSD: NewFloatFromLiteral(1.5).Ptr(),
+	}
+	assert.True(t, EqualFields(base, sameValues))
+
+	changed := *sameValues
+	changed.TotalTransactions = NewIntFromLiteral(4)
+	assert.False(t, EqualFields(base, &changed))
+
+	nulled := *sameValues
+	nulled.TotalLiquidityUSD = nil
+	assert.False(t, EqualFields(base, &nulled))
+}
@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+// cachingStore caches the entities read while resolving a query, the nested fields of
+// a page of entities read the same entities and tables over and over.
+type cachingStore struct {
+	storage.Store
+
+	lock     sync.Mutex
+	entities map[string]graphnode.Entity
+	tables   map[string][]graphnode.Entity
+}
+
+// newCachingStore wraps `store`, a `cachingFinder` when the store finds the pages of
+// entities itself.
+func newCachingStore(store storage.Store) storage.Store {
+	s := &cachingStore{
+		Store:    store,
+		entities: map[string]graphnode.Entity{},
+		tables:   map[string][]graphnode.Entity{},
+	}
+	if finder, ok := store.(query.Finder); ok {
+		return &cachingFinder{cachingStore: s, finder: finder, pages: map[string][]graphnode.Entity{}}
+	}
+	return s
+}
+
+func (s *cachingStore) Load(ctx context.Context, id string, entity graphnode.Entity, blockNum uint64) error {
+	key := fmt.Sprintf("%s/%s@%d", graphnode.GetTableName(entity), id, blockNum)
+
+	s.lock.Lock()
+	cached, found := s.entities[key]
+	s.lock.Unlock()
+	if !found {
+		if err := s.Store.Load(ctx, id, entity, blockNum); err != nil {
+			return err
+		}
+		if entity.Exists() {
+			cached = reflect.New(reflect.TypeOf(entity).Elem()).Interface().(graphnode.Entity)
+			reflect.ValueOf(cached).Elem().Set(reflect.ValueOf(entity).Elem())
+		}
+
+		s.lock.Lock()
+		s.entities[key] = cached
+		s.lock.Unlock()
+		return nil
+	}
+
+	if cached != nil {
+		reflect.ValueOf(entity).Elem().Set(reflect.ValueOf(cached).Elem())
+	}
+	return nil
+}
+
+func (s *cachingStore) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64) ([]graphnode.Entity, error) {
+	key := fmt.Sprintf("%s@%d", graphnode.GetTableName(model), blockNum)
+
+	s.lock.Lock()
+	cached, found := s.tables[key]
+	s.lock.Unlock()
+	if found {
+		return cached, nil
+	}
+
+	entities, err := s.Store.LoadAllDistinct(ctx, model, blockNum)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.tables[key] = entities
+	s.lock.Unlock()
+	return entities, nil
+}
+
+// cachingFinder caches the pages of entities found by the store as well.
+type cachingFinder struct {
+	*cachingStore
+	finder query.Finder
+
+	pages map[string][]graphnode.Entity
+}
+
+func (s *cachingFinder) FindEntities(ctx context.Context, model graphnode.Entity, blockNum uint64, opts *query.ListOptions) ([]graphnode.Entity, error) {
+	encoded, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("encoding options: %w", err)
+	}
+	key := fmt.Sprintf("%s@%d/%s", graphnode.GetTableName(model), blockNum, encoded)
+
+	s.lock.Lock()
+	cached, found := s.pages[key]
+	s.lock.Unlock()
+	if found {
+		return cached, nil
+	}
+
+	entities, err := s.finder.FindEntities(ctx, model, blockNum, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.pages[key] = entities
+	s.lock.Unlock()
+	return entities, nil
+}
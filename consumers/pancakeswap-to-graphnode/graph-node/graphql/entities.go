@@ -0,0 +1,49 @@
+package graphql
+
+import (
+	"strings"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+)
+
+// entityType is an `@entity` type of the schema along with the names of the query fields
+// reading one entity and a page of entities.
+type entityType struct {
+	*graphnode.SchemaEntity
+	single string
+	plural string
+}
+
+// parseEntities reads the `@entity` types of the GraphQL schema `sdl`, see
+// `graphnode.ParseSchema`.
+func parseEntities(sdl string, registry *graphnode.Registry) ([]*entityType, error) {
+	entities, err := graphnode.ParseSchema(sdl, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*entityType, len(entities))
+	for i, entity := range entities {
+		out[i] = &entityType{
+			SchemaEntity: entity,
+			single:       lowerFirst(entity.Name),
+			plural:       pluralize(lowerFirst(entity.Name)),
+		}
+	}
+	return out, nil
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// pluralize names a list of entities the way graph-node does.
+func pluralize(s string) string {
+	switch {
+	case strings.HasSuffix(s, "s"), strings.HasSuffix(s, "x"), strings.HasSuffix(s, "ch"), strings.HasSuffix(s, "sh"):
+		return s + "es"
+	case strings.HasSuffix(s, "y") && len(s) > 1 && !strings.ContainsAny(s[len(s)-2:len(s)-1], "aeiou"):
+		return s[:len(s)-1] + "ies"
+	}
+	return s + "s"
+}
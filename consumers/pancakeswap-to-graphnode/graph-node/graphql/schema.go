@@ -0,0 +1,487 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+)
+
+const (
+	DefaultFirst = 100
+	MaxFirst     = 1000
+)
+
+// entityValue is the value the fields of an entity resolve from, the nested entities are
+// read at the block of their parent.
+type entityValue struct {
+	ent   graphnode.Entity
+	block uint64
+}
+
+// whereField decodes an input field of a `<Entity>_filter` input object.
+type whereField struct {
+	column   string
+	operator string
+	list     bool
+}
+
+var (
+	bigIntScalar = gql.NewScalar(gql.ScalarConfig{
+		Name:        "BigInt",
+		Description: "Arbitrary precision integer, as a decimal string",
+		Serialize:   func(value interface{}) interface{} { return value },
+		ParseValue:  func(value interface{}) interface{} { return parseNumber(value, normalizeBigInt) },
+		ParseLiteral: func(value ast.Value) interface{} {
+			return parseNumber(literal(value), normalizeBigInt)
+		},
+	})
+	bigDecimalScalar = gql.NewScalar(gql.ScalarConfig{
+		Name:        "BigDecimal",
+		Description: "Arbitrary precision decimal, as a decimal string",
+		Serialize:   func(value interface{}) interface{} { return value },
+		ParseValue:  func(value interface{}) interface{} { return parseNumber(value, normalizeBigDecimal) },
+		ParseLiteral: func(value ast.Value) interface{} {
+			return parseNumber(literal(value), normalizeBigDecimal)
+		},
+	})
+	bytesScalar = gql.NewScalar(gql.ScalarConfig{
+		Name:        "Bytes",
+		Description: "Byte array, as a 0x prefixed hex string",
+		Serialize:   func(value interface{}) interface{} { return value },
+		ParseValue:  parseBytes,
+		ParseLiteral: func(value ast.Value) interface{} {
+			return parseBytes(literal(value))
+		},
+	})
+
+	orderDirectionEnum = gql.NewEnum(gql.EnumConfig{
+		Name: "OrderDirection",
+		Values: gql.EnumValueConfigMap{
+			"asc":  &gql.EnumValueConfig{Value: "asc"},
+			"desc": &gql.EnumValueConfig{Value: "desc"},
+		},
+	})
+
+	blockHeightInput = gql.NewInputObject(gql.InputObjectConfig{
+		Name: "Block_height",
+		Fields: gql.InputObjectConfigFieldMap{
+			"number":     &gql.InputObjectFieldConfig{Type: gql.Int, Description: "Reads the entities as they were at this block"},
+			"number_gte": &gql.InputObjectFieldConfig{Type: gql.Int, Description: "Reads the entities at the head block, fails unless it is at least this block"},
+		},
+	})
+
+	metaBlockType = gql.NewObject(gql.ObjectConfig{
+		Name: "_Block_",
+		Fields: gql.Fields{
+			"hash":   &gql.Field{Type: bytesScalar},
+			"number": &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		},
+	})
+	metaType = gql.NewObject(gql.ObjectConfig{
+		Name: "_Meta_",
+		Fields: gql.Fields{
+			"block":             &gql.Field{Type: gql.NewNonNull(metaBlockType)},
+			"deployment":        &gql.Field{Type: gql.NewNonNull(gql.String)},
+			"hasIndexingErrors": &gql.Field{Type: gql.NewNonNull(gql.Boolean)},
+		},
+	})
+)
+
+func literal(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.IntValue:
+		return v.Value
+	case *ast.FloatValue:
+		return v.Value
+	}
+	return nil
+}
+
+// parseNumber parses a number given as a string or a JSON number, normalized so that it
+// compares to the values of the store as formatted.
+func parseNumber(value interface{}, normalize func(s string) (string, bool)) interface{} {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil
+	}
+	normalized, ok := normalize(s)
+	if !ok {
+		return nil
+	}
+	return normalized
+}
+
+func normalizeBigInt(s string) (string, bool) {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return "", false
+	}
+	return i.String(), true
+}
+
+func normalizeBigDecimal(s string) (string, bool) {
+	d, err := graphnode.ParseBigDecimal(s)
+	if err != nil {
+		return "", false
+	}
+	return d.String(), true
+}
+
+func parseBytes(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, "0x") {
+		return nil
+	}
+	return strings.ToLower(s)
+}
+
+// buildSchema builds the GraphQL schema serving `entities`: for each entity, a field
+// reading one entity by id and a field reading a page of entities, graph-node style.
+func (s *Server) buildSchema(entities []*entityType) (gql.Schema, error) {
+	objects := map[string]*gql.Object{}
+	wheres := map[string]map[string]*whereField{}
+	filterInputs := map[string]*gql.InputObject{}
+	orderByEnums := map[string]*gql.Enum{}
+
+	for _, entity := range entities {
+		filterInputs[entity.Name], wheres[entity.Name] = filterInput(entity)
+		orderByEnums[entity.Name] = orderByEnum(entity)
+	}
+
+	byName := map[string]*entityType{}
+	for _, entity := range entities {
+		entity := entity
+		byName[entity.Name] = entity
+		objects[entity.Name] = gql.NewObject(gql.ObjectConfig{
+			Name: entity.Name,
+			Fields: gql.FieldsThunk(func() gql.Fields {
+				fields := gql.Fields{}
+				for _, field := range entity.Fields {
+					fields[field.Name] = s.objectField(entity, field, objects, filterInputs, orderByEnums, wheres, byName)
+				}
+				return fields
+			}),
+		})
+	}
+
+	queryFields := gql.Fields{}
+	for _, entity := range entities {
+		entity := entity
+		queryFields[entity.single] = &gql.Field{
+			Type: objects[entity.Name],
+			Args: gql.FieldConfigArgument{
+				"id":    &gql.ArgumentConfig{Type: gql.NewNonNull(gql.ID)},
+				"block": &gql.ArgumentConfig{Type: blockHeightInput},
+			},
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				block, err := s.block(p)
+				if err != nil {
+					return nil, err
+				}
+				return s.load(p.Context, entity, p.Args["id"].(string), block)
+			},
+		}
+		queryFields[entity.plural] = &gql.Field{
+			Type: listType(objects[entity.Name]),
+			Args: pageArgs(filterInputs[entity.Name], orderByEnums[entity.Name], true),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				block, err := s.block(p)
+				if err != nil {
+					return nil, err
+				}
+				return s.find(p, entity, wheres[entity.Name], block, nil)
+			},
+		}
+	}
+	queryFields["_meta"] = &gql.Field{
+		Type: metaType,
+		Args: gql.FieldConfigArgument{"block": &gql.ArgumentConfig{Type: blockHeightInput}},
+		Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return s.meta(p)
+		},
+	}
+
+	return gql.NewSchema(gql.SchemaConfig{
+		Query: gql.NewObject(gql.ObjectConfig{Name: "Query", Fields: queryFields}),
+	})
+}
+
+func (s *Server) objectField(entity *entityType, field *graphnode.SchemaField, objects map[string]*gql.Object, filterInputs map[string]*gql.InputObject, orderByEnums map[string]*gql.Enum, wheres map[string]map[string]*whereField, byName map[string]*entityType) *gql.Field {
+	switch field.Kind {
+	case graphnode.ReferenceField:
+		target := byName[field.Target]
+		return &gql.Field{
+			Type: nonNull(objects[field.Target], field.NonNull),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				source := p.Source.(*entityValue)
+				id := graphnode.FormatField(field.Value(source.ent))
+				if id == "null" || id == "" {
+					return nil, nil
+				}
+				value, err := s.load(p.Context, target, id, source.block)
+				if err != nil || value == nil {
+					return nil, err
+				}
+				return value, nil
+			},
+		}
+
+	case graphnode.ReferenceListField, graphnode.DerivedField:
+		target := byName[field.Target]
+		return &gql.Field{
+			Type: listType(objects[field.Target]),
+			Args: pageArgs(filterInputs[field.Target], orderByEnums[field.Target], false),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				source := p.Source.(*entityValue)
+
+				var related *query.Filter
+				if field.Kind == graphnode.ReferenceListField {
+					ids, _ := field.Value(source.ent).Interface().(graphnode.LocalStringArray)
+					related = &query.Filter{Column: "id", Operator: query.OperatorIn, Values: ids}
+				} else {
+					from := target.Field(field.DerivedFrom)
+					related = &query.Filter{Column: from.Column, Value: source.ent.GetID()}
+					if from.Kind == graphnode.ReferenceListField {
+						related = &query.Filter{Column: from.Column, Operator: query.OperatorContains, Values: []string{source.ent.GetID()}}
+					}
+				}
+				return s.find(p, target, wheres[field.Target], source.block, related)
+			},
+		}
+	}
+
+	return &gql.Field{
+		Type: nonNull(scalarType(field), field.NonNull),
+		Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return scalarValue(field.Value(p.Source.(*entityValue).ent)), nil
+		},
+	}
+}
+
+func scalarType(field *graphnode.SchemaField) gql.Output {
+	var typ gql.Output
+	switch field.Scalar {
+	case "ID":
+		typ = gql.ID
+	case "String":
+		typ = gql.String
+	case "Int":
+		typ = gql.Int
+	case "Boolean":
+		typ = gql.Boolean
+	case "BigInt":
+		typ = bigIntScalar
+	case "BigDecimal":
+		typ = bigDecimalScalar
+	case "Bytes":
+		typ = bytesScalar
+	}
+	if field.ScalarList {
+		return gql.NewList(gql.NewNonNull(typ))
+	}
+	return typ
+}
+
+// scalarValue returns the value of an entity field as it is served, numbers of arbitrary
+// precision and bytes as strings.
+func scalarValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch val := v.Interface().(type) {
+	case graphnode.Int, graphnode.Float, graphnode.Bytes:
+		formatted := graphnode.FormatField(v)
+		if formatted == "null" {
+			return nil
+		}
+		return formatted
+	case graphnode.Bool:
+		return bool(val)
+	case graphnode.LocalStringArray:
+		return []string(val)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	case reflect.String:
+		return v.String()
+	}
+	return graphnode.FormatField(v)
+}
+
+func nonNull(typ gql.Output, isNonNull bool) gql.Output {
+	if isNonNull {
+		return gql.NewNonNull(typ)
+	}
+	return typ
+}
+
+func listType(object *gql.Object) gql.Output {
+	return gql.NewNonNull(gql.NewList(gql.NewNonNull(object)))
+}
+
+func pageArgs(filter *gql.InputObject, orderBy *gql.Enum, withBlock bool) gql.FieldConfigArgument {
+	args := gql.FieldConfigArgument{
+		"skip":           &gql.ArgumentConfig{Type: gql.Int, DefaultValue: 0},
+		"first":          &gql.ArgumentConfig{Type: gql.Int, DefaultValue: DefaultFirst},
+		"orderBy":        &gql.ArgumentConfig{Type: orderBy},
+		"orderDirection": &gql.ArgumentConfig{Type: orderDirectionEnum},
+		"where":          &gql.ArgumentConfig{Type: filter},
+	}
+	if withBlock {
+		args["block"] = &gql.ArgumentConfig{Type: blockHeightInput}
+	}
+	return args
+}
+
+// operators lists the filter operators of each scalar type, the equality operator is
+// the field name alone, the others are suffixed with `_<operator>`.
+var operators = map[string][]string{
+	"ID":         {query.OperatorEqual, query.OperatorNot, query.OperatorGt, query.OperatorLt, query.OperatorGte, query.OperatorLte, query.OperatorIn, query.OperatorNotIn, query.OperatorContains, query.OperatorNotContains, query.OperatorStartsWith, query.OperatorEndsWith},
+	"String":     {query.OperatorEqual, query.OperatorNot, query.OperatorGt, query.OperatorLt, query.OperatorGte, query.OperatorLte, query.OperatorIn, query.OperatorNotIn, query.OperatorContains, query.OperatorNotContains, query.OperatorStartsWith, query.OperatorEndsWith},
+	"Int":        {query.OperatorEqual, query.OperatorNot, query.OperatorGt, query.OperatorLt, query.OperatorGte, query.OperatorLte, query.OperatorIn, query.OperatorNotIn},
+	"BigInt":     {query.OperatorEqual, query.OperatorNot, query.OperatorGt, query.OperatorLt, query.OperatorGte, query.OperatorLte, query.OperatorIn, query.OperatorNotIn},
+	"BigDecimal": {query.OperatorEqual, query.OperatorNot, query.OperatorGt, query.OperatorLt, query.OperatorGte, query.OperatorLte, query.OperatorIn, query.OperatorNotIn},
+	"Bytes":      {query.OperatorEqual, query.OperatorNot, query.OperatorIn, query.OperatorNotIn, query.OperatorContains, query.OperatorNotContains},
+	"Boolean":    {query.OperatorEqual, query.OperatorNot, query.OperatorIn, query.OperatorNotIn},
+}
+
+var listOperators = []string{query.OperatorContains, query.OperatorNotContains}
+
+// filterInput builds the `<Entity>_filter` input object, along with the decoding of its
+// fields.
+func filterInput(entity *entityType) (*gql.InputObject, map[string]*whereField) {
+	inputFields := gql.InputObjectConfigFieldMap{}
+	wheres := map[string]*whereField{}
+
+	for _, field := range entity.Fields {
+		var typ gql.Input
+		ops := listOperators
+		switch {
+		case field.Kind == graphnode.DerivedField:
+			continue
+		case field.Kind == graphnode.ReferenceListField:
+			typ = gql.String
+		case field.Kind == graphnode.ReferenceField:
+			typ = gql.String
+			ops = operators["String"]
+		case field.ScalarList:
+			typ = scalarType(&graphnode.SchemaField{Scalar: field.Scalar}).(gql.Input)
+		default:
+			typ = scalarType(field).(gql.Input)
+			ops = operators[field.Scalar]
+		}
+
+		for _, op := range ops {
+			name := field.Name
+			if op != query.OperatorEqual {
+				name += "_" + op
+			}
+
+			list := op == query.OperatorIn || op == query.OperatorNotIn || field.Kind == graphnode.ReferenceListField || field.ScalarList
+			inputType := typ
+			if list {
+				inputType = gql.NewList(gql.NewNonNull(typ))
+			}
+			inputFields[name] = &gql.InputObjectFieldConfig{Type: inputType}
+			wheres[name] = &whereField{column: field.Column, operator: op, list: list}
+		}
+	}
+
+	return gql.NewInputObject(gql.InputObjectConfig{Name: entity.Name + "_filter", Fields: inputFields}), wheres
+}
+
+func orderByEnum(entity *entityType) *gql.Enum {
+	values := gql.EnumValueConfigMap{}
+	for _, field := range entity.Fields {
+		if field.Kind == graphnode.ScalarField && !field.ScalarList || field.Kind == graphnode.ReferenceField {
+			values[field.Name] = &gql.EnumValueConfig{Value: field.Column}
+		}
+	}
+	return gql.NewEnum(gql.EnumConfig{Name: entity.Name + "_orderBy", Values: values})
+}
+
+// listOptions decodes the page arguments of a list field.
+func listOptions(args map[string]interface{}, wheres map[string]*whereField) (*query.ListOptions, error) {
+	opts := &query.ListOptions{}
+	if first, ok := args["first"].(int); ok {
+		opts.First = first
+	}
+	if skip, ok := args["skip"].(int); ok {
+		opts.Skip = skip
+	}
+	if opts.First < 0 || opts.First > MaxFirst {
+		return nil, fmt.Errorf("first must be between 0 and %d", MaxFirst)
+	}
+	if opts.Skip < 0 {
+		return nil, errors.New("skip must not be negative")
+	}
+
+	if column, ok := args["orderBy"].(string); ok {
+		direction, _ := args["orderDirection"].(string)
+		opts.OrderBy = &query.Order{Column: column, Descending: direction == "desc"}
+	}
+
+	where, _ := args["where"].(map[string]interface{})
+	// Sorted so that errors do not depend on the order of the map
+	names := make([]string, 0, len(where))
+	for name := range where {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		decoder := wheres[name]
+		filter := &query.Filter{Column: decoder.column, Operator: decoder.operator}
+		if decoder.list {
+			values, _ := where[name].([]interface{})
+			for _, value := range values {
+				filter.Values = append(filter.Values, formatValue(value))
+			}
+		} else {
+			filter.Value = formatValue(where[name])
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+	return opts, nil
+}
+
+// formatValue formats an argument value the way the filtered column formats.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
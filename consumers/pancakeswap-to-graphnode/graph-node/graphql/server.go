@@ -0,0 +1,214 @@
+// Package graphql serves the entities of a store through the GraphQL API graph-node
+// generates from the schema of a subgraph: a field reading an entity by id and a field
+// reading a page of entities for every `@entity` type, with graph-node's filters,
+// ordering, pagination, nested relations, `block` time travel and `_meta`.
+//
+// Entities are read from the versioned tables the way `query` reads them, so this is
+// meant for local stacks and development rather than production traffic.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gql "github.com/graphql-go/graphql"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+// Head is the last block saved in the store.
+type Head struct {
+	Number uint64
+	Hash   string
+}
+
+// HeadFunc returns the last block saved in the store, nil when there is none.
+type HeadFunc func(ctx context.Context) (*Head, error)
+
+type Server struct {
+	schema     gql.Schema
+	store      storage.Store
+	registry   *graphnode.Registry
+	head       HeadFunc
+	deployment string
+	logger     *zap.Logger
+}
+
+// NewServer builds the API of the GraphQL schema `sdl`, whose entities are stored in the
+// tables of `registry`.
+func NewServer(sdl string, registry *graphnode.Registry, store storage.Store, head HeadFunc, deployment string, logger *zap.Logger) (*Server, error) {
+	entities, err := parseEntities(sdl, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		store:      store,
+		registry:   registry,
+		head:       head,
+		deployment: deployment,
+		logger:     logger,
+	}
+	if s.schema, err = s.buildSchema(entities); err != nil {
+		return nil, fmt.Errorf("building graphql schema: %w", err)
+	}
+	return s, nil
+}
+
+// Request is a GraphQL request as clients POST it.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type contextKey struct{}
+
+// Do executes `req`.
+func (s *Server) Do(ctx context.Context, req *Request) *gql.Result {
+	// The entities read are cached for the request only
+	ctx = context.WithValue(ctx, contextKey{}, newCachingStore(s.store))
+
+	return gql.Do(gql.Params{
+		Schema:         s.schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        ctx,
+	})
+}
+
+// ServeHTTP serves GraphQL requests POSTed as JSON, or sent as the `query` parameter of a
+// GET request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &Request{}
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("decoding variables: %w", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("query is required"))
+		return
+	}
+
+	result := s.Do(r.Context(), req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": err.Error()}}})
+}
+
+// block returns the block the `block` argument of a query field selects, the head block
+// when not set.
+func (s *Server) block(p gql.ResolveParams) (uint64, error) {
+	head, err := s.head(p.Context)
+	if err != nil {
+		return 0, err
+	}
+	if head == nil {
+		return 0, errors.New("no block saved in store yet")
+	}
+
+	arg, _ := p.Args["block"].(map[string]interface{})
+	if number, ok := arg["number"].(int); ok {
+		if number < 0 || uint64(number) > head.Number {
+			return 0, fmt.Errorf("block %d is not available, the latest block is %d", number, head.Number)
+		}
+		return uint64(number), nil
+	}
+	if number, ok := arg["number_gte"].(int); ok && uint64(number) > head.Number {
+		return 0, fmt.Errorf("block %d is not available, the latest block is %d", number, head.Number)
+	}
+	return head.Number, nil
+}
+
+func (s *Server) meta(p gql.ResolveParams) (interface{}, error) {
+	head, err := s.head(p.Context)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, errors.New("no block saved in store yet")
+	}
+
+	block, err := s.block(p)
+	if err != nil {
+		return nil, err
+	}
+
+	metaBlock := map[string]interface{}{"number": int(block)}
+	if block == head.Number && head.Hash != "" {
+		metaBlock["hash"] = "0x" + strings.TrimPrefix(head.Hash, "0x")
+	}
+	return map[string]interface{}{
+		"block":             metaBlock,
+		"deployment":        s.deployment,
+		"hasIndexingErrors": false,
+	}, nil
+}
+
+func requestStore(ctx context.Context) storage.Store {
+	return ctx.Value(contextKey{}).(storage.Store)
+}
+
+// load returns the entity `id` of `entity` valid at `block`, nil when it did not exist.
+func (s *Server) load(ctx context.Context, entity *entityType, id string, block uint64) (interface{}, error) {
+	ent, err := query.Get(ctx, requestStore(ctx), s.registry, entity.Table, id, block)
+	if err != nil {
+		return nil, err
+	}
+	if ent == nil {
+		return nil, nil
+	}
+	return &entityValue{ent: ent, block: block}, nil
+}
+
+// find returns the page of entities the arguments of a list field select, `related`
+// restricts them to the entities related to the parent entity.
+func (s *Server) find(p gql.ResolveParams, entity *entityType, wheres map[string]*whereField, block uint64, related *query.Filter) (interface{}, error) {
+	opts, err := listOptions(p.Args, wheres)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	if opts.First == 0 {
+		return out, nil
+	}
+	if related != nil {
+		opts.Filters = append(opts.Filters, related)
+	}
+
+	entities, err := query.Find(p.Context, requestStore(p.Context), s.registry, entity.Table, block, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, ent := range entities {
+		out = append(out, &entityValue{ent: ent, block: block})
+	}
+	return out, nil
+}
@@ -0,0 +1,248 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testSchema = `
+type Token @entity {
+  id: ID!
+  symbol: String!
+  decimals: BigInt!
+  "Pairs whose first token is this token"
+  pairBase: [Pair!]! @derivedFrom(field: "token0")
+}
+
+type Pair @entity {
+  id: ID!
+  token0: Token! @parallel(step: 1)
+  token1: Token!
+  reserve0: BigDecimal!
+  txCount: Int!
+  active: Boolean!
+  swaps: [Swap]!
+}
+
+type Swap @entity(immutable: true) {
+  id: ID!
+  pair: Pair!
+  amount: BigDecimal
+}
+`
+
+type Token struct {
+	graphnode.Base
+	Symbol   string        `db:"symbol"`
+	Decimals graphnode.Int `db:"decimals"`
+}
+
+type Pair struct {
+	graphnode.Base
+	Token0   string                     `db:"token_0"`
+	Token1   string                     `db:"token_1"`
+	Reserve0 graphnode.Float            `db:"reserve_0"`
+	TxCount  int64                      `db:"tx_count"`
+	Active   graphnode.Bool             `db:"active"`
+	Swaps    graphnode.LocalStringArray `db:"swaps,nullable"`
+}
+
+type Swap struct {
+	graphnode.Base
+	Pair   string           `db:"pair"`
+	Amount *graphnode.Float `db:"amount,nullable"`
+}
+
+func testRegistry() *graphnode.Registry {
+	return graphnode.NewRegistry(&Token{}, &Pair{}, &Swap{})
+}
+
+func save(t *testing.T, store storage.Store, blockNum uint64, entities ...graphnode.Entity) {
+	ctx := context.Background()
+	updates := map[string]map[string]graphnode.Entity{}
+	for _, ent := range entities {
+		table := graphnode.GetTableName(ent)
+
+		current, _ := testRegistry().GetInterface(table)
+		require.NoError(t, store.Load(ctx, ent.GetID(), current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+
+		if updates[table] == nil {
+			updates[table] = map[string]graphnode.Entity{}
+		}
+		updates[table][ent.GetID()] = ent
+	}
+	require.NoError(t, store.BatchSave(ctx, blockNum, "", time.Time{}, updates, ""))
+}
+
+func float(s string) graphnode.Float {
+	f, err := graphnode.ParseFloat(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func testServer(t *testing.T) *Server {
+	store := memory.New(zap.NewNop(), testRegistry())
+	save(t, store, 10,
+		&Token{Base: graphnode.NewBase("cake"), Symbol: "CAKE", Decimals: graphnode.NewIntFromLiteral(18)},
+		&Token{Base: graphnode.NewBase("wbnb"), Symbol: "WBNB", Decimals: graphnode.NewIntFromLiteral(18)},
+		&Token{Base: graphnode.NewBase("usdt"), Symbol: "USDT", Decimals: graphnode.NewIntFromLiteral(6)},
+		&Pair{Base: graphnode.NewBase("p1"), Token0: "cake", Token1: "wbnb", Reserve0: float("9.5"), TxCount: 1, Active: true},
+		&Pair{Base: graphnode.NewBase("p2"), Token0: "cake", Token1: "usdt", Reserve0: float("10"), TxCount: 2},
+	)
+	amount := float("1.25")
+	save(t, store, 20,
+		&Pair{Base: graphnode.NewBase("p1"), Token0: "cake", Token1: "wbnb", Reserve0: float("100.5"), TxCount: 3, Active: true, Swaps: graphnode.LocalStringArray{"s1", "s2"}},
+		&Pair{Base: graphnode.NewBase("p3"), Token0: "wbnb", Token1: "usdt", Reserve0: float("2"), TxCount: 1, Active: true},
+		&Swap{Base: graphnode.NewBase("s1"), Pair: "p1", Amount: &amount},
+		&Swap{Base: graphnode.NewBase("s2"), Pair: "p1"},
+	)
+
+	server, err := NewServer(testSchema, testRegistry(), store, func(ctx context.Context) (*Head, error) {
+		return &Head{Number: 20, Hash: "abc"}, nil
+	}, "QmTest", zap.NewNop())
+	require.NoError(t, err)
+	return server
+}
+
+func TestServer(t *testing.T) {
+	server := testServer(t)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "by id",
+			query:    `{ pair(id: "p1") { id reserve0 txCount active swaps { id } token0 { symbol decimals } } }`,
+			expected: `{"data":{"pair":{"id":"p1","reserve0":"100.5","txCount":3,"active":true,"swaps":[{"id":"s1"},{"id":"s2"}],"token0":{"symbol":"CAKE","decimals":"18"}}}}`,
+		},
+		{
+			name:     "time travel",
+			query:    `{ pair(id: "p1", block: {number: 15}) { reserve0 swaps { id } } missing: pair(id: "p3", block: {number: 15}) { id } }`,
+			expected: `{"data":{"pair":{"reserve0":"9.5","swaps":[]},"missing":null}}`,
+		},
+		{
+			name:     "ordered by number",
+			query:    `{ pairs(orderBy: reserve0, orderDirection: desc) { id } }`,
+			expected: `{"data":{"pairs":[{"id":"p1"},{"id":"p2"},{"id":"p3"}]}}`,
+		},
+		{
+			name:     "page",
+			query:    `{ pairs(orderBy: reserve0, first: 1, skip: 1) { id } none: pairs(first: 0) { id } }`,
+			expected: `{"data":{"pairs":[{"id":"p2"}],"none":[]}}`,
+		},
+		{
+			name:     "filters",
+			query:    `{ gt: pairs(where: {reserve0_gt: "9.99"}) { id } in: pairs(where: {token1_in: ["usdt"], txCount_lte: 1}) { id } not: tokens(where: {symbol_not: "CAKE", symbol_starts_with: "W"}) { id } bool: pairs(where: {active: false}) { id } }`,
+			expected: `{"data":{"gt":[{"id":"p1"},{"id":"p2"}],"in":[{"id":"p3"}],"not":[{"id":"wbnb"}],"bool":[{"id":"p2"}]}}`,
+		},
+		{
+			name:     "list contains",
+			query:    `{ pairs(where: {swaps_contains: ["s2"]}) { id } }`,
+			expected: `{"data":{"pairs":[{"id":"p1"}]}}`,
+		},
+		{
+			name:     "derived",
+			query:    `{ token(id: "cake") { pairBase(orderBy: txCount) { id txCount } } }`,
+			expected: `{"data":{"token":{"pairBase":[{"id":"p2","txCount":2},{"id":"p1","txCount":3}]}}}`,
+		},
+		{
+			name:     "derived at block",
+			query:    `{ token(id: "wbnb", block: {number: 10}) { pairBase { id } } }`,
+			expected: `{"data":{"token":{"pairBase":[]}}}`,
+		},
+		{
+			name:     "nested nullable",
+			query:    `{ swaps { id amount pair { token1 { symbol } } } }`,
+			expected: `{"data":{"swaps":[{"id":"s1","amount":"1.25","pair":{"token1":{"symbol":"WBNB"}}},{"id":"s2","amount":null,"pair":{"token1":{"symbol":"WBNB"}}}]}}`,
+		},
+		{
+			name:     "meta",
+			query:    `{ _meta { block { number hash } deployment hasIndexingErrors } past: _meta(block: {number: 12}) { block { number hash } } }`,
+			expected: `{"data":{"_meta":{"block":{"number":20,"hash":"0xabc"},"deployment":"QmTest","hasIndexingErrors":false},"past":{"block":{"number":12,"hash":null}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := server.Do(context.Background(), &Request{Query: test.query})
+			require.Empty(t, result.Errors)
+
+			data, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(data))
+		})
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	server := testServer(t)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "future block", query: `{ pairs(block: {number: 30}) { id } }`, expected: "block 30 is not available, the latest block is 20"},
+		{name: "number_gte", query: `{ pairs(block: {number_gte: 21}) { id } }`, expected: "block 21 is not available, the latest block is 20"},
+		{name: "page size", query: `{ pairs(first: 5000) { id } }`, expected: "first must be between 0 and 1000"},
+		{name: "unknown filter", query: `{ pairs(where: {size: 1}) { id } }`, expected: `Argument "where" has invalid value {size: 1}.
+In field "size": Unknown field.`},
+		{name: "invalid number", query: `{ pairs(where: {reserve0_gt: "abc"}) { id } }`, expected: `Argument "where" has invalid value {reserve0_gt: "abc"}.
+In field "reserve0_gt": Expected type "BigDecimal", found "abc".`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := server.Do(context.Background(), &Request{Query: test.query})
+			require.Len(t, result.Errors, 1)
+			assert.Equal(t, test.expected, result.Errors[0].Message)
+		})
+	}
+}
+
+func TestServer_HTTP(t *testing.T) {
+	server := httptest.NewServer(testServer(t))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"query":"query($id: ID!) { token(id: $id) { symbol } }","variables":{"id":"usdt"}}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, map[string]interface{}{"data": map[string]interface{}{"token": map[string]interface{}{"symbol": "USDT"}}}, body)
+}
+
+func TestNewServer_InvalidSchema(t *testing.T) {
+	_, err := NewServer(`type Pair @entity { id: ID! reserve: BigDecimal! }`, testRegistry(), nil, nil, "", zap.NewNop())
+	assert.EqualError(t, err, `entity Pair: field reserve has no column "reserve" in table "pair"`)
+
+	_, err = NewServer(`type Token @entity { id: ID! pairs: [Pair!]! @derivedFrom(field: "token1") } type Pair @entity { id: ID! token0: Token! }`, testRegistry(), nil, nil, "", zap.NewNop())
+	assert.EqualError(t, err, `entity Token: field pairs is derived from Pair.token1, which does not reference Token`)
+}
+
+func TestPluralize(t *testing.T) {
+	for single, plural := range map[string]string{"pair": "pairs", "pancakeFactory": "pancakeFactories", "pairHourData": "pairHourDatas", "day": "days", "box": "boxes"} {
+		assert.Equal(t, plural, pluralize(single))
+	}
+}
@@ -0,0 +1,38 @@
+package graphnode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// BlockColumn is the column holding the block an immutable entity was created at.
+const BlockColumn = "block$"
+
+var ErrImmutableEntity = errors.New("immutable entity")
+
+// Immutable entities are created once and never updated nor deleted, like graph-node's
+// `@entity(immutable: true)`. Their table has a `block$` column instead of `block_range`
+// and `_updated_block_number`, read back as an open-ended block range starting at `block$`.
+type Immutable interface {
+	Immutable() bool
+}
+
+func IsImmutable(entity Entity) bool {
+	immutable, ok := entity.(Immutable)
+	return ok && immutable.Immutable()
+}
+
+// IsImmutable tells whether the entities of `tableName` are immutable, false when the
+// table is unknown.
+func (r *Registry) IsImmutable(tableName string) bool {
+	t, ok := r.types[tableName]
+	if !ok {
+		return false
+	}
+	return IsImmutable(reflect.New(t).Interface().(Entity))
+}
+
+func NewImmutableEntityError(tableName, id, operation string) error {
+	return fmt.Errorf("%w: table %s, key %s: %s rejected, the entity cannot change once created", ErrImmutableEntity, tableName, id, operation)
+}
@@ -42,6 +42,7 @@ type Entity interface {
 	SetBlockRange(br *BlockRange)
 	GetBlockRange() *BlockRange
 	SetUpdatedBlockNum(blockNum uint64)
+	GetUpdatedBlockNum() uint64
 
 	Exists() bool
 	SetExists(exists bool)
@@ -2,13 +2,18 @@ package metrics
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/streamingfast/bstream"
 	"go.uber.org/zap/zapcore"
 )
 
+// ExecutionTime accumulates the time spent in each step of the block processing. The steps
+// run concurrently, updates must go through `Record`.
 type ExecutionTime struct {
+	lock sync.Mutex
+
 	TotalExecution   time.Duration
 	WaitForBlock     time.Duration
 	UnmarshalBlock   time.Duration
@@ -26,12 +31,26 @@ type ExecutionTime struct {
 	SelectQueriesDurations map[string]time.Duration
 	SelectQueriesCounts    map[string]int64
 
-	StoreSave int64
-	StoreCall int64
-	Count     int64
+	StoreSave    int64
+	StoreSkipped int64
+	StoreCall    int64
+	Count        int64
+
+	DanglingReferences int64
+}
+
+// Record applies `update` while holding the lock of the execution times.
+func (e *ExecutionTime) Record(update func(e *ExecutionTime)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	update(e)
 }
 
 func (e *ExecutionTime) Clean() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	e.TotalExecution = 0
 	e.WaitForBlock = 0
 	e.UnmarshalBlock = 0
@@ -42,18 +61,26 @@ func (e *ExecutionTime) Clean() {
 	e.Count = 0
 	e.SelectQueries = 0
 	e.StoreSave = 0
+	e.StoreSkipped = 0
 	e.StoreCall = 0
+	e.DanglingReferences = 0
 
 	e.SelectQueriesDurations = make(map[string]time.Duration)
 	e.SelectQueriesCounts = make(map[string]int64)
 }
 
 func (e *ExecutionTime) Finalize(t time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	e.TotalExecution += t
 	e.Count++
 }
 
 func (e *ExecutionTime) String() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	avgTotalExecution := time.Duration(int64(e.TotalExecution) / e.Count)
 	avgWaitForBlock := time.Duration(int64(e.WaitForBlock) / e.Count)
 	avgWaitForBlockRatio := float64(avgWaitForBlock) / float64(avgTotalExecution) * 100.0
@@ -80,7 +107,7 @@ func (e *ExecutionTime) String() string {
 		allSelects = fmt.Sprintf("%s %s: %d (%s),", allSelects, k, e.SelectQueriesCounts[k], time.Duration(int64(v)/e.Count))
 	}
 
-	return fmt.Sprintf("Total: %s, Wait for block: %s (%% %.1f), Unmarshal block: %s (%% %.1f), processing: %s (%% %.1f), queries: %s (%% %.1f), rpc: %s (%% %.1f), store flush: %s (%% %.1f | updates: %% %.1f) [Store BatchSave count: avg %d total: %d, %d distinct calls ] [Queries: %s] [for %d blocks]",
+	return fmt.Sprintf("Total: %s, Wait for block: %s (%% %.1f), Unmarshal block: %s (%% %.1f), processing: %s (%% %.1f), queries: %s (%% %.1f), rpc: %s (%% %.1f), store flush: %s (%% %.1f | updates: %% %.1f) [Store BatchSave count: avg %d total: %d, %d distinct calls, %d unchanged skipped ] [Queries: %s] [for %d blocks]",
 		avgTotalExecution,
 		avgWaitForBlock,
 		avgWaitForBlockRatio,
@@ -98,12 +125,16 @@ func (e *ExecutionTime) String() string {
 		avgStoreSave,
 		e.StoreSave,
 		e.StoreCall,
+		e.StoreSkipped,
 		allSelects,
 		e.Count,
 	)
 }
 
 func (e *ExecutionTime) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	avgTotalExecution := time.Duration(int64(e.TotalExecution) / e.Count)
 	avgWaitForBlock := time.Duration(int64(e.WaitForBlock) / e.Count)
 	avgWaitForBlockRatio := float64(avgWaitForBlock) / float64(avgTotalExecution) * 100.0
@@ -141,6 +172,8 @@ func (e *ExecutionTime) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 	encoder.AddInt64("store_save_count_avg", avgStoreSave)
 	encoder.AddInt64("store_save_count_total", e.StoreSave)
 	encoder.AddInt64("store_save_count_distinct", e.StoreCall)
+	encoder.AddInt64("store_save_skipped_unchanged", e.StoreSkipped)
+	encoder.AddInt64("dangling_references", e.DanglingReferences)
 	encoder.AddString("queries", allSelects)
 	encoder.AddInt64("block_count", e.Count)
 	return nil
@@ -6,49 +6,105 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"strconv"
 )
 
+// floatPrec is the precision of the binary floats converted from a Float, enough to hold
+// its 34 significant digits.
+const floatPrec = 128
+
+// Float is a graph-node BigDecimal, see BigDecimal for its rounding rules. The zero value is
+// nil, like a NULL column.
 type Float struct {
-	float *big.Float
+	decimal *BigDecimal
 }
 
-func NewFloat(f *big.Float) Float         { return Float{float: f} }
-func FloatAdd(a, b Float) Float           { return Float{float: new(big.Float).Add(a.float, b.float)} }
-func FloatSub(a, b Float) Float           { return Float{float: new(big.Float).Sub(a.float, b.float)} }
-func FloatMul(a, b Float) Float           { return Float{float: new(big.Float).Mul(a.float, b.float)} }
-func FloatQuo(a, b Float) Float           { return Float{float: new(big.Float).Quo(a.float, b.float)} }
-func NewFloatFromLiteral(f float64) Float { return Float{float: big.NewFloat(f)} }
+func NewFloat(f *big.Float) Float            { return NewFloatFromDecimal(NewBigDecimalFromBigFloat(f)) }
+func NewFloatFromDecimal(d BigDecimal) Float { return Float{decimal: &d} }
+func FloatAdd(a, b Float) Float              { return NewFloatFromDecimal(a.decimal.Add(*b.decimal)) }
+func FloatSub(a, b Float) Float              { return NewFloatFromDecimal(a.decimal.Sub(*b.decimal)) }
+func FloatMul(a, b Float) Float              { return NewFloatFromDecimal(a.decimal.Mul(*b.decimal)) }
+func FloatQuo(a, b Float) Float              { return NewFloatFromDecimal(a.decimal.Quo(*b.decimal)) }
 
-func (b *Float) Float() *big.Float              { return new(big.Float).Copy(b.float) }
-func (b Float) Ptr() *Float                     { return &b }
-func (b Float) String() string                  { return b.float.Text('g', -1) }
-func (b Float) StringRounded(digits int) string { return b.float.Text('g', digits) }
+// NewFloatFromLiteral returns the shortest decimal that reads back as `f`, so that `0.1`
+// is 0.1 and not its binary approximation.
+func NewFloatFromLiteral(f float64) Float {
+	d, err := ParseBigDecimal(strconv.FormatFloat(f, 'g', -1, 64))
+	if err != nil {
+		panic(fmt.Sprintf("converting literal %v to decimal: %s", f, err))
+	}
+	return NewFloatFromDecimal(d)
+}
 
-func (b Float) MarshalJSON() ([]byte, error) {
-	cnt, err := b.float.GobEncode()
+// ParseFloat parses a decimal number, see ParseBigDecimal.
+func ParseFloat(s string) (Float, error) {
+	d, err := ParseBigDecimal(s)
 	if err != nil {
-		return nil, fmt.Errorf("failed to gob encode entity.Float: %w", err)
+		return Float{}, err
 	}
-	return json.Marshal(hex.EncodeToString(cnt))
+	return NewFloatFromDecimal(d), nil
 }
-func (b *Float) UnmarshalJSON(data []byte) error {
-	var s string
-	if err := json.Unmarshal(data, &s); err != nil {
-		return fmt.Errorf("failed to json unmarshall entity.Float: %w", err)
+
+func (b *Float) Float() *big.Float              { return b.decimal.BigFloat(floatPrec) }
+func (b Float) Decimal() BigDecimal             { return *b.decimal }
+func (b Float) Ptr() *Float                     { return &b }
+func (b Float) String() string                  { return b.decimal.String() }
+func (b Float) StringRounded(digits int) string { return b.decimal.Round(int64(digits)).String() }
+
+// IsNil reports whether the value was never set, like a NULL column.
+func (b Float) IsNil() bool { return b.decimal == nil }
+
+// EqualWithin reports whether `b` and `o` differ by at most `tolerance`, relative to the
+// largest absolute value of the two. A zero tolerance requires an exact match.
+func (b Float) EqualWithin(o Float, tolerance float64) bool {
+	if b.decimal == nil || o.decimal == nil {
+		return b.decimal == nil && o.decimal == nil
+	}
+	if tolerance == 0 {
+		return b.decimal.Cmp(*o.decimal) == 0
 	}
 
-	cnt, err := hex.DecodeString(s)
-	if err != nil {
-		return fmt.Errorf("failed to hex decode entity.Float: %w", err)
+	bf, of := b.Float(), o.Float()
+	diff := new(big.Float).Sub(bf, of)
+	largest := new(big.Float).Abs(bf)
+	if abs := new(big.Float).Abs(of); abs.Cmp(largest) > 0 {
+		largest = abs
 	}
+	return diff.Abs(diff).Cmp(largest.Mul(largest, big.NewFloat(tolerance))) <= 0
+}
 
-	v := new(big.Float)
-	if err := v.GobDecode(cnt); err != nil {
-		return fmt.Errorf("failed to gob decoder entity.Float: %w", err)
+// MarshalJSON encodes the value as a decimal string, `null` when nil.
+func (b Float) MarshalJSON() ([]byte, error) {
+	if b.decimal == nil {
+		return []byte("null"), nil
 	}
-	v.SetPrec(100)
+	return json.Marshal(b.decimal.String())
+}
 
-	*b = NewFloat(v)
+// UnmarshalJSON decodes a decimal string or number, as well as the hex encoded gob blob
+// written by previous versions.
+func (b *Float) UnmarshalJSON(data []byte) error {
+	s, legacy, err := unmarshalNumericJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to json unmarshall entity.Float: %w", err)
+	}
+
+	switch {
+	case legacy != nil:
+		v := new(big.Float)
+		if err := v.GobDecode(legacy); err != nil {
+			return fmt.Errorf("failed to gob decode entity.Float %s: %w", data, err)
+		}
+		*b = NewFloat(v)
+	case s == "":
+		*b = Float{}
+	default:
+		d, err := ParseBigDecimal(s)
+		if err != nil {
+			return fmt.Errorf("failed to json unmarshall entity.Float: %w", err)
+		}
+		*b = NewFloatFromDecimal(d)
+	}
 	return nil
 }
 
@@ -57,13 +113,10 @@ func (b Float) MarshalCSV() ([]byte, error) {
 }
 
 func (b Float) Value() (driver.Value, error) {
-	if b.float == nil {
+	if b.decimal == nil {
 		return nil, nil
 	}
-	// 34 is the value the `graph-node` has in its `normalized`
-	// function, and corresponds to what we see in the DB for some
-	// values.
-	return b.float.Text('g', -1), nil
+	return b.decimal.String(), nil
 }
 
 func (b *Float) Scan(value interface{}) error {
@@ -71,21 +124,17 @@ func (b *Float) Scan(value interface{}) error {
 		return nil
 	}
 
-	sv, err := driver.String.ConvertValue(value)
+	bs, err := scanBytes(value)
 	if err != nil {
 		return err
 	}
-	bs, ok := sv.([]byte)
-	if !ok {
-		return fmt.Errorf("could not convert data to byte array")
-	}
 
-	newFloat, _, err := big.ParseFloat(string(bs), 10, 100, big.ToNearestEven)
+	d, err := ParseBigDecimal(string(bs))
 	if err != nil {
 		return fmt.Errorf("failed to set string %q: %s", string(bs), err)
 	}
 
-	b.float = newFloat
+	b.decimal = &d
 
 	return nil
 }
@@ -107,32 +156,48 @@ func NewIntFromLiteralUnsigned(i uint64) Int { return Int{int: big.NewInt(0).Set
 func (i *Int) Int() *big.Int { return new(big.Int).Set(i.int) }
 func (i Int) Ptr() *Int      { return &i }
 func (b Int) String() string { return b.int.String() }
-func (b Int) AsFloat() Float { return Float{float: new(big.Float).SetInt(b.int)} }
-func (b Int) MarshalJSON() ([]byte, error) {
-	cnt, err := b.int.GobEncode()
-	if err != nil {
-		return nil, fmt.Errorf("failed to gob encode entity.Int: %w", err)
+func (b Int) AsFloat() Float { return NewFloatFromDecimal(NewBigDecimal(b.int, 0)) }
+func (b Int) IsNil() bool    { return b.int == nil }
+
+func (b Int) Equal(o Int) bool {
+	if b.int == nil || o.int == nil {
+		return b.int == nil && o.int == nil
 	}
-	return json.Marshal(hex.EncodeToString(cnt))
+	return b.int.Cmp(o.int) == 0
 }
 
-func (b *Int) UnmarshalJSON(data []byte) error {
-	var s string
-	if err := json.Unmarshal(data, &s); err != nil {
-		return fmt.Errorf("failed to json unmarshall entity.Float: %w", err)
+// MarshalJSON encodes the value as a decimal string, `null` when nil.
+func (b Int) MarshalJSON() ([]byte, error) {
+	if b.int == nil {
+		return []byte("null"), nil
 	}
+	return json.Marshal(b.int.String())
+}
 
-	cnt, err := hex.DecodeString(s)
+// UnmarshalJSON decodes a decimal string or number, as well as the hex encoded gob blob
+// written by previous versions.
+func (b *Int) UnmarshalJSON(data []byte) error {
+	s, legacy, err := unmarshalNumericJSON(data)
 	if err != nil {
-		return fmt.Errorf("failed to hex decode entity.Float: %w", err)
+		return fmt.Errorf("failed to json unmarshall entity.Int: %w", err)
 	}
 
-	v := new(big.Int)
-	if err := v.GobDecode(cnt); err != nil {
-		return fmt.Errorf("failed to gob decoder entity.Float: %w", err)
+	switch {
+	case legacy != nil:
+		v := new(big.Int)
+		if err := v.GobDecode(legacy); err != nil {
+			return fmt.Errorf("failed to gob decode entity.Int %s: %w", data, err)
+		}
+		*b = NewInt(v)
+	case s == "":
+		*b = Int{}
+	default:
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("failed to json unmarshall entity.Int: invalid integer %q", s)
+		}
+		*b = NewInt(v)
 	}
-
-	*b = NewInt(v)
 	return nil
 }
 
@@ -151,14 +216,10 @@ func (b *Int) Scan(value interface{}) error {
 		return nil
 	}
 
-	sv, err := driver.String.ConvertValue(value)
+	bs, err := scanBytes(value)
 	if err != nil {
 		return err
 	}
-	bs, ok := sv.([]byte)
-	if !ok {
-		return fmt.Errorf("could not convert data to byte array")
-	}
 
 	b.int = new(big.Int)
 	if _, ok := b.int.SetString(string(bs), 10); !ok {
@@ -168,13 +229,63 @@ func (b *Int) Scan(value interface{}) error {
 	return nil
 }
 
-var zf = big.NewFloat(0)
 var zi = big.NewInt(0)
 
 func Z() Float {
-	return NewFloat(zf)
+	return NewFloatFromLiteral(0)
 }
 
 func I() Int {
 	return NewInt(zi)
 }
+
+// unmarshalNumericJSON returns the decimal text of a JSON string or number, empty for
+// `null`, or the gob blob of a hex string written by previous versions.
+//
+// Gob blobs start with a version byte, so their hex form starts with a `0` followed by a
+// digit, which a decimal written by MarshalJSON never does. Such a string can however be
+// all digits, so it is never read as a decimal: when it isn't a gob blob either, it is
+// rejected rather than guessed.
+func unmarshalNumericJSON(data []byte) (string, []byte, error) {
+	if string(data) == "null" {
+		return "", nil, nil
+	}
+	if len(data) == 0 || data[0] != '"' {
+		return string(data), nil, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return "", nil, err
+	}
+
+	if s == "" {
+		// a nil value encoded by previous versions
+		return "", nil, nil
+	}
+	if len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9' {
+		legacy, err := hex.DecodeString(s)
+		if err != nil {
+			return "", nil, fmt.Errorf("ambiguous value %q, neither a decimal nor a hex encoded gob blob: %w", s, err)
+		}
+		return "", legacy, nil
+	}
+	return s, nil, nil
+}
+
+// scanBytes returns the textual representation of a scanned value, drivers return
+// either `[]byte` (Postgres) or `string` (SQLite) for text and numeric columns.
+func scanBytes(value interface{}) ([]byte, error) {
+	sv, err := driver.String.ConvertValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := sv.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("could not convert data to byte array")
+}
@@ -1,6 +1,7 @@
 package graphnode
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -64,14 +65,47 @@ func TestExponentToBigDecimal(t *testing.T) {
 }
 
 func TestFloatMul(t *testing.T) {
-	t.Skip("precision issue")
 	reserve0, _ := new(big.Int).SetString("45765335860512456044", 10)
 	reserve1, _ := new(big.Int).SetString("74892810941332951", 10)
-	tokenDecimal := NewIntFromLiteral(18)
-	reserver0WithDecimal := NewFloat(ConvertTokenToDecimal(reserve0, tokenDecimal.Int().Int64()))
-	reserver1WithDecimal := NewFloat(ConvertTokenToDecimal(reserve1, tokenDecimal.Int().Int64()))
-	price := NewFloat(new(big.Float).Quo(reserver0WithDecimal.Float(), reserver1WithDecimal.Float()))
-	assert.Equal(t, "611.0778228949449984270327371611629", price.StringRounded(34))
+	reserver0WithDecimal := NewFloatFromDecimal(NewBigDecimal(reserve0, -18))
+	reserver1WithDecimal := NewFloatFromDecimal(NewBigDecimal(reserve1, -18))
+	price := FloatQuo(reserver0WithDecimal, reserver1WithDecimal)
+	assert.Equal(t, "611.0778228949449984270327371611629", price.String())
+	assert.Equal(t, "611.0778", price.StringRounded(7))
+}
+
+func TestFloatStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		scanned  interface{}
+		expected string
+	}{
+		{"postgres numeric", []byte("1.500000"), "1.5"},
+		{"sqlite text", "-0.00012", "-0.00012"},
+		{"exponent", "2.5e+21", "2500000000000000000000"},
+		{"beyond precision", "0.12345678901234567890123456789012345678", "0.1234567890123456789012345678901235"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var f Float
+			require.NoError(t, f.Scan(test.scanned))
+
+			value, err := f.Value()
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, value)
+
+			csv, err := f.MarshalCSV()
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(csv))
+
+			cnt, err := json.Marshal(f)
+			require.NoError(t, err)
+			var read Float
+			require.NoError(t, json.Unmarshal(cnt, &read))
+			assert.Equal(t, f, read)
+		})
+	}
 }
 
 func TestPrecisionComparedToRust(t *testing.T) {
@@ -111,3 +145,101 @@ func TestPrecisionComparedToRust(t *testing.T) {
 	//}
 
 }
+
+type jsonEntity struct {
+	Base
+	Reserve  Float
+	Volume   *Float
+	Missing  Float
+	Count    Int
+	NoCount  Int
+	Hash     Bytes
+	Approved Bool
+}
+
+func TestJSONRepresentation(t *testing.T) {
+	volume, err := ParseFloat("-0.00012")
+	require.NoError(t, err)
+	reserve, err := ParseFloat("1234.5678")
+	require.NoError(t, err)
+
+	ent := &jsonEntity{
+		Base:     Base{ID: "a", VID: 2, BlockRange: &BlockRange{StartBlock: 10, EndBlock: 20}},
+		Reserve:  reserve,
+		Volume:   &volume,
+		Count:    NewIntFromLiteral(-42),
+		Hash:     Bytes{0xca, 0xfe},
+		Approved: true,
+	}
+
+	cnt, err := json.Marshal(ent)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"ID": "a", "VID": 2, "BlockRange": "[10,20)", "UpdatedBlockNum": 0, "MutatedOnStep": 0,
+		"Reserve": "1234.5678", "Volume": "-0.00012", "Missing": null,
+		"Count": "-42", "NoCount": null, "Hash": "0xcafe", "Approved": true
+	}`, string(cnt))
+
+	read := &jsonEntity{}
+	require.NoError(t, json.Unmarshal(cnt, read))
+	assert.Equal(t, ent, read)
+	assert.True(t, read.Missing.IsNil())
+	assert.True(t, read.NoCount.IsNil())
+
+	ent.BlockRange.EndBlock = 0
+	cnt, err = json.Marshal(ent.BlockRange)
+	require.NoError(t, err)
+	assert.Equal(t, `"[10,)"`, string(cnt))
+}
+
+func TestJSONLegacyDecoding(t *testing.T) {
+	gobHex := func(v interface{ GobEncode() ([]byte, error) }) string {
+		cnt, err := v.GobEncode()
+		require.NoError(t, err)
+		return `"` + hex.EncodeToString(cnt) + `"`
+	}
+
+	legacyFloat, _, err := big.ParseFloat("1823.231", 10, 100, big.ToNearestEven)
+	require.NoError(t, err)
+
+	legacy := fmt.Sprintf(`{"EntityName": "json_entity", "Entities": {"a": {
+		"ID": "a", "BlockRange": {"StartBlock": 10, "EndBlock": 20},
+		"Reserve": %s, "Volume": %s, "Missing": "",
+		"Count": %s, "NoCount": "", "Hash": "yv4=", "Approved": true
+	}}}`, gobHex(legacyFloat), gobHex(big.NewFloat(12.5)), gobHex(big.NewInt(-1234567)))
+
+	exported := &ExportedEntities{TypeGetter: NewRegistry(&jsonEntity{}), Legacy: true}
+	require.NoError(t, json.Unmarshal([]byte(legacy), exported))
+	ent := exported.Entities["a"].(*jsonEntity)
+	assert.Equal(t, "1823.231", ent.Reserve.String())
+	assert.Equal(t, "12.5", ent.Volume.String())
+	assert.True(t, ent.Missing.IsNil(), "nil values were encoded as empty strings")
+	assert.Equal(t, "-1234567", ent.Count.String())
+	assert.True(t, ent.NoCount.IsNil())
+	assert.Equal(t, Bytes{0xca, 0xfe}, ent.Hash)
+	assert.Equal(t, &BlockRange{StartBlock: 10, EndBlock: 20}, ent.BlockRange)
+
+	// Gob blobs are decoded outside of legacy entities too, even when their hex is all digits
+	var i Int
+	require.Equal(t, `"0212"`, gobHex(big.NewInt(18)))
+	require.NoError(t, json.Unmarshal([]byte(`"0212"`), &i))
+	assert.Equal(t, "18", i.String())
+	require.NoError(t, json.Unmarshal([]byte(gobHex(big.NewInt(0))), &i))
+	assert.Equal(t, "0", i.String())
+
+	var f Float
+	allDigits := gobHex(new(big.Float).SetPrec(8).SetInt64(257)) // rounded to 256
+	require.Equal(t, `"010200000008000000098000000000000000"`, allDigits)
+	require.NoError(t, json.Unmarshal([]byte(allDigits), &f))
+	assert.Equal(t, "256", f.String())
+	require.NoError(t, json.Unmarshal([]byte(`12.50`), &f))
+	assert.Equal(t, "12.5", f.String())
+
+	// A leading zero is neither a decimal we write nor a gob blob
+	assert.Error(t, json.Unmarshal([]byte(`"0123"`), &i))
+	assert.Error(t, json.Unmarshal([]byte(`"0012"`), &i))
+	assert.Error(t, json.Unmarshal([]byte(`"0123"`), &f))
+
+	var b Bytes
+	assert.Error(t, json.Unmarshal([]byte(`"yv4="`), &b))
+}
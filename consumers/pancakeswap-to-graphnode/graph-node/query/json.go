@@ -0,0 +1,59 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+)
+
+// Encode encodes `ent` as a JSON object of its columns, in the order of the table. `Int`
+// and `Float` values are decimal strings, which clients decode without losing precision,
+// `Bytes` are 0x prefixed hex strings and block ranges read as in the database.
+func Encode(ent graphnode.Entity) (json.RawMessage, error) {
+	v := reflect.ValueOf(ent).Elem()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range graphnode.DBFields(v.Type()) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(field.ColumnName)
+		if err != nil {
+			return nil, err
+		}
+		value, err := encodeValue(v.FieldByName(field.Name))
+		if err != nil {
+			return nil, fmt.Errorf("encoding column %q: %w", field.ColumnName, err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeValue(v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return []byte("null"), nil
+		}
+		v = v.Elem()
+	}
+
+	switch val := v.Interface().(type) {
+	case graphnode.Bytes, graphnode.BlockRange:
+		return json.Marshal(graphnode.FormatField(v))
+	case graphnode.LocalStringArray:
+		if val == nil {
+			return []byte("[]"), nil
+		}
+		return json.Marshal([]string(val))
+	}
+	return json.Marshal(v.Interface())
+}
@@ -0,0 +1,454 @@
+// Package query reads entities from a store to inspect its data: an entity at a given
+// block, the entities of a table matching some filters, or every version of an entity.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/diff"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+var (
+	ErrUnknownTable  = errors.New("unknown table")
+	ErrUnknownColumn = errors.New("unknown column")
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// Column is a column of an entity along with its value, formatted as in the database.
+type Column struct {
+	Name  string
+	Value string
+}
+
+// Filter matches the entities whose column `Column` compares to `Value` as `Operator`
+// tells, formats to `Value` when `Operator` is empty. Numbers compare by value, the
+// `in` and `not_in` operators match `Values` and so does `contains` for list columns.
+// Nil values format to `null`, only the equality and `in` operators match them.
+type Filter struct {
+	Column   string
+	Operator string
+	Value    string
+	Values   []string
+}
+
+const (
+	OperatorEqual       = ""
+	OperatorNot         = "not"
+	OperatorGt          = "gt"
+	OperatorGte         = "gte"
+	OperatorLt          = "lt"
+	OperatorLte         = "lte"
+	OperatorIn          = "in"
+	OperatorNotIn       = "not_in"
+	OperatorContains    = "contains"
+	OperatorNotContains = "not_contains"
+	OperatorStartsWith  = "starts_with"
+	OperatorEndsWith    = "ends_with"
+)
+
+// ParseFilter parses a `column=value` filter.
+func ParseFilter(s string) (*Filter, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid filter %q, expected column=value", s)
+	}
+	return &Filter{Column: s[:i], Value: s[i+1:]}, nil
+}
+
+// Change is a column whose value differs from the previous version of an entity.
+type Change struct {
+	Column string
+	Old    string
+	New    string
+}
+
+// Version is a version of an entity along with the columns it changed.
+type Version struct {
+	Entity graphnode.Entity
+	// DeletedBefore is set when the entity was deleted at the end of the previous version,
+	// this version then recreated it.
+	DeletedBefore bool
+	// Changes is empty for the first version
+	Changes []*Change
+}
+
+// Columns returns the columns of `ent` in the order of the table.
+func Columns(ent graphnode.Entity) (out []*Column) {
+	v := reflect.ValueOf(ent).Elem()
+	for _, field := range graphnode.DBFields(v.Type()) {
+		out = append(out, &Column{Name: field.ColumnName, Value: graphnode.FormatField(v.FieldByName(field.Name))})
+	}
+	return out
+}
+
+// Get returns the version of the entity `id` valid at `blockNum`, nil when the entity did
+// not exist at that block.
+func Get(ctx context.Context, store storage.Store, registry *graphnode.Registry, table, id string, blockNum uint64) (graphnode.Entity, error) {
+	ent, err := newEntity(registry, table)
+	if err != nil {
+		return nil, err
+	}
+
+	ent.SetID(id)
+	if err := store.Load(ctx, id, ent, blockNum); err != nil {
+		return nil, fmt.Errorf("loading %q from %q at block %d: %w", id, table, blockNum, err)
+	}
+	if !ent.Exists() {
+		return nil, nil
+	}
+	return ent, nil
+}
+
+// List returns the entities of `table` valid at `blockNum` matching every filter.
+func List(ctx context.Context, store storage.Store, registry *graphnode.Registry, table string, blockNum uint64, filters []*Filter) (out []graphnode.Entity, err error) {
+	model, err := newEntity(registry, table)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := checkFilters(model, table, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := store.LoadAllDistinct(ctx, model, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("loading %q at block %d: %w", table, blockNum, err)
+	}
+
+	for _, ent := range entities {
+		matched, err := matches(ent, columns, filters)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			out = append(out, ent)
+		}
+	}
+	return out, nil
+}
+
+// Order sorts entities on `Column`, ascending unless `Descending`.
+type Order struct {
+	Column     string
+	Descending bool
+}
+
+// ListOptions selects a page of the entities of a table. Entities are sorted on `OrderBy`
+// then on their id, on their id only when `OrderBy` is nil.
+type ListOptions struct {
+	Filters []*Filter
+	OrderBy *Order
+	// First is the maximum number of entities returned, 0 returns them all
+	First int
+	Skip  int
+}
+
+// Finder is implemented by the stores filtering, sorting and paginating the entities of a
+// table themselves, `Find` otherwise reads the whole table at the block. The options are
+// validated against the columns of `model` before being handed to the store.
+type Finder interface {
+	FindEntities(ctx context.Context, model graphnode.Entity, blockNum uint64, opts *ListOptions) ([]graphnode.Entity, error)
+}
+
+// Find returns the page `opts` of the entities of `table` valid at `blockNum`.
+func Find(ctx context.Context, store storage.Store, registry *graphnode.Registry, table string, blockNum uint64, opts *ListOptions) ([]graphnode.Entity, error) {
+	model, err := newEntity(registry, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var orderField string
+	if opts.OrderBy != nil {
+		for _, field := range graphnode.DBFields(reflect.TypeOf(model).Elem()) {
+			if field.ColumnName == opts.OrderBy.Column {
+				orderField = field.Name
+			}
+		}
+		if orderField == "" {
+			return nil, fmt.Errorf("%w %q in table %q", ErrUnknownColumn, opts.OrderBy.Column, table)
+		}
+	}
+
+	if finder, ok := store.(Finder); ok {
+		if _, err := checkFilters(model, table, opts.Filters); err != nil {
+			return nil, err
+		}
+		entities, err := finder.FindEntities(ctx, model, blockNum, opts)
+		if err != nil {
+			return nil, fmt.Errorf("finding %q at block %d: %w", table, blockNum, err)
+		}
+		return entities, nil
+	}
+
+	entities, err := List(ctx, store, registry, table, blockNum, opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entities, func(i, j int) bool {
+		if orderField != "" {
+			cmp := CompareValues(reflect.ValueOf(entities[i]).Elem().FieldByName(orderField), reflect.ValueOf(entities[j]).Elem().FieldByName(orderField))
+			if opts.OrderBy.Descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return entities[i].GetID() < entities[j].GetID()
+	})
+
+	if opts.Skip >= len(entities) {
+		return nil, nil
+	}
+	entities = entities[opts.Skip:]
+	if opts.First > 0 && opts.First < len(entities) {
+		entities = entities[:opts.First]
+	}
+	return entities, nil
+}
+
+// CompareValues compares two values of the same entity field, numbers by value and the
+// other types as they format, nil values first.
+func CompareValues(a, b reflect.Value) int {
+	if a.Kind() == reflect.Ptr {
+		switch {
+		case a.IsNil() && b.IsNil():
+			return 0
+		case a.IsNil():
+			return -1
+		case b.IsNil():
+			return 1
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+
+	switch va := a.Interface().(type) {
+	case graphnode.Int:
+		vb := b.Interface().(graphnode.Int)
+		if va.IsNil() || vb.IsNil() {
+			return compareNil(va.IsNil(), vb.IsNil())
+		}
+		return va.Int().Cmp(vb.Int())
+	case graphnode.Float:
+		vb := b.Interface().(graphnode.Float)
+		if va.IsNil() || vb.IsNil() {
+			return compareNil(va.IsNil(), vb.IsNil())
+		}
+		return va.Decimal().Cmp(vb.Decimal())
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	case reflect.Bool:
+		return compareOrdered(!a.Bool() && b.Bool(), a.Bool() && !b.Bool())
+	}
+	return strings.Compare(graphnode.FormatField(a), graphnode.FormatField(b))
+}
+
+func compareNil(aNil, bNil bool) int {
+	return compareOrdered(aNil && !bNil, !aNil && bNil)
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// checkFilters rejects the filters on unknown columns or with an unknown operator, it
+// returns the fields of `model` by column.
+func checkFilters(model graphnode.Entity, table string, filters []*Filter) (map[string]string, error) {
+	columns := map[string]string{}
+	for _, field := range graphnode.DBFields(reflect.TypeOf(model).Elem()) {
+		columns[field.ColumnName] = field.Name
+	}
+	for _, filter := range filters {
+		if _, found := columns[filter.Column]; !found {
+			return nil, fmt.Errorf("%w %q in table %q", ErrUnknownColumn, filter.Column, table)
+		}
+		if !validOperators[filter.Operator] {
+			return nil, fmt.Errorf("%w %q on column %q", ErrInvalidFilter, filter.Operator, filter.Column)
+		}
+	}
+	return columns, nil
+}
+
+func matches(ent graphnode.Entity, columns map[string]string, filters []*Filter) (bool, error) {
+	v := reflect.ValueOf(ent).Elem()
+	for _, filter := range filters {
+		matched, err := filter.match(v.FieldByName(columns[filter.Column]))
+		if err != nil {
+			return false, fmt.Errorf("%w on column %q: %s", ErrInvalidFilter, filter.Column, err)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+var validOperators = map[string]bool{
+	OperatorEqual: true, OperatorNot: true,
+	OperatorGt: true, OperatorGte: true, OperatorLt: true, OperatorLte: true,
+	OperatorIn: true, OperatorNotIn: true,
+	OperatorContains: true, OperatorNotContains: true, OperatorStartsWith: true, OperatorEndsWith: true,
+}
+
+func (f *Filter) match(v reflect.Value) (bool, error) {
+	formatted := graphnode.FormatField(v)
+
+	switch f.Operator {
+	case OperatorEqual:
+		return formatted == f.Value, nil
+	case OperatorNot:
+		return formatted != f.Value, nil
+	case OperatorIn, OperatorNotIn:
+		found := false
+		for _, value := range f.Values {
+			if formatted == value {
+				found = true
+				break
+			}
+		}
+		return found == (f.Operator == OperatorIn), nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false, nil
+		}
+		v = v.Elem()
+	}
+	if formatted == "null" {
+		return false, nil
+	}
+
+	switch f.Operator {
+	case OperatorContains, OperatorNotContains:
+		contained := true
+		if array, ok := v.Interface().(graphnode.LocalStringArray); ok {
+			elements := map[string]bool{}
+			for _, element := range array {
+				elements[element] = true
+			}
+			for _, value := range f.Values {
+				contained = contained && elements[value]
+			}
+		} else {
+			contained = strings.Contains(formatted, f.Value)
+		}
+		return contained == (f.Operator == OperatorContains), nil
+	case OperatorStartsWith:
+		return strings.HasPrefix(formatted, f.Value), nil
+	case OperatorEndsWith:
+		return strings.HasSuffix(formatted, f.Value), nil
+	}
+
+	cmp, err := compareTo(v, f.Value)
+	if err != nil {
+		return false, err
+	}
+	switch f.Operator {
+	case OperatorGt:
+		return cmp > 0, nil
+	case OperatorGte:
+		return cmp >= 0, nil
+	case OperatorLt:
+		return cmp < 0, nil
+	}
+	return cmp <= 0, nil
+}
+
+// compareTo compares the value of a field to `s`, parsed to the type of the field.
+func compareTo(v reflect.Value, s string) (int, error) {
+	switch val := v.Interface().(type) {
+	case graphnode.Int:
+		other, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return 0, fmt.Errorf("invalid integer %q", s)
+		}
+		return val.Int().Cmp(other), nil
+	case graphnode.Float:
+		other, err := graphnode.ParseBigDecimal(s)
+		if err != nil {
+			return 0, err
+		}
+		return val.Decimal().Cmp(other), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		other, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", s)
+		}
+		return compareOrdered(v.Int() < other, v.Int() > other), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		other, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", s)
+		}
+		return compareOrdered(v.Uint() < other, v.Uint() > other), nil
+	}
+	return strings.Compare(graphnode.FormatField(v), s), nil
+}
+
+// History returns every version of the entity `id` still retained by the store, oldest
+// first, with the columns each version changed.
+func History(ctx context.Context, store storage.Store, registry *graphnode.Registry, table, id string) ([]*Version, error) {
+	historyLoader, ok := store.(storage.HistoryLoader)
+	if !ok {
+		return nil, fmt.Errorf("store does not support loading the history of entities")
+	}
+
+	model, err := newEntity(registry, table)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := historyLoader.LoadHistory(ctx, id, model)
+	if err != nil {
+		return nil, fmt.Errorf("loading history of %q from %q: %w", id, table, err)
+	}
+
+	out := make([]*Version, len(entities))
+	for i, ent := range entities {
+		out[i] = &Version{Entity: ent}
+		if i == 0 {
+			continue
+		}
+
+		previous := entities[i-1]
+		out[i].DeletedBefore = previous.GetBlockRange().EndBlock != ent.GetBlockRange().StartBlock
+		for _, field := range diff.Entities(previous, ent, 0) {
+			out[i].Changes = append(out[i].Changes, &Change{Column: field.Field, Old: field.Expected, New: field.Actual})
+		}
+	}
+	return out, nil
+}
+
+func newEntity(registry *graphnode.Registry, table string) (graphnode.Entity, error) {
+	ent, found := registry.GetInterface(table)
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTable, table)
+	}
+	return ent, nil
+}
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func save(t *testing.T, store storage.Store, blockNum uint64, entities map[string]graphnode.Entity) {
+	ctx := context.Background()
+	for id, ent := range entities {
+		if ent == nil {
+			continue
+		}
+		current := &storagetest.TestEntity{Base: graphnode.NewBase(id)}
+		require.NoError(t, store.Load(ctx, id, current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+	}
+	require.NoError(t, store.BatchSave(ctx, blockNum, "", time.Time{}, map[string]map[string]graphnode.Entity{"test_entity": entities}, ""))
+}
+
+func testStore(t *testing.T) storage.Store {
+	store := memory.New(zap.NewNop(), storagetest.Registry())
+	save(t, store, 10, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a1", 1), "b": storagetest.NewTestEntity("b", "b1", 1)})
+	save(t, store, 20, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a2", 1), "c": storagetest.NewTestEntity("c", "c1", 2)})
+	save(t, store, 30, map[string]graphnode.Entity{"a": nil})
+	save(t, store, 40, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a3", 3)})
+	return store
+}
+
+func columnValues(ent graphnode.Entity) map[string]string {
+	out := map[string]string{}
+	for _, column := range Columns(ent) {
+		out[column.Name] = column.Value
+	}
+	return out
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	store := testStore(t)
+	registry := storagetest.Registry()
+
+	ent, err := Get(ctx, store, registry, "test_entity", "a", 25)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "a", "vid": "3", "block_range": "[20,30)", "_updated_block_number": "30", "name": "a2", "amount": "1"}, columnValues(ent))
+
+	ent, err = Get(ctx, store, registry, "test_entity", "a", 35)
+	require.NoError(t, err)
+	assert.Nil(t, ent)
+
+	_, err = Get(ctx, store, registry, "unknown", "a", 35)
+	assert.EqualError(t, err, `unknown table "unknown"`)
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	store := testStore(t)
+	registry := storagetest.Registry()
+
+	ids := func(entities []graphnode.Entity) (out []string) {
+		for _, ent := range entities {
+			out = append(out, ent.GetID())
+		}
+		return out
+	}
+
+	tests := []struct {
+		name          string
+		blockNum      uint64
+		filters       []string
+		expected      []string
+		expectedError string
+	}{
+		{name: "all", blockNum: 25, expected: []string{"a", "b", "c"}},
+		{name: "deleted", blockNum: 35, expected: []string{"b", "c"}},
+		{name: "filtered", blockNum: 25, filters: []string{"amount=1"}, expected: []string{"a", "b"}},
+		{name: "every filter", blockNum: 25, filters: []string{"amount=1", "name=b1"}, expected: []string{"b"}},
+		{name: "unknown column", blockNum: 25, filters: []string{"size=1"}, expectedError: `unknown column "size" in table "test_entity"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var filters []*Filter
+			for _, f := range test.filters {
+				filter, err := ParseFilter(f)
+				require.NoError(t, err)
+				filters = append(filters, filter)
+			}
+
+			entities, err := List(ctx, store, registry, "test_entity", test.blockNum, filters)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, ids(entities))
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	registry := storagetest.Registry()
+
+	store := memory.New(zap.NewNop(), registry)
+	save(t, store, 10, map[string]graphnode.Entity{
+		"x": storagetest.NewTestEntity("x", "n1", 9),
+		"y": storagetest.NewTestEntity("y", "n1", 10),
+		"z": storagetest.NewTestEntity("z", "n2", 2),
+		"w": storagetest.NewTestEntity("w", "n2", 10),
+	})
+
+	tests := []struct {
+		name          string
+		opts          *ListOptions
+		expected      []string
+		expectedError string
+	}{
+		{name: "by id", opts: &ListOptions{}, expected: []string{"w", "x", "y", "z"}},
+		{name: "numbers by value", opts: &ListOptions{OrderBy: &Order{Column: "amount"}}, expected: []string{"z", "x", "w", "y"}},
+		{name: "descending, ties by id", opts: &ListOptions{OrderBy: &Order{Column: "amount", Descending: true}}, expected: []string{"w", "y", "x", "z"}},
+		{name: "page", opts: &ListOptions{OrderBy: &Order{Column: "amount"}, Skip: 1, First: 2}, expected: []string{"x", "w"}},
+		{name: "past the end", opts: &ListOptions{Skip: 4}, expected: nil},
+		{name: "filtered", opts: &ListOptions{Filters: []*Filter{{Column: "name", Value: "n2"}}, OrderBy: &Order{Column: "amount"}}, expected: []string{"z", "w"}},
+		{name: "unknown column", opts: &ListOptions{OrderBy: &Order{Column: "size"}}, expectedError: `unknown column "size" in table "test_entity"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := Find(ctx, store, registry, "test_entity", 10, test.opts)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				assert.ErrorIs(t, err, ErrUnknownColumn)
+				return
+			}
+			require.NoError(t, err)
+
+			var ids []string
+			for _, ent := range entities {
+				ids = append(ids, ent.GetID())
+			}
+			assert.Equal(t, test.expected, ids)
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ent, err := Get(context.Background(), testStore(t), storagetest.Registry(), "test_entity", "a", 25)
+	require.NoError(t, err)
+
+	encoded, err := Encode(ent)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"a","vid":3,"block_range":"[20,30)","_updated_block_number":30,"name":"a2","amount":"1"}`, string(encoded))
+}
+
+func TestHistory(t *testing.T) {
+	versions, err := History(context.Background(), testStore(t), storagetest.Registry(), "test_entity", "a")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	assert.Equal(t, "[10,20)", versions[0].Entity.GetBlockRange().String())
+	assert.False(t, versions[0].DeletedBefore)
+	assert.Empty(t, versions[0].Changes)
+
+	assert.Equal(t, "[20,30)", versions[1].Entity.GetBlockRange().String())
+	assert.False(t, versions[1].DeletedBefore)
+	assert.Equal(t, []*Change{{Column: "name", Old: "a1", New: "a2"}}, versions[1].Changes)
+
+	assert.Equal(t, "[40,)", versions[2].Entity.GetBlockRange().String())
+	assert.True(t, versions[2].DeletedBefore)
+	assert.Equal(t, []*Change{{Column: "name", Old: "a2", New: "a3"}, {Column: "amount", Old: "1", New: "3"}}, versions[2].Changes)
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter("name=a=b")
+	require.NoError(t, err)
+	assert.Equal(t, &Filter{Column: "name", Value: "a=b"}, filter)
+
+	_, err = ParseFilter("=a")
+	assert.EqualError(t, err, `invalid filter "=a", expected column=value`)
+}
@@ -11,6 +11,9 @@ type Registry struct {
 	entities   []Entity
 	types      map[string]reflect.Type
 	interfaces map[reflect.Type]Entity
+
+	// schema holds the `@entity` types of the GraphQL schema by table, once loaded
+	schema map[string]*SchemaEntity
 }
 
 func NewRegistry(entities ...Entity) *Registry {
@@ -0,0 +1,293 @@
+package graphnode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/iancoleman/strcase"
+)
+
+var ErrDanglingReference = errors.New("dangling reference")
+
+type FieldKind int
+
+const (
+	// ScalarField holds a value in its column
+	ScalarField FieldKind = iota
+	// ReferenceField holds in its column the id of an entity of `Target`
+	ReferenceField
+	// ReferenceListField holds in its column an array of ids of entities of `Target`
+	ReferenceListField
+	// DerivedField lists the entities of `Target` whose field `DerivedFrom` references
+	// the entity, it has no column
+	DerivedField
+)
+
+// SchemaEntity is an `@entity` type of the GraphQL schema along with the table storing it.
+type SchemaEntity struct {
+	Name   string
+	Table  string
+	Fields []*SchemaField
+}
+
+// SchemaField is a field of an `@entity` type, `Column` and `GoField` are empty for
+// derived fields.
+type SchemaField struct {
+	Name       string
+	Kind       FieldKind
+	NonNull    bool
+	Scalar     string
+	ScalarList bool
+
+	// Target is the type referenced by a reference or derived field, stored by
+	// `TargetTable`
+	Target      string
+	TargetTable string
+	DerivedFrom string
+
+	Column  string
+	GoField string
+}
+
+func (e *SchemaEntity) Field(name string) *SchemaField {
+	for _, field := range e.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// IsRelation tells whether the field references or is derived from other entities.
+func (f *SchemaField) IsRelation() bool {
+	return f.Kind != ScalarField
+}
+
+// Value returns the struct field of `ent` storing `f`.
+func (f *SchemaField) Value(ent Entity) reflect.Value {
+	return reflect.ValueOf(ent).Elem().FieldByName(f.GoField)
+}
+
+// IDs returns the ids referenced by `ent` through the reference field `f`, the empty ids
+// are left out.
+func (f *SchemaField) IDs(ent Entity) []string {
+	var ids []string
+	switch v := f.Value(ent).Interface().(type) {
+	case string:
+		ids = []string{v}
+	case *string:
+		if v != nil {
+			ids = []string{*v}
+		}
+	case LocalStringArray:
+		ids = v
+	case []string:
+		ids = v
+	}
+
+	out := ids[:0:0]
+	for _, id := range ids {
+		if id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+var schemaScalars = map[string]bool{"ID": true, "String": true, "Int": true, "Boolean": true, "BigInt": true, "BigDecimal": true, "Bytes": true}
+
+// LoadSchema reads the relations between the entities from the GraphQL schema `sdl`, see
+// `ParseSchema`.
+func (r *Registry) LoadSchema(sdl string) error {
+	entities, err := ParseSchema(sdl, r)
+	if err != nil {
+		return err
+	}
+
+	r.schema = map[string]*SchemaEntity{}
+	for _, entity := range entities {
+		r.schema[entity.Table] = entity
+	}
+	return nil
+}
+
+// SchemaEntity returns the `@entity` type stored by `tableName`, false when no schema was
+// loaded or the table is not part of it.
+func (r *Registry) SchemaEntity(tableName string) (*SchemaEntity, bool) {
+	entity, found := r.schema[tableName]
+	return entity, found
+}
+
+// Relations returns the reference and derived fields of the entities of `tableName`.
+func (r *Registry) Relations(tableName string) []*SchemaField {
+	entity, found := r.schema[tableName]
+	if !found {
+		return nil
+	}
+
+	var out []*SchemaField
+	for _, field := range entity.Fields {
+		if field.IsRelation() {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+// ParseSchema reads the `@entity` types of the GraphQL schema `sdl`, each one must be
+// stored by a table of `registry`: the table of a type is its name in snake case, as is
+// the column of a field. A `@derivedFrom` field must name a field referencing back its
+// entity.
+func ParseSchema(sdl string, registry *Registry) ([]*SchemaEntity, error) {
+	doc, err := parser.Parse(parser.ParseParams{Source: sdl})
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+
+	var definitions []*ast.ObjectDefinition
+	names := map[string]bool{}
+	for _, definition := range doc.Definitions {
+		object, ok := definition.(*ast.ObjectDefinition)
+		if !ok || schemaDirective(object.Directives, "entity") == nil {
+			continue
+		}
+		definitions = append(definitions, object)
+		names[object.Name.Value] = true
+	}
+
+	var out []*SchemaEntity
+	for _, definition := range definitions {
+		entity := &SchemaEntity{
+			Name:  definition.Name.Value,
+			Table: strcase.ToSnake(definition.Name.Value),
+		}
+
+		goType, found := registry.GetType(entity.Table)
+		if !found {
+			return nil, fmt.Errorf("entity %s: no table %q in registry", entity.Name, entity.Table)
+		}
+		columns := map[string]string{}
+		for _, field := range DBFields(goType) {
+			columns[field.ColumnName] = field.Name
+		}
+
+		for _, fieldDefinition := range definition.Fields {
+			field, err := newSchemaField(fieldDefinition, names)
+			if err != nil {
+				return nil, fmt.Errorf("entity %s: %w", entity.Name, err)
+			}
+
+			if field.Kind != DerivedField {
+				field.Column = strcase.ToSnake(field.Name)
+				if field.GoField = columns[field.Column]; field.GoField == "" {
+					return nil, fmt.Errorf("entity %s: field %s has no column %q in table %q", entity.Name, field.Name, field.Column, entity.Table)
+				}
+			}
+			entity.Fields = append(entity.Fields, field)
+		}
+		out = append(out, entity)
+	}
+
+	byName := map[string]*SchemaEntity{}
+	for _, entity := range out {
+		byName[entity.Name] = entity
+	}
+	for _, entity := range out {
+		for _, field := range entity.Fields {
+			if field.Kind != DerivedField {
+				continue
+			}
+			from := byName[field.Target].Field(field.DerivedFrom)
+			if from == nil || (from.Kind != ReferenceField && from.Kind != ReferenceListField) || from.Target != entity.Name {
+				return nil, fmt.Errorf("entity %s: field %s is derived from %s.%s, which does not reference %s", entity.Name, field.Name, field.Target, field.DerivedFrom, entity.Name)
+			}
+		}
+	}
+	return out, nil
+}
+
+func newSchemaField(definition *ast.FieldDefinition, entities map[string]bool) (*SchemaField, error) {
+	field := &SchemaField{Name: definition.Name.Value}
+
+	typ := definition.Type
+	if nonNull, ok := typ.(*ast.NonNull); ok {
+		field.NonNull = true
+		typ = nonNull.Type
+	}
+	list := false
+	if l, ok := typ.(*ast.List); ok {
+		list = true
+		typ = l.Type
+		if nonNull, ok := typ.(*ast.NonNull); ok {
+			typ = nonNull.Type
+		}
+	}
+	named, ok := typ.(*ast.Named)
+	if !ok {
+		return nil, fmt.Errorf("field %s: unsupported type", field.Name)
+	}
+	typeName := named.Name.Value
+
+	switch {
+	case entities[typeName]:
+		field.Target = typeName
+		field.TargetTable = strcase.ToSnake(typeName)
+		field.Kind = ReferenceField
+		if list {
+			field.Kind = ReferenceListField
+		}
+		if derived := schemaDirective(definition.Directives, "derivedFrom"); derived != nil {
+			from, ok := schemaArgument(derived, "field").(*ast.StringValue)
+			if !ok || !list {
+				return nil, fmt.Errorf("field %s: invalid @derivedFrom", field.Name)
+			}
+			field.Kind = DerivedField
+			field.DerivedFrom = from.Value
+		}
+	case schemaScalars[typeName]:
+		field.Scalar = typeName
+		field.ScalarList = list
+	default:
+		return nil, fmt.Errorf("field %s: unsupported type %s", field.Name, typeName)
+	}
+	return field, nil
+}
+
+func schemaDirective(directives []*ast.Directive, name string) *ast.Directive {
+	for _, d := range directives {
+		if d.Name.Value == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func schemaArgument(d *ast.Directive, name string) ast.Value {
+	for _, arg := range d.Arguments {
+		if arg.Name.Value == name {
+			return arg.Value
+		}
+	}
+	return nil
+}
+
+// DanglingReference is a reference to an entity which does not exist at the block the
+// referencing entity is written.
+type DanglingReference struct {
+	Table    string
+	ID       string
+	Field    string
+	Target   string
+	TargetID string
+}
+
+func (d *DanglingReference) String() string {
+	return fmt.Sprintf("%s %s.%s -> %s %s", d.Table, d.ID, d.Field, d.Target, d.TargetID)
+}
+
+func NewDanglingReferencesError(blockNum uint64, references []*DanglingReference) error {
+	return fmt.Errorf("%w: block %d: %d references to missing entities, first one: %s", ErrDanglingReference, blockNum, len(references), references[0])
+}
@@ -0,0 +1,106 @@
+package graphnode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Owner struct {
+	Base
+	Name string `db:"name"`
+}
+
+type Item struct {
+	Base
+	Owner    string           `db:"owner"`
+	Previous *string          `db:"previous,nullable"`
+	Related  LocalStringArray `db:"related,nullable"`
+}
+
+const schemaTestSDL = `
+type Owner @entity {
+  id: ID!
+  name: String!
+  items: [Item!]! @derivedFrom(field: "owner")
+}
+
+type Item @entity(immutable: true) {
+  id: ID!
+  owner: Owner! @parallel(step: 1)
+  previous: Item
+  related: [Item!]!
+}
+`
+
+func TestRegistry_LoadSchema(t *testing.T) {
+	registry := NewRegistry(&Owner{}, &Item{})
+	require.NoError(t, registry.LoadSchema(schemaTestSDL))
+
+	owner, found := registry.SchemaEntity("owner")
+	require.True(t, found)
+	assert.Equal(t, "Owner", owner.Name)
+	assert.Equal(t, &SchemaField{Name: "items", Kind: DerivedField, NonNull: true, Target: "Item", TargetTable: "item", DerivedFrom: "owner"}, owner.Field("items"))
+
+	var relations []string
+	for _, field := range registry.Relations("item") {
+		relations = append(relations, field.Name)
+	}
+	assert.Equal(t, []string{"owner", "previous", "related"}, relations)
+	assert.Nil(t, registry.Relations("unknown"))
+
+	item, _ := registry.SchemaEntity("item")
+	assert.Equal(t, ReferenceField, item.Field("owner").Kind)
+	assert.Equal(t, "Owner", item.Field("owner").GoField)
+	assert.Equal(t, ReferenceListField, item.Field("related").Kind)
+}
+
+func TestParseSchema_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		sdl           string
+		expectedError string
+	}{
+		{
+			name:          "unknown table",
+			sdl:           `type Missing @entity { id: ID! }`,
+			expectedError: `entity Missing: no table "missing" in registry`,
+		},
+		{
+			name:          "unknown column",
+			sdl:           `type Owner @entity { id: ID! size: Int! }`,
+			expectedError: `entity Owner: field size has no column "size" in table "owner"`,
+		},
+		{
+			name: "derived from a field not referencing back",
+			sdl: `type Owner @entity { id: ID! items: [Item!]! @derivedFrom(field: "previous") }
+type Item @entity { id: ID! owner: Owner! previous: Item related: [Item!]! }`,
+			expectedError: `entity Owner: field items is derived from Item.previous, which does not reference Owner`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSchema(test.sdl, NewRegistry(&Owner{}, &Item{}))
+			assert.EqualError(t, err, test.expectedError)
+		})
+	}
+}
+
+func TestSchemaField_IDs(t *testing.T) {
+	registry := NewRegistry(&Owner{}, &Item{})
+	require.NoError(t, registry.LoadSchema(schemaTestSDL))
+	item, _ := registry.SchemaEntity("item")
+
+	previous := "i0"
+	ent := &Item{Base: NewBase("i1"), Owner: "o1", Previous: &previous, Related: LocalStringArray{"i2", "", "i3"}}
+	assert.Equal(t, []string{"o1"}, item.Field("owner").IDs(ent))
+	assert.Equal(t, []string{"i0"}, item.Field("previous").IDs(ent))
+	assert.Equal(t, []string{"i2", "i3"}, item.Field("related").IDs(ent))
+
+	ent = &Item{Base: NewBase("i1")}
+	assert.Empty(t, item.Field("owner").IDs(ent))
+	assert.Empty(t, item.Field("previous").IDs(ent))
+	assert.Empty(t, item.Field("related").IDs(ent))
+}
@@ -0,0 +1,354 @@
+// Package snapshot dumps the entities valid at a given block to a gzip compressed stream
+// of JSON lines, and restores such a dump into an empty store.
+//
+// The first line is a `Header`, every following line is a `graphnode.ExportedEntities`
+// holding the entities of one table whose current version started at `BlockNum`. Lines
+// are ordered by `BlockNum` so a restore only keeps a single block in memory. Version 2
+// encodes the entity values as documented on `graphnode.ExportedEntities`, version 1
+// encoded numbers as gob blobs and is still restored.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+const FormatVersion = 2
+
+type Header struct {
+	Version  int
+	BlockNum uint64
+	// Cursor is the cursor of `BlockNum`, empty when the snapshot was not taken at the head
+	// of the store, in which case loading must resume from `BlockNum + 1`.
+	Cursor string
+	Tables map[string]int
+}
+
+// Dump writes every entity of the registry valid at `blockNum`. Each table is streamed, in
+// the order of the start block of the entities, to a temporary file of `dir`, the system
+// one when empty, then the tables are merged so that only one block of each is in memory.
+func Dump(ctx context.Context, store storage.Store, registry *graphnode.Registry, blockNum uint64, cursor string, dir string, w io.Writer, logger *zap.Logger) (*Header, error) {
+	header := &Header{
+		Version:  FormatVersion,
+		BlockNum: blockNum,
+		Cursor:   cursor,
+		Tables:   map[string]int{},
+	}
+
+	tempDir, err := os.MkdirTemp(dir, "snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var tables []*tableReader
+	defer func() {
+		for _, table := range tables {
+			table.Close()
+		}
+	}()
+	for _, model := range registry.Entities() {
+		tableName := graphnode.GetTableName(model)
+		path := filepath.Join(tempDir, tableName+".ndjson.gz")
+
+		start := time.Now()
+		count, err := dumpTable(ctx, store, model, blockNum, path)
+		if err != nil {
+			return nil, fmt.Errorf("dumping %q entities at block %d: %w", tableName, blockNum, err)
+		}
+		header.Tables[tableName] = count
+		logger.Info("dumped table", zap.String("table", tableName), zap.Int("entity_count", count), zap.Duration("duration", time.Since(start)))
+
+		table, err := openTableReader(tableName, path)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	if err := encoder.Encode(header); err != nil {
+		return nil, fmt.Errorf("writing header: %w", err)
+	}
+
+	// The lines of every table are merged, ordered by block then table name
+	for {
+		var next *tableReader
+		for _, table := range tables {
+			if table.line == nil {
+				continue
+			}
+			if next == nil || table.blockNum < next.blockNum || (table.blockNum == next.blockNum && table.name < next.name) {
+				next = table
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		if _, err := gz.Write(next.line); err != nil {
+			return nil, fmt.Errorf("writing %q entities of block %d: %w", next.name, next.blockNum, err)
+		}
+		if err := next.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("closing gzip stream: %w", err)
+	}
+	return header, nil
+}
+
+// dumpTable writes to `path` the entities of the table of `model` valid at `blockNum`, a
+// line per start block, and returns their count.
+func dumpTable(ctx context.Context, store storage.Store, model graphnode.Entity, blockNum uint64, path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	if err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(gz)
+
+	tableName := graphnode.GetTableName(model)
+	var current *graphnode.ExportedEntities
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := encoder.Encode(current); err != nil {
+			return fmt.Errorf("writing entities of block %d: %w", current.BlockNum, err)
+		}
+		return nil
+	}
+
+	count := 0
+	err = streamAllDistinct(ctx, store, model, blockNum, func(ent graphnode.Entity) error {
+		startBlock := ent.GetBlockRange().StartBlock
+		if current == nil || current.BlockNum != startBlock {
+			if err := flush(); err != nil {
+				return err
+			}
+			current = &graphnode.ExportedEntities{BlockNum: startBlock, EntityName: tableName, Entities: graphnode.Map{}}
+		}
+		current.Entities[ent.GetID()] = ent
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("closing temporary file: %w", err)
+	}
+	return count, file.Close()
+}
+
+// streamAllDistinct streams the entities of the stores implementing
+// `storage.DistinctStreamer`, the others have them loaded then sorted.
+func streamAllDistinct(ctx context.Context, store storage.Store, model graphnode.Entity, blockNum uint64, handle func(ent graphnode.Entity) error) error {
+	if streamer, ok := store.(storage.DistinctStreamer); ok {
+		return streamer.StreamAllDistinct(ctx, model, blockNum, handle)
+	}
+
+	entities, err := store.LoadAllDistinct(ctx, model, blockNum)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entities[i].GetBlockRange().StartBlock < entities[j].GetBlockRange().StartBlock
+	})
+	for _, ent := range entities {
+		if err := handle(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableReader reads back the lines written by `dumpTable`, `line` is the current one, nil
+// once every line was read.
+type tableReader struct {
+	name     string
+	file     *os.File
+	gz       *gzip.Reader
+	reader   *bufio.Reader
+	line     []byte
+	blockNum uint64
+}
+
+func openTableReader(name, path string) (*tableReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening temporary file: %w", err)
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("opening temporary file: %w", err)
+	}
+
+	table := &tableReader{name: name, file: file, gz: gz, reader: bufio.NewReader(gz)}
+	if err := table.advance(); err != nil {
+		table.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+func (t *tableReader) advance() error {
+	line, err := t.reader.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		t.line = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %q entities: %w", t.name, err)
+	}
+
+	block := struct{ BlockNum uint64 }{}
+	if err := json.Unmarshal(line, &block); err != nil {
+		return fmt.Errorf("decoding %q entities: %w", t.name, err)
+	}
+	t.line, t.blockNum = line, block.BlockNum
+	return nil
+}
+
+func (t *tableReader) Close() error {
+	t.gz.Close()
+	return t.file.Close()
+}
+
+// Restore seeds `store`, which must be empty, with a snapshot written by `Dump`. Each
+// entity is saved at the block its version started at, so it gets back its original
+// `lower(block_range)`, left open-ended.
+func Restore(ctx context.Context, store storage.Store, registry *graphnode.Registry, r io.Reader, logger *zap.Logger) (*Header, error) {
+	cursor, err := store.LoadCursor(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading cursor: %w", err)
+	}
+	if cursor != "" {
+		return nil, fmt.Errorf("store is not empty, a cursor is already saved")
+	}
+	// The cursor is only saved at the end, a restore interrupted before left entities
+	for _, model := range registry.Entities() {
+		empty, err := tableEmpty(ctx, store, model)
+		if err != nil {
+			return nil, fmt.Errorf("checking table %q: %w", graphnode.GetTableName(model), err)
+		}
+		if !empty {
+			return nil, fmt.Errorf("store is not empty, table %q has entities, a previous restore may have been interrupted", graphnode.GetTableName(model))
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(gz))
+	header := &Header{}
+	if err := decoder.Decode(header); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Version < 1 || header.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected at most %d", header.Version, FormatVersion)
+	}
+
+	var blockNum uint64
+	updates := map[string]map[string]graphnode.Entity{}
+	saved := 0
+	save := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		// The cursor is only saved once everything is restored
+		if err := store.BatchSave(ctx, blockNum, "", time.Time{}, updates, ""); err != nil {
+			return fmt.Errorf("saving entities of block %d: %w", blockNum, err)
+		}
+		updates = map[string]map[string]graphnode.Entity{}
+		return nil
+	}
+
+	for {
+		exported := &graphnode.ExportedEntities{TypeGetter: registry, Legacy: header.Version < 2}
+		if err := decoder.Decode(exported); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("reading entities: %w", err)
+		}
+
+		if exported.BlockNum < blockNum {
+			return nil, fmt.Errorf("entities of block %d found after block %d, snapshot is not ordered", exported.BlockNum, blockNum)
+		}
+		if exported.BlockNum != blockNum {
+			if err := save(); err != nil {
+				return nil, err
+			}
+			blockNum = exported.BlockNum
+		}
+
+		table, found := updates[exported.EntityName]
+		if !found {
+			table = map[string]graphnode.Entity{}
+			updates[exported.EntityName] = table
+		}
+		for id, ent := range exported.Entities {
+			// A new version, not an update of the dumped one
+			ent.SetVID(0)
+			ent.SetBlockRange(nil)
+			table[id] = ent
+			saved++
+		}
+	}
+	if err := save(); err != nil {
+		return nil, err
+	}
+
+	if err := store.BatchSave(ctx, header.BlockNum, "", time.Time{}, nil, header.Cursor); err != nil {
+		return nil, fmt.Errorf("saving cursor: %w", err)
+	}
+
+	logger.Info("snapshot restored", zap.Uint64("block_num", header.BlockNum), zap.Int("entity_count", saved))
+	return header, nil
+}
+
+var errFound = errors.New("found")
+
+// tableEmpty tells whether the table of `model` has no current entity, as the ones saved
+// by a restore.
+func tableEmpty(ctx context.Context, store storage.Store, model graphnode.Entity) (bool, error) {
+	err := streamAllDistinct(ctx, store, model, math.MaxInt32, func(graphnode.Entity) error {
+		return errFound
+	})
+	if err == errFound {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func save(t *testing.T, store storage.Store, blockNum uint64, cursor string, entities map[string]graphnode.Entity) {
+	ctx := context.Background()
+	for id, ent := range entities {
+		if ent == nil {
+			continue
+		}
+		current := &storagetest.TestEntity{Base: graphnode.NewBase(id)}
+		require.NoError(t, store.Load(ctx, id, current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+	}
+	require.NoError(t, store.BatchSave(ctx, blockNum, "", time.Time{}, map[string]map[string]graphnode.Entity{"test_entity": entities}, cursor))
+}
+
+func versions(t *testing.T, store storage.Store, blockNum uint64) map[string]string {
+	entities, err := store.LoadAllDistinct(context.Background(), &storagetest.TestEntity{}, blockNum)
+	require.NoError(t, err)
+
+	out := map[string]string{}
+	for _, ent := range entities {
+		e := ent.(*storagetest.TestEntity)
+		out[e.ID] = e.Name + "/" + e.Amount.String() + "/" + e.BlockRange.String()
+	}
+	return out
+}
+
+func TestDumpRestore(t *testing.T) {
+	ctx := context.Background()
+	registry := storagetest.Registry()
+	source := memory.New(zap.NewNop(), registry)
+
+	save(t, source, 10, "c10", map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a1", 1), "b": storagetest.NewTestEntity("b", "b1", 1)})
+	save(t, source, 20, "c20", map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a2", 2), "c": storagetest.NewTestEntity("c", "c1", 1)})
+	save(t, source, 30, "c30", map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a3", 3), "b": nil})
+
+	buf := &bytes.Buffer{}
+	header, err := Dump(ctx, source, registry, 25, "c25", t.TempDir(), buf, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"test_entity": 3, "poi2$": 0}, header.Tables)
+
+	target := memory.New(zap.NewNop(), registry)
+	restored, err := Restore(ctx, target, registry, bytes.NewReader(buf.Bytes()), zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(25), restored.BlockNum)
+
+	expected := map[string]string{"a": "a2/2/[20,)", "b": "b1/1/[10,)", "c": "c1/1/[20,)"}
+	assert.Equal(t, expected, versions(t, target, 25))
+	assert.Equal(t, expected, versions(t, target, 1000))
+	assert.Equal(t, map[string]string{"b": "b1/1/[10,)"}, versions(t, target, 15))
+
+	cursor, err := target.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "c25", cursor)
+
+	_, err = Restore(ctx, target, registry, bytes.NewReader(buf.Bytes()), zap.NewNop())
+	assert.EqualError(t, err, "store is not empty, a cursor is already saved")
+}
+
+func TestRestore_Interrupted(t *testing.T) {
+	ctx := context.Background()
+	registry := storagetest.Registry()
+
+	// Entities saved by a restore that failed before saving the cursor
+	target := memory.New(zap.NewNop(), registry)
+	save(t, target, 10, "", map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a1", 1)})
+
+	buf := &bytes.Buffer{}
+	_, err := Dump(ctx, memory.New(zap.NewNop(), registry), registry, 10, "c10", t.TempDir(), buf, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = Restore(ctx, target, registry, bytes.NewReader(buf.Bytes()), zap.NewNop())
+	assert.EqualError(t, err, `store is not empty, table "test_entity" has entities, a previous restore may have been interrupted`)
+}
+
+func TestRestore_Version1(t *testing.T) {
+	amount, err := big.NewInt(7).GobEncode()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	_, err = fmt.Fprintf(gz, `{"Version": 1, "BlockNum": 10, "Cursor": "c10"}
+{"BlockNum": 10, "EntityName": "test_entity", "Entities": {"a": {"ID": "a", "Name": "a1", "Amount": %q}}}
+`, hex.EncodeToString(amount))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	registry := storagetest.Registry()
+	target := memory.New(zap.NewNop(), registry)
+	_, err = Restore(context.Background(), target, registry, buf, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a1/7/[10,)"}, versions(t, target, 10))
+}
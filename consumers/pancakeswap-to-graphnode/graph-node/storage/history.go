@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"context"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+)
+
+// HistoryLoader is implemented by the stores able to list every version of an entity.
+type HistoryLoader interface {
+	// LoadHistory returns the versions of the entity `id` of the table of `model`, still
+	// retained after pruning, ordered by the start of their block range. It returns no
+	// version when the entity never existed.
+	LoadHistory(ctx context.Context, id string, model graphnode.Entity) ([]graphnode.Entity, error)
+}
@@ -0,0 +1,14 @@
+package storage
+
+import "context"
+
+// LatestMirror is implemented by the stores able to maintain, next to a versioned entity
+// table, a table holding only the current version of each entity, keyed by id. Readers
+// needing the current state then do plain primary key lookups.
+type LatestMirror interface {
+	// EnableLatestTables maintains the mirror of `tables` from then on, every save updates
+	// it along with the versioned table. Missing mirrors are created and filled with the
+	// current versions. Enabled mirrors stay maintained by the later runs, enabling them
+	// again is not needed.
+	EnableLatestTables(ctx context.Context, tables []string) error
+}
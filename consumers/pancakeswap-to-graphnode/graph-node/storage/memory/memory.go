@@ -0,0 +1,395 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+// store keeps every entity version in memory, with the same versioning rules as the
+// Postgres store: each save closes the `block_range` of the previous version and inserts
+// a new open-ended version, a `nil` entity closes the latest version of its id.
+type store struct {
+	lock     sync.RWMutex
+	registry *graphnode.Registry
+	logger   *zap.Logger
+
+	// tables maps a table name to the versions of each id, ordered by vid
+	tables        map[string]map[string][]graphnode.Entity
+	lastVID       uint64
+	cursor        string
+	earliestBlock uint64
+	provenance    []*storage.Provenance
+}
+
+func New(logger *zap.Logger, registry *graphnode.Registry) *store {
+	return &store{
+		registry: registry,
+		logger:   logger,
+		tables:   map[string]map[string][]graphnode.Entity{},
+	}
+}
+
+func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string, blockTime time.Time, updates map[string]map[string]graphnode.Entity, cursor string) (err error) {
+	if ctx.Err() != nil {
+		return fmt.Errorf("block %d not saved: %w", blockNum, ctx.Err())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, tableName := range sortedTableNames(updates) {
+		if err := s.batchSave(blockNum, tableName, updates[tableName]); err != nil {
+			return fmt.Errorf("batch saving: %w", err)
+		}
+	}
+
+	s.cursor = cursor
+	return nil
+}
+
+func (s *store) batchSave(blockNum uint64, tableName string, entities map[string]graphnode.Entity) error {
+	if _, found := s.registry.GetType(tableName); !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	table := s.table(tableName)
+	ids := sortedIDs(entities)
+
+	// This loop is ONLY for updating the block ranges.
+	for _, id := range ids {
+		ent := entities[id]
+
+		var version graphnode.Entity
+		if ent == nil { // deleted
+			version = latestVersion(table[id])
+		} else if ent.GetBlockRange() != nil {
+			version = versionByVID(table[id], ent.GetVID())
+		}
+
+		if version == nil {
+			continue
+		}
+
+		version.SetBlockRange(&graphnode.BlockRange{
+			StartBlock: version.GetBlockRange().StartBlock,
+			EndBlock:   blockNum,
+		})
+		version.SetUpdatedBlockNum(blockNum)
+	}
+
+	for _, id := range ids {
+		ent := entities[id]
+		if ent == nil {
+			continue
+		}
+
+		s.lastVID++
+		ent.SetVID(s.lastVID)
+		ent.SetBlockRange(&graphnode.BlockRange{
+			StartBlock: blockNum,
+		})
+		ent.SetUpdatedBlockNum(blockNum)
+
+		if e, ok := ent.(graphnode.Sanitizable); ok {
+			e.Sanitize()
+		}
+
+		table[id] = append(table[id], cloneEntity(ent))
+	}
+
+	return nil
+}
+
+func (s *store) Load(ctx context.Context, id string, entity graphnode.Entity, blockNum uint64) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if blockNum < s.earliestBlock {
+		return storage.NewBlockPrunedError(blockNum, s.earliestBlock)
+	}
+
+	tableName := graphnode.GetTableName(entity)
+	for _, version := range s.tables[tableName][id] {
+		if version.GetBlockRange().Contains(blockNum) {
+			copyEntity(entity, version)
+			entity.SetExists(true)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *store) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64) (out []graphnode.Entity, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if blockNum < s.earliestBlock {
+		return nil, storage.NewBlockPrunedError(blockNum, s.earliestBlock)
+	}
+
+	tableName := graphnode.GetTableName(model)
+	for _, versions := range s.tables[tableName] {
+		for _, version := range versions {
+			if version.GetBlockRange().Contains(blockNum) {
+				ent := cloneEntity(version)
+				ent.SetExists(true)
+				out = append(out, ent)
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].GetVID() < out[j].GetVID()
+	})
+
+	return out, nil
+}
+
+func (s *store) LoadHistory(ctx context.Context, id string, model graphnode.Entity) (out []graphnode.Entity, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, version := range s.tables[graphnode.GetTableName(model)][id] {
+		ent := cloneEntity(version)
+		ent.SetExists(true)
+		out = append(out, ent)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].GetBlockRange().StartBlock < out[j].GetBlockRange().StartBlock
+	})
+
+	return out, nil
+}
+
+func (s *store) LoadCursor(ctx context.Context) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.cursor, nil
+}
+
+func (s *store) CleanDataAtBlock(ctx context.Context, blockNum uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	badBlockNum := blockNum + 1
+	for tableName, table := range s.tables {
+		deleted, updated := 0, 0
+		for id, versions := range table {
+			kept := versions[:0]
+			for _, version := range versions {
+				br := version.GetBlockRange()
+				if br.IsOpen() && version.GetUpdatedBlockNum() == badBlockNum {
+					deleted++
+					continue
+				}
+
+				if br.Contains(blockNum) && br.EndBlock == badBlockNum && version.GetUpdatedBlockNum() == badBlockNum {
+					version.SetBlockRange(&graphnode.BlockRange{StartBlock: br.StartBlock})
+					updated++
+				}
+				kept = append(kept, version)
+			}
+			setVersions(table, id, kept)
+		}
+
+		s.logger.Info("cleaned data at block", zap.String("table", tableName), zap.Uint64("bad_block_num", badBlockNum), zap.Int("deleted_rows", deleted), zap.Int("updated_rows", updated))
+	}
+
+	return nil
+}
+
+func (s *store) CleanUpFork(ctx context.Context, longestChainStartBlock uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for tableName, table := range s.tables {
+		deleted, updated := 0, 0
+		for id, versions := range table {
+			kept := versions[:0]
+			for _, version := range versions {
+				br := version.GetBlockRange()
+				updatedAt := version.GetUpdatedBlockNum()
+
+				if (updatedAt > longestChainStartBlock && !br.Contains(longestChainStartBlock)) ||
+					(updatedAt >= longestChainStartBlock && br.Contains(longestChainStartBlock) && br.StartBlock == longestChainStartBlock) {
+					deleted++
+					continue
+				}
+				kept = append(kept, version)
+			}
+
+			for _, version := range kept {
+				br := version.GetBlockRange()
+				if version.GetUpdatedBlockNum() >= longestChainStartBlock && (br.Contains(longestChainStartBlock) || (!br.IsOpen() && br.EndBlock == longestChainStartBlock)) {
+					version.SetBlockRange(&graphnode.BlockRange{StartBlock: br.StartBlock})
+					version.SetUpdatedBlockNum(br.StartBlock)
+					updated++
+				}
+			}
+			setVersions(table, id, kept)
+		}
+
+		s.logger.Info("cleaned fork", zap.String("table", tableName), zap.Uint64("new_head", longestChainStartBlock), zap.Int("deleted_rows", deleted), zap.Int("updated_rows", updated))
+	}
+
+	return nil
+}
+
+// PruneBelow deletes the versions closed at or before `earliestBlock`, tables and ids are
+// walked in order so that batches are deterministic.
+func (s *store) PruneBelow(ctx context.Context, earliestBlock uint64, batchSize int) (deleted int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if earliestBlock > s.earliestBlock {
+		s.earliestBlock = earliestBlock
+	}
+
+	tableNames := make([]string, 0, len(s.tables))
+	for tableName := range s.tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		table := s.tables[tableName]
+		ids := make([]string, 0, len(table))
+		for id := range table {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			kept := table[id][:0]
+			for _, version := range table[id] {
+				br := version.GetBlockRange()
+				if deleted < batchSize && !br.IsOpen() && br.EndBlock <= earliestBlock {
+					deleted++
+					continue
+				}
+				kept = append(kept, version)
+			}
+			setVersions(table, id, kept)
+
+			if deleted >= batchSize {
+				return deleted, nil
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *store) EarliestBlock(ctx context.Context) (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.earliestBlock, nil
+}
+
+func (s *store) LoadProvenance(ctx context.Context) ([]*storage.Provenance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]*storage.Provenance(nil), s.provenance...), nil
+}
+
+func (s *store) SaveProvenance(ctx context.Context, provenance *storage.Provenance) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.provenance = append(s.provenance, provenance)
+	return nil
+}
+
+func (s *store) Close() error { return nil }
+
+func (s *store) table(tableName string) map[string][]graphnode.Entity {
+	table, found := s.tables[tableName]
+	if !found {
+		table = map[string][]graphnode.Entity{}
+		s.tables[tableName] = table
+	}
+	return table
+}
+
+func setVersions(table map[string][]graphnode.Entity, id string, versions []graphnode.Entity) {
+	if len(versions) == 0 {
+		delete(table, id)
+		return
+	}
+	table[id] = versions
+}
+
+// latestVersion mimics `ORDER BY block_range DESC LIMIT 1`
+func latestVersion(versions []graphnode.Entity) (out graphnode.Entity) {
+	for _, version := range versions {
+		if out == nil || rangeGreater(version.GetBlockRange(), out.GetBlockRange()) {
+			out = version
+		}
+	}
+	return out
+}
+
+func rangeGreater(a, b *graphnode.BlockRange) bool {
+	if a.StartBlock != b.StartBlock {
+		return a.StartBlock > b.StartBlock
+	}
+	if a.IsOpen() || b.IsOpen() {
+		return a.IsOpen() && !b.IsOpen()
+	}
+	return a.EndBlock > b.EndBlock
+}
+
+func versionByVID(versions []graphnode.Entity, vid uint64) graphnode.Entity {
+	for _, version := range versions {
+		if version.GetVID() == vid {
+			return version
+		}
+	}
+	return nil
+}
+
+func cloneEntity(ent graphnode.Entity) graphnode.Entity {
+	clone := reflect.New(reflect.TypeOf(ent).Elem()).Interface().(graphnode.Entity)
+	copyEntity(clone, ent)
+	return clone
+}
+
+// copyEntity copies `src` into `dst`, the block range is copied too so that the stored
+// versions never share it with the caller's entities.
+func copyEntity(dst, src graphnode.Entity) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+	if br := src.GetBlockRange(); br != nil {
+		dst.SetBlockRange(&graphnode.BlockRange{StartBlock: br.StartBlock, EndBlock: br.EndBlock})
+	}
+}
+
+func sortedTableNames(updates map[string]map[string]graphnode.Entity) []string {
+	out := make([]string, 0, len(updates))
+	for tableName := range updates {
+		out = append(out, tableName)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedIDs(entities map[string]graphnode.Entity) []string {
+	out := make([]string, 0, len(entities))
+	for id := range entities {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
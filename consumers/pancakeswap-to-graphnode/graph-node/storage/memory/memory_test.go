@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+func TestStore_Conformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		return New(zap.NewNop(), storagetest.Registry())
+	})
+}
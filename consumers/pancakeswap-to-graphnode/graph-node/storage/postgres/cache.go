@@ -3,6 +3,7 @@ package postgres
 import (
 	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -17,13 +18,16 @@ func newEntityCache() *entityCache {
 	}
 }
 
+// entityCache is shared by the loads of the block being processed and the save of the
+// previous one, which run concurrently.
 type entityCache struct {
+	lock      sync.Mutex
 	entityMap map[string]map[string]graphnode.Entity
 
 	CacheAll bool
 }
 
-func (c entityCache) getTable(name string) map[string]graphnode.Entity {
+func (c *entityCache) getTable(name string) map[string]graphnode.Entity {
 	m := c.entityMap
 	table, found := m[name]
 	if found {
@@ -33,7 +37,10 @@ func (c entityCache) getTable(name string) map[string]graphnode.Entity {
 	return m[name]
 }
 
-func (c entityCache) GetEntity(tableName, id string, out graphnode.Entity) (found bool) {
+func (c *entityCache) GetEntity(tableName, id string, out graphnode.Entity) (found bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	table := c.getTable(tableName)
 	if e, found := table[id]; found {
 		cacheHits++
@@ -46,7 +53,10 @@ func (c entityCache) GetEntity(tableName, id string, out graphnode.Entity) (foun
 	return false
 }
 
-func (c entityCache) purgeCache(blockNum uint64, blockTime time.Time) {
+func (c *entityCache) purgeCache(blockNum uint64, blockTime time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	for _, rows := range c.entityMap {
 		for id, ent := range rows {
 			if purgeableEntity, ok := ent.(graphnode.Finalizable); ok {
@@ -58,13 +68,19 @@ func (c entityCache) purgeCache(blockNum uint64, blockTime time.Time) {
 	}
 }
 
-func (c entityCache) SetEntity(tableName string, entity graphnode.Entity) {
+func (c *entityCache) SetEntity(tableName string, entity graphnode.Entity) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	table := c.getTable(tableName)
 	id := entity.GetID()
 
 	table[id] = entity
 }
 
-func (c entityCache) Invalidate(tableName string, id string) {
+func (c *entityCache) Invalidate(tableName string, id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	delete(c.entityMap[tableName], id)
 }
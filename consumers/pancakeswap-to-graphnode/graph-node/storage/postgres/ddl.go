@@ -31,7 +31,10 @@ func InitiateSchema(ctx context.Context, db *sqlx.DB, subgraph *subgraph.Definit
 		return fmt.Errorf("launch err: %w", err)
 	}
 
-	return nil
+	return createStoreTables(schema, func(statement string) error {
+		_, err := db.ExecContext(ctx, statement)
+		return err
+	})
 }
 
 func CreateTables(ctx context.Context, db *sqlx.DB, subgraph *subgraph.Definition, schema string, logger *zap.Logger) error {
@@ -0,0 +1,278 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+type DeployOptions struct {
+	// SubgraphName is the name graph-node serves the deployment under, ex: `pancakeswap/exchange`
+	SubgraphName string
+	// DeploymentHash identifies the deployment, defaults to the hash of the manifest
+	DeploymentHash string
+	// Network defaults to the network of the first data source of the manifest
+	Network string
+	// Shard is the graph-node store shard holding the deployment, `primary` when not sharded
+	Shard string
+	// Partitioning lists the tables created partitioned by block, none when nil
+	Partitioning *Partitioning
+}
+
+// Deployment is a deployment registered in graph-node's metadata.
+type Deployment struct {
+	ID     int64
+	Hash   string
+	Schema string
+}
+
+type manifestHeader struct {
+	SpecVersion string `yaml:"specVersion"`
+	Description string `yaml:"description"`
+	Repository  string `yaml:"repository"`
+	Features    []string
+	DataSources []struct {
+		Network string
+		Source  struct {
+			StartBlock uint64 `yaml:"startBlock"`
+		}
+	} `yaml:"dataSources"`
+}
+
+// startBlock is the lowest start block of the data sources, the earliest block of the
+// deployment.
+func (m *manifestHeader) startBlock() uint64 {
+	var out uint64
+	for i, dataSource := range m.DataSources {
+		if i == 0 || dataSource.Source.StartBlock < out {
+			out = dataSource.Source.StartBlock
+		}
+	}
+	return out
+}
+
+// Deploy registers the subgraph in graph-node's metadata the way `graph deploy` does, without
+// assigning it to an index node: graph-node serves the data this loader writes but never
+// indexes it itself. The deployment schema is allocated by graph-node's `deployment_schemas`
+// table and created from the subgraph DDL, its earliest block is the lowest start block of
+// the manifest data sources. Everything is done in a single transaction.
+func Deploy(ctx context.Context, logger *zap.Logger, dsn string, def *subgraph.Definition, opts DeployOptions) (*Deployment, error) {
+	manifest := &manifestHeader{}
+	if err := yaml.Unmarshal([]byte(def.Manifest), manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	if opts.DeploymentHash == "" {
+		opts.DeploymentHash = manifestHash(def.Manifest)
+	}
+	if opts.Network == "" {
+		if len(manifest.DataSources) == 0 || manifest.DataSources[0].Network == "" {
+			return nil, fmt.Errorf("manifest has no data source network, a network is required")
+		}
+		opts.Network = manifest.DataSources[0].Network
+	}
+	if opts.Shard == "" {
+		opts.Shard = "primary"
+	}
+	if manifest.Features == nil {
+		manifest.Features = []string{}
+	}
+
+	def, err := opts.Partitioning.definition(def)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := dbFromDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin deploy transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing string
+	err = tx.GetContext(ctx, &existing, `SELECT name FROM subgraphs.deployment_schemas WHERE subgraph = $1`, opts.DeploymentHash)
+	if err == nil {
+		return nil, fmt.Errorf("deployment %s already exists in schema %s", opts.DeploymentHash, existing)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("looking up deployment %s: %w", opts.DeploymentHash, err)
+	}
+
+	deployment := &Deployment{Hash: opts.DeploymentHash}
+	err = tx.QueryRowxContext(ctx, `INSERT INTO subgraphs.deployment_schemas (subgraph, shard, version, network, active) VALUES ($1, $2, 'relational', $3, true) RETURNING id, name`,
+		opts.DeploymentHash, opts.Shard, opts.Network).Scan(&deployment.ID, &deployment.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("allocating deployment schema: %w", err)
+	}
+	logger.Info("allocated deployment schema", zap.String("schema", deployment.Schema), zap.Int64("deployment_id", deployment.ID))
+
+	if err := createSchema(ctx, tx, def, deployment.Schema); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO subgraphs.subgraph_deployment (id, deployment, failed, synced, health, entity_count, earliest_block_number) VALUES ($1, $2, false, false, 'healthy', 0, $3)`,
+		deployment.ID, deployment.Hash, manifest.startBlock())
+	if err != nil {
+		return nil, fmt.Errorf("inserting subgraph_deployment: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO subgraphs.subgraph_manifest (id, spec_version, description, repository, features, schema) VALUES ($1, $2, $3, $4, $5, $6)`,
+		deployment.ID, manifest.SpecVersion, manifest.Description, manifest.Repository, "{"+strings.Join(manifest.Features, ",")+"}", def.GraphQLSchema)
+	if err != nil {
+		return nil, fmt.Errorf("inserting subgraph_manifest: %w", err)
+	}
+
+	if err := registerSubgraphVersion(ctx, tx, opts.SubgraphName, deployment.Hash); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit deploy transaction: %w", err)
+	}
+	return deployment, nil
+}
+
+// deploymentRegistered tells whether the store's deployment is registered in graph-node's
+// metadata, as done by `Deploy`. The store then keeps the deployment head up to date,
+// otherwise graph-node does not know about the data and the head is not tracked.
+func (s *store) deploymentRegistered(ctx context.Context) (bool, error) {
+	if s.subgraphDeploymentID == "" {
+		return false, nil
+	}
+
+	var metadata sql.NullString
+	if err := s.db.GetContext(ctx, &metadata, `SELECT to_regclass('subgraphs.subgraph_deployment')::text`); err != nil {
+		return false, fmt.Errorf("looking up graph-node metadata: %w", err)
+	}
+
+	registered := false
+	if metadata.Valid {
+		err := s.db.GetContext(ctx, &registered, `SELECT exists(SELECT 1 FROM subgraphs.subgraph_deployment WHERE deployment = $1)`, s.subgraphDeploymentID)
+		if err != nil {
+			return false, fmt.Errorf("looking up deployment %q: %w", s.subgraphDeploymentID, err)
+		}
+	}
+
+	if !registered {
+		s.logger.Warn("deployment not registered in graph-node metadata, its head will not be tracked, run `exchange deploy` to register it",
+			zap.String("deployment", s.subgraphDeploymentID))
+	}
+	return registered, nil
+}
+
+// createSchema creates the schema, its tables and indexes from the subgraph DDL, and the
+// store tables.
+func createSchema(ctx context.Context, tx *sqlx.Tx, def *subgraph.Definition, schema string) error {
+	exec := func(statement string) error {
+		_, err := tx.ExecContext(ctx, strings.ReplaceAll(statement, "%%SCHEMA%%", schema))
+		if err != nil {
+			return fmt.Errorf("executing statement %s: %w", statement, err)
+		}
+		return nil
+	}
+
+	if err := def.DDL.InitiateSchema(exec); err != nil {
+		return fmt.Errorf("initiating schema %s: %w", schema, err)
+	}
+	if err := createStoreTables(schema, exec); err != nil {
+		return err
+	}
+	if err := def.DDL.CreateTables(func(_ string, statement string) error { return exec(statement) }); err != nil {
+		return fmt.Errorf("creating tables in %s: %w", schema, err)
+	}
+	if err := def.DDL.CreateIndexes(func(_ string, statement string) error { return exec(statement) }); err != nil {
+		return fmt.Errorf("creating indexes in %s: %w", schema, err)
+	}
+	return nil
+}
+
+// registerSubgraphVersion creates the subgraph `name` when missing and makes the deployment
+// its current version.
+func registerSubgraphVersion(ctx context.Context, tx *sqlx.Tx, name, deploymentHash string) error {
+	createdAt := time.Now().Unix()
+
+	var subgraphID string
+	err := tx.GetContext(ctx, &subgraphID, `SELECT id FROM subgraphs.subgraph WHERE name = $1`, name)
+	if err == sql.ErrNoRows {
+		subgraphID, err = entityID()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO subgraphs.subgraph (id, name, created_at, block_range) VALUES ($1, $2, $3, '[0,)')`, subgraphID, name, createdAt)
+		if err != nil {
+			return fmt.Errorf("inserting subgraph %q: %w", name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("looking up subgraph %q: %w", name, err)
+	}
+
+	versionID, err := entityID()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO subgraphs.subgraph_version (id, subgraph, deployment, created_at, block_range) VALUES ($1, $2, $3, $4, '[0,)')`, versionID, subgraphID, deploymentHash, createdAt)
+	if err != nil {
+		return fmt.Errorf("inserting subgraph_version: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE subgraphs.subgraph SET current_version = $1 WHERE id = $2`, versionID, subgraphID)
+	if err != nil {
+		return fmt.Errorf("setting current version of subgraph %q: %w", name, err)
+	}
+	return nil
+}
+
+// entityID returns a random id, like the ones graph-node generates for its metadata entities.
+func entityID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// manifestHash returns the sha2-256 multihash of the manifest, base58 encoded like an IPFS
+// hash (`Qm...`), which is the format graph-node expects for deployment hashes.
+func manifestHash(manifest string) string {
+	digest := sha256.Sum256([]byte(manifest))
+	multihash := append([]byte{0x12, 0x20}, digest[:]...)
+
+	n := new(big.Int).SetBytes(multihash)
+	base, mod := big.NewInt(58), new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.QuoRem(n, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range multihash {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
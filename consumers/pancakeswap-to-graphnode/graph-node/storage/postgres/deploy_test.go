@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestManifestHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		expected string
+	}{
+		{"empty", "", "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"},
+		{"manifest", "specVersion: 0.0.2\n", "QmRuJUXB26XCsyAk4M8csuhHgZmJtTQdXtRMWY4HZpPbuv"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, manifestHash(test.manifest))
+		})
+	}
+}
+
+func TestManifestStartBlock(t *testing.T) {
+	manifest := &manifestHeader{}
+	assert.Equal(t, uint64(0), manifest.startBlock())
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+dataSources:
+  - source:
+      startBlock: 586851
+  - source:
+      startBlock: 600
+`), manifest))
+	assert.Equal(t, uint64(600), manifest.startBlock())
+}
+
+// metadataDDL creates the graph-node metadata tables `Deploy` writes to, with the columns
+// it uses, when the test database does not have them.
+const metadataDDL = `
+create schema if not exists subgraphs;
+create table if not exists subgraphs.deployment_schemas (id serial primary key, subgraph text not null, name text not null default 'sgd' || currval('subgraphs.deployment_schemas_id_seq'), shard text not null, version text not null, network text not null, active boolean not null, created_at timestamptz not null default now());
+create table if not exists subgraphs.subgraph_deployment (id integer primary key, deployment text not null unique, failed boolean not null, synced boolean not null, health text not null, entity_count numeric not null, earliest_block_number integer not null default 0, latest_ethereum_block_number numeric, latest_ethereum_block_hash bytea);
+create table if not exists subgraphs.subgraph_manifest (id integer primary key, spec_version text not null, description text, repository text, features text[] not null, schema text not null);
+create table if not exists subgraphs.subgraph (id text primary key, name text not null unique, current_version text, pending_version text, created_at numeric not null, vid bigserial, block_range int4range not null);
+create table if not exists subgraphs.subgraph_version (id text primary key, subgraph text not null, deployment text not null, created_at numeric not null, vid bigserial, block_range int4range not null);
+`
+
+type deployTestDDL struct {
+	latestTestDDL
+}
+
+func (deployTestDDL) InitiateSchema(handleStatement func(statement string) error) error {
+	return handleStatement(`create schema if not exists %%SCHEMA%%;`)
+}
+
+// TestDeploy checks the rows `Deploy` writes to graph-node's metadata and the schema it
+// creates.
+func TestDeploy(t *testing.T) {
+	dsn := testDSN(t)
+	ctx := context.Background()
+
+	db, err := dbFromDSN(dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(metadataDDL)
+	require.NoError(t, err)
+
+	suffix := time.Now().UnixNano()
+	def := &subgraph.Definition{
+		PackageName: "deploy",
+		Entities:    storagetest.Registry(),
+		DDL:         deployTestDDL{},
+		Manifest: `specVersion: 0.0.4
+description: Deploy test
+repository: https://github.com/streamingfast/substreams-playground
+features:
+  - grafting
+dataSources:
+  - network: bsc
+    source:
+      startBlock: 6809737
+  - network: bsc
+    source:
+      startBlock: 586851
+`,
+		GraphQLSchema: "type TestEntity @entity { id: ID! }",
+	}
+	opts := DeployOptions{SubgraphName: fmt.Sprintf("test/deploy-%d", suffix), DeploymentHash: fmt.Sprintf("QmDeploy%d", suffix)}
+
+	deployment, err := Deploy(ctx, zap.NewNop(), dsn, def, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", deployment.Schema))
+		_, _ = db.Exec(`DELETE FROM subgraphs.subgraph_version WHERE deployment = $1`, deployment.Hash)
+		_, _ = db.Exec(`DELETE FROM subgraphs.subgraph WHERE name = $1`, opts.SubgraphName)
+		_, _ = db.Exec(`DELETE FROM subgraphs.subgraph_manifest WHERE id = $1`, deployment.ID)
+		_, _ = db.Exec(`DELETE FROM subgraphs.subgraph_deployment WHERE id = $1`, deployment.ID)
+		_, _ = db.Exec(`DELETE FROM subgraphs.deployment_schemas WHERE id = $1`, deployment.ID)
+	})
+	assert.Equal(t, opts.DeploymentHash, deployment.Hash)
+
+	row := func(query string, args ...interface{}) map[string]interface{} {
+		out := map[string]interface{}{}
+		require.NoError(t, db.QueryRowx(query, args...).MapScan(out))
+		return out
+	}
+
+	assert.Equal(t, map[string]interface{}{"subgraph": opts.DeploymentHash, "name": deployment.Schema, "shard": "primary", "version": "relational", "network": "bsc", "active": true},
+		row(`SELECT subgraph, name, shard, version::text AS version, network, active FROM subgraphs.deployment_schemas WHERE id = $1`, deployment.ID))
+	assert.Equal(t, map[string]interface{}{"deployment": opts.DeploymentHash, "failed": false, "synced": false, "health": "healthy", "earliest_block_number": int64(586851)},
+		row(`SELECT deployment, failed, synced, health::text AS health, earliest_block_number::bigint AS earliest_block_number FROM subgraphs.subgraph_deployment WHERE id = $1`, deployment.ID))
+	assert.Equal(t, map[string]interface{}{"spec_version": "0.0.4", "description": "Deploy test", "repository": "https://github.com/streamingfast/substreams-playground", "features": "{grafting}", "schema": def.GraphQLSchema},
+		row(`SELECT spec_version, description, repository, features::text AS features, schema FROM subgraphs.subgraph_manifest WHERE id = $1`, deployment.ID))
+
+	subgraphRow := row(`SELECT id, current_version FROM subgraphs.subgraph WHERE name = $1`, opts.SubgraphName)
+	assert.Equal(t, map[string]interface{}{"subgraph": subgraphRow["id"], "deployment": opts.DeploymentHash},
+		row(`SELECT subgraph, deployment FROM subgraphs.subgraph_version WHERE id = $1`, subgraphRow["current_version"]))
+
+	// The schema is created from the DDL, along with the store tables
+	assertTables(t, db, deployment.Schema, "latest_tables", "partitioning", "provenance", "pruning", "test_entity")
+
+	_, err = Deploy(ctx, zap.NewNop(), dsn, def, opts)
+	assert.EqualError(t, err, fmt.Sprintf("deployment %s already exists in schema %s", opts.DeploymentHash, deployment.Schema))
+}
+
+func assertTables(t *testing.T, db *sqlx.DB, schema string, expected ...string) {
+	t.Helper()
+	var tables []string
+	require.NoError(t, db.Select(&tables, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 ORDER BY table_name`, schema))
+	assert.Equal(t, expected, tables)
+}
@@ -0,0 +1,266 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+)
+
+// FindEntities implements `query.Finder`: the filters, the ordering and the page are
+// turned into SQL, only the selected entities are read. Values format and compare as
+// `query.Find` does in memory, text in byte order and numbers by value.
+func (s *store) FindEntities(ctx context.Context, model graphnode.Entity, blockNum uint64, opts *query.ListOptions) (out []graphnode.Entity, err error) {
+	if err := s.checkNotPruned(blockNum); err != nil {
+		return nil, err
+	}
+
+	tableName := graphnode.GetTableName(model)
+	sqlQuery, args, err := s.findQuery(reflect.TypeOf(model).Elem(), tableName, blockNum, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	modelsPtr := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+	if err := s.db.SelectContext(ctx, modelsPtr.Interface(), sqlQuery, args...); err != nil {
+		return nil, err
+	}
+
+	models := modelsPtr.Elem()
+	for i := 0; i < models.Len(); i++ {
+		ent := models.Index(i).Interface().(graphnode.Entity)
+		ent.SetExists(true)
+		out = append(out, ent)
+	}
+	return out, nil
+}
+
+func (s *store) findQuery(entityType reflect.Type, tableName string, blockNum uint64, opts *query.ListOptions) (string, []interface{}, error) {
+	b := &findBuilder{columns: map[string]*findColumn{}}
+	for _, field := range graphnode.DBFields(entityType) {
+		fieldType, _ := entityType.FieldByName(field.Name)
+		b.columns[field.ColumnName] = newFindColumn(s.columnExpr(tableName, field.ColumnName), fieldType.Type)
+	}
+
+	conditions := []string{s.containsBlock(tableName, fmt.Sprint(blockNum))}
+	for _, filter := range opts.Filters {
+		condition, err := b.condition(filter)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	order := `id COLLATE "C"`
+	if opts.OrderBy != nil {
+		column, found := b.columns[opts.OrderBy.Column]
+		if !found {
+			return "", nil, fmt.Errorf("%w %q in table %q", query.ErrUnknownColumn, opts.OrderBy.Column, tableName)
+		}
+		direction := "ASC NULLS FIRST"
+		if opts.OrderBy.Descending {
+			direction = "DESC NULLS LAST"
+		}
+		order = fmt.Sprintf("%s %s, %s", column.orderExpr(), direction, order)
+	}
+
+	sqlQuery := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s ORDER BY %s", s.selectColumns(tableName), s.schemaName, tableName, strings.Join(conditions, " AND "), order)
+	if opts.First > 0 {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", opts.First)
+	}
+	if opts.Skip > 0 {
+		sqlQuery += fmt.Sprintf(" OFFSET %d", opts.Skip)
+	}
+	return sqlQuery, b.args, nil
+}
+
+// columnExpr is the expression reading `column`, immutable tables store the block of
+// their single version in `block$` only.
+func (s *store) columnExpr(tableName, column string) string {
+	if s.isImmutable(tableName) {
+		switch column {
+		case "block_range":
+			return fmt.Sprintf("int4range(%q, NULL)", graphnode.BlockColumn)
+		case "_updated_block_number":
+			return fmt.Sprintf("%q", graphnode.BlockColumn)
+		}
+	}
+	return fmt.Sprintf("%q", column)
+}
+
+type findColumnKind int
+
+const (
+	textColumn findColumnKind = iota
+	integerColumn
+	decimalColumn
+	boolColumn
+	bytesColumn
+	arrayColumn
+)
+
+type findColumn struct {
+	expr string
+	kind findColumnKind
+}
+
+func newFindColumn(expr string, fieldType reflect.Type) *findColumn {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	column := &findColumn{expr: expr}
+	switch fieldType {
+	case reflect.TypeOf(graphnode.Int{}):
+		column.kind = integerColumn
+	case reflect.TypeOf(graphnode.Float{}):
+		column.kind = decimalColumn
+	case reflect.TypeOf(graphnode.Bytes{}):
+		column.kind = bytesColumn
+	case reflect.TypeOf(graphnode.LocalStringArray{}):
+		column.kind = arrayColumn
+	default:
+		switch fieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			column.kind = integerColumn
+		case reflect.Bool:
+			column.kind = boolColumn
+		}
+	}
+	return column
+}
+
+func (c *findColumn) numeric() bool {
+	return c.kind == integerColumn || c.kind == decimalColumn
+}
+
+// text is the value as `graphnode.FormatField` formats it, `null` when NULL. Nil bytes and
+// lists format empty as they do in memory.
+func (c *findColumn) text() string {
+	switch c.kind {
+	case bytesColumn:
+		return fmt.Sprintf(`'0x' || coalesce(encode(%s, 'hex'), '')`, c.expr)
+	case arrayColumn:
+		return fmt.Sprintf(`'[' || coalesce(array_to_string(%s, ' '), '') || ']'`, c.expr)
+	}
+	return fmt.Sprintf(`coalesce(%s::text, 'null')`, c.expr)
+}
+
+func (c *findColumn) orderExpr() string {
+	switch {
+	case c.numeric(), c.kind == boolColumn:
+		return c.expr
+	case c.kind == bytesColumn, c.kind == arrayColumn:
+		return c.text() + ` COLLATE "C"`
+	}
+	return c.expr + `::text COLLATE "C"`
+}
+
+// parse checks that `value` is a number of the type of the column.
+func (c *findColumn) parse(value string) error {
+	if c.kind == decimalColumn {
+		_, err := graphnode.ParseBigDecimal(value)
+		return err
+	}
+	if _, ok := new(big.Int).SetString(value, 10); !ok {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	return nil
+}
+
+type findBuilder struct {
+	columns map[string]*findColumn
+	args    []interface{}
+}
+
+func (b *findBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *findBuilder) condition(filter *query.Filter) (string, error) {
+	column, found := b.columns[filter.Column]
+	if !found {
+		return "", fmt.Errorf("%w %q", query.ErrUnknownColumn, filter.Column)
+	}
+
+	switch filter.Operator {
+	case query.OperatorEqual:
+		return b.in(column, []string{filter.Value}), nil
+	case query.OperatorNot:
+		return fmt.Sprintf("NOT %s", b.in(column, []string{filter.Value})), nil
+	case query.OperatorIn:
+		return b.in(column, filter.Values), nil
+	case query.OperatorNotIn:
+		return fmt.Sprintf("NOT %s", b.in(column, filter.Values)), nil
+	}
+
+	// The other operators never match a nil value
+	notNull := fmt.Sprintf("%s <> 'null'", column.text())
+	if column.numeric() {
+		notNull = fmt.Sprintf("%s IS NOT NULL", column.expr)
+	}
+
+	var condition string
+	switch filter.Operator {
+	case query.OperatorContains, query.OperatorNotContains:
+		if column.kind == arrayColumn {
+			condition = fmt.Sprintf("coalesce(%s, '{}') @> %s::text[]", column.expr, b.arg(pq.Array(filter.Values)))
+		} else {
+			condition = fmt.Sprintf("strpos(%s, %s) > 0", column.text(), b.arg(filter.Value))
+		}
+		if filter.Operator == query.OperatorNotContains {
+			condition = "NOT " + condition
+		}
+	case query.OperatorStartsWith:
+		value := b.arg(filter.Value)
+		condition = fmt.Sprintf("left(%s, char_length(%s)) = %s", column.text(), value, value)
+	case query.OperatorEndsWith:
+		value := b.arg(filter.Value)
+		condition = fmt.Sprintf("right(%s, char_length(%s)) = %s", column.text(), value, value)
+	default:
+		operator := map[string]string{query.OperatorGt: ">", query.OperatorGte: ">=", query.OperatorLt: "<", query.OperatorLte: "<="}[filter.Operator]
+		if operator == "" {
+			return "", fmt.Errorf("%w %q on column %q", query.ErrInvalidFilter, filter.Operator, filter.Column)
+		}
+		if column.numeric() {
+			if err := column.parse(filter.Value); err != nil {
+				return "", fmt.Errorf("%w on column %q: %s", query.ErrInvalidFilter, filter.Column, err)
+			}
+			condition = fmt.Sprintf("%s %s %s::numeric", column.expr, operator, b.arg(filter.Value))
+		} else {
+			condition = fmt.Sprintf(`%s COLLATE "C" %s %s`, column.text(), operator, b.arg(filter.Value))
+		}
+	}
+	return fmt.Sprintf("(%s AND %s)", notNull, condition), nil
+}
+
+// in is the condition matching the values formatting to one of `values`, numbers match by
+// value and `null` matches NULL. It is never NULL itself, so it can be negated.
+func (b *findBuilder) in(column *findColumn, values []string) string {
+	if !column.numeric() {
+		return fmt.Sprintf("(%s = ANY(%s))", column.text(), b.arg(pq.Array(values)))
+	}
+
+	var numbers []string
+	matchNull := false
+	for _, value := range values {
+		if value == "null" {
+			matchNull = true
+		} else if column.parse(value) == nil {
+			numbers = append(numbers, value)
+		}
+	}
+
+	condition := fmt.Sprintf("coalesce(%s = ANY(%s::numeric[]), false)", column.expr, b.arg(pq.Array(numbers)))
+	if matchNull {
+		condition = fmt.Sprintf("(%s OR %s IS NULL)", condition, column.expr)
+	}
+	return condition
+}
@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/query"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/memory"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_FindQuery(t *testing.T) {
+	s := &store{schemaName: "sgd1"}
+	opts := &query.ListOptions{
+		Filters: []*query.Filter{
+			{Column: "amount", Operator: query.OperatorGte, Value: "10"},
+			{Column: "name", Operator: query.OperatorStartsWith, Value: "ap"},
+		},
+		OrderBy: &query.Order{Column: "amount", Descending: true},
+		First:   10,
+		Skip:    20,
+	}
+
+	sqlQuery, args, err := s.findQuery(reflect.TypeOf(storagetest.TestEntity{}), "test_entity", 42, opts)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM sgd1.test_entity WHERE block_range @> 42`+
+		` AND ("amount" IS NOT NULL AND "amount" >= $1::numeric)`+
+		` AND (coalesce("name"::text, 'null') <> 'null' AND left(coalesce("name"::text, 'null'), char_length($2)) = $2)`+
+		` ORDER BY "amount" DESC NULLS LAST, id COLLATE "C" LIMIT 10 OFFSET 20`, sqlQuery)
+	assert.Equal(t, []interface{}{"10", "ap"}, args)
+
+	_, _, err = s.findQuery(reflect.TypeOf(storagetest.TestEntity{}), "test_entity", 42, &query.ListOptions{
+		Filters: []*query.Filter{{Column: "amount", Operator: query.OperatorLt, Value: "ten"}},
+	})
+	assert.ErrorIs(t, err, query.ErrInvalidFilter)
+}
+
+// TestStore_FindEntities checks that the pages found in SQL are the ones `query.Find`
+// selects in memory.
+func TestStore_FindEntities(t *testing.T) {
+	s := newTestStore(t, testDSN(t))
+	mem := memory.New(zap.NewNop(), storagetest.Registry())
+
+	for _, store := range []storage.Store{s, mem} {
+		save := func(blockNum uint64, entities ...*storagetest.TestEntity) {
+			table := map[string]graphnode.Entity{}
+			for _, ent := range entities {
+				current := &storagetest.TestEntity{Base: graphnode.NewBase(ent.ID)}
+				require.NoError(t, store.Load(context.Background(), ent.ID, current, blockNum))
+				if current.Exists() {
+					ent.SetVID(current.GetVID())
+					ent.SetBlockRange(current.GetBlockRange())
+				}
+				table[ent.ID] = ent
+			}
+			updates := map[string]map[string]graphnode.Entity{"test_entity": table}
+			require.NoError(t, store.BatchSave(context.Background(), blockNum, "", time.Unix(int64(blockNum), 0), updates, "cursor"))
+		}
+
+		save(10, storagetest.NewTestEntity("a", "apple", 3), storagetest.NewTestEntity("b", "banana", 10), storagetest.NewTestEntity("c", "cherry", 2))
+		save(20, storagetest.NewTestEntity("d", "date", 10), storagetest.NewTestEntity("a", "apricot", 3), storagetest.NewTestEntity("e", "Elderberry", 100))
+	}
+
+	filter := func(column, operator, value string, values ...string) []*query.Filter {
+		return []*query.Filter{{Column: column, Operator: operator, Value: value, Values: values}}
+	}
+	tests := []struct {
+		name     string
+		blockNum uint64
+		opts     *query.ListOptions
+		expected []string
+	}{
+		{"all at 15", 15, &query.ListOptions{}, []string{"a:apple", "b:banana", "c:cherry"}},
+		{"all at 20", 20, &query.ListOptions{}, []string{"a:apricot", "b:banana", "c:cherry", "d:date", "e:Elderberry"}},
+		{"amount gt", 20, &query.ListOptions{Filters: filter("amount", query.OperatorGt, "9")}, []string{"b:banana", "d:date", "e:Elderberry"}},
+		{"amount equal", 20, &query.ListOptions{Filters: filter("amount", query.OperatorEqual, "10")}, []string{"b:banana", "d:date"}},
+		{"amount not in", 20, &query.ListOptions{Filters: filter("amount", query.OperatorNotIn, "", "3", "10", "null")}, []string{"c:cherry", "e:Elderberry"}},
+		{"name in", 20, &query.ListOptions{Filters: filter("name", query.OperatorIn, "", "date", "apple")}, []string{"d:date"}},
+		{"name not", 20, &query.ListOptions{Filters: filter("name", query.OperatorNot, "date")}, []string{"a:apricot", "b:banana", "c:cherry", "e:Elderberry"}},
+		{"name contains", 20, &query.ListOptions{Filters: filter("name", query.OperatorContains, "rr")}, []string{"c:cherry", "e:Elderberry"}},
+		{"name not contains", 20, &query.ListOptions{Filters: filter("name", query.OperatorNotContains, "a")}, []string{"c:cherry", "e:Elderberry"}},
+		{"name ends with", 20, &query.ListOptions{Filters: filter("name", query.OperatorEndsWith, "ot")}, []string{"a:apricot"}},
+		{"name lt in byte order", 20, &query.ListOptions{Filters: filter("name", query.OperatorLt, "b")}, []string{"a:apricot", "e:Elderberry"}},
+		{"order by name", 20, &query.ListOptions{OrderBy: &query.Order{Column: "name"}}, []string{"e:Elderberry", "a:apricot", "b:banana", "c:cherry", "d:date"}},
+		{"order by amount desc", 20, &query.ListOptions{OrderBy: &query.Order{Column: "amount", Descending: true}, Skip: 1, First: 3}, []string{"b:banana", "d:date", "a:apricot"}},
+		{"skip past the end", 20, &query.ListOptions{Skip: 5}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, store := range []storage.Store{s, mem} {
+				entities, err := query.Find(context.Background(), store, storagetest.Registry(), "test_entity", test.blockNum, test.opts)
+				require.NoError(t, err)
+
+				var found []string
+				for _, ent := range entities {
+					found = append(found, ent.GetID()+":"+ent.(*storagetest.TestEntity).Name)
+				}
+				assert.Equal(t, test.expected, found)
+			}
+		})
+	}
+}
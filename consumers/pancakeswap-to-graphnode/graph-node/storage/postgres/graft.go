@@ -0,0 +1,288 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/streamingfast/bstream"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+type GraftOptions struct {
+	// SourceSchema is the schema of the deployment the data is copied from
+	SourceSchema string
+	// TargetSchema is created from the subgraph DDL when it does not exist, its tables must be empty otherwise
+	TargetSchema string
+	// Block is the last block copied, the target resumes at Block + 1
+	Block uint64
+	// BlockID is the hash of Block, it defaults to the block of the source cursor when it is at Block
+	BlockID string
+	// Partitioning lists the tables created partitioned by block when the target schema is
+	// created, none when nil
+	Partitioning *Partitioning
+}
+
+// Graft bootstraps the target schema with the data of the source schema as of `opts.Block`,
+// so a deployment fixed from that block onward does not have to be loaded from its start
+// block again. Every version valid up to the block is copied with its `vid`, versions closed
+// after it are open again, the `vid` sequences continue those of the source and the target
+// cursor is set at the block. Everything is done in a single transaction.
+func Graft(ctx context.Context, logger *zap.Logger, dsn string, def *subgraph.Definition, opts GraftOptions) (cursor string, err error) {
+	if opts.SourceSchema == opts.TargetSchema {
+		return "", fmt.Errorf("source and target schema are both %q", opts.SourceSchema)
+	}
+	if opts.Block == 0 {
+		return "", fmt.Errorf("graft block is required")
+	}
+
+	def, err = opts.Partitioning.definition(def)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := dbFromDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("creating database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin graft transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	blockID, err := graftBlockID(ctx, tx, opts)
+	if err != nil {
+		return "", err
+	}
+
+	earliestBlock, err := graftEarliestBlock(ctx, tx, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var targetTables int
+	if err := tx.GetContext(ctx, &targetTables, `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1`, opts.TargetSchema); err != nil {
+		return "", fmt.Errorf("listing tables of schema %q: %w", opts.TargetSchema, err)
+	}
+	if targetTables == 0 {
+		logger.Info("creating target schema", zap.String("schema", opts.TargetSchema))
+		if err := createSchema(ctx, tx, def, opts.TargetSchema); err != nil {
+			return "", err
+		}
+	}
+
+	// The partitions of the copied versions must exist before the copy
+	if err := createPartitionsUpTo(ctx, tx, opts.TargetSchema, def.Entities, opts.Block); err != nil {
+		return "", err
+	}
+
+	columns, err := graftColumns(ctx, tx, opts.SourceSchema, opts.TargetSchema)
+	if err != nil {
+		return "", err
+	}
+
+	tables := make([]string, 0, def.Entities.Len())
+	for table := range def.Entities.Data() {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if len(columns[table]) == 0 {
+			return "", fmt.Errorf("table %s does not exist in both schemas %q and %q", table, opts.SourceSchema, opts.TargetSchema)
+		}
+
+		var hasRows bool
+		if err := tx.GetContext(ctx, &hasRows, fmt.Sprintf(`SELECT exists(SELECT 1 FROM %s.%s)`, opts.TargetSchema, table)); err != nil {
+			return "", fmt.Errorf("checking table %s of target: %w", table, err)
+		}
+		if hasRows {
+			return "", fmt.Errorf("table %s of target schema %q is not empty", table, opts.TargetSchema)
+		}
+
+		res, err := tx.ExecContext(ctx, graftStatement(opts.SourceSchema, opts.TargetSchema, table, columns[table], opts.Block))
+		if err != nil {
+			return "", fmt.Errorf("copying table %s: %w", table, err)
+		}
+		copied, err := res.RowsAffected()
+		if err != nil {
+			return "", fmt.Errorf("copying table %s: %w", table, err)
+		}
+
+		if err := graftSequence(ctx, tx, opts.SourceSchema, opts.TargetSchema, table); err != nil {
+			return "", err
+		}
+		logger.Info("grafted table", zap.String("table", table), zap.Int64("versions", copied))
+	}
+
+	if earliestBlock > 0 {
+		if _, err := tx.ExecContext(ctx, strings.ReplaceAll(storeTables["pruning"], "%%SCHEMA%%", opts.TargetSchema)); err != nil {
+			return "", fmt.Errorf("creating pruning table: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s.pruning (id, earliest_block) VALUES (1, $1)`, opts.TargetSchema), earliestBlock); err != nil {
+			return "", fmt.Errorf("saving earliest block: %w", err)
+		}
+	}
+
+	cursor = graftCursor(opts.Block, blockID)
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.cursor (id integer PRIMARY KEY, cursor text)`, opts.TargetSchema)); err != nil {
+		return "", fmt.Errorf("creating cursor table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s.cursor (id, cursor) VALUES (1, $1)`, opts.TargetSchema), cursor); err != nil {
+		return "", fmt.Errorf("saving cursor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit graft transaction: %w", err)
+	}
+	return cursor, nil
+}
+
+// graftBlockID returns the hash of the graft block, taken from the source cursor when the
+// options do not have it. The source must have been loaded up to the graft block.
+func graftBlockID(ctx context.Context, tx *sqlx.Tx, opts GraftOptions) (string, error) {
+	var opaque string
+	err := tx.GetContext(ctx, &opaque, fmt.Sprintf(`SELECT cursor FROM %s.cursor WHERE id = 1`, opts.SourceSchema))
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no cursor saved in source schema %q", opts.SourceSchema)
+	}
+	if err != nil {
+		return "", fmt.Errorf("loading cursor of source schema %q: %w", opts.SourceSchema, err)
+	}
+
+	c, err := bstream.CursorFromOpaque(opaque)
+	if err != nil {
+		return "", fmt.Errorf("decoding cursor %q: %w", opaque, err)
+	}
+	if c.Block.Num() < opts.Block {
+		return "", fmt.Errorf("source schema %q is loaded up to block %d only, below graft block %d", opts.SourceSchema, c.Block.Num(), opts.Block)
+	}
+
+	switch {
+	case opts.BlockID != "":
+		return opts.BlockID, nil
+	case c.Block.Num() == opts.Block:
+		return c.Block.ID(), nil
+	}
+	return "", fmt.Errorf("source cursor is at block %d, the id of graft block %d is required", c.Block.Num(), opts.Block)
+}
+
+// graftEarliestBlock returns the earliest block of the source, the graft block must not
+// have been pruned.
+func graftEarliestBlock(ctx context.Context, tx *sqlx.Tx, opts GraftOptions) (uint64, error) {
+	var pruning sql.NullString
+	if err := tx.GetContext(ctx, &pruning, `SELECT to_regclass($1)::text`, opts.SourceSchema+".pruning"); err != nil {
+		return 0, fmt.Errorf("looking up pruning table: %w", err)
+	}
+	if !pruning.Valid {
+		return 0, nil
+	}
+
+	var earliestBlock uint64
+	if err := tx.GetContext(ctx, &earliestBlock, fmt.Sprintf(`SELECT coalesce(max(earliest_block), 0) FROM %s.pruning`, opts.SourceSchema)); err != nil {
+		return 0, fmt.Errorf("loading earliest block: %w", err)
+	}
+	if earliestBlock > opts.Block {
+		return 0, fmt.Errorf("source schema %q is pruned below block %d, above graft block %d", opts.SourceSchema, earliestBlock, opts.Block)
+	}
+	return earliestBlock, nil
+}
+
+// graftColumns returns, by table, the columns of the target that the source also has, in
+// the order of the target.
+func graftColumns(ctx context.Context, tx *sqlx.Tx, source, target string) (map[string][]string, error) {
+	var columns []struct {
+		Schema string `db:"table_schema"`
+		Table  string `db:"table_name"`
+		Column string `db:"column_name"`
+	}
+	query := `SELECT table_schema, table_name, column_name FROM information_schema.columns WHERE table_schema IN ($1, $2) ORDER BY ordinal_position`
+	if err := tx.SelectContext(ctx, &columns, query, source, target); err != nil {
+		return nil, fmt.Errorf("listing columns of schemas %q and %q: %w", source, target, err)
+	}
+
+	inSource := map[string]bool{}
+	for _, column := range columns {
+		if column.Schema == source {
+			inSource[column.Table+"."+column.Column] = true
+		}
+	}
+
+	out := map[string][]string{}
+	for _, column := range columns {
+		if column.Schema == target && inSource[column.Table+"."+column.Column] {
+			out[column.Table] = append(out[column.Table], column.Column)
+		}
+	}
+	return out, nil
+}
+
+// graftStatement copies the versions of `table` valid up to `block`, versions closed after
+// `block` are open again, as done when reverting blocks. Immutable tables only have the
+// entities created up to `block` copied.
+func graftStatement(source, target, table string, columns []string, block uint64) string {
+	names := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = fmt.Sprintf("%q", column)
+		switch column {
+		case "block_range":
+			values[i] = fmt.Sprintf("CASE WHEN upper(block_range) > %d THEN int4range(lower(block_range), NULL) ELSE block_range END", block)
+		case "_updated_block_number":
+			values[i] = fmt.Sprintf("CASE WHEN upper(block_range) > %d THEN lower(block_range) ELSE _updated_block_number END", block)
+		default:
+			values[i] = names[i]
+		}
+	}
+
+	statement := fmt.Sprintf(`INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s`,
+		target, table, strings.Join(names, ", "), strings.Join(values, ", "), source, table)
+
+	// Tables without a block column are not versioned, all their rows are copied
+	switch {
+	case contains(columns, graphnode.BlockColumn):
+		statement += fmt.Sprintf(" WHERE %q <= %d", graphnode.BlockColumn, block)
+	case contains(columns, "block_range"):
+		statement += fmt.Sprintf(" WHERE lower(block_range) <= %d", block)
+	}
+	return statement
+}
+
+// graftSequence sets the `vid` sequence of the target table where the source one is.
+func graftSequence(ctx context.Context, tx *sqlx.Tx, source, target, table string) error {
+	var sourceSequence, targetSequence sql.NullString
+	if err := tx.GetContext(ctx, &sourceSequence, `SELECT pg_get_serial_sequence($1, 'vid')`, fmt.Sprintf("%s.%s", source, table)); err != nil {
+		return fmt.Errorf("looking up vid sequence of %s.%s: %w", source, table, err)
+	}
+	if err := tx.GetContext(ctx, &targetSequence, `SELECT pg_get_serial_sequence($1, 'vid')`, fmt.Sprintf("%s.%s", target, table)); err != nil {
+		return fmt.Errorf("looking up vid sequence of %s.%s: %w", target, table, err)
+	}
+	if !sourceSequence.Valid || !targetSequence.Valid {
+		return fmt.Errorf("table %s has no vid sequence", table)
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`SELECT setval($1, last_value, is_called) FROM %s`, sourceSequence.String), targetSequence.String); err != nil {
+		return fmt.Errorf("setting vid sequence of %s.%s: %w", target, table, err)
+	}
+	return nil
+}
+
+// graftCursor returns the cursor of the irreversible graft block, streaming from it resumes
+// at the next block.
+func graftCursor(blockNum uint64, blockID string) string {
+	block := bstream.NewBlockRef(strings.TrimPrefix(blockID, "0x"), blockNum)
+	return (&bstream.Cursor{
+		Step:      bstream.StepIrreversible,
+		Block:     block,
+		LIB:       block,
+		HeadBlock: block,
+	}).ToOpaque()
+}
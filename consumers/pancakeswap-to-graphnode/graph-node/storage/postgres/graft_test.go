@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGraftStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		columns  []string
+		expected string
+	}{
+		{
+			"versioned columns",
+			[]string{"vid", "id", "block_range", "_updated_block_number", "name"},
+			`INSERT INTO sgd2.token ("vid", "id", "block_range", "_updated_block_number", "name") ` +
+				`SELECT "vid", "id", CASE WHEN upper(block_range) > 100 THEN int4range(lower(block_range), NULL) ELSE block_range END, ` +
+				`CASE WHEN upper(block_range) > 100 THEN lower(block_range) ELSE _updated_block_number END, "name" ` +
+				`FROM sgd1.token WHERE lower(block_range) <= 100`,
+		},
+		{
+			"plain columns",
+			[]string{"id", "digest"},
+			`INSERT INTO sgd2.token ("id", "digest") SELECT "id", "digest" FROM sgd1.token`,
+		},
+		{
+			"immutable columns",
+			[]string{"vid", "id", "block$", "name"},
+			`INSERT INTO sgd2.token ("vid", "id", "block$", "name") SELECT "vid", "id", "block$", "name" FROM sgd1.token WHERE "block$" <= 100`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, graftStatement("sgd1", "sgd2", "token", test.columns, 100))
+		})
+	}
+}
+
+func TestGraftCursor(t *testing.T) {
+	c, err := bstream.CursorFromOpaque(graftCursor(6809800, "0xabc123"))
+	require.NoError(t, err)
+
+	assert.Equal(t, bstream.StepIrreversible, c.Step)
+	assert.Equal(t, uint64(6809800), c.Block.Num())
+	assert.Equal(t, "abc123", c.Block.ID())
+	assert.Equal(t, uint64(6809800), c.LIB.Num())
+	assert.Equal(t, uint64(6809800), c.HeadBlock.Num())
+}
+
+// TestGraft checks that the versions valid at the graft block are copied with their vid,
+// the versions closed after it open again, and that the target continues the vid sequence
+// of the source from the graft cursor.
+func TestGraft(t *testing.T) {
+	dsn := testDSN(t)
+	ctx := context.Background()
+	source := newTestStore(t, dsn)
+
+	save := func(blockNum uint64, entities map[string]graphnode.Entity) {
+		for id, ent := range entities {
+			current := &storagetest.TestEntity{Base: graphnode.NewBase(id)}
+			require.NoError(t, source.Load(ctx, id, current, blockNum))
+			if current.Exists() && ent != nil {
+				ent.SetVID(current.GetVID())
+				ent.SetBlockRange(current.GetBlockRange())
+			}
+		}
+		updates := map[string]map[string]graphnode.Entity{"test_entity": entities}
+		require.NoError(t, source.BatchSave(ctx, blockNum, "", time.Unix(int64(blockNum), 0), updates, graftCursor(blockNum, fmt.Sprintf("0x%d", blockNum))))
+	}
+	save(10, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a1", 1), "b": storagetest.NewTestEntity("b", "b1", 1)})
+	save(20, map[string]graphnode.Entity{"a": storagetest.NewTestEntity("a", "a2", 2)})
+	save(30, map[string]graphnode.Entity{"b": nil, "c": storagetest.NewTestEntity("c", "c1", 1)})
+
+	targetSchema := fmt.Sprintf("graft_%d", time.Now().UnixNano())
+	_, err := source.db.Exec(strings.ReplaceAll(conformanceDDL, "%%SCHEMA%%", targetSchema))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = source.db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", targetSchema))
+	})
+
+	def := &subgraph.Definition{PackageName: "conformance", Entities: storagetest.Registry()}
+	cursor, err := Graft(ctx, zap.NewNop(), dsn, def, GraftOptions{SourceSchema: source.schemaName, TargetSchema: targetSchema, Block: 20, BlockID: "0xabc"})
+	require.NoError(t, err)
+
+	target, err := New(zap.NewNop(), metrics.NewBlockMetrics(), dsn, targetSchema, "conformance", def, map[string]bool{}, true)
+	require.NoError(t, err)
+	defer target.Close()
+	require.NoError(t, createStoreTables(targetSchema, func(statement string) error {
+		_, err := target.db.Exec(statement)
+		return err
+	}))
+	require.NoError(t, target.RegisterEntities())
+
+	savedCursor, err := target.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, cursor, savedCursor)
+	c, err := bstream.CursorFromOpaque(savedCursor)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), c.Block.Num())
+	assert.Equal(t, "abc", c.Block.ID())
+
+	type row struct {
+		VID        int64  `db:"vid"`
+		ID         string `db:"id"`
+		BlockRange string `db:"block_range"`
+	}
+	rows := func(schema string, condition string) (out []row) {
+		query := fmt.Sprintf(`SELECT vid, id, block_range::text FROM %s.test_entity %s ORDER BY vid`, schema, condition)
+		require.NoError(t, source.db.Select(&out, query))
+		return out
+	}
+	// `b` deleted and `c` created after the graft block are not seen
+	assert.Equal(t, []row{
+		{VID: rows(source.schemaName, "WHERE id = 'a' AND lower(block_range) = 10")[0].VID, ID: "a", BlockRange: "[10,20)"},
+		{VID: rows(source.schemaName, "WHERE id = 'b'")[0].VID, ID: "b", BlockRange: "[10,)"},
+		{VID: rows(source.schemaName, "WHERE id = 'a' AND lower(block_range) = 20")[0].VID, ID: "a", BlockRange: "[20,)"},
+	}, rows(targetSchema, ""))
+
+	// The vid sequence goes on after the versions of the source
+	var sourceVID, targetVID int64
+	require.NoError(t, source.db.Get(&sourceVID, fmt.Sprintf(`SELECT last_value FROM %s.test_entity_vid_seq`, source.schemaName)))
+	require.NoError(t, source.db.Get(&targetVID, fmt.Sprintf(`SELECT last_value FROM %s.test_entity_vid_seq`, targetSchema)))
+	assert.Equal(t, sourceVID, targetVID)
+
+	updates := map[string]map[string]graphnode.Entity{"test_entity": {"d": storagetest.NewTestEntity("d", "d1", 1)}}
+	require.NoError(t, target.BatchSave(ctx, 21, "", time.Unix(21, 0), updates, graftCursor(21, "0xdef")))
+	created := rows(targetSchema, "WHERE id = 'd'")
+	require.Len(t, created, 1)
+	assert.Greater(t, created[0].VID, sourceVID)
+}
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"go.uber.org/zap"
+)
+
+// immutableSelectColumns lists the columns of an immutable entity, its `block$` is read
+// back as an open-ended `block_range` and as `_updated_block_number`.
+func immutableSelectColumns(entityType reflect.Type) string {
+	var columns []string
+	for _, field := range graphnode.DBFields(entityType) {
+		switch field.ColumnName {
+		case "block_range":
+			columns = append(columns, fmt.Sprintf("int4range(%q, NULL) AS block_range", graphnode.BlockColumn))
+		case "_updated_block_number":
+			columns = append(columns, fmt.Sprintf("%q AS _updated_block_number", graphnode.BlockColumn))
+		default:
+			columns = append(columns, fmt.Sprintf("%q", field.ColumnName))
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// hasVersionedLayout tells whether the table with columns `actual` stores its entities
+// as `block_range` versions. Immutable entities only get the append-only `block$` layout in
+// the tables created for them, a table created before its entity became immutable keeps
+// its versions, the loader still rejects the changes of its entities.
+func hasVersionedLayout(actual []*dbColumn) bool {
+	for _, column := range actual {
+		if column.Name == "block_range" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadImmutableLayout reads the layout of the immutable tables, the ones still holding
+// `block_range` versions are then read and written as the tables of mutable entities.
+func (s *store) loadImmutableLayout(ctx context.Context) error {
+	var versioned []string
+	query := `SELECT table_name FROM information_schema.columns WHERE table_schema = $1 AND column_name = 'block_range'`
+	if err := s.db.SelectContext(ctx, &versioned, query, s.schemaName); err != nil {
+		return fmt.Errorf("listing versioned tables of schema %q: %w", s.schemaName, err)
+	}
+
+	for _, table := range versioned {
+		if s.isImmutable(table) {
+			s.logger.Info("immutable entities stored as versions, the table predates the block$ layout", zap.String("table", table))
+			delete(s.immutableColumns, table)
+		}
+	}
+	return nil
+}
+
+func (s *store) selectColumns(tableName string) string {
+	if columns, found := s.immutableColumns[tableName]; found {
+		return columns
+	}
+	return "*"
+}
+
+// containsBlock is the condition matching the version of an entity valid at `blockNum`.
+// Partitioned tables of mutable entities get their partition key bounded as well, so only
+// the partitions starting at or before `blockNum` are scanned.
+func (s *store) containsBlock(tableName string, blockNum string) string {
+	if s.isImmutable(tableName) {
+		return fmt.Sprintf("%q <= %s", graphnode.BlockColumn, blockNum)
+	}
+	if s.isPartitioned(tableName) {
+		return fmt.Sprintf("block_range @> %s and block_range < int4range(%s + 1, %s + 2)", blockNum, blockNum, blockNum)
+	}
+	return "block_range @> " + blockNum
+}
+
+func (s *store) isImmutable(tableName string) bool {
+	_, found := s.immutableColumns[tableName]
+	return found
+}
+
+// checkImmutableUpdates rejects the deletes and the updates of existing versions.
+func checkImmutableUpdates(tableName string, entities map[string]graphnode.Entity) error {
+	for id, ent := range entities {
+		if ent == nil {
+			return graphnode.NewImmutableEntityError(tableName, id, "delete")
+		}
+		if ent.GetBlockRange() != nil {
+			return graphnode.NewImmutableEntityError(tableName, id, "update")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+// LatestTableSuffix is appended to the name of an entity table to name its mirror, the
+// table holding the current version of each entity.
+const LatestTableSuffix = "_latest"
+
+func LatestTableName(table string) string {
+	return table + LatestTableSuffix
+}
+
+// LatestTableStatement derives the `create table` statement of the mirror of `table` from
+// its definition in the DDL. The mirror has the entity columns and `_updated_block_number`,
+// the block the current version was written at, `id` is its primary key.
+func LatestTableStatement(ddl subgraph.DDL, table string) (string, error) {
+	tables, err := subgraph.TableDefinitions(ddl)
+	if err != nil {
+		return "", fmt.Errorf("parsing ddl: %w", err)
+	}
+
+	for _, definition := range tables {
+		if definition.Name != table {
+			continue
+		}
+
+		var columns []string
+		for _, column := range definition.Columns {
+			switch column.Name {
+			case "vid", "block_range", graphnode.BlockColumn:
+				continue
+			case "id":
+				columns = append(columns, column.Definition()+fmt.Sprintf(" constraint %s_pkey primary key", LatestTableName(table)))
+			default:
+				columns = append(columns, column.Definition())
+			}
+		}
+		return fmt.Sprintf("create table if not exists %%%%SCHEMA%%%%.%s\n(\n\t%s\n);", LatestTableName(table), strings.Join(columns, ",\n\t")), nil
+	}
+	return "", fmt.Errorf("table %q not found in ddl", table)
+}
+
+// latestColumns lists the columns of the mirror of an entity table.
+func latestColumns(entityType reflect.Type) []string {
+	columns := []string{"id", "_updated_block_number"}
+	for _, field := range graphnode.DBFields(entityType) {
+		if !field.Base {
+			columns = append(columns, field.ColumnName)
+		}
+	}
+	return columns
+}
+
+// buildLatestUpsertQuery writes the given versions of the entities to the mirror, replacing
+// the ones it held.
+func buildLatestUpsertQuery(schemaName, tableName string, entityType reflect.Type) string {
+	var names, values, updates []string
+	for _, column := range latestColumns(entityType) {
+		names = append(names, fmt.Sprintf("%q", column))
+		values = append(values, ":"+column)
+		if column != "id" {
+			updates = append(updates, fmt.Sprintf("%q = excluded.%q", column, column))
+		}
+	}
+
+	return fmt.Sprintf(`INSERT INTO %s.%s (%s) VALUES (%s) ON CONFLICT (id) DO UPDATE SET %s`,
+		schemaName, LatestTableName(tableName), strings.Join(names, ", "), strings.Join(values, ", "), strings.Join(updates, ", "))
+}
+
+// buildLatestSyncQuery copies the open versions of the entities `$1` to the mirror.
+func buildLatestSyncQuery(schemaName, tableName string, entityType reflect.Type) string {
+	var names []string
+	for _, column := range latestColumns(entityType) {
+		names = append(names, fmt.Sprintf("%q", column))
+	}
+	columns := strings.Join(names, ", ")
+
+	return fmt.Sprintf(`INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s WHERE id = ANY($1) AND upper_inf(block_range) ON CONFLICT (id) DO NOTHING`,
+		schemaName, LatestTableName(tableName), columns, columns, schemaName, tableName)
+}
+
+// EnableLatestTables creates the mirrors of `tables` not enabled yet and records them in
+// the `latest_tables` table, every store registered on the schema maintains them from then
+// on, see `loadLatestTables`.
+func (s *store) EnableLatestTables(ctx context.Context, tables []string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	exists, err := s.tableExists(ctx, "latest_tables")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("table %s.latest_tables does not exist, run `exchange migrate` to create it", s.schemaName)
+	}
+
+	sort.Strings(tables)
+	for _, table := range tables {
+		if _, found := s.subgraph.Entities.GetType(table); !found {
+			return fmt.Errorf("unknown entity table %q", table)
+		}
+		if s.isImmutable(table) {
+			return fmt.Errorf("table %q holds immutable entities, it has no versions to mirror", table)
+		}
+		if s.latestTables[table] {
+			continue
+		}
+
+		if err := s.createLatestTable(ctx, table); err != nil {
+			return fmt.Errorf("table %q: %w", table, err)
+		}
+		s.latestTables[table] = true
+	}
+	return nil
+}
+
+// loadLatestTables maintains the mirrors recorded in the `latest_tables` table, whether
+// they are enabled again or not, so that no save leaves them behind.
+func (s *store) loadLatestTables(ctx context.Context) error {
+	exists, err := s.tableExists(ctx, "latest_tables")
+	if err != nil || !exists {
+		return err
+	}
+
+	var tables []string
+	if err := s.db.SelectContext(ctx, &tables, fmt.Sprintf(`SELECT table_name FROM %s.latest_tables ORDER BY table_name`, s.schemaName)); err != nil {
+		return fmt.Errorf("loading latest tables: %w", err)
+	}
+
+	for _, table := range tables {
+		if _, found := s.subgraph.Entities.GetType(table); !found {
+			return fmt.Errorf("latest mirror recorded for unknown entity table %q", table)
+		}
+		s.latestTables[table] = true
+	}
+	if len(tables) > 0 {
+		s.logger.Info("maintaining latest mirror tables", zap.Strings("tables", tables))
+	}
+	return nil
+}
+
+// createLatestTable fills the mirror of `table`, created when missing, with the open
+// versions of its entities and records it. A mirror left from before it was recorded may be
+// behind, it is filled again.
+func (s *store) createLatestTable(ctx context.Context, table string) error {
+	create, err := LatestTableStatement(s.subgraph.DDL, table)
+	if err != nil {
+		return err
+	}
+
+	entityType, _ := s.subgraph.Entities.GetType(table)
+	var names []string
+	for _, column := range latestColumns(entityType) {
+		names = append(names, fmt.Sprintf("%q", column))
+	}
+	columns := strings.Join(names, ", ")
+	fill := fmt.Sprintf(`INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s WHERE upper_inf(block_range)`, s.schemaName, LatestTableName(table), columns, columns, s.schemaName, table)
+
+	statements := []string{
+		strings.ReplaceAll(create, "%%SCHEMA%%", s.schemaName),
+		fmt.Sprintf(`DELETE FROM %s.%s`, s.schemaName, LatestTableName(table)),
+		fill,
+		fmt.Sprintf(`INSERT INTO %s.latest_tables (table_name) VALUES ('%s') ON CONFLICT (table_name) DO NOTHING`, s.schemaName, table),
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("executing %q: %w", statement, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	s.logger.Info("filled latest mirror table", zap.String("table", table), zap.String("mirror", LatestTableName(table)))
+	return nil
+}
+
+// saveLatest applies the saved versions to the mirror of `tableName` in the transaction of
+// the save, deleted entities are removed from it.
+func (s *store) saveLatest(ctx context.Context, dbTx *sqlx.Tx, tableName string, entities map[string]graphnode.Entity) error {
+	var deleted []string
+	var saved []graphnode.Entity
+	for id, ent := range entities {
+		if ent == nil {
+			deleted = append(deleted, id)
+			continue
+		}
+		saved = append(saved, ent)
+	}
+
+	var execer sqlx.ExtContext = s.db
+	if dbTx != nil {
+		execer = dbTx
+	}
+
+	if len(deleted) > 0 {
+		query := fmt.Sprintf(`DELETE FROM %s.%s WHERE id = ANY($1)`, s.schemaName, LatestTableName(tableName))
+		if _, err := execer.ExecContext(ctx, query, pq.Array(deleted)); err != nil {
+			return fmt.Errorf("deleting from %q: %w", LatestTableName(tableName), err)
+		}
+	}
+
+	if len(saved) > 0 {
+		entityType, _ := s.subgraph.Entities.GetType(tableName)
+		if _, err := sqlx.NamedExecContext(ctx, execer, buildLatestUpsertQuery(s.schemaName, tableName, entityType), saved); err != nil {
+			return fmt.Errorf("upserting into %q, %d entities: %w", LatestTableName(tableName), len(saved), err)
+		}
+	}
+	return nil
+}
+
+// syncLatest rewrites the mirror rows of the entities `ids` from their open version, after
+// a fork or a revert changed which version is open. It runs in the transaction `tx` of the
+// revert, the mirror never sees a partial one.
+func (s *store) syncLatest(ctx context.Context, tx *sqlx.Tx, tableName string, ids []string) error {
+	if !s.latestTables[tableName] || len(ids) == 0 {
+		return nil
+	}
+
+	entityType, _ := s.subgraph.Entities.GetType(tableName)
+	statements := []string{
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE id = ANY($1)`, s.schemaName, LatestTableName(tableName)),
+		buildLatestSyncQuery(s.schemaName, tableName, entityType),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, pq.Array(ids)); err != nil {
+			return fmt.Errorf("syncing %q: %w", LatestTableName(tableName), err)
+		}
+	}
+
+	s.logger.Info("synced latest mirror table", zap.String("table", tableName), zap.Int("entity_count", len(ids)))
+	return nil
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type latestTestDDL struct{}
+
+func (latestTestDDL) InitiateSchema(handleStatement func(statement string) error) error {
+	return nil
+}
+
+func (latestTestDDL) CreateTables(handleStatement func(table string, statement string) error) error {
+	return handleStatement("test_entity", `
+create table if not exists %%SCHEMA%%.test_entity
+(
+	id text not null,
+
+	"name" text not null,
+
+	"amount" numeric not null,
+
+	vid bigserial not null constraint test_entity_pkey primary key,
+	block_range int4range not null,
+	_updated_block_number numeric not null
+);`)
+}
+
+func (latestTestDDL) CreateIndexes(handleStatement func(table string, statement string) error) error {
+	return nil
+}
+
+func (latestTestDDL) DropIndexes(handleStatement func(table string, statement string) error) error {
+	return nil
+}
+
+func TestLatestTableStatement(t *testing.T) {
+	statement, err := LatestTableStatement(latestTestDDL{}, "test_entity")
+	require.NoError(t, err)
+	assert.Equal(t, `create table if not exists %%SCHEMA%%.test_entity_latest
+(
+	"id" text not null constraint test_entity_latest_pkey primary key,
+	"name" text not null,
+	"amount" numeric not null,
+	"_updated_block_number" numeric not null
+);`, statement)
+
+	_, err = LatestTableStatement(latestTestDDL{}, "unknown")
+	assert.EqualError(t, err, `table "unknown" not found in ddl`)
+}
+
+func TestLatestQueries(t *testing.T) {
+	entityType := reflect.TypeOf(storagetest.TestEntity{})
+
+	assert.Equal(t,
+		`INSERT INTO sgd1.test_entity_latest ("id", "_updated_block_number", "name", "amount") VALUES (:id, :_updated_block_number, :name, :amount) ON CONFLICT (id) DO UPDATE SET "_updated_block_number" = excluded."_updated_block_number", "name" = excluded."name", "amount" = excluded."amount"`,
+		buildLatestUpsertQuery("sgd1", "test_entity", entityType),
+	)
+	assert.Equal(t,
+		`INSERT INTO sgd1.test_entity_latest ("id", "_updated_block_number", "name", "amount") SELECT "id", "_updated_block_number", "name", "amount" FROM sgd1.test_entity WHERE id = ANY($1) AND upper_inf(block_range) ON CONFLICT (id) DO NOTHING`,
+		buildLatestSyncQuery("sgd1", "test_entity", entityType),
+	)
+}
+
+// TestStore_LatestTables checks that an enabled mirror is maintained by the later runs and
+// reverted along with the versions.
+func TestStore_LatestTables(t *testing.T) {
+	ctx := context.Background()
+	dsn := testDSN(t)
+	s := newTestStore(t, dsn)
+	s.subgraph.DDL = latestTestDDL{}
+
+	save := func(st *store, blockNum uint64, ent *storagetest.TestEntity) {
+		current := &storagetest.TestEntity{Base: graphnode.NewBase(ent.ID)}
+		require.NoError(t, st.Load(ctx, ent.ID, current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+		updates := map[string]map[string]graphnode.Entity{"test_entity": {ent.ID: ent}}
+		require.NoError(t, st.BatchSave(ctx, blockNum, "", time.Unix(int64(blockNum), 0), updates, "cursor"))
+	}
+	mirrored := func() map[string]string {
+		var rows []struct {
+			ID     string `db:"id"`
+			Amount string `db:"amount"`
+		}
+		require.NoError(t, s.db.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT id, amount::text AS amount FROM %s.test_entity_latest`, s.schemaName)))
+		out := map[string]string{}
+		for _, row := range rows {
+			out[row.ID] = row.Amount
+		}
+		return out
+	}
+
+	save(s, 10, storagetest.NewTestEntity("a", "a", 1))
+	require.NoError(t, s.EnableLatestTables(ctx, []string{"test_entity"}))
+	assert.Equal(t, map[string]string{"a": "1"}, mirrored())
+
+	// A later run maintains the mirror without enabling it again
+	restarted, err := New(zap.NewNop(), metrics.NewBlockMetrics(), dsn, s.schemaName, "conformance", s.subgraph, map[string]bool{}, true)
+	require.NoError(t, err)
+	defer restarted.db.Close()
+	require.NoError(t, restarted.RegisterEntities())
+
+	save(restarted, 20, storagetest.NewTestEntity("a", "a", 2))
+	save(restarted, 21, storagetest.NewTestEntity("b", "b", 3))
+	assert.Equal(t, map[string]string{"a": "2", "b": "3"}, mirrored())
+
+	// The fork cleanup reverts the mirror along with the versions
+	require.NoError(t, restarted.CleanUpFork(ctx, 20))
+	assert.Equal(t, map[string]string{"a": "1"}, mirrored())
+}
@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+const DefaultPartitionSize = 1_000_000
+
+// Partitioning lists the entity tables declared `partition by range` on the block an
+// entity version starts at, each partition holding `Size` blocks.
+type Partitioning struct {
+	Tables []string
+	Size   uint64
+}
+
+// partitionedDDL is the DDL of a subgraph where the tables of the partitioning are
+// created partitioned. The partitions themselves are created by the store as the head
+// advances.
+type partitionedDDL struct {
+	subgraph.DDL
+
+	partitioning *Partitioning
+	immutable    map[string]bool
+}
+
+// NewPartitionedDDL wraps the DDL of `def` to create the tables of `partitioning` as
+// partitioned tables.
+func NewPartitionedDDL(def *subgraph.Definition, partitioning *Partitioning) (subgraph.DDL, error) {
+	if partitioning.Size == 0 {
+		return nil, fmt.Errorf("partition size must be greater than 0")
+	}
+
+	immutable := map[string]bool{}
+	for _, table := range partitioning.Tables {
+		if _, found := def.Entities.GetType(table); !found {
+			return nil, fmt.Errorf("unknown entity table %q", table)
+		}
+		immutable[table] = def.Entities.IsImmutable(table)
+	}
+
+	return &partitionedDDL{DDL: def.DDL, partitioning: partitioning, immutable: immutable}, nil
+}
+
+// definition returns `def` with its DDL creating the tables of the partitioning as
+// partitioned tables, `def` itself when there is no partitioning.
+func (p *Partitioning) definition(def *subgraph.Definition) (*subgraph.Definition, error) {
+	if p == nil || len(p.Tables) == 0 {
+		return def, nil
+	}
+
+	ddl, err := NewPartitionedDDL(def, p)
+	if err != nil {
+		return nil, fmt.Errorf("partitioning: %w", err)
+	}
+	partitioned := *def
+	partitioned.DDL = ddl
+	return &partitioned, nil
+}
+
+func (d *partitionedDDL) InitiateSchema(handleStatement func(statement string) error) error {
+	if err := d.DDL.InitiateSchema(handleStatement); err != nil {
+		return err
+	}
+
+	// The partitioning is recorded before the store tables are created
+	if err := handleStatement(storeTables["partitioning"]); err != nil {
+		return err
+	}
+	for _, table := range d.partitioning.Tables {
+		statement := fmt.Sprintf(`insert into %%%%SCHEMA%%%%.partitioning (table_name, partition_size) values ('%s', %d) on conflict (table_name) do nothing;`, table, d.partitioning.Size)
+		if err := handleStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *partitionedDDL) CreateTables(handleStatement func(table string, statement string) error) error {
+	return d.DDL.CreateTables(func(table string, statement string) error {
+		immutable, found := d.immutable[table]
+		if !found {
+			return handleStatement(table, statement)
+		}
+
+		partitioned, err := partitionCreateTable(statement, table, immutable)
+		if err != nil {
+			return err
+		}
+		return handleStatement(table, partitioned)
+	})
+}
+
+// partitionKey is the column holding the block an entity version starts at. Ranges are
+// ordered by their lower bound first, so the versions of mutable entities are partitioned
+// on the block they start at and stay in the same partition as closing them does not
+// change it.
+func partitionKey(immutable bool) string {
+	if immutable {
+		return fmt.Sprintf("%q", graphnode.BlockColumn)
+	}
+	return "block_range"
+}
+
+// partitionBound is the lowest value of the partition key of the versions starting at
+// `blockNum`, the smallest range starting there for mutable entities.
+func partitionBound(immutable bool, blockNum uint64) string {
+	if immutable {
+		return fmt.Sprint(blockNum)
+	}
+	return fmt.Sprintf("'[%d,%d)'", blockNum, blockNum+1)
+}
+
+// partitionCreateTable rewrites the `create table` statement of `table` to declare it
+// partitioned by range of its partition key. The primary key and unique constraints of a
+// partitioned table must hold the partition key, it is added to them.
+func partitionCreateTable(statement, table string, immutable bool) (string, error) {
+	header := regexp.MustCompile(`(?i)create\s+table\s+(?:if\s+not\s+exists\s+)?%%SCHEMA%%\."?` + regexp.QuoteMeta(table) + `"?\s*\(`)
+	loc := header.FindStringIndex(statement)
+	if loc == nil {
+		return "", fmt.Errorf("table %q: create statement not found", table)
+	}
+
+	end := -1
+	depth := 1
+	for i := loc[1]; i < len(statement) && end == -1; i++ {
+		switch statement[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end == -1 {
+		return "", fmt.Errorf("table %q: unbalanced parenthesis in create statement", table)
+	}
+
+	// The primary key of the `vid` column, named or not
+	body := regexp.MustCompile(`(?i)(?:\s+constraint\s+"?`+regexp.QuoteMeta(table)+`_pkey"?)?\s+primary\s+key(\s*,)`).ReplaceAllString(statement[loc[1]:end], "$1")
+	if immutable {
+		body = regexp.MustCompile(`(?i)unique\s*\(\s*"?id"?\s*\)`).ReplaceAllString(body, fmt.Sprintf("unique (id, %q)", graphnode.BlockColumn))
+	}
+	body = strings.TrimRight(body, " \t\n") + fmt.Sprintf(",\n\tconstraint %s_pkey primary key (vid, %s)\n", table, partitionKey(immutable))
+
+	return statement[:loc[1]] + body + ") partition by range (" + partitionKey(immutable) + ")" + statement[end+1:], nil
+}
+
+func partitionName(table string, index uint64) string {
+	return fmt.Sprintf("%s_p%d", table, index)
+}
+
+// createPartitionStatement creates the partition `index` of `table`, holding the versions
+// starting in blocks [index * size, (index + 1) * size).
+func createPartitionStatement(schema, table string, immutable bool, size, index uint64) string {
+	return fmt.Sprintf(`create table if not exists %s.%s partition of %s.%s for values from (%s) to (%s)`,
+		schema, partitionName(table, index), schema, table, partitionBound(immutable, index*size), partitionBound(immutable, (index+1)*size))
+}
+
+// loadPartitioning caches the partition size of the partitioned tables listed in the
+// `partitioning` table, schemas created before it have none.
+func (s *store) loadPartitioning(ctx context.Context) error {
+	var exists bool
+	if err := s.db.GetContext(ctx, &exists, `SELECT to_regclass($1) IS NOT NULL`, s.schemaName+".partitioning"); err != nil {
+		return fmt.Errorf("looking up partitioning table: %w", err)
+	}
+	if !exists {
+		s.partitionSizes = map[string]uint64{}
+		return nil
+	}
+
+	sizes, err := partitionSizes(ctx, s.db, s.schemaName)
+	if err != nil {
+		return err
+	}
+	s.partitionSizes = sizes
+	return nil
+}
+
+func partitionSizes(ctx context.Context, db sqlx.QueryerContext, schema string) (map[string]uint64, error) {
+	var rows []struct {
+		Table string `db:"table_name"`
+		Size  uint64 `db:"partition_size"`
+	}
+	if err := sqlx.SelectContext(ctx, db, &rows, fmt.Sprintf(`SELECT table_name, partition_size FROM %s.partitioning`, schema)); err != nil {
+		return nil, fmt.Errorf("loading partitioning: %w", err)
+	}
+
+	out := map[string]uint64{}
+	for _, row := range rows {
+		out[row.Table] = row.Size
+	}
+	return out, nil
+}
+
+// ensurePartitions creates, when `blockNum` reaches the end of the partitions created so
+// far, the partition holding it along with the next one for every partitioned table, so
+// saves only wait on partition creation once every two partitions.
+func (s *store) ensurePartitions(ctx context.Context, blockNum uint64) error {
+	if blockNum < s.partitionedUpTo {
+		return nil
+	}
+
+	tables := make([]string, 0, len(s.partitionSizes))
+	for table := range s.partitionSizes {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	upTo := uint64(math.MaxUint64)
+	for _, table := range tables {
+		size := s.partitionSizes[table]
+		index := blockNum / size
+		for _, i := range []uint64{index, index + 1} {
+			if _, err := s.db.ExecContext(ctx, createPartitionStatement(s.schemaName, table, s.isImmutable(table), size, i)); err != nil {
+				return fmt.Errorf("creating partition %s: %w", partitionName(table, i), err)
+			}
+		}
+		if tableUpTo := (index + 2) * size; tableUpTo < upTo {
+			upTo = tableUpTo
+		}
+		s.logger.Info("partitions ready", zap.String("table", table), zap.Uint64("up_to_block", (index+2)*size))
+	}
+	s.partitionedUpTo = upTo
+	return nil
+}
+
+// createPartitionsUpTo creates every partition of the partitioned tables of `schema`
+// holding blocks up to `blockNum`.
+func createPartitionsUpTo(ctx context.Context, tx *sqlx.Tx, schema string, registry *graphnode.Registry, blockNum uint64) error {
+	if _, err := tx.ExecContext(ctx, strings.ReplaceAll(storeTables["partitioning"], "%%SCHEMA%%", schema)); err != nil {
+		return fmt.Errorf("creating partitioning table: %w", err)
+	}
+
+	sizes, err := partitionSizes(ctx, tx, schema)
+	if err != nil {
+		return err
+	}
+	for table, size := range sizes {
+		for i := uint64(0); i <= blockNum/size; i++ {
+			if _, err := tx.ExecContext(ctx, createPartitionStatement(schema, table, registry.IsImmutable(table), size, i)); err != nil {
+				return fmt.Errorf("creating partition %s: %w", partitionName(table, i), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *store) isPartitioned(tableName string) bool {
+	_, found := s.partitionSizes[tableName]
+	return found
+}
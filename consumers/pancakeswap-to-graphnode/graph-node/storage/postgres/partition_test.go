@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPartitionCreateTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		table     string
+		immutable bool
+		statement string
+		expected  string
+	}{
+		{
+			"mutable",
+			"pair_hour_data",
+			false,
+			`
+create table if not exists %%SCHEMA%%.pair_hour_data
+(
+	id text not null,
+
+	"reserve_0" numeric not null,
+
+	vid bigserial not null constraint pair_hour_data_pkey primary key,
+	block_range int4range not null,
+	_updated_block_number numeric not null
+);
+
+alter table %%SCHEMA%%.pair_hour_data owner to graph;
+`,
+			`
+create table if not exists %%SCHEMA%%.pair_hour_data
+(
+	id text not null,
+
+	"reserve_0" numeric not null,
+
+	vid bigserial not null,
+	block_range int4range not null,
+	_updated_block_number numeric not null,
+	constraint pair_hour_data_pkey primary key (vid, block_range)
+) partition by range (block_range);
+
+alter table %%SCHEMA%%.pair_hour_data owner to graph;
+`,
+		},
+		{
+			"immutable",
+			"swap",
+			true,
+			`
+create table if not exists %%SCHEMA%%.swap
+(
+	id text not null,
+
+	"pair" text not null,
+
+	vid bigserial not null constraint swap_pkey primary key,
+	block$ int not null,
+	constraint swap_id_key unique (id)
+);
+`,
+			`
+create table if not exists %%SCHEMA%%.swap
+(
+	id text not null,
+
+	"pair" text not null,
+
+	vid bigserial not null,
+	block$ int not null,
+	constraint swap_id_key unique (id, "block$"),
+	constraint swap_pkey primary key (vid, "block$")
+) partition by range ("block$");
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := partitionCreateTable(test.statement, test.table, test.immutable)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	unnamed, err := partitionCreateTable("create table %%SCHEMA%%.token (id text not null, vid bigserial not null primary key, block_range int4range not null);", "token", false)
+	require.NoError(t, err)
+	assert.Equal(t, "create table %%SCHEMA%%.token (id text not null, vid bigserial not null, block_range int4range not null,\n\tconstraint token_pkey primary key (vid, block_range)\n) partition by range (block_range);", unnamed)
+
+	_, err = partitionCreateTable(`create table if not exists %%SCHEMA%%.token (id text not null);`, "swap", true)
+	assert.EqualError(t, err, `table "swap": create statement not found`)
+}
+
+func TestCreatePartitionStatement(t *testing.T) {
+	assert.Equal(t,
+		`create table if not exists sgd1.swap_p12 partition of sgd1.swap for values from (1200000) to (1300000)`,
+		createPartitionStatement("sgd1", "swap", true, 100000, 12),
+	)
+	assert.Equal(t,
+		`create table if not exists sgd1.pair_p12 partition of sgd1.pair for values from ('[1200000,1200001)') to ('[1300000,1300001)')`,
+		createPartitionStatement("sgd1", "pair", false, 100000, 12),
+	)
+}
+
+// TestStore_PartitionedConformance runs the conformance tests against a store whose entity
+// table is partitioned every 10 blocks, so they save, revert and prune across partitions.
+func TestStore_PartitionedConformance(t *testing.T) {
+	dsn := testDSN(t)
+
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		schema := fmt.Sprintf("partitioned_%d", time.Now().UnixNano())
+		def := &subgraph.Definition{PackageName: "conformance", Entities: storagetest.Registry()}
+		s, err := New(zap.NewNop(), metrics.NewBlockMetrics(), dsn, schema, "conformance", def, map[string]bool{}, true)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, _ = s.db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+			_ = s.db.Close()
+		})
+
+		createTable := conformanceDDL[strings.Index(conformanceDDL, "create table %%SCHEMA%%.test_entity"):strings.Index(conformanceDDL, "create table %%SCHEMA%%.\"poi2$\"")]
+		partitioned, err := partitionCreateTable(createTable, "test_entity", false)
+		require.NoError(t, err)
+
+		ddl := strings.Replace(conformanceDDL, createTable, partitioned, 1)
+		_, err = s.db.Exec(strings.ReplaceAll(ddl, "%%SCHEMA%%", schema))
+		require.NoError(t, err)
+		require.NoError(t, createStoreTables(schema, func(statement string) error {
+			_, err := s.db.Exec(statement)
+			return err
+		}))
+		_, err = s.db.Exec(fmt.Sprintf("insert into %s.partitioning (table_name, partition_size) values ('test_entity', 10)", schema))
+		require.NoError(t, err)
+
+		require.NoError(t, s.RegisterEntities())
+		require.True(t, s.isPartitioned("test_entity"))
+		return s
+	})
+}
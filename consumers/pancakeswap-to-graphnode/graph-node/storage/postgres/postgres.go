@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/abourget/llerrgroup"
@@ -37,6 +38,27 @@ type store struct {
 	logger                *zap.Logger
 	subgraphDeploymentID  string
 	notifyTag             int64
+
+	// trackDeploymentHead is set when the deployment is registered in graph-node's metadata,
+	// its head is then moved forward with every flushed block
+	trackDeploymentHead bool
+
+	// earliestBlock mirrors the `pruning` table, it is read on every load
+	earliestBlock uint64
+
+	// immutableColumns holds the select columns of the immutable tables
+	immutableColumns map[string]string
+
+	// latestTables are the tables whose `<table>_latest` mirror is maintained
+	latestTables map[string]bool
+
+	// partitionSizes mirrors the `partitioning` table, partitionedUpTo is the block up to
+	// which the partitions of every partitioned table exist
+	partitionSizes  map[string]uint64
+	partitionedUpTo uint64
+
+	// readOnly is set by `NewReader`, loads then always read the table at the requested block
+	readOnly bool
 }
 
 type storeEventChangeData struct {
@@ -86,6 +108,13 @@ func New(
 		return nil, fmt.Errorf("creating database: %w", err)
 	}
 
+	immutableColumns := map[string]string{}
+	for tableName, entityType := range subgraph.Entities.Data() {
+		if subgraph.Entities.IsImmutable(tableName) {
+			immutableColumns[tableName] = immutableSelectColumns(entityType)
+		}
+	}
+
 	return &store{
 		db:                    db,
 		metrics:               metrics,
@@ -103,6 +132,10 @@ func New(
 		neverReadFromDB: entitiesNeverReadFromDB,
 		logger:          logger,
 		withTransaction: withTransaction,
+
+		immutableColumns: immutableColumns,
+		latestTables:     map[string]bool{},
+		partitionSizes:   map[string]uint64{},
 	}, nil
 }
 
@@ -128,6 +161,36 @@ func (s *store) StartLogger(ctx context.Context) {
 }
 
 func (s *store) RegisterEntities() error {
+	drifts, err := CheckSchema(context.Background(), s.db, s.schemaName, s.subgraph.Entities)
+	if err != nil {
+		return fmt.Errorf("checking schema: %w", err)
+	}
+	if len(drifts) > 0 {
+		return &DriftError{Schema: s.schemaName, Drifts: drifts}
+	}
+
+	if err := s.loadEarliestBlock(context.Background()); err != nil {
+		return err
+	}
+
+	if err := s.loadPartitioning(context.Background()); err != nil {
+		return err
+	}
+
+	if err := s.loadImmutableLayout(context.Background()); err != nil {
+		return err
+	}
+
+	if err := s.loadLatestTables(context.Background()); err != nil {
+		return err
+	}
+
+	tracked, err := s.deploymentRegistered(context.Background())
+	if err != nil {
+		return err
+	}
+	s.trackDeploymentHead = tracked
+
 	for _, entity := range s.subgraph.Entities.Entities() {
 		if err := s.registerStatements(entity); err != nil {
 			return err
@@ -138,8 +201,12 @@ func (s *store) RegisterEntities() error {
 }
 
 func (s *store) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64) (out []graphnode.Entity, err error) {
+	if err := s.checkNotPruned(blockNum); err != nil {
+		return nil, err
+	}
+
 	tableName := graphnode.GetTableName(model)
-	query := fmt.Sprintf("SELECT * FROM %s.%s WHERE block_range @> %d", s.schemaName, tableName, blockNum)
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s", s.selectColumns(tableName), s.schemaName, tableName, s.containsBlock(tableName, fmt.Sprint(blockNum)))
 
 	// FIXME: Could we somehow be able to correctly have a []graphnode.Entity directly? Here we create a new empty pointer
 	// to a slice of the specific "models" type (for example `models.Pair`). This is used by `SelectContext` to know how
@@ -158,16 +225,74 @@ func (s *store) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blo
 	out = make([]graphnode.Entity, modelCount)
 	for i := 0; i < modelCount; i++ {
 		out[i] = models.Index(i).Interface().(graphnode.Entity)
+		out[i].SetExists(true)
 	}
 	return
 }
 
+func (s *store) StreamAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64, handle func(ent graphnode.Entity) error) error {
+	if err := s.checkNotPruned(blockNum); err != nil {
+		return err
+	}
+
+	tableName := graphnode.GetTableName(model)
+	order := "lower(block_range), vid"
+	if s.isImmutable(tableName) {
+		order = fmt.Sprintf("%q, vid", graphnode.BlockColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s ORDER BY %s", s.selectColumns(tableName), s.schemaName, tableName, s.containsBlock(tableName, fmt.Sprint(blockNum)), order)
+
+	rows, err := s.db.QueryxContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("stream all from %q: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	modelType := reflect.TypeOf(model).Elem()
+	for rows.Next() {
+		ent := reflect.New(modelType).Interface().(graphnode.Entity)
+		if err := rows.StructScan(ent); err != nil {
+			return fmt.Errorf("scanning %q entity: %w", tableName, err)
+		}
+		ent.SetExists(true)
+		if err := handle(ent); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("stream all from %q: %w", tableName, err)
+	}
+	return nil
+}
+
+func (s *store) LoadHistory(ctx context.Context, id string, model graphnode.Entity) (out []graphnode.Entity, err error) {
+	tableName := graphnode.GetTableName(model)
+	order := "lower(block_range), vid"
+	if s.isImmutable(tableName) {
+		order = "vid"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE id = $1 ORDER BY %s", s.selectColumns(tableName), s.schemaName, tableName, order)
+
+	modelsPtr := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+	if err := s.db.SelectContext(ctx, modelsPtr.Interface(), query, id); err != nil {
+		return nil, fmt.Errorf("load history of %q from %q: %w", id, tableName, err)
+	}
+
+	models := modelsPtr.Elem()
+	for i := 0; i < models.Len(); i++ {
+		ent := models.Index(i).Interface().(graphnode.Entity)
+		ent.SetExists(true)
+		out = append(out, ent)
+	}
+	return out, nil
+}
+
 func (s *store) registerStatements(ent graphnode.Entity) error {
 	tableName := graphnode.GetTableName(ent)
 	s.logger.Info("registering entity", zap.String("table_name", tableName))
 
 	// load
-	query := fmt.Sprintf("SELECT * FROM %q.%q WHERE id = $1 and block_range @> $2", s.schemaName, tableName)
+	query := fmt.Sprintf("SELECT %s FROM %q.%q WHERE id = $1 and %s", s.selectColumns(tableName), s.schemaName, tableName, s.containsBlock(tableName, "$2"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
@@ -178,7 +303,7 @@ func (s *store) registerStatements(ent graphnode.Entity) error {
 	s.loadStmts[tableName] = queryPreparedStmt
 	s.logger.Info("query registered")
 
-	save := buildSaveQuery(s.schemaName, tableName, ent)
+	save := buildSaveQuery(s.schemaName, tableName, ent, s.isImmutable(tableName))
 	s.saveStmts[tableName] = save
 
 	savePrepared, err := s.db.PrepareNamedContext(ctx, save)
@@ -188,6 +313,11 @@ func (s *store) registerStatements(ent graphnode.Entity) error {
 	s.preparedSaveStmts[tableName] = savePrepared
 	s.logger.Info("save statement registered")
 
+	// Immutable entities are never updated, their block range is never closed
+	if s.isImmutable(tableName) {
+		return nil
+	}
+
 	update := fmt.Sprintf(`UPDATE %q.%q
 	                       SET
                              "_updated_block_number" = $1,
@@ -209,9 +339,13 @@ func (s *store) registerStatements(ent graphnode.Entity) error {
 }
 
 func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string, blockTime time.Time, updates map[string]map[string]graphnode.Entity, cursor string) (err error) {
-	// It seems we are called even if the context is done, we should find the upstream where abortion should be called instead of here, a bit hackish for now
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	// Nothing was written yet, the block is reported as not saved so the caller does not move on
 	if ctx.Err() != nil {
-		return nil
+		return fmt.Errorf("block %d not saved: %w", blockNum, ctx.Err())
 	}
 
 	// The save operation must complete fully, so we use an independent context. We then start a go routine
@@ -232,6 +366,10 @@ func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string
 		}
 	}()
 
+	if err := s.ensurePartitions(saveCtx, blockNum); err != nil {
+		return fmt.Errorf("block %d not saved: %w", blockNum, err)
+	}
+
 	trxs := []*sqlx.Tx{}
 	eg := llerrgroup.New(saveConcurrentUpdates)
 	for tableName, entities := range updates {
@@ -255,6 +393,11 @@ func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string
 			if err != nil {
 				return fmt.Errorf("batch saving: %w", err)
 			}
+			if s.latestTables[theTableName] {
+				if err := s.saveLatest(saveCtx, tx, theTableName, theEntities); err != nil {
+					return fmt.Errorf("saving latest: %w", err)
+				}
+			}
 			return nil
 		})
 	}
@@ -273,7 +416,7 @@ func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string
 	}
 	trxs = append(trxs, depTx)
 
-	if err = s.updateDeploymentHead(ctx, depTx, blockNum, blockHash); err != nil {
+	if err = s.updateDeploymentHead(saveCtx, depTx, blockNum, blockHash); err != nil {
 		s.rollback(saveCtx, trxs)
 		return fmt.Errorf("unable to save subgraph deployemnt head: %w", err)
 	}
@@ -364,6 +507,13 @@ func (s *store) mustCommit(trxs []*sqlx.Tx) {
 }
 
 func (s *store) batchSave(ctx context.Context, dbTx *sqlx.Tx, blockNum uint64, tableName string, entities map[string]graphnode.Entity) (err error) {
+	// Only new immutable entities reach the insert, there is no block range to close
+	if s.subgraph.Entities.IsImmutable(tableName) {
+		if err := checkImmutableUpdates(tableName, entities); err != nil {
+			return err
+		}
+	}
+
 	// This for loop is ONLY for updating the block ranges.
 	var jsonSnips []string
 	for id, ent := range entities {
@@ -378,6 +528,7 @@ func (s *store) batchSave(ctx context.Context, dbTx *sqlx.Tx, blockNum uint64, t
 			if !ent.Exists() {
 				continue
 			}
+			s.persistentCache.Invalidate(tableName, id)
 		}
 		blockRange := ent.GetBlockRange()
 		if blockRange == nil {
@@ -404,7 +555,7 @@ func (s *store) batchSave(ctx context.Context, dbTx *sqlx.Tx, blockNum uint64, t
 		// example: '[[2168648,"[1407391,1407392)"],[...]]'
 		startUpdate := time.Now()
 		err := s.UpdateBlockRange(ctx, dbTx, tableName, blockNum, jsonArray)
-		s.metrics.Exec.StoreUpdatesOnly += time.Since(startUpdate)
+		s.metrics.Exec.Record(func(e *metrics.ExecutionTime) { e.StoreUpdatesOnly += time.Since(startUpdate) })
 		if err != nil {
 			return fmt.Errorf("error updating block range: %w", err)
 		}
@@ -430,13 +581,15 @@ func (s *store) batchSave(ctx context.Context, dbTx *sqlx.Tx, blockNum uint64, t
 	if len(processableEntities) == 0 {
 		return nil
 	}
-	s.metrics.Exec.StoreSave += int64(len(processableEntities))
-	s.metrics.Exec.StoreCall += 1
+	s.metrics.Exec.Record(func(e *metrics.ExecutionTime) {
+		e.StoreSave += int64(len(processableEntities))
+		e.StoreCall += 1
+	})
 	s.firstBlockWritten = true
 
 	startInsert := time.Now()
 	defer func() {
-		s.metrics.Exec.StoreInsertsOnly += time.Since(startInsert)
+		s.metrics.Exec.Record(func(e *metrics.ExecutionTime) { e.StoreInsertsOnly += time.Since(startInsert) })
 	}()
 
 	if len(processableEntities) == 1 {
@@ -509,45 +662,95 @@ func (s *store) batchSave(ctx context.Context, dbTx *sqlx.Tx, blockNum uint64, t
 }
 
 func (s *store) CleanDataAtBlock(ctx context.Context, blockNum uint64) error {
-	return s.cleanDBAboveBlockNum(blockNum)
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	return s.inRevertTransaction(ctx, func(tx *sqlx.Tx) error {
+		return s.cleanDBAboveBlockNum(ctx, tx, blockNum)
+	})
+}
+
+// inRevertTransaction runs `revert` in a single transaction: the versions of every table
+// and their latest mirrors are reverted together or not at all.
+func (s *store) inRevertTransaction(ctx context.Context, revert func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err := revert(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
 }
 
-func (s *store) cleanDBAboveBlockNum(blockNum uint64) error {
+func (s *store) cleanDBAboveBlockNum(ctx context.Context, tx *sqlx.Tx, blockNum uint64) error {
 	badBlockNum := blockNum + 1
 	for table := range s.subgraph.Entities.Data() {
-		deleteStmt := fmt.Sprintf("DELETE FROM %s.%s where _updated_block_number = %d and upper(block_range) is null", s.schemaName, table, badBlockNum)
-		updateStmt := fmt.Sprintf("UPDATE %s.%s set block_range = int4range(lower(block_range), NULL) where block_range @> %d and upper(block_range) = %d and _updated_block_number = %d", s.schemaName, table, blockNum, badBlockNum, badBlockNum)
+		if s.isImmutable(table) {
+			deleteStmt := fmt.Sprintf("DELETE FROM %s.%s where %q >= %d returning id", s.schemaName, table, graphnode.BlockColumn, badBlockNum)
+			ids, err := s.execInvalidating(ctx, tx, table, deleteStmt)
+			if err != nil {
+				return fmt.Errorf("delete rows of table: %s where %s >= %d: %w", table, graphnode.BlockColumn, badBlockNum, err)
+			}
+			s.logger.Info("deleted immutable rows above block", zap.String("table", table), zap.Uint64("bad_block_num", badBlockNum), zap.Int("effected_rows", len(ids)))
+			continue
+		}
+
+		deleteStmt := fmt.Sprintf("DELETE FROM %s.%s where _updated_block_number = %d and upper(block_range) is null returning id", s.schemaName, table, badBlockNum)
+		updateStmt := fmt.Sprintf("UPDATE %s.%s set block_range = int4range(lower(block_range), NULL) where block_range @> %d and upper(block_range) = %d and _updated_block_number = %d returning id", s.schemaName, table, blockNum, badBlockNum, badBlockNum)
 
 		startDel := time.Now()
-		res, err := s.db.Exec(deleteStmt)
+		deletedIDs, err := s.execInvalidating(ctx, tx, table, deleteStmt)
 		if err != nil {
 			return fmt.Errorf("delete rows of table: %s where range is [%d, ]: %w", table, badBlockNum, err)
 		}
-
-		affectedRowCount, err := res.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("deleted effect row: %w", err)
-		}
-		s.logger.Info("deleted rows with bad block_range", zap.String("table", table), zap.Uint64("bad_block_num", badBlockNum), zap.Int64("effected_rows", affectedRowCount), zap.Duration("duration", time.Since(startDel)))
+		s.logger.Info("deleted rows with bad block_range", zap.String("table", table), zap.Uint64("bad_block_num", badBlockNum), zap.Int("effected_rows", len(deletedIDs)), zap.Duration("duration", time.Since(startDel)))
 
 		startUpd := time.Now()
-		res, err = s.db.Exec(updateStmt)
+		updatedIDs, err := s.execInvalidating(ctx, tx, table, updateStmt)
 		if err != nil {
 			return fmt.Errorf("update rows of table: %s where upper(block_range) is [%d, ]: %w", table, badBlockNum, err)
 		}
+		s.logger.Info("updated rows with bad upper block_range", zap.String("table", table), zap.Uint64("bad_block_num", badBlockNum), zap.Int("effected_rows", len(updatedIDs)), zap.Duration("duration", time.Since(startUpd)))
 
-		affectedRowCount, err = res.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("updated effect row: %w", err)
+		if err := s.syncLatest(ctx, tx, table, append(deletedIDs, updatedIDs...)); err != nil {
+			return err
 		}
-		s.logger.Info("updated rows with bad upper block_range", zap.String("table", table), zap.Uint64("bad_block_num", badBlockNum), zap.Int64("effected_rows", affectedRowCount), zap.Duration("duration", time.Since(startUpd)))
 	}
 	return nil
 }
 
-func buildSaveQuery(schemaName, tableName string, ent graphnode.Entity) string {
+// execInvalidating runs a statement returning the `id` of the affected rows and removes
+// those ids from the entity cache. The ids are returned, once per affected row.
+func (s *store) execInvalidating(ctx context.Context, tx *sqlx.Tx, table string, stmt string) (ids []string, err error) {
+	rows, err := tx.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ids, fmt.Errorf("cannot read rows: %w", err)
+		}
+		ids = append(ids, id)
+		s.persistentCache.Invalidate(table, id)
+	}
+	return ids, rows.Err()
+}
+
+func buildSaveQuery(schemaName, tableName string, ent graphnode.Entity, immutable bool) string {
 	fields := []string{`"id"`, `"block_range"`, `_updated_block_number`}
 	colonFields := []string{":id", ":block_range", `:_updated_block_number`}
+	if immutable {
+		fields = []string{`"id"`, fmt.Sprintf("%q", graphnode.BlockColumn)}
+		colonFields = []string{":id", ":_updated_block_number"}
+	}
 
 	for _, el := range graphnode.DBFields(reflect.TypeOf(ent)) {
 		if el.Base {
@@ -563,42 +766,53 @@ func buildSaveQuery(schemaName, tableName string, ent graphnode.Entity) string {
 func (s *store) Load(ctx context.Context, id string, ent graphnode.Entity, blockNum uint64) error {
 	startOne := time.Now()
 	defer func() {
-		s.metrics.Exec.FullLoadTime += time.Since(startOne)
+		s.metrics.Exec.Record(func(e *metrics.ExecutionTime) { e.FullLoadTime += time.Since(startOne) })
 	}()
-	tableName := graphnode.GetTableName(ent)
-
-	found := s.persistentCache.GetEntity(tableName, id, ent)
-	if found {
-		return nil
+	if err := s.checkNotPruned(blockNum); err != nil {
+		return err
 	}
 
-	if cacheable, ok := ent.(graphnode.Cacheable); ok {
-		if cacheable.SkipDBLookup() {
+	tableName := graphnode.GetTableName(ent)
+
+	// The cache holds the latest versions only, readers may ask for any block
+	if !s.readOnly {
+		found := s.persistentCache.GetEntity(tableName, id, ent)
+		if found {
 			return nil
 		}
-	}
 
-	if s.firstBlockWritten && s.neverReadFromDB[tableName] {
-		return nil
+		if cacheable, ok := ent.(graphnode.Cacheable); ok {
+			if cacheable.SkipDBLookup() {
+				return nil
+			}
+		}
+
+		if s.firstBlockWritten && s.neverReadFromDB[tableName] {
+			return nil
+		}
 	}
 
 	// FIXME syntax error with @> using a prepared named statement
 	// LIMIT 1 is an optimization because GIST index is cannot be UNIQUE, but we assume it is.
 	// The index can have an additional uniqueness constraint, but it won't help performance, on the contrary.
-	query := fmt.Sprintf("SELECT * FROM "+s.schemaName+"."+tableName+" WHERE id = '%s' and block_range @> %d LIMIT 1", id, blockNum)
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE id = $1 and %s LIMIT 1", s.selectColumns(tableName), s.schemaName, tableName, s.containsBlock(tableName, fmt.Sprint(blockNum)))
 
 	//stmt := s.loadStmts[tableName]
 	start := time.Now()
 	defer func() {
-		s.metrics.Exec.SelectQueries += time.Since(start)
-		s.metrics.Exec.SelectQueriesCounts[tableName]++
 		duration := time.Since(start)
-		s.metrics.Exec.SelectQueriesDurations[tableName] += duration
+		s.metrics.Exec.Record(func(e *metrics.ExecutionTime) {
+			e.SelectQueries += duration
+			e.SelectQueriesCounts[tableName]++
+			e.SelectQueriesDurations[tableName] += duration
+		})
 		if duration > time.Millisecond*100 {
 			s.logger.Info("slow query from DB", zap.Bool("found", ent.Exists()), zap.Duration("duration", duration), zap.String("query", query), zap.Uint64("block_num", blockNum), zap.String("id", id))
 		}
 
-		s.persistentCache.SetEntity(tableName, ent) // existing OR non-existing will be cached
+		if !s.readOnly {
+			s.persistentCache.SetEntity(tableName, ent) // existing OR non-existing will be cached
+		}
 	}()
 
 	// We are creating a temporary Entity as to not override the caller's
@@ -607,7 +821,7 @@ func (s *store) Load(ctx context.Context, id string, ent graphnode.Entity, block
 	// i.e. a *bool which is set to null would be changed to (false)
 	entType, _ := s.subgraph.Entities.GetType(tableName)
 	tempEnt := reflect.New(entType).Interface()
-	err := s.db.GetContext(ctx, tempEnt, query)
+	err := s.db.GetContext(ctx, tempEnt, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil
@@ -631,8 +845,10 @@ func (s *store) EntityForID(ctx context.Context, tableName string, id string, en
 		}
 		return fmt.Errorf("get with context %q: %w", id, err)
 	}
-	s.metrics.Exec.SelectQueriesCounts[tableName]++
-	s.metrics.Exec.SelectQueriesDurations[tableName] += time.Since(start)
+	s.metrics.Exec.Record(func(e *metrics.ExecutionTime) {
+		e.SelectQueriesCounts[tableName]++
+		e.SelectQueriesDurations[tableName] += time.Since(start)
+	})
 	entity.SetExists(true)
 	return
 }
@@ -656,9 +872,17 @@ func (s *store) UpdateBlockRange(ctx context.Context, dbTx *sqlx.Tx, tableName s
 }
 
 func (s *store) updateDeploymentHead(ctx context.Context, tx *sqlx.Tx, blockNumer uint64, blockHash string) error {
-	return nil //todo: fix this please
+	if !s.trackDeploymentHead {
+		return nil
+	}
+
+	hash, err := hex.DecodeString(strings.TrimPrefix(blockHash, "0x"))
+	if err != nil {
+		return fmt.Errorf("decoding block hash %q: %w", blockHash, err)
+	}
+
 	updateDeploymentQuery := "update subgraphs.subgraph_deployment set latest_ethereum_block_number=$1, latest_ethereum_block_hash=$2 where deployment = $3"
-	result, err := tx.ExecContext(ctx, updateDeploymentQuery, blockNumer, blockHash, s.subgraphDeploymentID)
+	result, err := tx.ExecContext(ctx, updateDeploymentQuery, blockNumer, hash, s.subgraphDeploymentID)
 	if err != nil {
 		return fmt.Errorf("failed updating subgraph %q at block %d: %w", s.subgraphDeploymentID, blockNumer, err)
 	}
@@ -689,8 +913,16 @@ func (s *store) LoadCursor(ctx context.Context) (string, error) {
 		Cursor string `db:"cursor"`
 	}{}
 
-	// create the table if not exists:
-	_, _ = s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.cursor (id integer PRIMARY KEY, cursor text);`, s.schemaName))
+	if s.readOnly {
+		// A deployment written by graph-node has no cursor
+		exists, err := s.tableExists(ctx, "cursor")
+		if err != nil || !exists {
+			return "", err
+		}
+	} else {
+		// create the table if not exists:
+		_, _ = s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.cursor (id integer PRIMARY KEY, cursor text);`, s.schemaName))
+	}
 	if err := s.db.GetContext(ctx, &row, fmt.Sprintf("SELECT * FROM %s.cursor WHERE id = 1", s.schemaName)); err != nil {
 		if err == sql.ErrNoRows {
 			return "", nil // nothing exists yet
@@ -703,9 +935,27 @@ func (s *store) LoadCursor(ctx context.Context) (string, error) {
 }
 
 func (s *store) CleanUpFork(ctx context.Context, longestChainStartBlock uint64) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 	//longestChainStartBlock:  first new block on the longest chain (after the common ancestor)
+	return s.inRevertTransaction(ctx, func(tx *sqlx.Tx) error {
+		return s.cleanUpFork(ctx, tx, longestChainStartBlock)
+	})
+}
 
+func (s *store) cleanUpFork(ctx context.Context, tx *sqlx.Tx, longestChainStartBlock uint64) error {
 	for table := range s.subgraph.Entities.Data() {
+		if s.isImmutable(table) {
+			deleteStmt := fmt.Sprintf("delete from %s.%s where %q >= %d returning id", s.schemaName, table, graphnode.BlockColumn, longestChainStartBlock)
+			ids, err := s.execInvalidating(ctx, tx, table, deleteStmt)
+			if err != nil {
+				return fmt.Errorf("delete rows of table: %s where %s >= %d: %w", table, graphnode.BlockColumn, longestChainStartBlock, err)
+			}
+			s.logger.Info("deleted immutable rows because of a fork", zap.String("table", table), zap.Uint64("new_head", longestChainStartBlock), zap.Int("affected_rows", len(ids)))
+			continue
+		}
+
 		// SCENARIOS
 		//a,1,1,"[1,10)",10,k
 		//a,2,2,"[10,20)",20,k
@@ -750,45 +1000,32 @@ func (s *store) CleanUpFork(ctx context.Context, longestChainStartBlock uint64)
 		s.logger.Info("cleaning fork", zap.String("delete_statement", deleteStmt), zap.String("update_statement", updateStmt))
 
 		startDel := time.Now()
-		rows, err := s.db.QueryContext(ctx, deleteStmt)
+		deletedIDs, err := s.execInvalidating(ctx, tx, table, deleteStmt)
 		if err != nil {
 			return fmt.Errorf("delete rows of table: %s where _updated_block_number > %d: %w", table, longestChainStartBlock, err)
 		}
-
-		var count int
-		for rows.Next() {
-			count++
-			var id string
-			if err := rows.Scan(&id); err != nil {
-				return fmt.Errorf("cannot read rows: %w", err)
-			}
-			s.persistentCache.Invalidate(table, id)
-		}
-
-		s.logger.Info("deleted rows because of a fork", zap.String("table", table), zap.Uint64("new_head", longestChainStartBlock), zap.Int("affected_rows", count), zap.Duration("duration", time.Since(startDel)))
+		s.logger.Info("deleted rows because of a fork", zap.String("table", table), zap.Uint64("new_head", longestChainStartBlock), zap.Int("affected_rows", len(deletedIDs)), zap.Duration("duration", time.Since(startDel)))
 
 		startUpd := time.Now()
-		rows, err = s.db.QueryContext(ctx, updateStmt)
+		updatedIDs, err := s.execInvalidating(ctx, tx, table, updateStmt)
 		if err != nil {
 			return fmt.Errorf("update rows of table: %s where _updated_block_number = %d: %w", table, longestChainStartBlock, err)
 		}
+		s.logger.Info("updated rows because of a fork", zap.String("table", table), zap.Uint64("new_head", longestChainStartBlock), zap.Int("affected_rows", len(updatedIDs)), zap.Duration("duration", time.Since(startUpd)))
 
-		count = 0
-		for rows.Next() {
-			count++
-			var id string
-			if err := rows.Scan(&id); err != nil {
-				return fmt.Errorf("cannot read rows: %w", err)
-			}
-			s.persistentCache.Invalidate(table, id)
+		// The entities whose open version changed get their mirror row rewritten
+		if err := s.syncLatest(ctx, tx, table, append(deletedIDs, updatedIDs...)); err != nil {
+			return err
 		}
-		s.logger.Info("updated rows because of a fork", zap.String("table", table), zap.Uint64("new_head", longestChainStartBlock), zap.Int("affected_rows", count), zap.Duration("duration", time.Since(startUpd)))
 
 	}
 	return nil
 }
 
 func (s *store) TruncateAll(ctx context.Context, confirmFunc func(tables []string) (bool, error)) (bool, error) {
+	if err := s.checkWritable(); err != nil {
+		return false, err
+	}
 	sqlStmts := []string{}
 	labels := []string{}
 	for _, tbl := range SystemTables {
@@ -803,6 +1040,10 @@ func (s *store) TruncateAll(ctx context.Context, confirmFunc func(tables []strin
 		sqlStmts = append(sqlStmts, sqlStmt)
 		labels = append(labels, label)
 	}
+	for table := range s.latestTables {
+		sqlStmts = append(sqlStmts, s.truncateStmt(LatestTableName(table)))
+		labels = append(labels, fmt.Sprintf("%s.%s", s.schemaName, LatestTableName(table)))
+	}
 	ok, err := confirmFunc(labels)
 	if err != nil {
 		return false, fmt.Errorf("confirmation failed: %w", err)
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+)
+
+func (s *store) LoadProvenance(ctx context.Context) (out []*storage.Provenance, err error) {
+	// Schemas created before provenance was recorded have nothing recorded until migrated
+	exists, err := s.tableExists(ctx, "provenance")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT package_name, package_version, module_name, module_hash, start_block, since_block FROM %s.provenance ORDER BY id`, s.schemaName)
+	if err := s.db.SelectContext(ctx, &out, query); err != nil {
+		return nil, fmt.Errorf("loading provenance: %w", err)
+	}
+	return out, nil
+}
+
+func (s *store) SaveProvenance(ctx context.Context, provenance *storage.Provenance) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	exists, err := s.tableExists(ctx, "provenance")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("table %s.provenance does not exist, run `exchange migrate` to create it", s.schemaName)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s.provenance (package_name, package_version, module_name, module_hash, start_block, since_block) VALUES (:package_name, :package_version, :module_name, :module_hash, :start_block, :since_block)`, s.schemaName)
+	if _, err := s.db.NamedExecContext(ctx, query, provenance); err != nil {
+		return fmt.Errorf("saving provenance: %w", err)
+	}
+	return nil
+}
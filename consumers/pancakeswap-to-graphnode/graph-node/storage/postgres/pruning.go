@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+// loadEarliestBlock caches the earliest queryable block held by the `pruning` table, the
+// schemas created before it are not pruned until migrated.
+func (s *store) loadEarliestBlock(ctx context.Context) error {
+	exists, err := s.tableExists(ctx, "pruning")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		s.logger.Warn("pruning table missing, run `exchange migrate` to create it", zap.String("schema", s.schemaName))
+		return nil
+	}
+
+	var earliestBlock uint64
+	if err := s.db.GetContext(ctx, &earliestBlock, fmt.Sprintf(`SELECT coalesce(max(earliest_block), 0) FROM %s.pruning`, s.schemaName)); err != nil {
+		return fmt.Errorf("loading earliest block: %w", err)
+	}
+
+	atomic.StoreUint64(&s.earliestBlock, earliestBlock)
+	return nil
+}
+
+func (s *store) checkNotPruned(blockNum uint64) error {
+	if earliest := atomic.LoadUint64(&s.earliestBlock); blockNum < earliest {
+		return storage.NewBlockPrunedError(blockNum, earliest)
+	}
+	return nil
+}
+
+func (s *store) EarliestBlock(ctx context.Context) (uint64, error) {
+	return atomic.LoadUint64(&s.earliestBlock), nil
+}
+
+// PruneBelow records `earliestBlock` then deletes, table by table, at most `batchSize`
+// versions closed at or before it. Only closed versions are deleted, so the entity
+// cache, which holds the open ones, is left untouched. Each batch is its own short
+// statement, saves are never waiting on a long running delete.
+func (s *store) PruneBelow(ctx context.Context, earliestBlock uint64, batchSize int) (deleted int, err error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s.pruning (id, earliest_block) VALUES (1, $1) ON CONFLICT (id) DO UPDATE SET earliest_block = greatest(%s.pruning.earliest_block, excluded.earliest_block)`, s.schemaName, s.schemaName)
+	if _, err := s.db.ExecContext(ctx, query, earliestBlock); err != nil {
+		return 0, fmt.Errorf("saving earliest block: %w", err)
+	}
+
+	// Once recorded, loads below the earliest block must fail before any of their versions
+	// is deleted
+	if earliestBlock > atomic.LoadUint64(&s.earliestBlock) {
+		atomic.StoreUint64(&s.earliestBlock, earliestBlock)
+	}
+
+	tables := make([]string, 0, s.subgraph.Entities.Len())
+	for table := range s.subgraph.Entities.Data() {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if deleted >= batchSize {
+			break
+		}
+		// Immutable entities are never closed, there is nothing to prune
+		if s.isImmutable(table) {
+			continue
+		}
+
+		// The condition matches the `<table>_block_range_closed` index of the DDL
+		stmt := fmt.Sprintf(`DELETE FROM %s.%s WHERE vid IN (SELECT vid FROM %s.%s WHERE COALESCE(upper(block_range), 2147483647) <= %d LIMIT %d)`, s.schemaName, table, s.schemaName, table, earliestBlock, batchSize-deleted)
+		res, err := s.db.ExecContext(ctx, stmt)
+		if err != nil {
+			return deleted, fmt.Errorf("pruning table %s: %w", table, err)
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("pruning table %s: %w", table, err)
+		}
+		deleted += int(count)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streamingfast/substream-pancakeswap/graph-node/metrics"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+var ErrReadOnly = errors.New("store is read-only")
+
+// NewReader opens the schema `subgraphSchema` for reading only, as the commands inspecting
+// a deployment do. Unlike `New` followed by `RegisterEntities`, nothing is created nor
+// prepared and the schema is not checked against the entities, a stock graph-node
+// deployment can be read. Entities are loaded from their table at the requested block,
+// bypassing the entity cache and `SkipDBLookup`.
+func NewReader(logger *zap.Logger, dsn string, subgraphSchema string, subgraph *subgraph.Definition) (*store, error) {
+	s, err := New(logger, metrics.NewBlockMetrics(), dsn, subgraphSchema, "", subgraph, map[string]bool{}, false)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+
+	if err := s.loadEarliestBlock(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadImmutableLayout(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *store) tableExists(ctx context.Context, table string) (bool, error) {
+	var exists bool
+	if err := s.db.GetContext(ctx, &exists, `SELECT to_regclass($1) IS NOT NULL`, fmt.Sprintf("%s.%q", s.schemaName, table)); err != nil {
+		return false, fmt.Errorf("looking up table %s.%s: %w", s.schemaName, table, err)
+	}
+	return exists, nil
+}
+
+func (s *store) checkWritable() error {
+	if s.readOnly {
+		return fmt.Errorf("schema %s: %w", s.schemaName, ErrReadOnly)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage/storagetest"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReader(t *testing.T) {
+	ctx := context.Background()
+	dsn := testDSN(t)
+	writer := newTestStore(t, dsn)
+
+	save := func(blockNum uint64, ent *storagetest.TestEntity) {
+		current := &storagetest.TestEntity{Base: graphnode.NewBase(ent.ID)}
+		require.NoError(t, writer.Load(ctx, ent.ID, current, blockNum))
+		if current.Exists() {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+		updates := map[string]map[string]graphnode.Entity{"test_entity": {ent.ID: ent}}
+		require.NoError(t, writer.BatchSave(ctx, blockNum, "", time.Time{}, updates, "cursor"))
+	}
+	save(10, storagetest.NewTestEntity("a", "a1", 1))
+	save(20, storagetest.NewTestEntity("a", "a2", 2))
+
+	reader, err := NewReader(zap.NewNop(), dsn, writer.schemaName, &subgraph.Definition{PackageName: "conformance", Entities: storagetest.Registry()})
+	require.NoError(t, err)
+
+	// Every load reads the version valid at its block, the first one read is not served again
+	for _, test := range []struct {
+		blockNum uint64
+		name     string
+	}{{15, "a1"}, {25, "a2"}, {12, "a1"}} {
+		ent := &storagetest.TestEntity{Base: graphnode.NewBase("a")}
+		require.NoError(t, reader.Load(ctx, "a", ent, test.blockNum))
+		require.True(t, ent.Exists(), "block %d", test.blockNum)
+		assert.Equal(t, test.name, ent.Name, "block %d", test.blockNum)
+	}
+
+	ent := &storagetest.TestEntity{Base: graphnode.NewBase("a")}
+	require.NoError(t, reader.Load(ctx, "a", ent, 5))
+	assert.False(t, ent.Exists())
+
+	cursor, err := reader.LoadCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cursor", cursor)
+
+	err = reader.BatchSave(ctx, 30, "", time.Time{}, map[string]map[string]graphnode.Entity{}, "")
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
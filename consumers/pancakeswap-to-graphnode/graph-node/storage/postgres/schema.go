@@ -0,0 +1,328 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+// Drift is a difference between an entity and the table it is stored in.
+type Drift struct {
+	Table   string
+	Column  string // empty when the whole table is missing
+	Problem string
+
+	// additive drifts can be fixed by `Migrate`
+	missingTable  bool
+	missingColumn bool
+}
+
+func (d *Drift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s: %s", d.Table, d.Problem)
+	}
+	return fmt.Sprintf("%s.%s: %s", d.Table, d.Column, d.Problem)
+}
+
+type DriftError struct {
+	Schema string
+	Drifts []*Drift
+}
+
+func (e *DriftError) Error() string {
+	lines := make([]string, len(e.Drifts))
+	for i, drift := range e.Drifts {
+		lines[i] = "  " + drift.String()
+	}
+	return fmt.Sprintf("schema %q does not match the registered entities, run `exchange migrate` to apply additive changes:\n%s", e.Schema, strings.Join(lines, "\n"))
+}
+
+type dbColumn struct {
+	Table      string `db:"table_name"`
+	Name       string `db:"column_name"`
+	UDTName    string `db:"udt_name"`
+	Nullable   string `db:"is_nullable"`
+	HasDefault bool   `db:"has_default"`
+}
+
+type expectedColumn struct {
+	name     string
+	udtNames []string
+	nullable bool
+}
+
+var (
+	numericUDTs = []string{"numeric", "int4", "int8"}
+	textUDTs    = []string{"text", "varchar"}
+)
+
+// expectedColumns derives the column names, types and nullability of an entity from its
+// `db` tags and Go types. The append-only layout of immutable entities has a `block$`
+// column instead of `block_range` and `_updated_block_number`.
+func expectedColumns(entityType reflect.Type, immutable bool) (out []*expectedColumn) {
+	if immutable {
+		out = append(out, &expectedColumn{name: graphnode.BlockColumn, udtNames: []string{"int4"}})
+	}
+
+	for _, tag := range graphnode.DBFields(entityType) {
+		if immutable && (tag.ColumnName == "block_range" || tag.ColumnName == "_updated_block_number") {
+			continue
+		}
+		field, _ := entityType.FieldByName(tag.Name)
+		fieldType := field.Type
+
+		column := &expectedColumn{name: tag.ColumnName, nullable: tag.Optional}
+		if fieldType.Kind() == reflect.Ptr {
+			column.nullable = column.nullable || !tag.Base
+			fieldType = fieldType.Elem()
+		}
+
+		switch fieldType {
+		case reflect.TypeOf(graphnode.Float{}), reflect.TypeOf(graphnode.Int{}):
+			column.udtNames = numericUDTs
+		case reflect.TypeOf(graphnode.BlockRange{}):
+			column.udtNames = []string{"int4range"}
+		case reflect.TypeOf(graphnode.Bool(false)):
+			column.udtNames = []string{"bool"}
+		case reflect.TypeOf(graphnode.Bytes{}):
+			column.udtNames = []string{"bytea"}
+		case reflect.TypeOf(graphnode.LocalStringArray{}):
+			column.udtNames = []string{"_text", "_varchar"}
+		case reflect.TypeOf(graphnode.Enum("")):
+			column.udtNames = nil // text or a user defined enum type
+		default:
+			switch fieldType.Kind() {
+			case reflect.String:
+				column.udtNames = textUDTs
+			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+				column.udtNames = numericUDTs
+			case reflect.Bool:
+				column.udtNames = []string{"bool"}
+			}
+		}
+		out = append(out, column)
+	}
+	return out
+}
+
+// compareColumns lists the drifts of `table`, `actual` holds its columns as found in
+// `information_schema.columns`, it is empty when the table does not exist.
+func compareColumns(table string, expected []*expectedColumn, actual []*dbColumn) (out []*Drift) {
+	if len(actual) == 0 {
+		return []*Drift{{Table: table, Problem: "table does not exist", missingTable: true}}
+	}
+
+	byName := map[string]*dbColumn{}
+	for _, column := range actual {
+		byName[column.Name] = column
+	}
+
+	known := map[string]bool{}
+	for _, column := range expected {
+		known[column.name] = true
+
+		dbCol, found := byName[column.name]
+		if !found {
+			out = append(out, &Drift{Table: table, Column: column.name, Problem: "column does not exist", missingColumn: true})
+			continue
+		}
+
+		if len(column.udtNames) > 0 && !contains(column.udtNames, dbCol.UDTName) {
+			out = append(out, &Drift{Table: table, Column: column.name, Problem: fmt.Sprintf("type is %s, expected one of %s", dbCol.UDTName, strings.Join(column.udtNames, ", "))})
+		}
+
+		dbNullable := dbCol.Nullable == "YES"
+		if column.nullable && !dbNullable {
+			out = append(out, &Drift{Table: table, Column: column.name, Problem: "column is NOT NULL but the entity field is nullable"})
+		}
+		if !column.nullable && dbNullable {
+			out = append(out, &Drift{Table: table, Column: column.name, Problem: "column is nullable but the entity field is not"})
+		}
+	}
+
+	for _, column := range actual {
+		if !known[column.Name] && column.Nullable != "YES" && !column.HasDefault {
+			out = append(out, &Drift{Table: table, Column: column.Name, Problem: "column is NOT NULL without default and is not an entity field, inserts would fail"})
+		}
+	}
+
+	return out
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSchema compares every registered entity against the columns of its table. The
+// tables of immutable entities are checked against the layout they were created with, see
+// `hasVersionedLayout`.
+func CheckSchema(ctx context.Context, db *sqlx.DB, schema string, registry *graphnode.Registry) (out []*Drift, err error) {
+	var columns []*dbColumn
+	query := `SELECT table_name, column_name, udt_name, is_nullable, column_default IS NOT NULL AS has_default FROM information_schema.columns WHERE table_schema = $1`
+	if err := db.SelectContext(ctx, &columns, query, schema); err != nil {
+		return nil, fmt.Errorf("listing columns of schema %q: %w", schema, err)
+	}
+
+	byTable := map[string][]*dbColumn{}
+	for _, column := range columns {
+		byTable[column.Table] = append(byTable[column.Table], column)
+	}
+
+	tables := make([]string, 0, registry.Len())
+	for table := range registry.Data() {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		entityType, _ := registry.GetType(table)
+		immutable := registry.IsImmutable(table) && !hasVersionedLayout(byTable[table])
+		out = append(out, compareColumns(table, expectedColumns(entityType, immutable), byTable[table])...)
+	}
+	return out, nil
+}
+
+// planMigration returns the statements fixing the additive drifts, the other drifts are
+// returned as not migratable.
+func planMigration(schema string, drifts []*Drift, tables []*subgraph.TableDefinition, createTables map[string]string) (statements []string, notMigratable []*Drift) {
+	definitions := map[string]*subgraph.TableDefinition{}
+	for _, table := range tables {
+		definitions[table.Name] = table
+	}
+
+	for _, drift := range drifts {
+		switch {
+		case drift.missingTable:
+			statement, found := createTables[drift.Table]
+			if !found {
+				notMigratable = append(notMigratable, &Drift{Table: drift.Table, Problem: "table does not exist and has no create statement in the DDL"})
+				continue
+			}
+			statements = append(statements, strings.ReplaceAll(statement, "%%SCHEMA%%", schema))
+
+		case drift.missingColumn:
+			definition, found := definitions[drift.Table]
+			if !found {
+				notMigratable = append(notMigratable, drift)
+				continue
+			}
+			column, found := definition.Column(drift.Column)
+			if !found {
+				notMigratable = append(notMigratable, &Drift{Table: drift.Table, Column: drift.Column, Problem: "column does not exist and is not defined in the DDL"})
+				continue
+			}
+			if column.NotNull() && !column.HasDefault() {
+				notMigratable = append(notMigratable, &Drift{Table: drift.Table, Column: drift.Column, Problem: "column does not exist and is NOT NULL without default, it cannot be added to existing rows"})
+				continue
+			}
+			statements = append(statements, fmt.Sprintf("alter table %s.%s add column %s", schema, drift.Table, column.Definition()))
+
+		default:
+			notMigratable = append(notMigratable, drift)
+		}
+	}
+	return statements, notMigratable
+}
+
+// Migrate applies, in a single transaction, the additive changes needed for the schema to
+// match the registered entities: missing tables, new nullable or defaulted columns and
+// missing indexes, all taken from the subgraph DDL, as well as the missing store tables. Nothing is applied if any drift is
+// not additive. The applied (or planned, when `dryRun` is set) statements are returned.
+func Migrate(ctx context.Context, db *sqlx.DB, def *subgraph.Definition, schema string, dryRun bool, logger *zap.Logger) ([]string, error) {
+	drifts, err := CheckSchema(ctx, db, schema, def.Entities)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := subgraph.TableDefinitions(def.DDL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ddl: %w", err)
+	}
+
+	createTables := map[string]string{}
+	err = def.DDL.CreateTables(func(table string, statement string) error {
+		createTables[table] = statement
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create table statements: %w", err)
+	}
+
+	statements, notMigratable := planMigration(schema, drifts, tables, createTables)
+	if len(notMigratable) > 0 {
+		return nil, &DriftError{Schema: schema, Drifts: notMigratable}
+	}
+
+	tableStatements, err := missingStoreTables(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, tableStatements...)
+
+	indexStatements, err := missingIndexes(ctx, db, def.DDL, schema)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, indexStatements...)
+
+	if dryRun || len(statements) == 0 {
+		return statements, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	for _, statement := range statements {
+		logger.Info("applying migration statement", zap.String("statement", statement))
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("executing %q: %w", statement, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	return statements, nil
+}
+
+func missingIndexes(ctx context.Context, db *sqlx.DB, ddl subgraph.DDL, schema string) (out []string, err error) {
+	var existing []string
+	if err := db.SelectContext(ctx, &existing, `SELECT indexname FROM pg_indexes WHERE schemaname = $1`, schema); err != nil {
+		return nil, fmt.Errorf("listing indexes of schema %q: %w", schema, err)
+	}
+
+	err = ddl.CreateIndexes(func(table string, statement string) error {
+		name, found := subgraph.ParseIndexName(statement)
+		if !found {
+			return fmt.Errorf("cannot find index name in %q", statement)
+		}
+		if !contains(existing, name) {
+			out = append(out, strings.ReplaceAll(statement, "%%SCHEMA%%", schema))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create index statements: %w", err)
+	}
+
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *store) Migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	return Migrate(ctx, s.db, s.subgraph, s.schemaName, dryRun, s.logger)
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaEntity struct {
+	graphnode.Base
+	Name    string                     `db:"name"`
+	Reserve graphnode.Float            `db:"reserve"`
+	Volume  *graphnode.Float           `db:"volume,nullable"`
+	Swaps   graphnode.LocalStringArray `db:"swaps,nullable"`
+}
+
+func baseColumns() []*dbColumn {
+	return []*dbColumn{
+		{Name: "id", UDTName: "text", Nullable: "NO"},
+		{Name: "vid", UDTName: "int8", Nullable: "NO", HasDefault: true},
+		{Name: "block_range", UDTName: "int4range", Nullable: "NO"},
+		{Name: "_updated_block_number", UDTName: "numeric", Nullable: "NO"},
+	}
+}
+
+func TestCompareColumns(t *testing.T) {
+	expected := expectedColumns(reflect.TypeOf(schemaEntity{}), false)
+
+	tests := []struct {
+		name     string
+		columns  []*dbColumn
+		expected []string
+	}{
+		{
+			"identical",
+			append(baseColumns(), &dbColumn{Name: "name", UDTName: "text", Nullable: "NO"}, &dbColumn{Name: "reserve", UDTName: "numeric", Nullable: "NO"}, &dbColumn{Name: "volume", UDTName: "numeric", Nullable: "YES"}, &dbColumn{Name: "swaps", UDTName: "_text", Nullable: "YES"}),
+			nil,
+		},
+		{
+			"missing table",
+			nil,
+			[]string{"schema_entity: table does not exist"},
+		},
+		{
+			"missing column",
+			append(baseColumns(), &dbColumn{Name: "name", UDTName: "text", Nullable: "NO"}, &dbColumn{Name: "reserve", UDTName: "numeric", Nullable: "NO"}, &dbColumn{Name: "swaps", UDTName: "_text", Nullable: "YES"}),
+			[]string{"schema_entity.volume: column does not exist"},
+		},
+		{
+			"type and nullability",
+			append(baseColumns(), &dbColumn{Name: "name", UDTName: "numeric", Nullable: "NO"}, &dbColumn{Name: "reserve", UDTName: "numeric", Nullable: "YES"}, &dbColumn{Name: "volume", UDTName: "numeric", Nullable: "NO"}, &dbColumn{Name: "swaps", UDTName: "_text", Nullable: "YES"}),
+			[]string{
+				"schema_entity.name: type is numeric, expected one of text, varchar",
+				"schema_entity.reserve: column is nullable but the entity field is not",
+				"schema_entity.volume: column is NOT NULL but the entity field is nullable",
+			},
+		},
+		{
+			"extra columns",
+			append(baseColumns(), &dbColumn{Name: "name", UDTName: "text", Nullable: "NO"}, &dbColumn{Name: "reserve", UDTName: "numeric", Nullable: "NO"}, &dbColumn{Name: "volume", UDTName: "numeric", Nullable: "YES"}, &dbColumn{Name: "swaps", UDTName: "_text", Nullable: "YES"},
+				&dbColumn{Name: "legacy", UDTName: "text", Nullable: "YES"}, &dbColumn{Name: "counter", UDTName: "int4", Nullable: "NO", HasDefault: true}, &dbColumn{Name: "required", UDTName: "text", Nullable: "NO"}),
+			[]string{"schema_entity.required: column is NOT NULL without default and is not an entity field, inserts would fail"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual []string
+			for _, drift := range compareColumns("schema_entity", expected, test.columns) {
+				actual = append(actual, drift.String())
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+type immutableSchemaEntity struct {
+	graphnode.Base
+	Name string `db:"name"`
+}
+
+func (e *immutableSchemaEntity) Immutable() bool { return true }
+
+func TestCompareColumnsImmutable(t *testing.T) {
+	expected := expectedColumns(reflect.TypeOf(immutableSchemaEntity{}), true)
+	columns := []*dbColumn{
+		{Name: "id", UDTName: "text", Nullable: "NO"},
+		{Name: "vid", UDTName: "int8", Nullable: "NO", HasDefault: true},
+		{Name: "block$", UDTName: "int4", Nullable: "NO"},
+		{Name: "name", UDTName: "text", Nullable: "NO"},
+	}
+	assert.Empty(t, compareColumns("immutable_schema_entity", expected, columns))
+
+	var actual []string
+	for _, drift := range compareColumns("immutable_schema_entity", expected, append(baseColumns(), &dbColumn{Name: "name", UDTName: "text", Nullable: "NO"})) {
+		actual = append(actual, drift.String())
+	}
+	assert.Equal(t, []string{
+		"immutable_schema_entity.block$: column does not exist",
+		"immutable_schema_entity.block_range: column is NOT NULL without default and is not an entity field, inserts would fail",
+		"immutable_schema_entity._updated_block_number: column is NOT NULL without default and is not an entity field, inserts would fail",
+	}, actual)
+
+	// A table created before its entity was immutable keeps its versions
+	versioned := append(baseColumns(), &dbColumn{Name: "name", UDTName: "text", Nullable: "NO"})
+	require.True(t, hasVersionedLayout(versioned))
+	require.False(t, hasVersionedLayout(columns))
+	assert.Empty(t, compareColumns("immutable_schema_entity", expectedColumns(reflect.TypeOf(immutableSchemaEntity{}), false), versioned))
+
+	assert.Equal(t, `"id", "vid", int4range("block$", NULL) AS block_range, "block$" AS _updated_block_number, "name"`, immutableSelectColumns(reflect.TypeOf(immutableSchemaEntity{})))
+}
+
+func TestPlanMigration(t *testing.T) {
+	tables, err := subgraph.ParseCreateTables(`
+create table if not exists %%SCHEMA%%.pair
+(
+	id text not null,
+	"name" text not null,
+	"volume" numeric,
+	"tx_count" numeric not null default 0,
+	"reserve" numeric not null,
+	vid bigserial not null constraint pair_pkey primary key,
+	block_range int4range not null,
+	_updated_block_number numeric not null
+);`)
+	require.NoError(t, err)
+	createTables := map[string]string{"token": "create table if not exists %%SCHEMA%%.token (id text not null);"}
+
+	drifts := []*Drift{
+		{Table: "pair", Column: "volume", missingColumn: true},
+		{Table: "pair", Column: "tx_count", missingColumn: true},
+		{Table: "token", missingTable: true},
+	}
+	statements, notMigratable := planMigration("sgd1", drifts, tables, createTables)
+	assert.Empty(t, notMigratable)
+	assert.Equal(t, []string{
+		`alter table sgd1.pair add column "volume" numeric`,
+		`alter table sgd1.pair add column "tx_count" numeric not null default 0`,
+		"create table if not exists sgd1.token (id text not null);",
+	}, statements)
+
+	drifts = []*Drift{
+		{Table: "pair", Column: "reserve", missingColumn: true},
+		{Table: "pair", Column: "name", Problem: "type is numeric, expected one of text, varchar"},
+		{Table: "bundle", missingTable: true},
+	}
+	_, notMigratable = planMigration("sgd1", drifts, tables, createTables)
+	require.Len(t, notMigratable, 3)
+	assert.Equal(t, "pair.reserve: column does not exist and is NOT NULL without default, it cannot be added to existing rows", notMigratable[0].String())
+	assert.Equal(t, "pair.name: type is numeric, expected one of text, varchar", notMigratable[1].String())
+	assert.Equal(t, "bundle: table does not exist and has no create statement in the DDL", notMigratable[2].String())
+}
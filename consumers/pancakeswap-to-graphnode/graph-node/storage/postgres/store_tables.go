@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// storeTables are the tables the store keeps besides the ones of the entities, keyed by
+// name. They are created along with the schema, and by `Migrate` in the schemas created
+// before them, never while registering the entities.
+var storeTables = map[string]string{
+	"latest_tables": `create table if not exists %%SCHEMA%%.latest_tables (table_name text primary key);`,
+	"partitioning":  `create table if not exists %%SCHEMA%%.partitioning (table_name text primary key, partition_size bigint not null);`,
+	"pruning":       `create table if not exists %%SCHEMA%%.pruning (id integer primary key, earliest_block bigint not null);`,
+	"provenance":    `create table if not exists %%SCHEMA%%.provenance (id serial primary key, package_name text not null, package_version text not null, module_name text not null, module_hash text not null, start_block bigint not null, since_block bigint not null, created_at timestamptz not null default now());`,
+}
+
+// createStoreTables creates the store tables of `schema` through `exec`.
+func createStoreTables(schema string, exec func(statement string) error) error {
+	for _, name := range storeTableNames() {
+		if err := exec(strings.ReplaceAll(storeTables[name], "%%SCHEMA%%", schema)); err != nil {
+			return fmt.Errorf("creating table %s.%s: %w", schema, name, err)
+		}
+	}
+	return nil
+}
+
+// missingStoreTables returns the statements creating the store tables missing in `schema`.
+func missingStoreTables(ctx context.Context, db *sqlx.DB, schema string) (out []string, err error) {
+	var existing []string
+	if err := db.SelectContext(ctx, &existing, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1`, schema); err != nil {
+		return nil, fmt.Errorf("listing tables of schema %q: %w", schema, err)
+	}
+
+	for _, name := range storeTableNames() {
+		if !contains(existing, name) {
+			out = append(out, strings.ReplaceAll(storeTables[name], "%%SCHEMA%%", schema))
+		}
+	}
+	return out, nil
+}
+
+func storeTableNames() []string {
+	names := make([]string, 0, len(storeTables))
+	for name := range storeTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
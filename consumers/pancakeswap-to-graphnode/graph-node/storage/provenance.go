@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+var ErrModuleChanged = errors.New("module changed")
+
+// Provenance identifies the substreams module whose output is loaded in a store.
+type Provenance struct {
+	PackageName    string `db:"package_name" json:"package_name"`
+	PackageVersion string `db:"package_version" json:"package_version"`
+	ModuleName     string `db:"module_name" json:"module_name"`
+	ModuleHash     string `db:"module_hash" json:"module_hash"`
+	// StartBlock is the initial block of the module
+	StartBlock uint64 `db:"start_block" json:"start_block"`
+	// SinceBlock is the first block loaded from the module
+	SinceBlock uint64 `db:"since_block" json:"since_block"`
+}
+
+func (p *Provenance) String() string {
+	return fmt.Sprintf("%s@%s module %s (%s) since block %d", p.PackageName, p.PackageVersion, p.ModuleName, p.ModuleHash, p.SinceBlock)
+}
+
+func NewModuleChangedError(loaded, current *Provenance) error {
+	return fmt.Errorf("%w: data was loaded by %s, refusing to continue with %s", ErrModuleChanged, loaded, current)
+}
+
+// ProvenanceStore is implemented by the stores recording which modules produced their data.
+type ProvenanceStore interface {
+	// LoadProvenance returns every provenance recorded, the current one last, or none when
+	// nothing was ever loaded.
+	LoadProvenance(ctx context.Context) ([]*Provenance, error)
+
+	// SaveProvenance records `provenance` as the current one.
+	SaveProvenance(ctx context.Context, provenance *Provenance) error
+}
+
+// CheckProvenance makes sure the data of `store` keeps coming from the same module: the
+// first load records `current`, later ones fail with `ErrModuleChanged` when the module
+// hash differs from the recorded one. When `force` is set, a different module is accepted
+// and recorded from `current.SinceBlock` onward.
+func CheckProvenance(ctx context.Context, store ProvenanceStore, current *Provenance, force bool, logger *zap.Logger) error {
+	recorded, err := store.LoadProvenance(ctx)
+	if err != nil {
+		return fmt.Errorf("loading provenance: %w", err)
+	}
+
+	if len(recorded) > 0 {
+		loaded := recorded[len(recorded)-1]
+		if loaded.ModuleHash == current.ModuleHash {
+			return nil
+		}
+		if !force {
+			return NewModuleChangedError(loaded, current)
+		}
+		logger.Warn("module changed, forcing migration", zap.Stringer("loaded", loaded), zap.Stringer("current", current))
+	}
+
+	if err := store.SaveProvenance(ctx, current); err != nil {
+		return fmt.Errorf("saving provenance: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testProvenanceStore struct {
+	recorded []*Provenance
+}
+
+func (s *testProvenanceStore) LoadProvenance(ctx context.Context) ([]*Provenance, error) {
+	return s.recorded, nil
+}
+
+func (s *testProvenanceStore) SaveProvenance(ctx context.Context, provenance *Provenance) error {
+	s.recorded = append(s.recorded, provenance)
+	return nil
+}
+
+func TestCheckProvenance(t *testing.T) {
+	v1 := &Provenance{PackageName: "pcs", PackageVersion: "v0.1.0", ModuleName: "db_out", ModuleHash: "aaaa", StartBlock: 10, SinceBlock: 10}
+	v1Resumed := &Provenance{PackageName: "pcs", PackageVersion: "v0.1.0", ModuleName: "db_out", ModuleHash: "aaaa", StartBlock: 10, SinceBlock: 50}
+	v2 := &Provenance{PackageName: "pcs", PackageVersion: "v0.2.0", ModuleName: "db_out", ModuleHash: "bbbb", StartBlock: 10, SinceBlock: 50}
+
+	tests := []struct {
+		name          string
+		recorded      []*Provenance
+		current       *Provenance
+		force         bool
+		expected      []*Provenance
+		expectedError error
+	}{
+		{"first load", nil, v1, false, []*Provenance{v1}, nil},
+		{"same module", []*Provenance{v1}, v1Resumed, false, []*Provenance{v1}, nil},
+		{"module changed", []*Provenance{v1}, v2, false, []*Provenance{v1}, ErrModuleChanged},
+		{"module changed forced", []*Provenance{v1}, v2, true, []*Provenance{v1, v2}, nil},
+		{"back to a previous module", []*Provenance{v1, v2}, v1Resumed, false, []*Provenance{v1, v2}, ErrModuleChanged},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &testProvenanceStore{recorded: append([]*Provenance(nil), test.recorded...)}
+
+			err := CheckProvenance(context.Background(), store, test.current, test.force, zap.NewNop())
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, store.recorded)
+		})
+	}
+}
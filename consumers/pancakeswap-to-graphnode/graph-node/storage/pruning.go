@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrBlockPruned = errors.New("block history was pruned")
+
+func NewBlockPrunedError(blockNum, earliestBlock uint64) error {
+	return fmt.Errorf("%w: block %d is below the earliest queryable block %d", ErrBlockPruned, blockNum, earliestBlock)
+}
+
+// Pruner is implemented by the stores able to delete the entity versions that are not
+// visible anymore at or above a given block.
+type Pruner interface {
+	// PruneBelow records `earliestBlock` as the earliest queryable block, reads below it
+	// fail with `ErrBlockPruned` from then on, and deletes at most `batchSize` versions
+	// closed at or before it. Pruning is complete once less than `batchSize` versions
+	// are deleted.
+	PruneBelow(ctx context.Context, earliestBlock uint64, batchSize int) (deleted int, err error)
+
+	// EarliestBlock returns the earliest queryable block, 0 when nothing was ever pruned.
+	EarliestBlock(ctx context.Context) (uint64, error)
+}
+
+// PruneRunner keeps the last `window` blocks of history, pruning older versions in small
+// batches from its own goroutine so that saves are never waiting on it.
+type PruneRunner struct {
+	pruner    Pruner
+	window    uint64
+	interval  time.Duration
+	batchSize int
+	logger    *zap.Logger
+
+	head uint64
+}
+
+func NewPruneRunner(pruner Pruner, window uint64, interval time.Duration, batchSize int, logger *zap.Logger) *PruneRunner {
+	return &PruneRunner{
+		pruner:    pruner,
+		window:    window,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// SetHead records the last block saved, it never blocks.
+func (r *PruneRunner) SetHead(blockNum uint64) {
+	atomic.StoreUint64(&r.head, blockNum)
+}
+
+// Run prunes every `interval` until `ctx` is done.
+func (r *PruneRunner) Run(ctx context.Context) error {
+	pruned, err := r.pruner.EarliestBlock(ctx)
+	if err != nil {
+		return fmt.Errorf("loading earliest block: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.interval):
+		}
+
+		head := atomic.LoadUint64(&r.head)
+		if head <= r.window || head-r.window <= pruned {
+			continue
+		}
+
+		target := head - r.window
+		if err := r.prune(ctx, target); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			r.logger.Warn("pruning failed, will retry", zap.Uint64("earliest_block", target), zap.Error(err))
+			continue
+		}
+		pruned = target
+	}
+}
+
+func (r *PruneRunner) prune(ctx context.Context, earliestBlock uint64) error {
+	start := time.Now()
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		deleted, err := r.pruner.PruneBelow(ctx, earliestBlock, r.batchSize)
+		if err != nil {
+			return err
+		}
+		total += deleted
+
+		if deleted < r.batchSize {
+			break
+		}
+	}
+
+	r.logger.Info("pruned history", zap.Uint64("earliest_block", earliestBlock), zap.Int("deleted_versions", total), zap.Duration("duration", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testPruner struct {
+	lock      sync.Mutex
+	earliest  uint64
+	remaining int
+	calls     []uint64
+}
+
+func (p *testPruner) PruneBelow(ctx context.Context, earliestBlock uint64, batchSize int) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.earliest = earliestBlock
+	p.calls = append(p.calls, earliestBlock)
+
+	deleted := batchSize
+	if p.remaining < batchSize {
+		deleted = p.remaining
+	}
+	p.remaining -= deleted
+	return deleted, nil
+}
+
+func (p *testPruner) EarliestBlock(ctx context.Context) (uint64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.earliest, nil
+}
+
+func (p *testPruner) called() []uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]uint64(nil), p.calls...)
+}
+
+func TestPruneRunner(t *testing.T) {
+	tests := []struct {
+		name          string
+		head          uint64
+		earliest      uint64
+		remaining     int
+		expectedCalls []uint64
+	}{
+		{"head within window", 100, 0, 25, nil},
+		{"already pruned", 150, 50, 25, nil},
+		{"batches until done", 150, 0, 25, []uint64{50, 50, 50}},
+		{"nothing to delete", 150, 0, 0, []uint64{50}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pruner := &testPruner{earliest: test.earliest, remaining: test.remaining}
+			runner := NewPruneRunner(pruner, 100, time.Millisecond, 10, zap.NewNop())
+			runner.SetHead(test.head)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			require.NoError(t, runner.Run(ctx))
+
+			assert.Equal(t, test.expectedCalls, pruner.called())
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+)
+
+// TranslateDDL rewrites every `create table` statement of the Postgres DDL of a subgraph
+// (the schema setup and the entity tables) for SQLite. Indexes are not translated, each
+// table gets a single `(id, block_range_start)` index instead, `(id, "block$")` for the
+// append-only tables of immutable entities.
+func TranslateDDL(ddl subgraph.DDL) (out []string, err error) {
+	tables, err := subgraph.TableDefinitions(ddl)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		columns, blockColumn, err := translateColumns(table.Columns)
+		if err != nil {
+			return nil, fmt.Errorf("table %q: %w", table.Name, err)
+		}
+
+		out = append(out, fmt.Sprintf("create table if not exists %q\n(\n\t%s\n)", table.Name, strings.Join(columns, ",\n\t")))
+		switch blockColumn {
+		case "block_range_start":
+			out = append(out, fmt.Sprintf("create index if not exists %q on %q (id, block_range_start)", table.Name+"_id_block_range", table.Name))
+		case graphnode.BlockColumn:
+			out = append(out, fmt.Sprintf("create index if not exists %q on %q (id, %q)", table.Name+"_id_block", table.Name, graphnode.BlockColumn))
+		}
+	}
+	return out, nil
+}
+
+// translateColumns returns the SQLite columns and the column holding the block of the
+// versions, empty for the tables not holding entities.
+func translateColumns(columns []*subgraph.ColumnDefinition) (out []string, blockColumn string, err error) {
+	for _, column := range columns {
+		switch column.Name {
+		case "vid":
+			out = append(out, "vid integer primary key autoincrement")
+			continue
+		case "block_range":
+			blockColumn = "block_range_start"
+			out = append(out, "block_range_start integer not null", "block_range_end integer")
+			continue
+		case "_updated_block_number":
+			out = append(out, "_updated_block_number integer not null")
+			continue
+		case graphnode.BlockColumn:
+			blockColumn = graphnode.BlockColumn
+			out = append(out, fmt.Sprintf("%q integer not null", graphnode.BlockColumn))
+			continue
+		}
+
+		columnType, err := translateType(column.Type)
+		if err != nil {
+			return nil, "", fmt.Errorf("column %q: %w", column.Name, err)
+		}
+		out = append(out, strings.Join(append([]string{fmt.Sprintf("%q", column.Name), columnType}, column.Constraints...), " "))
+	}
+	return out, blockColumn, nil
+}
+
+// translateType maps Postgres types to SQLite ones. Numeric values are kept as text so
+// that big numbers do not get the `REAL` affinity and lose precision.
+func translateType(pgType string) (string, error) {
+	switch strings.ToLower(pgType) {
+	case "text", "numeric", "text[]":
+		return "text", nil
+	case "bytea":
+		return "blob", nil
+	case "boolean":
+		return "boolean", nil
+	case "integer", "int", "int4", "int8", "bigint":
+		return "integer", nil
+	}
+	return "", fmt.Errorf("unsupported type %q", pgType)
+}
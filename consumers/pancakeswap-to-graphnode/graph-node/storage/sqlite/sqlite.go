@@ -0,0 +1,616 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/subgraph"
+	"go.uber.org/zap"
+)
+
+const DSNScheme = "sqlite://"
+
+// blockRangeColumn rebuilds the Postgres textual `int4range` out of the start/end
+// columns, so entities are scanned through `graphnode.BlockRange.Scan` like in Postgres.
+const blockRangeColumn = `'[' || block_range_start || ',' || ifnull(block_range_end, '') || ')' as block_range`
+
+// immutableBlockRangeColumn reads the `block$` of an immutable entity as an open-ended range.
+const immutableBlockRangeColumn = `'[' || "block$" || ',)' as block_range`
+
+// store implements `storage.Store` on top of a single SQLite file. The `block_range` of
+// each version is kept in two integer columns, `block_range_start` (inclusive) and
+// `block_range_end` (exclusive, NULL when open-ended). Immutable entities have a single
+// version, their tables only hold the block it starts at in `block$`.
+type store struct {
+	lock     sync.Mutex
+	db       *sqlx.DB
+	subgraph *subgraph.Definition
+	logger   *zap.Logger
+
+	selectColumns map[string]string
+	insertStmts   map[string]string
+	// immutable holds the tables with the append-only `block$` layout
+	immutable map[string]bool
+
+	// earliestBlock mirrors the `pruning` table, it is read on every load
+	earliestBlock uint64
+}
+
+// IsDSN reports whether `dsn` targets a SQLite database, ex: `sqlite://./data/exchange.db`.
+func IsDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, DSNScheme)
+}
+
+func New(logger *zap.Logger, dsn string, subgraph *subgraph.Definition) (*store, error) {
+	s, err := open(logger, dsn, "", subgraph)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.createTables(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	if err := s.loadLayout(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	if err := s.loadEarliestBlock(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewReader opens the database for reading only, nothing is created and the writes fail.
+func NewReader(logger *zap.Logger, dsn string, subgraph *subgraph.Definition) (*store, error) {
+	s, err := open(logger, dsn, "?mode=ro", subgraph)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadLayout(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	var pruningTables int
+	if err := s.db.Get(&pruningTables, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'pruning'`); err != nil {
+		s.db.Close()
+		return nil, fmt.Errorf("looking up pruning table: %w", err)
+	}
+	if pruningTables > 0 {
+		if err := s.loadEarliestBlock(); err != nil {
+			s.db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func open(logger *zap.Logger, dsn string, options string, subgraph *subgraph.Definition) (*store, error) {
+	if !IsDSN(dsn) {
+		return nil, fmt.Errorf("invalid dsn %q, expected scheme %q", dsn, DSNScheme)
+	}
+	path := strings.TrimPrefix(dsn, DSNScheme)
+	if path == "" {
+		return nil, fmt.Errorf("invalid dsn %q, missing database path", dsn)
+	}
+
+	source := path
+	if options != "" {
+		source = "file:" + path + options
+	}
+	db, err := sqlx.Open("sqlite3", source)
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database %q: %w", path, err)
+	}
+	// SQLite only allows a single writer, sharing one connection avoids `database is locked` errors
+	db.SetMaxOpenConns(1)
+
+	s := &store{
+		db:            db,
+		subgraph:      subgraph,
+		logger:        logger,
+		selectColumns: map[string]string{},
+		insertStmts:   map[string]string{},
+		immutable:     map[string]bool{},
+	}
+	return s, nil
+}
+
+// loadLayout builds the statements of every table for its layout. Only the tables created
+// with a `block$` column are append-only, the ones created before their entity became
+// immutable keep their versions.
+func (s *store) loadLayout() error {
+	for tableName, entityType := range s.subgraph.Entities.Data() {
+		if s.subgraph.Entities.IsImmutable(tableName) {
+			var blockColumns int
+			if err := s.db.Get(&blockColumns, `SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, tableName, graphnode.BlockColumn); err != nil {
+				return fmt.Errorf("reading layout of table %q: %w", tableName, err)
+			}
+			s.immutable[tableName] = blockColumns > 0
+		}
+
+		s.selectColumns[tableName] = buildSelectColumns(entityType, s.immutable[tableName])
+		s.insertStmts[tableName] = buildInsertQuery(tableName, entityType, s.immutable[tableName])
+	}
+	return nil
+}
+
+func (s *store) loadEarliestBlock() error {
+	if err := s.db.Get(&s.earliestBlock, `SELECT ifnull(max(earliest_block), 0) FROM pruning`); err != nil {
+		return fmt.Errorf("loading earliest block: %w", err)
+	}
+	return nil
+}
+
+func (s *store) createTables() error {
+	if s.subgraph.DDL == nil {
+		return fmt.Errorf("subgraph %q has no DDL", s.subgraph.PackageName)
+	}
+
+	statements, err := TranslateDDL(s.subgraph.DDL)
+	if err != nil {
+		return fmt.Errorf("translating ddl: %w", err)
+	}
+	statements = append(statements,
+		`create table if not exists "cursor" (id integer primary key, cursor text)`,
+		`create table if not exists pruning (id integer primary key, earliest_block integer not null)`,
+		`create table if not exists provenance (id integer primary key autoincrement, package_name text not null, package_version text not null, module_name text not null, module_hash text not null, start_block integer not null, since_block integer not null, created_at timestamp not null default current_timestamp)`,
+	)
+
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("executing %q: %w", statement, err)
+		}
+	}
+
+	s.logger.Info("sqlite tables created", zap.Int("statement_count", len(statements)))
+	return nil
+}
+
+func buildSelectColumns(entityType reflect.Type, immutable bool) string {
+	var columns []string
+	for _, field := range graphnode.DBFields(entityType) {
+		switch {
+		case field.ColumnName == "block_range" && immutable:
+			columns = append(columns, immutableBlockRangeColumn)
+		case field.ColumnName == "block_range":
+			columns = append(columns, blockRangeColumn)
+		case field.ColumnName == "_updated_block_number" && immutable:
+			columns = append(columns, fmt.Sprintf("%q as _updated_block_number", graphnode.BlockColumn))
+		default:
+			columns = append(columns, fmt.Sprintf("%q", field.ColumnName))
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// buildInsertQuery creates the insert of a new open-ended version, which always starts
+// at the block it was updated at.
+func buildInsertQuery(tableName string, entityType reflect.Type, immutable bool) string {
+	fields := []string{`"id"`, "block_range_start", "block_range_end", "_updated_block_number"}
+	colonFields := []string{":id", ":_updated_block_number", "NULL", ":_updated_block_number"}
+	if immutable {
+		fields = []string{`"id"`, fmt.Sprintf("%q", graphnode.BlockColumn)}
+		colonFields = []string{":id", ":_updated_block_number"}
+	}
+
+	for _, el := range graphnode.DBFields(entityType) {
+		if el.Base {
+			continue
+		}
+		fields = append(fields, `"`+el.ColumnName+`"`)
+		colonFields = append(colonFields, ":"+el.ColumnName)
+	}
+	return fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)", tableName, strings.Join(fields, ", "), strings.Join(colonFields, ", "))
+}
+
+// containsBlock is the condition matching the version of an entity valid at `blockNum`.
+func (s *store) containsBlock(tableName string, blockNum uint64) string {
+	if s.immutable[tableName] {
+		return fmt.Sprintf("%q <= %d", graphnode.BlockColumn, blockNum)
+	}
+	return containsBlock(blockNum)
+}
+
+func containsBlock(blockNum uint64) string {
+	return fmt.Sprintf("(block_range_start <= %d and (block_range_end is null or block_range_end > %d))", blockNum, blockNum)
+}
+
+func (s *store) BatchSave(ctx context.Context, blockNum uint64, blockHash string, blockTime time.Time, updates map[string]map[string]graphnode.Entity, cursor string) (err error) {
+	if ctx.Err() != nil {
+		return fmt.Errorf("block %d not saved: %w", blockNum, ctx.Err())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Once started, the save runs to completion even if `ctx` is cancelled: the single
+	// local transaction is short and interrupting it would only discard the block.
+	ctx = context.Background()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	for tableName, entities := range updates {
+		if err := s.batchSave(ctx, tx, blockNum, tableName, entities); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("batch saving: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO "cursor" (id, cursor) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET cursor = excluded.cursor`, cursor); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("saving cursor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (s *store) batchSave(ctx context.Context, tx *sqlx.Tx, blockNum uint64, tableName string, entities map[string]graphnode.Entity) error {
+	insertStmt, found := s.insertStmts[tableName]
+	if !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	// Only new immutable entities reach the insert, there is no block range to close
+	if s.subgraph.Entities.IsImmutable(tableName) {
+		for id, ent := range entities {
+			if ent == nil {
+				return graphnode.NewImmutableEntityError(tableName, id, "delete")
+			}
+			if ent.GetBlockRange() != nil {
+				return graphnode.NewImmutableEntityError(tableName, id, "update")
+			}
+		}
+	}
+
+	// This loop is ONLY for updating the block ranges.
+	for id, ent := range entities {
+		var vid uint64
+		if ent == nil { // deleted
+			// Mimics `ORDER BY block_range DESC LIMIT 1` on an `int4range`
+			query := fmt.Sprintf(`SELECT vid FROM %q WHERE id = ? ORDER BY block_range_start DESC, ifnull(block_range_end, 2147483647) DESC LIMIT 1`, tableName)
+			if err := tx.GetContext(ctx, &vid, query, id); err != nil {
+				if err == sql.ErrNoRows {
+					continue
+				}
+				return fmt.Errorf("get latest version of %q in %q: %w", id, tableName, err)
+			}
+		} else if ent.GetBlockRange() != nil {
+			vid = ent.GetVID()
+		} else {
+			continue
+		}
+
+		query := fmt.Sprintf(`UPDATE %q SET block_range_end = ?, _updated_block_number = ? WHERE vid = ?`, tableName)
+		if _, err := tx.ExecContext(ctx, query, blockNum, blockNum, vid); err != nil {
+			return fmt.Errorf("update %q block range of vid %d: %w", tableName, vid, err)
+		}
+	}
+
+	for _, ent := range entities {
+		if ent == nil {
+			continue
+		}
+		ent.SetBlockRange(&graphnode.BlockRange{
+			StartBlock: blockNum,
+		})
+		ent.SetUpdatedBlockNum(blockNum)
+		if e, ok := ent.(graphnode.Sanitizable); ok {
+			e.Sanitize()
+		}
+
+		res, err := tx.NamedExecContext(ctx, insertStmt, ent)
+		if err != nil {
+			return fmt.Errorf("inserting into %q, id=%s, range=%v: %w", tableName, ent.GetID(), ent.GetBlockRange(), err)
+		}
+
+		vid, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("getting vid of %q in %q: %w", ent.GetID(), tableName, err)
+		}
+		ent.SetVID(uint64(vid))
+	}
+
+	return nil
+}
+
+func (s *store) Load(ctx context.Context, id string, ent graphnode.Entity, blockNum uint64) error {
+	if earliest := atomic.LoadUint64(&s.earliestBlock); blockNum < earliest {
+		return storage.NewBlockPrunedError(blockNum, earliest)
+	}
+
+	tableName := graphnode.GetTableName(ent)
+	entType, found := s.subgraph.Entities.GetType(tableName)
+	if !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %q WHERE id = ? and %s LIMIT 1", s.selectColumns[tableName], tableName, s.containsBlock(tableName, blockNum))
+
+	// Scanned in a temporary entity, as to not override the caller's entity when no row is found
+	tempEnt := reflect.New(entType).Interface()
+	if err := s.db.GetContext(ctx, tempEnt, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("load %q from %q: %w", id, tableName, err)
+	}
+
+	reflect.ValueOf(ent).Elem().Set(reflect.ValueOf(tempEnt).Elem())
+	ent.SetExists(true)
+	return nil
+}
+
+func (s *store) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64) (out []graphnode.Entity, err error) {
+	if earliest := atomic.LoadUint64(&s.earliestBlock); blockNum < earliest {
+		return nil, storage.NewBlockPrunedError(blockNum, earliest)
+	}
+
+	tableName := graphnode.GetTableName(model)
+	if _, found := s.selectColumns[tableName]; !found {
+		return nil, fmt.Errorf("unknown table %q", tableName)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %q WHERE %s ORDER BY vid", s.selectColumns[tableName], tableName, s.containsBlock(tableName, blockNum))
+
+	modelsPtr := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+	if err := s.db.SelectContext(ctx, modelsPtr.Interface(), query); err != nil {
+		return nil, fmt.Errorf("load all from %q: %w", tableName, err)
+	}
+
+	models := modelsPtr.Elem()
+	for i := 0; i < models.Len(); i++ {
+		ent := models.Index(i).Interface().(graphnode.Entity)
+		ent.SetExists(true)
+		out = append(out, ent)
+	}
+	return out, nil
+}
+
+func (s *store) StreamAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64, handle func(ent graphnode.Entity) error) error {
+	if earliest := atomic.LoadUint64(&s.earliestBlock); blockNum < earliest {
+		return storage.NewBlockPrunedError(blockNum, earliest)
+	}
+
+	tableName := graphnode.GetTableName(model)
+	if _, found := s.selectColumns[tableName]; !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	order := "block_range_start, vid"
+	if s.immutable[tableName] {
+		order = fmt.Sprintf("%q, vid", graphnode.BlockColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %q WHERE %s ORDER BY %s", s.selectColumns[tableName], tableName, s.containsBlock(tableName, blockNum), order)
+
+	rows, err := s.db.QueryxContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("stream all from %q: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	modelType := reflect.TypeOf(model).Elem()
+	for rows.Next() {
+		ent := reflect.New(modelType).Interface().(graphnode.Entity)
+		if err := rows.StructScan(ent); err != nil {
+			return fmt.Errorf("scanning %q entity: %w", tableName, err)
+		}
+		ent.SetExists(true)
+		if err := handle(ent); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("stream all from %q: %w", tableName, err)
+	}
+	return nil
+}
+
+func (s *store) LoadHistory(ctx context.Context, id string, model graphnode.Entity) (out []graphnode.Entity, err error) {
+	tableName := graphnode.GetTableName(model)
+	if _, found := s.selectColumns[tableName]; !found {
+		return nil, fmt.Errorf("unknown table %q", tableName)
+	}
+
+	order := "block_range_start, vid"
+	if s.immutable[tableName] {
+		order = "vid"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %q WHERE id = ? ORDER BY %s", s.selectColumns[tableName], tableName, order)
+
+	modelsPtr := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+	if err := s.db.SelectContext(ctx, modelsPtr.Interface(), query, id); err != nil {
+		return nil, fmt.Errorf("load history of %q from %q: %w", id, tableName, err)
+	}
+
+	models := modelsPtr.Elem()
+	for i := 0; i < models.Len(); i++ {
+		ent := models.Index(i).Interface().(graphnode.Entity)
+		ent.SetExists(true)
+		out = append(out, ent)
+	}
+	return out, nil
+}
+
+func (s *store) LoadCursor(ctx context.Context) (string, error) {
+	var cursor sql.NullString
+	if err := s.db.GetContext(ctx, &cursor, `SELECT cursor FROM "cursor" WHERE id = 1`); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil // nothing exists yet
+		}
+		return "", fmt.Errorf("couldn't get cursor: %w", err)
+	}
+	return cursor.String, nil
+}
+
+func (s *store) CleanDataAtBlock(ctx context.Context, blockNum uint64) error {
+	badBlockNum := blockNum + 1
+	return s.exec(ctx, "cleaned data at block", []zap.Field{zap.Uint64("bad_block_num", badBlockNum)},
+		func(table string) string {
+			if s.immutable[table] {
+				return fmt.Sprintf("DELETE FROM %q WHERE %q >= %d", table, graphnode.BlockColumn, badBlockNum)
+			}
+			return fmt.Sprintf("DELETE FROM %q WHERE _updated_block_number = %d AND block_range_end IS NULL", table, badBlockNum)
+		},
+		func(table string) string {
+			return fmt.Sprintf("UPDATE %q SET block_range_end = NULL WHERE block_range_start <= %d AND block_range_end = %d AND _updated_block_number = %d", table, blockNum, badBlockNum, badBlockNum)
+		},
+	)
+}
+
+func (s *store) CleanUpFork(ctx context.Context, longestChainStartBlock uint64) error {
+	//longestChainStartBlock:  first new block on the longest chain (after the common ancestor)
+	l := longestChainStartBlock
+	return s.exec(ctx, "cleaned fork", []zap.Field{zap.Uint64("new_head", l)},
+		func(table string) string {
+			if s.immutable[table] {
+				return fmt.Sprintf("DELETE FROM %q WHERE %q >= %d", table, graphnode.BlockColumn, l)
+			}
+			return fmt.Sprintf("DELETE FROM %q WHERE (_updated_block_number > %d AND NOT %s) OR (_updated_block_number >= %d AND %s AND block_range_start = %d)", table, l, containsBlock(l), l, containsBlock(l), l)
+		},
+		func(table string) string {
+			return fmt.Sprintf("UPDATE %q SET block_range_end = NULL, _updated_block_number = block_range_start WHERE _updated_block_number >= %d AND (%s OR block_range_end = %d)", table, l, containsBlock(l), l)
+		},
+	)
+}
+
+// exec runs the delete then the update statement on every entity table in a single
+// transaction, immutable tables only have rows to delete.
+func (s *store) exec(ctx context.Context, msg string, fields []zap.Field, deleteStmt, updateStmt func(table string) string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	for table := range s.subgraph.Entities.Data() {
+		deleted, err := execCount(ctx, tx, deleteStmt(table))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("delete rows of table %s: %w", table, err)
+		}
+
+		var updated int64
+		if !s.immutable[table] {
+			updated, err = execCount(ctx, tx, updateStmt(table))
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("update rows of table %s: %w", table, err)
+			}
+		}
+
+		s.logger.Info(msg, append([]zap.Field{zap.String("table", table), zap.Int64("deleted_rows", deleted), zap.Int64("updated_rows", updated)}, fields...)...)
+	}
+
+	return tx.Commit()
+}
+
+// PruneBelow records `earliestBlock` then deletes, table by table, at most `batchSize`
+// versions closed at or before it.
+func (s *store) PruneBelow(ctx context.Context, earliestBlock uint64, batchSize int) (deleted int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Loads below the earliest block must fail before any of their versions is deleted
+	if earliestBlock > atomic.LoadUint64(&s.earliestBlock) {
+		atomic.StoreUint64(&s.earliestBlock, earliestBlock)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO pruning (id, earliest_block) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET earliest_block = max(earliest_block, excluded.earliest_block)`, earliestBlock); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("saving earliest block: %w", err)
+	}
+
+	tables := make([]string, 0, s.subgraph.Entities.Len())
+	for table := range s.subgraph.Entities.Data() {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if deleted >= batchSize {
+			break
+		}
+		// Immutable entities are never closed, there is nothing to prune
+		if s.immutable[table] {
+			continue
+		}
+
+		stmt := fmt.Sprintf(`DELETE FROM %q WHERE vid IN (SELECT vid FROM %q WHERE block_range_end <= %d LIMIT %d)`, table, table, earliestBlock, batchSize-deleted)
+		count, err := execCount(ctx, tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("pruning table %s: %w", table, err)
+		}
+		deleted += int(count)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return deleted, nil
+}
+
+func (s *store) EarliestBlock(ctx context.Context) (uint64, error) {
+	return atomic.LoadUint64(&s.earliestBlock), nil
+}
+
+func (s *store) LoadProvenance(ctx context.Context) (out []*storage.Provenance, err error) {
+	query := `SELECT package_name, package_version, module_name, module_hash, start_block, since_block FROM provenance ORDER BY id`
+	if err := s.db.SelectContext(ctx, &out, query); err != nil {
+		return nil, fmt.Errorf("loading provenance: %w", err)
+	}
+	return out, nil
+}
+
+func (s *store) SaveProvenance(ctx context.Context, provenance *storage.Provenance) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	query := `INSERT INTO provenance (package_name, package_version, module_name, module_hash, start_block, since_block) VALUES (:package_name, :package_version, :module_name, :module_hash, :start_block, :since_block)`
+	if _, err := s.db.NamedExecContext(ctx, query, provenance); err != nil {
+		return fmt.Errorf("saving provenance: %w", err)
+	}
+	return nil
+}
+
+func execCount(ctx context.Context, tx *sqlx.Tx, stmt string) (int64, error) {
+	res, err := tx.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,358 @@
+// Package storagetest contains the conformance suite every `storage.Store` implementation
+// must pass. The suite only loads entities at the head block being written, like the
+// loader does, historical reads go through `LoadAllDistinct`.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEntity is the entity the suite reads and writes, its table is `test_entity`.
+type TestEntity struct {
+	graphnode.Base
+	Name   string        `db:"name" csv:"name"`
+	Amount graphnode.Int `db:"amount" csv:"amount"`
+}
+
+func NewTestEntity(id, name string, amount int64) *TestEntity {
+	return &TestEntity{
+		Base:   graphnode.NewBase(id),
+		Name:   name,
+		Amount: graphnode.NewIntFromLiteral(amount),
+	}
+}
+
+// Registry returns the registry the store under test must be created with.
+func Registry() *graphnode.Registry {
+	return graphnode.NewRegistry(&TestEntity{})
+}
+
+// Factory returns a new, empty store created with the entities of `Registry()`.
+type Factory func(t *testing.T) storage.Store
+
+type version struct {
+	Name       string
+	Amount     string
+	BlockRange string
+}
+
+// RunConformance runs every conformance test against stores created by `factory`.
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s *harness)
+	}{
+		{"save and load", testSaveAndLoad},
+		{"versions", testVersions},
+		{"delete", testDelete},
+		{"cursor", testCursor},
+		{"clean data at block", testCleanDataAtBlock},
+		{"clean up fork", testCleanUpFork},
+		{"prune", testPrune},
+		{"history", testHistory},
+		{"stream all distinct", testStreamAllDistinct},
+		{"provenance", testProvenance},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := factory(t)
+			defer store.Close()
+
+			test.test(t, &harness{t: t, store: store})
+		})
+	}
+}
+
+type harness struct {
+	t     *testing.T
+	store storage.Store
+}
+
+func (h *harness) save(blockNum uint64, entities ...*TestEntity) {
+	h.write(blockNum, entities, nil)
+}
+
+func (h *harness) remove(blockNum uint64, ids ...string) {
+	h.write(blockNum, nil, ids)
+}
+
+// write applies the changes the way the loader does: entities are first loaded at
+// `blockNum` so the store knows which version gets closed.
+func (h *harness) write(blockNum uint64, entities []*TestEntity, removedIDs []string) {
+	table := map[string]graphnode.Entity{}
+	for _, ent := range entities {
+		current := h.load(ent.ID, blockNum)
+		if current != nil {
+			ent.SetVID(current.GetVID())
+			ent.SetBlockRange(current.GetBlockRange())
+		}
+		table[ent.ID] = ent
+	}
+	for _, id := range removedIDs {
+		table[id] = nil
+	}
+
+	updates := map[string]map[string]graphnode.Entity{"test_entity": table}
+	require.NoError(h.t, h.store.BatchSave(context.Background(), blockNum, "", time.Unix(int64(blockNum), 0), updates, cursorAt(blockNum)))
+}
+
+func (h *harness) load(id string, blockNum uint64) *TestEntity {
+	ent := &TestEntity{Base: graphnode.NewBase(id)}
+	require.NoError(h.t, h.store.Load(context.Background(), id, ent, blockNum))
+	if !ent.Exists() {
+		return nil
+	}
+	return ent
+}
+
+func (h *harness) at(blockNum uint64) map[string]version {
+	entities, err := h.store.LoadAllDistinct(context.Background(), &TestEntity{}, blockNum)
+	require.NoError(h.t, err)
+
+	out := map[string]version{}
+	for _, ent := range entities {
+		e := ent.(*TestEntity)
+		assert.True(h.t, e.Exists(), "entity %q loaded from store must exist", e.ID)
+		out[e.ID] = version{Name: e.Name, Amount: e.Amount.String(), BlockRange: e.BlockRange.String()}
+	}
+	return out
+}
+
+func (h *harness) ids(blockNum uint64) (out []string) {
+	for id := range h.at(blockNum) {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return
+}
+
+func cursorAt(blockNum uint64) string {
+	return "cursor:" + time.Unix(int64(blockNum), 0).UTC().Format(time.RFC3339)
+}
+
+func testSaveAndLoad(t *testing.T, h *harness) {
+	assert.Nil(t, h.load("a", 10))
+
+	h.save(10, NewTestEntity("a", "first", 1), NewTestEntity("b", "second", 2))
+
+	a := h.load("a", 11)
+	require.NotNil(t, a)
+	assert.Equal(t, "first", a.Name)
+	assert.Equal(t, "1", a.Amount.String())
+	assert.Equal(t, &graphnode.BlockRange{StartBlock: 10}, a.BlockRange)
+	assert.NotZero(t, a.VID)
+
+	assert.Equal(t, []string{"a", "b"}, h.ids(10))
+	assert.Empty(t, h.ids(9))
+}
+
+func testVersions(t *testing.T, h *harness) {
+	h.save(10, NewTestEntity("a", "v1", 1))
+	h.save(20, NewTestEntity("a", "v2", 2))
+	h.save(30, NewTestEntity("a", "v3", 3))
+
+	assert.Equal(t, "v3", h.load("a", 31).Name)
+
+	assert.Equal(t, map[string]version{"a": {"v1", "1", "[10,20)"}}, h.at(10))
+	assert.Equal(t, map[string]version{"a": {"v1", "1", "[10,20)"}}, h.at(19))
+	assert.Equal(t, map[string]version{"a": {"v2", "2", "[20,30)"}}, h.at(20))
+	assert.Equal(t, map[string]version{"a": {"v3", "3", "[30,)"}}, h.at(1000))
+}
+
+func testDelete(t *testing.T, h *harness) {
+	h.save(10, NewTestEntity("a", "v1", 1), NewTestEntity("b", "v1", 1))
+	h.save(20, NewTestEntity("a", "v2", 2))
+	h.remove(30, "a")
+	h.remove(30, "never-existed")
+
+	assert.Nil(t, h.load("a", 31))
+	assert.Equal(t, []string{"b"}, h.ids(30))
+	assert.Equal(t, map[string]version{"a": {"v2", "2", "[20,30)"}, "b": {"v1", "1", "[10,)"}}, h.at(29))
+
+	// Re-created after removal
+	h.save(40, NewTestEntity("a", "v3", 3))
+	assert.Equal(t, "v3", h.load("a", 41).Name)
+	assert.Equal(t, []string{"b"}, h.ids(35))
+	assert.Equal(t, []string{"a", "b"}, h.ids(40))
+}
+
+func testCursor(t *testing.T, h *harness) {
+	cursor, err := h.store.LoadCursor(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "", cursor)
+
+	h.save(10, NewTestEntity("a", "v1", 1))
+	h.save(11, NewTestEntity("a", "v2", 2))
+
+	cursor, err = h.store.LoadCursor(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, cursorAt(11), cursor)
+}
+
+func testCleanDataAtBlock(t *testing.T, h *harness) {
+	h.save(10, NewTestEntity("a", "v1", 1))
+	h.save(20, NewTestEntity("a", "v2", 2), NewTestEntity("b", "v1", 1))
+
+	require.NoError(t, h.store.CleanDataAtBlock(context.Background(), 19))
+
+	assert.Equal(t, map[string]version{"a": {"v1", "1", "[10,)"}}, h.at(20))
+	assert.Equal(t, "v1", h.load("a", 20).Name)
+}
+
+func testCleanUpFork(t *testing.T, h *harness) {
+	h.save(10, NewTestEntity("a", "v1", 1), NewTestEntity("c", "v1", 1))
+	h.save(20, NewTestEntity("a", "v2", 2))
+	h.save(25, NewTestEntity("b", "v1", 1))
+	h.save(30, NewTestEntity("a", "v3", 3))
+	h.remove(30, "c")
+
+	// Blocks 25 and above were on a forked chain
+	require.NoError(t, h.store.CleanUpFork(context.Background(), 25))
+
+	assert.Equal(t, map[string]version{"a": {"v2", "2", "[20,)"}, "c": {"v1", "1", "[10,)"}}, h.at(25))
+	assert.Equal(t, map[string]version{"a": {"v1", "1", "[10,20)"}, "c": {"v1", "1", "[10,)"}}, h.at(15))
+	assert.Nil(t, h.load("b", 25))
+	assert.Equal(t, "v2", h.load("a", 25).Name)
+
+	// The new chain keeps going from the common ancestor
+	h.save(25, NewTestEntity("a", "v2'", 4))
+	assert.Equal(t, map[string]version{"a": {"v2'", "4", "[25,)"}, "c": {"v1", "1", "[10,)"}}, h.at(25))
+	assert.Equal(t, map[string]version{"a": {"v2", "2", "[20,25)"}, "c": {"v1", "1", "[10,)"}}, h.at(24))
+}
+
+func testPrune(t *testing.T, h *harness) {
+	pruner, ok := h.store.(storage.Pruner)
+	if !ok {
+		t.Skip("store does not implement storage.Pruner")
+	}
+	ctx := context.Background()
+
+	earliest, err := pruner.EarliestBlock(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), earliest)
+
+	h.save(10, NewTestEntity("a", "v1", 1), NewTestEntity("b", "v1", 1), NewTestEntity("c", "v1", 1))
+	h.remove(15, "b")
+	h.save(20, NewTestEntity("a", "v2", 2))
+	h.save(30, NewTestEntity("a", "v3", 3))
+
+	// Versions closed at or before block 20: a [10,20) and b [10,15)
+	deleted, err := pruner.PruneBelow(ctx, 20, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	deleted, err = pruner.PruneBelow(ctx, 20, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	deleted, err = pruner.PruneBelow(ctx, 20, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	earliest, err = pruner.EarliestBlock(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), earliest)
+
+	assert.Equal(t, map[string]version{"a": {"v2", "2", "[20,30)"}, "c": {"v1", "1", "[10,)"}}, h.at(20))
+	assert.Equal(t, map[string]version{"a": {"v3", "3", "[30,)"}, "c": {"v1", "1", "[10,)"}}, h.at(30))
+	assert.Equal(t, "v3", h.load("a", 31).Name)
+
+	_, err = h.store.LoadAllDistinct(ctx, &TestEntity{}, 19)
+	assert.ErrorIs(t, err, storage.ErrBlockPruned)
+	assert.ErrorIs(t, h.store.Load(ctx, "c", &TestEntity{Base: graphnode.NewBase("c")}, 19), storage.ErrBlockPruned)
+
+	// The earliest block never moves back
+	_, err = pruner.PruneBelow(ctx, 5, 10)
+	require.NoError(t, err)
+	earliest, err = pruner.EarliestBlock(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), earliest)
+}
+
+func testHistory(t *testing.T, h *harness) {
+	historyLoader, ok := h.store.(storage.HistoryLoader)
+	if !ok {
+		t.Skip("store does not implement storage.HistoryLoader")
+	}
+
+	h.save(10, NewTestEntity("a", "v1", 1), NewTestEntity("b", "v1", 1))
+	h.save(20, NewTestEntity("a", "v2", 2))
+	h.remove(25, "a")
+	h.save(30, NewTestEntity("a", "v3", 3))
+
+	history := func(id string) (out []version) {
+		versions, err := historyLoader.LoadHistory(context.Background(), id, &TestEntity{})
+		require.NoError(t, err)
+		for _, ent := range versions {
+			e := ent.(*TestEntity)
+			assert.True(t, e.Exists(), "entity %q loaded from store must exist", e.ID)
+			out = append(out, version{Name: e.Name, Amount: e.Amount.String(), BlockRange: e.BlockRange.String()})
+		}
+		return out
+	}
+
+	assert.Equal(t, []version{{"v1", "1", "[10,20)"}, {"v2", "2", "[20,25)"}, {"v3", "3", "[30,)"}}, history("a"))
+	assert.Equal(t, []version{{"v1", "1", "[10,)"}}, history("b"))
+	assert.Empty(t, history("c"))
+}
+
+func testStreamAllDistinct(t *testing.T, h *harness) {
+	streamer, ok := h.store.(storage.DistinctStreamer)
+	if !ok {
+		t.Skip("store does not implement storage.DistinctStreamer")
+	}
+
+	h.save(10, NewTestEntity("b", "v1", 1))
+	h.save(20, NewTestEntity("c", "v1", 1))
+	h.save(25, NewTestEntity("a", "v1", 1))
+	h.save(30, NewTestEntity("b", "v2", 2))
+
+	var ids []string
+	err := streamer.StreamAllDistinct(context.Background(), &TestEntity{}, 35, func(ent graphnode.Entity) error {
+		assert.True(t, ent.Exists(), "entity %q loaded from store must exist", ent.GetID())
+		ids = append(ids, ent.GetID()+"@"+ent.GetBlockRange().String())
+		return nil
+	})
+	require.NoError(t, err)
+	// Ordered by the start of their block range
+	assert.Equal(t, []string{"c@[20,)", "a@[25,)", "b@[30,)"}, ids)
+
+	// Stops at the first error of the handler
+	stop := errors.New("stop")
+	count := 0
+	err = streamer.StreamAllDistinct(context.Background(), &TestEntity{}, 35, func(ent graphnode.Entity) error {
+		count++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, count)
+}
+
+func testProvenance(t *testing.T, h *harness) {
+	provenanceStore, ok := h.store.(storage.ProvenanceStore)
+	if !ok {
+		t.Skip("store does not implement storage.ProvenanceStore")
+	}
+	ctx := context.Background()
+
+	recorded, err := provenanceStore.LoadProvenance(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+
+	first := &storage.Provenance{PackageName: "pcs", PackageVersion: "v0.1.0", ModuleName: "db_out", ModuleHash: "aaaa", StartBlock: 10, SinceBlock: 10}
+	second := &storage.Provenance{PackageName: "pcs", PackageVersion: "v0.2.0", ModuleName: "db_out", ModuleHash: "bbbb", StartBlock: 10, SinceBlock: 30}
+	require.NoError(t, provenanceStore.SaveProvenance(ctx, first))
+	require.NoError(t, provenanceStore.SaveProvenance(ctx, second))
+
+	recorded, err = provenanceStore.LoadProvenance(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []*storage.Provenance{first, second}, recorded)
+}
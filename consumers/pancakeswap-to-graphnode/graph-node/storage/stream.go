@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"context"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+)
+
+// DistinctStreamer is implemented by the stores able to read the entities valid at a block
+// without loading them all in memory.
+type DistinctStreamer interface {
+	// StreamAllDistinct calls `handle` with every entity of the table of `model` valid at
+	// `blockNum`, ordered by the start of their block range, stopping at the first error
+	// `handle` returns.
+	StreamAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64, handle func(ent graphnode.Entity) error) error
+}
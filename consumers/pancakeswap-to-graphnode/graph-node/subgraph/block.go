@@ -0,0 +1,17 @@
+package subgraph
+
+import "time"
+
+type blockRef struct {
+	id        string
+	number    uint64
+	timestamp time.Time
+}
+
+func NewBlockRef(id string, number uint64, timestamp time.Time) BlockRef {
+	return &blockRef{id: id, number: number, timestamp: timestamp}
+}
+
+func (b *blockRef) ID() string           { return b.id }
+func (b *blockRef) Number() uint64       { return b.number }
+func (b *blockRef) Timestamp() time.Time { return b.timestamp }
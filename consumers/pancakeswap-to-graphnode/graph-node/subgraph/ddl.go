@@ -0,0 +1,162 @@
+package subgraph
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var createTableRegex = regexp.MustCompile(`(?i)create\s+table\s+(?:if\s+not\s+exists\s+)?%%SCHEMA%%\.("?[\w$]+"?)\s*\(`)
+var createIndexRegex = regexp.MustCompile(`(?i)create\s+(?:unique\s+)?index\s+(?:if\s+not\s+exists\s+)?("?[\w$]+"?)\s+on\s`)
+
+// TableDefinition is a `create table` statement of a `DDL`, as written for Postgres.
+type TableDefinition struct {
+	Name    string
+	Columns []*ColumnDefinition
+}
+
+func (t *TableDefinition) Column(name string) (*ColumnDefinition, bool) {
+	for _, column := range t.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return nil, false
+}
+
+type ColumnDefinition struct {
+	Name string
+	Type string
+	// Constraints holds the words following the type, ex: `not null`, `default 0`
+	Constraints []string
+}
+
+func (c *ColumnDefinition) NotNull() bool {
+	return strings.Contains(strings.ToLower(strings.Join(c.Constraints, " ")), "not null")
+}
+
+func (c *ColumnDefinition) HasDefault() bool {
+	for _, word := range c.Constraints {
+		if strings.EqualFold(word, "default") {
+			return true
+		}
+	}
+	return false
+}
+
+// Definition returns the column as it appears in a `create table` or `add column` statement.
+func (c *ColumnDefinition) Definition() string {
+	return strings.Join(append([]string{fmt.Sprintf("%q", c.Name), c.Type}, c.Constraints...), " ")
+}
+
+// ParseCreateTables extracts every `create table %%SCHEMA%%.<name> (...)` statement found
+// in `sql`. Table constraints (ex: `constraint x exclude using gist (...)`) are skipped.
+func ParseCreateTables(sql string) (out []*TableDefinition, err error) {
+	for _, loc := range createTableRegex.FindAllStringSubmatchIndex(sql, -1) {
+		table := &TableDefinition{Name: strings.Trim(sql[loc[2]:loc[3]], `"`)}
+
+		end := closingParen(sql, loc[1])
+		if end == -1 {
+			return nil, fmt.Errorf("table %q: unbalanced parenthesis in create statement", table.Name)
+		}
+
+		for _, definition := range splitColumns(sql[loc[1]:end]) {
+			fields := strings.Fields(definition)
+			if len(fields) == 0 || strings.EqualFold(fields[0], "constraint") {
+				continue
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("table %q: invalid column definition %q", table.Name, strings.TrimSpace(definition))
+			}
+
+			table.Columns = append(table.Columns, &ColumnDefinition{
+				Name:        strings.Trim(fields[0], `"`),
+				Type:        fields[1],
+				Constraints: fields[2:],
+			})
+		}
+		out = append(out, table)
+	}
+	return out, nil
+}
+
+// ParseIndexName returns the name of the index created by a `create index` statement.
+func ParseIndexName(statement string) (string, bool) {
+	match := createIndexRegex.FindStringSubmatch(statement)
+	if match == nil {
+		return "", false
+	}
+	return strings.Trim(match[1], `"`), true
+}
+
+// TableDefinitions returns the definition of every table created by the DDL, including the
+// ones of the schema setup, in order of appearance.
+func TableDefinitions(ddl DDL) (out []*TableDefinition, err error) {
+	var statements []string
+	err = ddl.InitiateSchema(func(statement string) error {
+		statements = append(statements, statement)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("schema setup statements: %w", err)
+	}
+
+	err = ddl.CreateTables(func(table string, statement string) error {
+		statements = append(statements, statement)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create table statements: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for _, statement := range statements {
+		tables, err := ParseCreateTables(statement)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range tables {
+			if seen[table.Name] {
+				continue
+			}
+			seen[table.Name] = true
+			out = append(out, table)
+		}
+	}
+	return out, nil
+}
+
+// closingParen returns the index of the parenthesis closing the one opened right before `start`.
+func closingParen(sql string, start int) int {
+	depth := 1
+	for i := start; i < len(sql); i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func splitColumns(body string) (out []string) {
+	depth, start := 0, 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, body[start:])
+}
@@ -0,0 +1,54 @@
+package subgraph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCreateTables(t *testing.T) {
+	tables, err := ParseCreateTables(`
+create table if not exists %%SCHEMA%%.token
+(
+	id text not null,
+
+	"name" text not null,
+
+	"derived_bnb" numeric,
+
+	vid bigserial not null constraint token_pkey primary key,
+	block_range int4range not null,
+	constraint token_id_block_range_excl exclude using gist (id with =, block_range with &&)
+);
+
+alter table %%SCHEMA%%.token owner to graph;
+
+create table %%SCHEMA%%.poi2$ (digest bytea not null);
+`)
+	require.NoError(t, err)
+	require.Len(t, tables, 2)
+
+	assert.Equal(t, "token", tables[0].Name)
+	require.Len(t, tables[0].Columns, 5)
+	assert.Equal(t, &ColumnDefinition{Name: "derived_bnb", Type: "numeric", Constraints: []string{}}, tables[0].Columns[2])
+	assert.False(t, tables[0].Columns[2].NotNull())
+	assert.True(t, tables[0].Columns[1].NotNull())
+	assert.Equal(t, `"vid" bigserial not null constraint token_pkey primary key`, tables[0].Columns[3].Definition())
+
+	assert.Equal(t, "poi2$", tables[1].Name)
+	assert.Equal(t, []*ColumnDefinition{{Name: "digest", Type: "bytea", Constraints: []string{"not", "null"}}}, tables[1].Columns)
+}
+
+func TestParseIndexName(t *testing.T) {
+	name, found := ParseIndexName(`create index if not exists pair_token_0_price on %%SCHEMA%%.pair using btree ("token_0_price");`)
+	assert.True(t, found)
+	assert.Equal(t, "pair_token_0_price", name)
+
+	name, found = ParseIndexName(`create unique index "poi2$_id" on %%SCHEMA%%.poi2$ (id);`)
+	assert.True(t, found)
+	assert.Equal(t, "poi2$_id", name)
+
+	_, found = ParseIndexName(`drop index if exists %%SCHEMA%%.pair_token_0_price;`)
+	assert.False(t, found)
+}
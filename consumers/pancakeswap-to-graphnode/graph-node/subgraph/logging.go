@@ -0,0 +1,7 @@
+package subgraph
+
+import (
+	"github.com/streamingfast/logging"
+)
+
+var zlog, _ = logging.PackageLogger("substreams.graph-node.subgraph", "github.com/streamingfast/substreams/graph-node/subgraph")
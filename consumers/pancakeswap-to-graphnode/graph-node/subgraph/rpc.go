@@ -0,0 +1,254 @@
+package subgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	eth "github.com/streamingfast/eth-go"
+	"github.com/streamingfast/eth-go/rpc"
+	"go.uber.org/zap"
+)
+
+const rpcBatchSize = 50
+const rpcMaxAttempts = 5
+const rpcRetryDelay = 500 * time.Millisecond
+
+// RPCClient performs `eth_call` requests pinned to a given block. Responses are only
+// ever returned when they are deterministic: successful calls and execution errors
+// (reverts, out of gas, etc.) are cached, while transport errors are retried and
+// eventually reported as an error, never as a response.
+type RPCClient struct {
+	client *rpc.Client
+	cache  RPCCache
+	logger *zap.Logger
+
+	methods map[string]*eth.MethodDef
+}
+
+// NewRPCClient creates a client of the JSON-RPC node at `endpoint`, `cache` can be `nil`.
+func NewRPCClient(endpoint string, cache RPCCache, logger *zap.Logger) *RPCClient {
+	httpClient := &http.Client{Transport: &statusTransport{base: http.DefaultTransport}}
+
+	return &RPCClient{
+		client:  rpc.NewClient(endpoint, rpc.WithHttpClient(httpClient)),
+		cache:   cache,
+		logger:  logger,
+		methods: map[string]*eth.MethodDef{},
+	}
+}
+
+// Calls executes the calls against the state at `blockNum`, responses are in the same
+// order as the calls.
+func (c *RPCClient) Calls(ctx context.Context, blockNum uint64, calls []*RPCCall) ([]*RPCResponse, error) {
+	out := make([]*RPCResponse, len(calls))
+	methods := make([]*eth.MethodDef, len(calls))
+
+	var missing []int
+	for idx, call := range calls {
+		method, err := c.method(call.MethodSignature)
+		if err != nil {
+			return nil, err
+		}
+		methods[idx] = method
+
+		if c.cache != nil {
+			if cached, found := c.cache.Get(rpcCacheKey(blockNum, call)); found {
+				out[idx] = cached.response(method)
+				continue
+			}
+		}
+		missing = append(missing, idx)
+	}
+
+	for start := 0; start < len(missing); start += rpcBatchSize {
+		end := start + rpcBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		batch := missing[start:end]
+		results, err := c.doCalls(ctx, blockNum, calls, methods, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, idx := range batch {
+			result := results[i]
+			if c.cache != nil {
+				if err := c.cache.Set(rpcCacheKey(blockNum, calls[idx]), result); err != nil {
+					return nil, fmt.Errorf("caching rpc call %q: %w", calls[idx].ToString(), err)
+				}
+			}
+			out[idx] = result.response(methods[idx])
+		}
+	}
+
+	return out, nil
+}
+
+func (c *RPCClient) doCalls(ctx context.Context, blockNum uint64, calls []*RPCCall, methods []*eth.MethodDef, batch []int) ([]*CachedRPCResult, error) {
+	reqs := make([]*rpc.RPCRequest, len(batch))
+	for i, idx := range batch {
+		addr, err := eth.NewAddress(calls[idx].ToAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", calls[idx].ToAddr, err)
+		}
+		reqs[i] = rpc.NewETHCall(addr, methods[idx], rpc.AtBlockNum(blockNum)).ToRequest()
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= rpcMaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * rpcRetryDelay):
+			}
+		}
+
+		results, err := c.tryCalls(ctx, calls, batch, reqs)
+		if err == nil {
+			return results, nil
+		}
+		if !isTransientRPCError(err) {
+			return nil, fmt.Errorf("rpc calls at block %d: %w", blockNum, err)
+		}
+
+		lastErr = err
+		c.logger.Warn("rpc calls failed, retrying", zap.Uint64("block_num", blockNum), zap.Int("attempt", attempt), zap.Int("call_count", len(batch)), zap.Error(err))
+	}
+
+	return nil, fmt.Errorf("rpc calls at block %d failed after %d attempts: %w", blockNum, rpcMaxAttempts, lastErr)
+}
+
+func (c *RPCClient) tryCalls(ctx context.Context, calls []*RPCCall, batch []int, reqs []*rpc.RPCRequest) ([]*CachedRPCResult, error) {
+	resps, err := c.client.DoRequests(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*CachedRPCResult, len(resps))
+	for i, resp := range resps {
+		if resp.Err != nil {
+			if !resp.Deterministic() {
+				return nil, &transientRPCError{fmt.Errorf("non-deterministic error calling %q: %w", calls[batch[i]].ToString(), resp.Err)}
+			}
+			results[i] = &CachedRPCResult{CallError: resp.Err.Error()}
+			continue
+		}
+		results[i] = &CachedRPCResult{Raw: resp.Content}
+	}
+
+	return results, nil
+}
+
+// transientRPCError is an error of the node which may not happen again, like a block not
+// yet known to the node it was routed to.
+type transientRPCError struct {
+	err error
+}
+
+func (e *transientRPCError) Error() string { return e.err.Error() }
+func (e *transientRPCError) Unwrap() error { return e.err }
+
+// rpcStatusError is an HTTP response of the node with an error status.
+type rpcStatusError struct {
+	StatusCode int
+}
+
+func (e *rpcStatusError) Error() string {
+	return fmt.Sprintf("error in response: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// statusTransport reports the HTTP responses with an error status as an `*rpcStatusError`,
+// the rpc client only reports them as text.
+type statusTransport struct {
+	base http.RoundTripper
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &rpcStatusError{StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// isTransientRPCError tells whether the calls failing with `err` are worth retrying: the
+// request did not reach the node, the node is overloaded or reported a transient error.
+// Malformed responses and the other errors are returned right away.
+func isTransientRPCError(err error) bool {
+	var transientErr *transientRPCError
+	if errors.As(err, &transientErr) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *rpcStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (c *RPCClient) method(signature string) (*eth.MethodDef, error) {
+	if method, found := c.methods[signature]; found {
+		return method, nil
+	}
+
+	method, err := eth.NewMethodDef(signature)
+	if err != nil {
+		return nil, fmt.Errorf("parsing method signature: %w", err)
+	}
+	c.methods[signature] = method
+	return method, nil
+}
+
+// CachedRPCResult is the deterministic outcome of an `eth_call`, as persisted in the cache.
+type CachedRPCResult struct {
+	Raw       string `json:"raw,omitempty"`
+	CallError string `json:"call_error,omitempty"`
+}
+
+func (r *CachedRPCResult) response(method *eth.MethodDef) *RPCResponse {
+	resp := &RPCResponse{Raw: r.Raw}
+	if r.CallError != "" {
+		resp.CallError = fmt.Errorf("%s", r.CallError)
+		return resp
+	}
+
+	if r.Raw == "" || r.Raw == "0x" {
+		resp.DecodingError = fmt.Errorf("empty response")
+		return resp
+	}
+
+	data, err := eth.NewHex(r.Raw)
+	if err != nil {
+		resp.DecodingError = fmt.Errorf("invalid hex response: %w", err)
+		return resp
+	}
+
+	resp.Decoded, resp.DecodingError = method.DecodeOutput(data)
+	return resp
+}
+
+func rpcCacheKey(blockNum uint64, call *RPCCall) string {
+	return fmt.Sprintf("%d:%s:%s", blockNum, strings.ToLower(call.ToAddr), call.MethodSignature)
+}
@@ -0,0 +1,137 @@
+package subgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// RPCCache stores deterministic `eth_call` results keyed by block, address and method signature.
+type RPCCache interface {
+	Get(key string) (*CachedRPCResult, bool)
+	Set(key string, result *CachedRPCResult) error
+	Close() error
+}
+
+type fileRPCCacheEntry struct {
+	Key string `json:"key"`
+	CachedRPCResult
+}
+
+// FileRPCCache is an append-only, newline-delimited JSON cache on disk. The whole file
+// is read in memory when opened and every new result is appended to it right away, so
+// a crash never loses more than the call being written.
+type FileRPCCache struct {
+	lock    sync.RWMutex
+	entries map[string]*CachedRPCResult
+	file    *os.File
+	writer  *bufio.Writer
+}
+
+func NewFileRPCCache(path string) (*FileRPCCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating rpc cache directory: %w", err)
+	}
+
+	entries := map[string]*CachedRPCResult{}
+	if err := readRPCCacheFile(path, entries); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening rpc cache %q: %w", path, err)
+	}
+
+	return &FileRPCCache{
+		entries: entries,
+		file:    file,
+		writer:  bufio.NewWriter(file),
+	}, nil
+}
+
+func readRPCCacheFile(path string, entries map[string]*CachedRPCResult) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("opening rpc cache %q: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := &fileRPCCacheEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// A partially written last line is expected after a crash, the call is simply done again.
+			zlog.Warn("skipping invalid rpc cache line", zap.String("path", path), zap.Int("line", line))
+			continue
+		}
+		result := entry.CachedRPCResult
+		entries[entry.Key] = &result
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading rpc cache %q: %w", path, err)
+	}
+	return nil
+}
+
+func (c *FileRPCCache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.entries)
+}
+
+func (c *FileRPCCache) Get(key string) (*CachedRPCResult, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result, found := c.entries[key]
+	return result, found
+}
+
+func (c *FileRPCCache) Set(key string, result *CachedRPCResult) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, found := c.entries[key]; found {
+		return nil
+	}
+
+	cnt, err := json.Marshal(&fileRPCCacheEntry{Key: key, CachedRPCResult: *result})
+	if err != nil {
+		return fmt.Errorf("marshal rpc cache entry: %w", err)
+	}
+
+	if _, err := c.writer.Write(append(cnt, '\n')); err != nil {
+		return fmt.Errorf("writing rpc cache entry: %w", err)
+	}
+	if err := c.writer.Flush(); err != nil {
+		return fmt.Errorf("flushing rpc cache: %w", err)
+	}
+
+	c.entries[key] = result
+	return nil
+}
+
+func (c *FileRPCCache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.writer.Flush(); err != nil {
+		return fmt.Errorf("flushing rpc cache: %w", err)
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,241 @@
+package subgraph
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const (
+	wbnbAddress     = "0xbb4cdb9cbd36b01bd1cbaebf2de08d9173bc095c"
+	revertAddress   = "0x0000000000000000000000000000000000000bad"
+	flakyAddress    = "0x000000000000000000000000000000000000f1a7"
+	garbageAddress  = "0x0000000000000000000000000000000000009a9b"
+	overloadAddress = "0x0000000000000000000000000000000000000503"
+	rejectAddress   = "0x0000000000000000000000000000000000000400"
+	eoaAddress      = "0x0000000000000000000000000000000000000001"
+	nameMethodID    = "06fdde03"
+	decimalsMethoID = "313ce567"
+)
+
+type fakeRPCServer struct {
+	lock       sync.Mutex
+	calls      int
+	blocks     []string
+	flakyCount int
+}
+
+func (s *fakeRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var reqs []struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var out []string
+	for _, req := range reqs {
+		s.calls++
+
+		var params struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		var block string
+		if err := json.Unmarshal(req.Params[0], &params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(req.Params[1], &block); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.blocks = append(s.blocks, block)
+
+		switch params.To {
+		case garbageAddress:
+			_, _ = w.Write([]byte(fmt.Sprintf(`[{"jsonrpc":"2.0","id":%s,"error":"malformed"}]`, string(req.ID))))
+			return
+		case overloadAddress:
+			if s.flakyCount == 0 {
+				s.flakyCount++
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+		case rejectAddress:
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case params.To == revertAddress:
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32000,"message":"execution reverted"}}`, string(req.ID)))
+		case params.To == flakyAddress && s.flakyCount == 0:
+			s.flakyCount++
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32000,"message":"header not found"}}`, string(req.ID)))
+		case params.To == eoaAddress:
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"0x"}`, string(req.ID)))
+		case strings.HasSuffix(params.Data, nameMethodID):
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"0x%s"}`, string(req.ID), abiEncodeString("Wrapped BNB")))
+		case strings.HasSuffix(params.Data, decimalsMethoID):
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"0x%064x"}`, string(req.ID), 18))
+		default:
+			out = append(out, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"0x"}`, string(req.ID)))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("[" + strings.Join(out, ",") + "]"))
+}
+
+func abiEncodeString(s string) string {
+	padded := make([]byte, (len(s)+31)/32*32)
+	copy(padded, s)
+	return fmt.Sprintf("%064x%064x%s", 32, len(s), hex.EncodeToString(padded))
+}
+
+func newTestRPCClient(t *testing.T, server *fakeRPCServer, cache RPCCache) *RPCClient {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	return NewRPCClient(httpServer.URL, cache, zap.NewNop())
+}
+
+func TestRPCClient_Calls(t *testing.T) {
+	server := &fakeRPCServer{}
+	client := newTestRPCClient(t, server, nil)
+
+	resps, err := client.Calls(context.Background(), 6809737, []*RPCCall{
+		{ToAddr: wbnbAddress, MethodSignature: "name() (string)"},
+		{ToAddr: wbnbAddress, MethodSignature: "decimals() (uint8)"},
+		{ToAddr: revertAddress, MethodSignature: "name() (string)"},
+		{ToAddr: eoaAddress, MethodSignature: "name() (string)"},
+	})
+	require.NoError(t, err)
+	require.Len(t, resps, 4)
+
+	require.NoError(t, resps[0].CallError)
+	require.NoError(t, resps[0].DecodingError)
+	assert.Equal(t, []interface{}{"Wrapped BNB"}, resps[0].Decoded)
+
+	require.NoError(t, resps[1].DecodingError)
+	assert.Equal(t, []interface{}{uint64(18)}, normalizeDecoded(resps[1].Decoded))
+
+	assert.Error(t, resps[2].CallError)
+	assert.Nil(t, resps[2].Decoded)
+
+	assert.NoError(t, resps[3].CallError)
+	assert.Error(t, resps[3].DecodingError)
+
+	assert.Equal(t, []string{"0x67e889", "0x67e889", "0x67e889", "0x67e889"}, server.blocks)
+}
+
+func TestRPCClient_RetriesNonDeterministicErrors(t *testing.T) {
+	server := &fakeRPCServer{}
+	client := newTestRPCClient(t, server, nil)
+
+	resps, err := client.Calls(context.Background(), 10, []*RPCCall{
+		{ToAddr: flakyAddress, MethodSignature: "name() (string)"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"Wrapped BNB"}, resps[0].Decoded)
+	assert.Equal(t, 2, server.calls)
+}
+
+func TestRPCClient_RetriesTransientErrorsOnly(t *testing.T) {
+	server := &fakeRPCServer{}
+	client := newTestRPCClient(t, server, nil)
+
+	resps, err := client.Calls(context.Background(), 10, []*RPCCall{{ToAddr: overloadAddress, MethodSignature: "decimals() (uint8)"}})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{uint64(18)}, normalizeDecoded(resps[0].Decoded))
+	assert.Equal(t, 2, server.calls)
+
+	server = &fakeRPCServer{}
+	client = newTestRPCClient(t, server, nil)
+
+	_, err = client.Calls(context.Background(), 10, []*RPCCall{{ToAddr: garbageAddress, MethodSignature: "name() (string)"}})
+	require.Error(t, err)
+	assert.Equal(t, 1, server.calls)
+
+	_, err = client.Calls(context.Background(), 10, []*RPCCall{{ToAddr: "not-an-address", MethodSignature: "name() (string)"}})
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.calls)
+
+	_, err = client.Calls(context.Background(), 10, []*RPCCall{{ToAddr: rejectAddress, MethodSignature: "name() (string)"}})
+	var statusErr *rpcStatusError
+	require.True(t, errors.As(err, &statusErr), "got %v", err)
+	assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+	assert.Equal(t, 2, server.calls)
+}
+
+func TestRPCClient_PersistentCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "rpc", "cache.jsonl")
+	calls := []*RPCCall{
+		{ToAddr: wbnbAddress, MethodSignature: "name() (string)"},
+		{ToAddr: revertAddress, MethodSignature: "name() (string)"},
+	}
+
+	cache, err := NewFileRPCCache(cachePath)
+	require.NoError(t, err)
+
+	server := &fakeRPCServer{}
+	client := newTestRPCClient(t, server, cache)
+
+	_, err = client.Calls(context.Background(), 10, calls)
+	require.NoError(t, err)
+	_, err = client.Calls(context.Background(), 10, calls)
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.calls)
+
+	_, err = client.Calls(context.Background(), 11, calls[:1])
+	require.NoError(t, err)
+	assert.Equal(t, 3, server.calls)
+	require.NoError(t, cache.Close())
+
+	// Reopened cache must answer without reaching the network
+	cache, err = NewFileRPCCache(cachePath)
+	require.NoError(t, err)
+	defer cache.Close()
+	assert.Equal(t, 3, cache.Len())
+
+	offline := NewRPCClient("http://127.0.0.1:1", cache, zap.NewNop())
+	resps, err := offline.Calls(context.Background(), 10, calls)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"Wrapped BNB"}, resps[0].Decoded)
+	assert.Error(t, resps[1].CallError)
+}
+
+func normalizeDecoded(in []interface{}) []interface{} {
+	out := make([]interface{}, len(in))
+	for i, v := range in {
+		switch n := v.(type) {
+		case *big.Int:
+			out[i] = n.Uint64()
+		case uint8:
+			out[i] = uint64(n)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,252 @@
+package subgraph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/streamingfast/substream-pancakeswap/graph-node/storage"
+	"go.uber.org/zap"
+)
+
+var _ Intrinsics = (*StoreIntrinsics)(nil)
+
+// StoreIntrinsics is an Intrinsics implementation backed by a `storage.Store`. Entities
+// saved or removed while handling a block are buffered in memory and only written to the
+// store when `Flush` is called. A `nil` entity in the updates buffer is a tombstone, the
+// store closes the block range of the previous version when it sees one.
+type StoreIntrinsics struct {
+	ctx      context.Context
+	store    storage.Store
+	registry *graphnode.Registry
+	rpc      *RPCClient
+	logger   *zap.Logger
+
+	step  int
+	block BlockRef
+
+	// cached entities
+	current map[string]map[string]graphnode.Entity
+	updates map[string]map[string]graphnode.Entity
+}
+
+// NewStoreIntrinsics creates the intrinsics of a subgraph, `rpc` can be `nil` in which case
+// any call to `RPC` fails.
+func NewStoreIntrinsics(ctx context.Context, store storage.Store, rpc *RPCClient, registry *graphnode.Registry, logger *zap.Logger) *StoreIntrinsics {
+	return &StoreIntrinsics{
+		ctx:      ctx,
+		store:    store,
+		rpc:      rpc,
+		registry: registry,
+		logger:   logger,
+		current:  map[string]map[string]graphnode.Entity{},
+		updates:  map[string]map[string]graphnode.Entity{},
+	}
+}
+
+// StartBlock resets the per-block buffers, it must be called before the handlers of a
+// new block are invoked.
+func (i *StoreIntrinsics) StartBlock(block BlockRef, step int) {
+	i.block = block
+	i.step = step
+	i.current = map[string]map[string]graphnode.Entity{}
+	i.updates = map[string]map[string]graphnode.Entity{}
+}
+
+// Updates returns the entities buffered for the current block, keyed by table name and id.
+func (i *StoreIntrinsics) Updates() map[string]map[string]graphnode.Entity {
+	return i.updates
+}
+
+// Flush writes the buffered updates of the current block to the store along with the cursor.
+func (i *StoreIntrinsics) Flush(cursor string) error {
+	if i.block == nil {
+		return fmt.Errorf("no block started")
+	}
+
+	if err := i.store.BatchSave(i.ctx, i.block.Number(), i.block.ID(), i.block.Timestamp(), i.updates, cursor); err != nil {
+		return fmt.Errorf("batch save at block %d: %w", i.block.Number(), err)
+	}
+	return nil
+}
+
+func (i *StoreIntrinsics) Save(entity graphnode.Entity) error {
+	if entity.GetID() == "" {
+		return fmt.Errorf("id was not set before calling save")
+	}
+
+	entity.SetExists(true)
+	entity.SetMutated(i.step)
+	i.updateTable(graphnode.GetTableName(entity))[entity.GetID()] = entity
+
+	return nil
+}
+
+func (i *StoreIntrinsics) Load(entity graphnode.Entity) error {
+	tableName := graphnode.GetTableName(entity)
+	id := entity.GetID()
+
+	if id == "" {
+		return fmt.Errorf("id was not set before calling load")
+	}
+
+	// First check from updates
+	if cachedEntity, found := i.updateTable(tableName)[id]; found {
+		if cachedEntity == nil {
+			return nil
+		}
+		copyEntity(entity, cachedEntity)
+		return nil
+	}
+
+	// Then from what was already loaded during this block
+	currentTable := i.currentTable(tableName)
+	if cachedEntity, found := currentTable[id]; found {
+		if cachedEntity == nil {
+			return nil
+		}
+		copyEntity(entity, cachedEntity)
+		return nil
+	}
+
+	// Load from store otherwise
+	if err := i.store.Load(i.ctx, id, entity, i.blockNum()); err != nil {
+		return fmt.Errorf("failed loading entity: %w", err)
+	}
+
+	if !entity.Exists() {
+		currentTable[id] = nil
+		return nil
+	}
+
+	clone, err := i.cloneEntity(tableName, entity)
+	if err != nil {
+		return err
+	}
+	currentTable[id] = clone
+
+	return nil
+}
+
+// LoadAllDistinct returns every entity of the model's table valid at `blockNum`. Updates
+// buffered for the current block are overlaid on top of what the store returns, removed
+// entities are omitted and entities not yet in the store follow in ID order.
+func (i *StoreIntrinsics) LoadAllDistinct(model graphnode.Entity, blockNum uint64) ([]graphnode.Entity, error) {
+	tableName := graphnode.GetTableName(model)
+
+	entities, err := i.store.LoadAllDistinct(i.ctx, model, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("load all distinct %q at block %d: %w", tableName, blockNum, err)
+	}
+
+	updateTable := i.updates[tableName]
+	if len(updateTable) == 0 {
+		return entities, nil
+	}
+
+	seen := map[string]bool{}
+	out := make([]graphnode.Entity, 0, len(entities))
+	for _, ent := range entities {
+		id := ent.GetID()
+		seen[id] = true
+
+		updated, found := updateTable[id]
+		if !found {
+			out = append(out, ent)
+			continue
+		}
+		if updated != nil {
+			out = append(out, updated)
+		}
+	}
+
+	var added []string
+	for id, updated := range updateTable {
+		if !seen[id] && updated != nil {
+			added = append(added, id)
+		}
+	}
+	sort.Strings(added)
+	for _, id := range added {
+		out = append(out, updateTable[id])
+	}
+
+	return out, nil
+}
+
+func (i *StoreIntrinsics) Remove(entity graphnode.Entity) error {
+	id := entity.GetID()
+	if id == "" {
+		return fmt.Errorf("id was not set before calling remove")
+	}
+
+	entity.SetExists(false)
+	i.updateTable(graphnode.GetTableName(entity))[id] = nil
+
+	return nil
+}
+
+func (i *StoreIntrinsics) Block() BlockRef {
+	return i.block
+}
+
+func (i *StoreIntrinsics) Step() int {
+	return i.step
+}
+
+func (i *StoreIntrinsics) StepBelow(step int) bool {
+	return i.step < step
+}
+
+func (i *StoreIntrinsics) StepAbove(step int) bool {
+	return i.step > step
+}
+
+func (i *StoreIntrinsics) RPC(calls []*RPCCall) ([]*RPCResponse, error) {
+	if i.rpc == nil {
+		return nil, fmt.Errorf("no rpc client configured")
+	}
+	return i.rpc.Calls(i.ctx, i.blockNum(), calls)
+}
+
+func (i *StoreIntrinsics) blockNum() uint64 {
+	if i.block == nil {
+		return 0
+	}
+	return i.block.Number()
+}
+
+func (i *StoreIntrinsics) updateTable(tableName string) map[string]graphnode.Entity {
+	table, found := i.updates[tableName]
+	if !found {
+		table = make(map[string]graphnode.Entity)
+		i.updates[tableName] = table
+	}
+	return table
+}
+
+func (i *StoreIntrinsics) currentTable(tableName string) map[string]graphnode.Entity {
+	table, found := i.current[tableName]
+	if !found {
+		table = make(map[string]graphnode.Entity)
+		i.current[tableName] = table
+	}
+	return table
+}
+
+func (i *StoreIntrinsics) cloneEntity(tableName string, entity graphnode.Entity) (graphnode.Entity, error) {
+	reflectType, ok := i.registry.GetType(tableName)
+	if !ok {
+		return nil, fmt.Errorf("unable to retrieve entity type for table %q", tableName)
+	}
+
+	clone := reflect.New(reflectType).Interface().(graphnode.Entity)
+	copyEntity(clone, entity)
+	return clone, nil
+}
+
+func copyEntity(dst, src graphnode.Entity) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
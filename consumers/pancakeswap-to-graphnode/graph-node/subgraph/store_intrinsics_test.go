@@ -0,0 +1,137 @@
+package subgraph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	graphnode "github.com/streamingfast/substream-pancakeswap/graph-node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testEntity struct {
+	graphnode.Base
+	Name string `db:"name"`
+}
+
+type testStore struct {
+	rows    map[string]*testEntity
+	loads   int
+	saved   map[string]map[string]graphnode.Entity
+	savedAt uint64
+}
+
+func (s *testStore) BatchSave(ctx context.Context, blockNum uint64, blockHash string, blockTime time.Time, updates map[string]map[string]graphnode.Entity, cursor string) error {
+	s.saved = updates
+	s.savedAt = blockNum
+	return nil
+}
+
+func (s *testStore) Load(ctx context.Context, id string, entity graphnode.Entity, blockNum uint64) error {
+	s.loads++
+	if row, found := s.rows[id]; found {
+		*(entity.(*testEntity)) = *row
+		entity.SetExists(true)
+	}
+	return nil
+}
+
+func (s *testStore) LoadAllDistinct(ctx context.Context, model graphnode.Entity, blockNum uint64) (out []graphnode.Entity, err error) {
+	for _, row := range s.rows {
+		ent := *row
+		out = append(out, &ent)
+	}
+	return
+}
+
+func (s *testStore) LoadCursor(ctx context.Context) (string, error)              { return "", nil }
+func (s *testStore) CleanDataAtBlock(ctx context.Context, blockNum uint64) error { return nil }
+func (s *testStore) CleanUpFork(ctx context.Context, newHeadBlock uint64) error  { return nil }
+func (s *testStore) Close() error                                                { return nil }
+
+func newTestIntrinsics(rows ...*testEntity) (*StoreIntrinsics, *testStore) {
+	store := &testStore{rows: map[string]*testEntity{}}
+	for _, row := range rows {
+		store.rows[row.ID] = row
+	}
+	intrinsics := NewStoreIntrinsics(context.Background(), store, nil, graphnode.NewRegistry(&testEntity{}), zap.NewNop())
+	intrinsics.StartBlock(NewBlockRef("0xaa", 10, time.Unix(0, 0)), 2)
+	return intrinsics, store
+}
+
+func TestStoreIntrinsics_LoadCachesStoreLookups(t *testing.T) {
+	intrinsics, store := newTestIntrinsics(&testEntity{Base: graphnode.NewBase("a"), Name: "first"})
+
+	for i := 0; i < 2; i++ {
+		ent := &testEntity{Base: graphnode.NewBase("a")}
+		require.NoError(t, intrinsics.Load(ent))
+		assert.True(t, ent.Exists())
+		assert.Equal(t, "first", ent.Name)
+	}
+
+	missing := &testEntity{Base: graphnode.NewBase("b")}
+	require.NoError(t, intrinsics.Load(missing))
+	require.NoError(t, intrinsics.Load(missing))
+	assert.False(t, missing.Exists())
+
+	assert.Equal(t, 2, store.loads)
+}
+
+func TestStoreIntrinsics_SaveAndRemove(t *testing.T) {
+	intrinsics, store := newTestIntrinsics(&testEntity{Base: graphnode.NewBase("a"), Name: "first"})
+
+	require.NoError(t, intrinsics.Save(&testEntity{Base: graphnode.NewBase("b"), Name: "second"}))
+	require.NoError(t, intrinsics.Remove(&testEntity{Base: graphnode.NewBase("a")}))
+
+	removed := &testEntity{Base: graphnode.NewBase("a")}
+	require.NoError(t, intrinsics.Load(removed))
+	assert.False(t, removed.Exists())
+
+	saved := &testEntity{Base: graphnode.NewBase("b")}
+	require.NoError(t, intrinsics.Load(saved))
+	assert.True(t, saved.Exists())
+	assert.Equal(t, "second", saved.Name)
+	assert.Equal(t, 2, saved.MutatedOnStep)
+
+	all, err := intrinsics.LoadAllDistinct(&testEntity{}, 10)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "b", all[0].GetID())
+
+	require.NoError(t, intrinsics.Flush("cursor"))
+	assert.Equal(t, uint64(10), store.savedAt)
+	assert.Nil(t, store.saved["test_entity"]["a"])
+	assert.NotNil(t, store.saved["test_entity"]["b"])
+}
+
+func TestStoreIntrinsics_LoadAllDistinctOrder(t *testing.T) {
+	intrinsics, _ := newTestIntrinsics(&testEntity{Base: graphnode.NewBase("m"), Name: "stored"})
+
+	for _, id := range []string{"z", "b", "m", "x", "c"} {
+		require.NoError(t, intrinsics.Save(&testEntity{Base: graphnode.NewBase(id), Name: id}))
+	}
+
+	for i := 0; i < 10; i++ {
+		all, err := intrinsics.LoadAllDistinct(&testEntity{}, 10)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, ent := range all {
+			ids = append(ids, ent.GetID())
+		}
+		require.Equal(t, []string{"m", "b", "c", "x", "z"}, ids)
+		assert.Equal(t, "m", all[0].(*testEntity).Name)
+	}
+}
+
+func TestStoreIntrinsics_Steps(t *testing.T) {
+	intrinsics, _ := newTestIntrinsics()
+
+	assert.Equal(t, 2, intrinsics.Step())
+	assert.True(t, intrinsics.StepBelow(3))
+	assert.False(t, intrinsics.StepBelow(2))
+	assert.True(t, intrinsics.StepAbove(1))
+	assert.False(t, intrinsics.StepAbove(2))
+}
@@ -19,67 +19,112 @@ func (x *DatabaseChanges) Squash() error {
 	return nil
 }
 
+type rowKey struct {
+	table string
+	pk    string
+}
+
+type rowChanges struct {
+	firstOrdinal uint64
+	changes      []*TableChange
+}
+
+// Merge squashes the changes of each row (same table and pk) into a single change, a row
+// created then deleted has no change left. The squashed changes are ordered by the ordinal
+// of the first change of their row, rows with the same first ordinal keep their input
+// order, and fields keep the order in which they first appear. `x` is left untouched.
 func (x TableChanges) Merge() ([]*TableChange, error) {
-	//group the table changes by key
-	tableMap := make(map[string]map[string][]*TableChange)
-	for _, i := range x {
-		if _, ok := tableMap[i.Table]; !ok {
-			tableMap[i.Table] = make(map[string][]*TableChange)
+	rows := map[rowKey]*rowChanges{}
+	var ordered []*rowChanges
+	for _, change := range x {
+		key := rowKey{table: change.Table, pk: change.Pk}
+		row, found := rows[key]
+		if !found {
+			row = &rowChanges{firstOrdinal: change.Ordinal}
+			rows[key] = row
+			ordered = append(ordered, row)
+		}
+		if change.Ordinal < row.firstOrdinal {
+			row.firstOrdinal = change.Ordinal
 		}
-		tableMap[i.Table][i.Pk] = append(tableMap[i.Table][i.Pk], i)
+		row.changes = append(row.changes, change)
 	}
 
-	//merge each group
-	result := make([]*TableChange, 0)
-	for _, tableChanges := range tableMap {
-		for _, tableChange := range tableChanges {
-			switch len(tableChange) {
-			case 0:
-				continue
-			case 1:
-				result = append(result, tableChange[0])
-			default:
-				sort.Slice(tableChange, func(i, j int) bool {
-					return tableChange[i].Ordinal < tableChange[j].Ordinal
-				})
-
-				currentTableChange := tableChange[0]
-				createdHere := currentTableChange.Operation == TableChange_CREATE
-
-				for i := 1; i <= len(tableChange)-1; i++ {
-					next := tableChange[i]
-					err := currentTableChange.Merge(next)
-					if err != nil {
-						return nil, err
-					}
-				}
-
-				// row created and deleted in the same table change... we do nothing
-				if createdHere && tableChange[0].Operation == TableChange_DELETE {
-					continue
-				}
-
-				result = append(result, currentTableChange)
-			}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].firstOrdinal < ordered[j].firstOrdinal
+	})
+
+	result := make([]*TableChange, 0, len(ordered))
+	for _, row := range ordered {
+		squashed, err := squashRow(row.changes)
+		if err != nil {
+			return nil, err
+		}
+		if squashed != nil {
+			result = append(result, squashed)
 		}
 	}
 
 	return result, nil
 }
 
+// squashRow merges the changes of a single row, it returns nil when the row did not exist
+// before the changes and does not exist after them.
+func squashRow(changes []*TableChange) (*TableChange, error) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Ordinal < changes[j].Ordinal
+	})
+
+	first := changes[0]
+	if first.Operation == TableChange_UNSET {
+		return nil, fmt.Errorf("table %s, key %s: operation is not set", first.Table, first.Pk)
+	}
+
+	out := &TableChange{
+		Table:     first.Table,
+		Pk:        first.Pk,
+		BlockNum:  first.BlockNum,
+		Ordinal:   first.Ordinal,
+		Operation: first.Operation,
+		Fields:    copyFields(first.Fields),
+	}
+
+	for _, next := range changes[1:] {
+		if err := out.Merge(next); err != nil {
+			return nil, err
+		}
+	}
+
+	// A row created then deleted leaves nothing to apply, while a row deleted then created
+	// again ends as a CREATE replacing the previous row.
+	if first.Operation == TableChange_CREATE && out.Operation == TableChange_DELETE {
+		return nil, nil
+	}
+
+	return out, nil
+}
+
+// Merge applies `next`, a later change of the same row, on top of `x`.
 func (x *TableChange) Merge(next *TableChange) error {
 	if x.Table != next.Table {
 		return fmt.Errorf("table mismatch: %s != %s. merging only supported on same table", x.Table, next.Table)
 	}
 
+	if x.Pk != next.Pk {
+		return fmt.Errorf("key mismatch: %s != %s. merging only supported on same key", x.Pk, next.Pk)
+	}
+
 	if x.Ordinal >= next.Ordinal {
 		return fmt.Errorf("non-increasing ordinal")
 	}
 
 	switch next.Operation {
 	case TableChange_DELETE:
+		if x.Operation == TableChange_DELETE {
+			return fmt.Errorf("table %s, key %s: trying to delete row already deleted", x.Table, x.Pk)
+		}
 		x.Operation = next.Operation
-		x.Fields = next.Fields
+		x.Fields = copyFields(next.Fields)
 	case TableChange_CREATE:
 		if x.Operation != TableChange_DELETE {
 			zlog.Error("trying to create row when current operation is not delete, row already exists",
@@ -90,41 +135,57 @@ func (x *TableChange) Merge(next *TableChange) error {
 			return fmt.Errorf("trying to create row when current operation is not delete, row already exists")
 		}
 		x.Operation = next.Operation
-		x.Fields = next.Fields
+		x.Fields = copyFields(next.Fields)
 	case TableChange_UPDATE:
-		fieldValues := make(map[string]*Field)
-		for _, oldField := range x.Fields {
-			fieldValues[oldField.Name] = oldField
+		if x.Operation == TableChange_DELETE {
+			return fmt.Errorf("table %s, key %s: trying to update row already deleted", x.Table, x.Pk)
+		}
+
+		positions := make(map[string]int, len(x.Fields))
+		for i, oldField := range x.Fields {
+			positions[oldField.Name] = i
 		}
 
 		for _, newField := range next.Fields {
-			oldField, ok := fieldValues[newField.Name]
+			i, ok := positions[newField.Name]
 			if !ok {
-				fieldValues[newField.Name] = newField
+				positions[newField.Name] = len(x.Fields)
+				x.Fields = append(x.Fields, copyField(newField))
 				continue
 			}
 
+			oldField := x.Fields[i]
 			if newField.OldValue != oldField.NewValue {
 				return fmt.Errorf("update field mismatch: old value supposed to be %s, got %s", oldField.NewValue, newField.OldValue)
 			}
 
-			fieldValues[newField.Name] = &Field{
+			x.Fields[i] = &Field{
 				Name:     newField.Name,
 				NewValue: newField.NewValue,
 				OldValue: oldField.OldValue,
 			}
 		}
-
-		fields := make([]*Field, 0)
-		for _, f := range fieldValues {
-			fields = append(fields, f)
-		}
-		x.Fields = fields
+	default:
+		return fmt.Errorf("table %s, key %s: unsupported operation %s", x.Table, x.Pk, next.Operation)
 	}
 
-	x.Pk = next.Pk
 	x.Ordinal = next.Ordinal
 	x.BlockNum = next.BlockNum
 
 	return nil
 }
+
+func copyFields(fields []*Field) []*Field {
+	if fields == nil {
+		return nil
+	}
+	out := make([]*Field, len(fields))
+	for i, field := range fields {
+		out[i] = copyField(field)
+	}
+	return out
+}
+
+func copyField(field *Field) *Field {
+	return &Field{Name: field.Name, NewValue: field.NewValue, OldValue: field.OldValue}
+}
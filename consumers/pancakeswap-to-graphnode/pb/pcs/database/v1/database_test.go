@@ -1,36 +1,11 @@
 package database
 
 import (
-	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
-func (x TableChanges) isEqual(t *testing.T, expected TableChanges) {
-	require.Equal(t, len(x), len(expected))
-
-	sort.Slice(x, func(i, j int) bool {
-		return x[i].Table < x[j].Table
-	})
-
-	for i, tc := range x {
-		tc.isEqual(t, expected[i])
-	}
-}
-
-func (x *TableChange) isEqual(t *testing.T, expected *TableChange) {
-	sort.Slice(x.Fields, func(i, j int) bool {
-		return x.Fields[i].Name < x.Fields[j].Name
-	})
-
-	sort.Slice(expected.Fields, func(i, j int) bool {
-		return x.Fields[i].Name < x.Fields[j].Name
-	})
-
-	require.Equal(t, expected, x)
-}
-
 func TestTableChanges_Merge(t *testing.T) {
 	var tableChanges []*TableChange
 	tableChanges = append(tableChanges,
@@ -137,6 +112,7 @@ func TestTableChanges_Merge(t *testing.T) {
 		},
 	)
 
+	// Ordered by the first ordinal of each row, ties keep the input order
 	expected := []*TableChange{
 		{
 			Table:     "table.1",
@@ -148,13 +124,6 @@ func TestTableChanges_Merge(t *testing.T) {
 				{Name: "f2", OldValue: "", NewValue: "23"},
 			},
 		},
-		{
-			Table:     "table.2",
-			Ordinal:   4,
-			Pk:        "two",
-			Operation: TableChange_DELETE,
-			Fields:    []*Field{},
-		},
 		{
 			Table:     "table.4",
 			Ordinal:   2,
@@ -165,6 +134,13 @@ func TestTableChanges_Merge(t *testing.T) {
 				{Name: "f2", OldValue: "", NewValue: "42"},
 			},
 		},
+		{
+			Table:     "table.2",
+			Ordinal:   4,
+			Pk:        "two",
+			Operation: TableChange_DELETE,
+			Fields:    []*Field{},
+		},
 		{
 			Table:     "table.4",
 			Ordinal:   4,
@@ -180,5 +156,57 @@ func TestTableChanges_Merge(t *testing.T) {
 	changes, err := TableChanges(tableChanges).Merge()
 	require.Nil(t, err)
 
-	TableChanges(changes).isEqual(t, expected)
+	require.Equal(t, expected, changes)
+}
+
+func TestTableChanges_MergeFieldOrder(t *testing.T) {
+	changes := []*TableChange{
+		{Table: "t", Pk: "a", Ordinal: 1, Operation: TableChange_UPDATE, Fields: []*Field{{Name: "z", OldValue: "0", NewValue: "1"}, {Name: "a", OldValue: "0", NewValue: "1"}}},
+		{Table: "t", Pk: "a", Ordinal: 2, Operation: TableChange_UPDATE, Fields: []*Field{{Name: "m", OldValue: "0", NewValue: "1"}, {Name: "z", OldValue: "1", NewValue: "2"}}},
+		{Table: "t", Pk: "a", Ordinal: 3, Operation: TableChange_UPDATE, Fields: []*Field{{Name: "b", OldValue: "0", NewValue: "1"}}},
+	}
+
+	for i := 0; i < 20; i++ {
+		merged, err := TableChanges(changes).Merge()
+		require.NoError(t, err)
+		require.Len(t, merged, 1)
+
+		var names []string
+		for _, field := range merged[0].Fields {
+			names = append(names, field.Name)
+		}
+		require.Equal(t, []string{"z", "a", "m", "b"}, names)
+	}
+}
+
+func TestTableChanges_MergeErrors(t *testing.T) {
+	create := func(ordinal uint64) *TableChange {
+		return &TableChange{Table: "t", Pk: "a", Ordinal: ordinal, Operation: TableChange_CREATE, Fields: []*Field{{Name: "f", NewValue: "1"}}}
+	}
+	update := func(ordinal uint64, oldValue string) *TableChange {
+		return &TableChange{Table: "t", Pk: "a", Ordinal: ordinal, Operation: TableChange_UPDATE, Fields: []*Field{{Name: "f", OldValue: oldValue, NewValue: "2"}}}
+	}
+	remove := func(ordinal uint64) *TableChange {
+		return &TableChange{Table: "t", Pk: "a", Ordinal: ordinal, Operation: TableChange_DELETE}
+	}
+
+	tests := []struct {
+		name          string
+		changes       []*TableChange
+		expectedError string
+	}{
+		{"create existing row", []*TableChange{update(1, "0"), create(2)}, "trying to create row when current operation is not delete, row already exists"},
+		{"update deleted row", []*TableChange{remove(1), update(2, "0")}, "table t, key a: trying to update row already deleted"},
+		{"delete deleted row", []*TableChange{create(1), remove(2), remove(3)}, "table t, key a: trying to delete row already deleted"},
+		{"old value mismatch", []*TableChange{create(1), update(2, "0")}, "update field mismatch: old value supposed to be 1, got 0"},
+		{"duplicate ordinal", []*TableChange{create(1), update(1, "1")}, "non-increasing ordinal"},
+		{"unset operation", []*TableChange{{Table: "t", Pk: "a", Ordinal: 1}}, "table t, key a: operation is not set"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := TableChanges(test.changes).Merge()
+			require.EqualError(t, err, test.expectedError)
+		})
+	}
 }
@@ -113,9 +113,9 @@ func applyTableChange(rv reflect.Value, fieldChange *Field, fieldTags *FieldTags
 			n, err = strconv.ParseInt(fieldChange.NewValue, 10, 64)
 			rv.Set(reflect.ValueOf(graphnode.NewIntFromLiteral(n)))
 		case "graphnode.Float":
-			var n float64
-			n, err = strconv.ParseFloat(fieldChange.NewValue, 64)
-			rv.Set(reflect.ValueOf(graphnode.NewFloatFromLiteral(n)))
+			var f graphnode.Float
+			f, err = graphnode.ParseFloat(fieldChange.NewValue)
+			rv.Set(reflect.ValueOf(f))
 		default:
 
 			return fmt.Errorf("nested structure not supported %q", rt)
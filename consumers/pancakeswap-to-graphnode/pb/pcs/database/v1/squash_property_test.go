@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rows is the state of the database as seen by the changes: row key, field name, value.
+// A row absent from the map does not exist.
+type rows map[rowKey]map[string]string
+
+func (r rows) clone() rows {
+	out := rows{}
+	for key, fields := range r {
+		out[key] = map[string]string{}
+		for name, value := range fields {
+			out[key][name] = value
+		}
+	}
+	return out
+}
+
+// apply applies a change the way a consumer does, failing on anything inconsistent with
+// the current state.
+func (r rows) apply(change *TableChange) error {
+	key := rowKey{table: change.Table, pk: change.Pk}
+	fields, exists := r[key]
+
+	switch change.Operation {
+	case TableChange_CREATE:
+		// A CREATE replaces the row, which happens for a row deleted then created again, as
+		// the graphnode Loader resets a recreated entity before applying the fields
+		fields = map[string]string{}
+		for _, field := range change.Fields {
+			fields[field.Name] = field.NewValue
+		}
+		r[key] = fields
+	case TableChange_UPDATE:
+		if !exists {
+			return fmt.Errorf("update of missing row %v", key)
+		}
+		for _, field := range change.Fields {
+			if fields[field.Name] != field.OldValue {
+				return fmt.Errorf("row %v field %s: old value %q, current value %q", key, field.Name, field.OldValue, fields[field.Name])
+			}
+			fields[field.Name] = field.NewValue
+		}
+	case TableChange_DELETE:
+		if !exists {
+			return fmt.Errorf("delete of missing row %v", key)
+		}
+		delete(r, key)
+	default:
+		return fmt.Errorf("unexpected operation %s", change.Operation)
+	}
+	return nil
+}
+
+// randomChanges generates a valid sequence of changes, with increasing ordinals, on top
+// of a random initial state.
+func randomChanges(rnd *rand.Rand) (initial rows, changes []*TableChange) {
+	tables := []string{"pair", "token"}
+	pks := []string{"a", "b", "c"}
+	fieldNames := []string{"f1", "f2", "f3"}
+
+	initial = rows{}
+	for _, table := range tables {
+		for _, pk := range pks {
+			if rnd.Intn(2) == 0 {
+				continue
+			}
+			fields := map[string]string{}
+			for _, name := range fieldNames {
+				fields[name] = fmt.Sprintf("%d", rnd.Intn(100))
+			}
+			initial[rowKey{table, pk}] = fields
+		}
+	}
+
+	current := initial.clone()
+	count := 1 + rnd.Intn(20)
+	for i := 0; i < count; i++ {
+		key := rowKey{tables[rnd.Intn(len(tables))], pks[rnd.Intn(len(pks))]}
+		change := &TableChange{Table: key.table, Pk: key.pk, BlockNum: 1, Ordinal: uint64(i + 1)}
+
+		if fields, exists := current[key]; exists {
+			if rnd.Intn(3) == 0 {
+				change.Operation = TableChange_DELETE
+			} else {
+				change.Operation = TableChange_UPDATE
+				for _, name := range fieldNames {
+					if rnd.Intn(2) == 0 {
+						change.Fields = append(change.Fields, &Field{Name: name, OldValue: fields[name], NewValue: fmt.Sprintf("%d", rnd.Intn(100))})
+					}
+				}
+			}
+		} else {
+			change.Operation = TableChange_CREATE
+			for _, name := range fieldNames {
+				if rnd.Intn(3) != 0 {
+					change.Fields = append(change.Fields, &Field{Name: name, NewValue: fmt.Sprintf("%d", rnd.Intn(100))})
+				}
+			}
+		}
+
+		if err := current.apply(change); err != nil {
+			panic(err)
+		}
+		changes = append(changes, change)
+	}
+
+	return initial, changes
+}
+
+func shuffled(rnd *rand.Rand, changes []*TableChange) []*TableChange {
+	out := append([]*TableChange(nil), changes...)
+	rnd.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+func TestTableChanges_MergeProperties(t *testing.T) {
+	for seed := int64(0); seed < 2000; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		initial, changes := randomChanges(rnd)
+
+		expected := initial.clone()
+		firstOrdinals := map[rowKey]uint64{}
+		for _, change := range changes {
+			require.NoError(t, expected.apply(change), "seed %d", seed)
+
+			key := rowKey{change.Table, change.Pk}
+			if _, found := firstOrdinals[key]; !found {
+				firstOrdinals[key] = change.Ordinal
+			}
+		}
+
+		input := shuffled(rnd, changes)
+		inputCopy := copyChanges(input)
+
+		merged, err := TableChanges(input).Merge()
+		require.NoError(t, err, "seed %d", seed)
+
+		// The input is left untouched
+		require.Equal(t, inputCopy, copyChanges(input), "seed %d", seed)
+
+		// Applying the squashed changes gives the same state as applying every change
+		actual := initial.clone()
+		for _, change := range merged {
+			require.NoError(t, actual.apply(change), "seed %d", seed)
+		}
+		require.Equal(t, expected, actual, "seed %d", seed)
+
+		// A single change per row, ordered by the first ordinal of the row
+		seen := map[rowKey]bool{}
+		var lastOrdinal uint64
+		for _, change := range merged {
+			key := rowKey{change.Table, change.Pk}
+			require.False(t, seen[key], "seed %d: row %v squashed twice", seed, key)
+			seen[key] = true
+
+			require.Greater(t, firstOrdinals[key], lastOrdinal, "seed %d: row %v out of order", seed, key)
+			lastOrdinal = firstOrdinals[key]
+		}
+
+		// Deterministic whatever the input order
+		again, err := TableChanges(shuffled(rnd, changes)).Merge()
+		require.NoError(t, err, "seed %d", seed)
+		require.Equal(t, merged, again, "seed %d", seed)
+	}
+}
+
+func copyChanges(changes []*TableChange) []*TableChange {
+	out := make([]*TableChange, len(changes))
+	for i, change := range changes {
+		out[i] = &TableChange{Table: change.Table, Pk: change.Pk, BlockNum: change.BlockNum, Ordinal: change.Ordinal, Operation: change.Operation, Fields: copyFields(change.Fields)}
+	}
+	return out
+}